@@ -0,0 +1,69 @@
+// Command audit-verify checks the audit log against its hash chain and signed checkpoints and
+// reports the first broken link. It reads DATABASE_URL and AUDIT_HMAC_KEY like the server does,
+// and exits with status 1 if the log doesn't verify.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/karanbihani/file-vault/internal/core/audit"
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the result as JSON")
+	flag.Parse()
+
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		log.Fatal("DATABASE_URL environment variable is not set")
+	}
+	dbpool, err := pgxpool.New(context.Background(), dbURL)
+	if err != nil {
+		log.Fatalf("Unable to connect to database: %v", err)
+	}
+	defer dbpool.Close()
+
+	// Verifying doesn't log anything or read archived segments, so the service needs neither a
+	// queue nor the archive.
+	auditService := audit.NewService(dbpool, db.New(dbpool), []byte(os.Getenv("AUDIT_HMAC_KEY")), 0, nil)
+	result, err := auditService.Verify(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to verify the audit log: %v", err)
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("Verified %d entries and %d checkpoints.\n", result.EntriesVerified, result.CheckpointsVerified)
+		if result.SegmentsVerified > 0 {
+			fmt.Printf("Verified the records of %d archived segments holding %d entries.\n", result.SegmentsVerified, result.ArchivedEntries)
+		}
+		if result.UnchainedEntries > 0 {
+			fmt.Printf("%d entries from before the hash chain was introduced can't be verified.\n", result.UnchainedEntries)
+		}
+		if result.Break != nil {
+			fmt.Printf("BROKEN at entry %d", result.Break.EntryID)
+			if result.Break.CheckpointID != 0 {
+				fmt.Printf(" (checkpoint %d)", result.Break.CheckpointID)
+			}
+			if result.Break.SegmentID != 0 {
+				fmt.Printf(" (archived segment %d)", result.Break.SegmentID)
+			}
+			fmt.Printf(": %s.\n", result.Break.Reason)
+		} else if result.LastEntryID != 0 {
+			fmt.Printf("The audit log is intact up to entry %d, hash %s.\n", result.LastEntryID, result.LastHash)
+		}
+	}
+	if !result.Valid {
+		os.Exit(1)
+	}
+}
@@ -3,22 +3,35 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/karanbihani/file-vault/internal/api"      // Adjust path
 	"github.com/karanbihani/file-vault/internal/auth"     // Adjust path
 	"github.com/karanbihani/file-vault/internal/core/files" // Adjust path
+	"github.com/karanbihani/file-vault/internal/core/folders"
 	"github.com/karanbihani/file-vault/internal/core/rbac" // <-- Add this
 	"github.com/karanbihani/file-vault/internal/db"       // Add this import
+	"github.com/karanbihani/file-vault/internal/mail"
 	"github.com/karanbihani/file-vault/internal/storage"  // Adjust path
 	"github.com/karanbihani/file-vault/internal/core/admin"
 	"github.com/karanbihani/file-vault/internal/core/stats"
 	"github.com/karanbihani/file-vault/internal/core/shares"
 	"github.com/karanbihani/file-vault/internal/core/search"
 	"github.com/karanbihani/file-vault/internal/core/audit" // Adjust path
+	"github.com/karanbihani/file-vault/internal/core/uploads"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// shutdownTimeout is how long the server waits for requests and audit log writes to finish
+// when it is stopped.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	// --- Database Connection ---
 	dbURL := os.Getenv("DATABASE_URL")
@@ -37,36 +50,222 @@ func main() {
 	// We create the querier object ONCE here.
 	queries := db.New(dbpool)
 	
-	// --- MinIO Client Initialization ---
-	minioConfig := storage.Config{
-		Endpoint:        os.Getenv("MINIO_ENDPOINT"),
-		AccessKeyID:     os.Getenv("MINIO_ACCESS_KEY_ID"),
-		SecretAccessKey: os.Getenv("MINIO_SECRET_ACCESS_KEY"),
-		BucketName:      os.Getenv("MINIO_BUCKET_NAME"),
-		UseSSL:          false, // For local development
+	// --- Storage Backend Initialization ---
+	// STORAGE_BACKEND selects where file contents live: "minio" (default), "local" or "memory".
+	// Archived audit log segments are kept in the same place, under AUDIT_ARCHIVE_PREFIX, unless
+	// AUDIT_ARCHIVE_BUCKET names a separate MinIO bucket for them.
+	var storageBackend, auditArchiveBackend storage.Backend
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "minio":
+		minioConfig := storage.Config{
+			Endpoint:        os.Getenv("MINIO_ENDPOINT"),
+			AccessKeyID:     os.Getenv("MINIO_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("MINIO_SECRET_ACCESS_KEY"),
+			BucketName:      os.Getenv("MINIO_BUCKET_NAME"),
+			UseSSL:          false, // For local development
+		}
+		storageBackend = storage.NewClient(context.Background(), minioConfig)
+		log.Println("MinIO client initialized and bucket is ready.")
+		if bucket := os.Getenv("AUDIT_ARCHIVE_BUCKET"); bucket != "" {
+			minioConfig.BucketName = bucket
+			auditArchiveBackend = storage.NewClient(context.Background(), minioConfig)
+			log.Printf("Audit log archive bucket %s is ready.", bucket)
+		}
+	case "local":
+		storageBackend = storage.NewLocalBackend(os.Getenv("STORAGE_LOCAL_PATH"))
+		log.Printf("Local filesystem storage initialized at %s.", os.Getenv("STORAGE_LOCAL_PATH"))
+	case "memory":
+		storageBackend = storage.NewMemoryBackend()
+		log.Println("In-memory storage initialized. File contents will not survive a restart.")
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q", backend)
+	}
+	if auditArchiveBackend == nil {
+		auditArchiveBackend = storageBackend
+	}
+	auditArchivePrefix := os.Getenv("AUDIT_ARCHIVE_PREFIX")
+	if auditArchivePrefix == "" {
+		auditArchivePrefix = "audit-archive/"
+	}
+
+	// --- Mail Sender Initialization ---
+	// MAIL_SENDER selects how verification and password reset emails are delivered:
+	// "log" (default) writes them to the server log, "file" saves them to MAIL_FILE_PATH and
+	// "smtp" sends them through the SMTP_* server. The log sender leaves out message bodies,
+	// and with them the links, unless MAIL_LOG_BODY is set for development.
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "File Vault <no-reply@localhost>"
+	}
+	var mailSender mail.Sender
+	switch sender := os.Getenv("MAIL_SENDER"); sender {
+	case "", "log":
+		logBody := false
+		if v := os.Getenv("MAIL_LOG_BODY"); v != "" {
+			if logBody, err = strconv.ParseBool(v); err != nil {
+				log.Fatalf("Invalid MAIL_LOG_BODY: %v", err)
+			}
+		}
+		mailSender = mail.NewLogSender(logBody)
+		if logBody {
+			log.Println("WARNING: Emails, including their verification and password reset links, will be written to the log.")
+		} else {
+			log.Println("Emails will be written to the log without their bodies.")
+		}
+	case "file":
+		mailSender = mail.NewFileSender(os.Getenv("MAIL_FILE_PATH"), mailFrom)
+		log.Printf("Emails will be saved to %s.", os.Getenv("MAIL_FILE_PATH"))
+	case "smtp":
+		smtpPort := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			if smtpPort, err = strconv.Atoi(v); err != nil {
+				log.Fatalf("Invalid SMTP_PORT: %v", err)
+			}
+		}
+		mailSender = mail.NewSMTPSender(mail.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     smtpPort,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		})
+		log.Printf("Emails will be sent through %s.", os.Getenv("SMTP_HOST"))
+	default:
+		log.Fatalf("Unknown MAIL_SENDER %q", sender)
 	}
-	storageClient := storage.NewClient(context.Background(), minioConfig)
-	log.Println("MinIO client initialized and bucket is ready.")
 
 	// --- Initialize Services ---
 	// We inject the shared 'queries' object into both services.
 
-	auditService := audit.NewService(queries)
-	authService := auth.NewService(dbpool, queries)
-	fileService := files.NewService(dbpool, queries, storageClient, auditService)
-	sharesService := shares.NewService(queries, storageClient, auditService) // Create the shares service
+	// AUDIT_HMAC_KEY signs the audit log's hash chain. Without it, someone who can edit the
+	// database could also recompute the chain.
+	auditHMACKey := os.Getenv("AUDIT_HMAC_KEY")
+	if auditHMACKey == "" {
+		log.Println("WARNING: AUDIT_HMAC_KEY is not set, so the audit log hash chain is not signed.")
+	}
+	// Audit entries that don't have to commit with a change are queued and written in the
+	// background. At most AUDIT_QUEUE_SIZE can wait at once; beyond that they only reach the log.
+	auditQueueSize := 1000
+	if v := os.Getenv("AUDIT_QUEUE_SIZE"); v != "" {
+		if auditQueueSize, err = strconv.Atoi(v); err != nil || auditQueueSize < 1 {
+			log.Fatalf("Invalid AUDIT_QUEUE_SIZE: must be a positive integer")
+		}
+	}
+	auditService := audit.NewService(dbpool, queries, []byte(auditHMACKey), auditQueueSize, storage.NewPrefixBackend(auditArchiveBackend, auditArchivePrefix))
+	// The head of the chain is checkpointed every AUDIT_CHECKPOINT_INTERVAL_MINUTES (0 turns it off).
+	auditCheckpointMinutes := 60
+	if v := os.Getenv("AUDIT_CHECKPOINT_INTERVAL_MINUTES"); v != "" {
+		if auditCheckpointMinutes, err = strconv.Atoi(v); err != nil || auditCheckpointMinutes < 0 {
+			log.Fatalf("Invalid AUDIT_CHECKPOINT_INTERVAL_MINUTES: must be a non-negative integer")
+		}
+	}
+	if auditCheckpointMinutes > 0 {
+		auditService.StartCheckpointer(time.Duration(auditCheckpointMinutes) * time.Minute)
+	}
+	// Entries older than AUDIT_RETENTION_DAYS are moved to the archive (0 keeps them in the database).
+	auditRetentionDays := 0
+	if v := os.Getenv("AUDIT_RETENTION_DAYS"); v != "" {
+		if auditRetentionDays, err = strconv.Atoi(v); err != nil || auditRetentionDays < 0 {
+			log.Fatalf("Invalid AUDIT_RETENTION_DAYS: must be a non-negative integer")
+		}
+	}
+	if auditRetentionDays > 0 {
+		auditService.StartArchiver(time.Duration(auditRetentionDays)*24*time.Hour, time.Hour)
+	}
+
+	// Each user's permissions are cached for PERMISSION_CACHE_TTL_SECONDS (0 turns the cache off).
+	// Changes made by other replicas arrive through Postgres LISTEN/NOTIFY.
+	permissionCacheTTLSeconds := 60
+	if v := os.Getenv("PERMISSION_CACHE_TTL_SECONDS"); v != "" {
+		if permissionCacheTTLSeconds, err = strconv.Atoi(v); err != nil || permissionCacheTTLSeconds < 0 {
+			log.Fatalf("Invalid PERMISSION_CACHE_TTL_SECONDS: must be a non-negative integer")
+		}
+	}
+	permissionResolver := auth.NewPermissionResolver(dbpool, queries, time.Duration(permissionCacheTTLSeconds)*time.Second)
+	permissionResolver.StartListener()
+
+	authService := auth.NewService(dbpool, queries, auditService, mailSender, permissionResolver)
+	// Sessions that have expired or been revoked are kept for a week for investigation.
+	authService.StartSessionPurger(7*24*time.Hour, time.Hour)
+	fileService := files.NewService(dbpool, queries, storageBackend, auditService, permissionResolver)
+
+	// Trashed files are purged for good after TRASH_RETENTION_DAYS.
+	trashRetentionDays := 30
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		if trashRetentionDays, err = strconv.Atoi(v); err != nil || trashRetentionDays < 1 {
+			log.Fatalf("Invalid TRASH_RETENTION_DAYS: must be a positive integer")
+		}
+	}
+	fileService.StartTrashPurger(time.Duration(trashRetentionDays)*24*time.Hour, time.Hour)
+
+	// SHARE_LINK_MAX_DAYS is both the default and the upper limit of a public share link's
+	// lifetime. 0 lets links be created without an expiry.
+	shareLinkMaxDays := 30
+	if v := os.Getenv("SHARE_LINK_MAX_DAYS"); v != "" {
+		if shareLinkMaxDays, err = strconv.Atoi(v); err != nil || shareLinkMaxDays < 0 {
+			log.Fatalf("Invalid SHARE_LINK_MAX_DAYS: must be a non-negative integer")
+		}
+	}
+	// REQUIRE_VERIFIED_EMAIL_FOR_SHARING stops unverified accounts from sharing with users or being shared with.
+	requireVerifiedEmail := false
+	if v := os.Getenv("REQUIRE_VERIFIED_EMAIL_FOR_SHARING"); v != "" {
+		if requireVerifiedEmail, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("Invalid REQUIRE_VERIFIED_EMAIL_FOR_SHARING: %v", err)
+		}
+	}
+	sharesService := shares.NewService(queries, storageBackend, auditService, time.Duration(shareLinkMaxDays)*24*time.Hour, requireVerifiedEmail) // Create the shares service
+	sharesService.StartGrantPurger(time.Hour)
 	statsService := stats.NewService(queries)
-	rbacService := rbac.NewService(queries) // <-- Initialize the new RBAC service
-	adminService := admin.NewService(queries) // <-- ADD THIS
-	searchService := search.NewService(queries)
+	rbacService := rbac.NewService(dbpool, queries, auditService, permissionResolver) // <-- Initialize the new RBAC service
+	searchService := search.NewService(queries, permissionResolver)
+	folderService := folders.NewService(dbpool, queries, fileService, auditService)
+
+	// Incomplete resumable uploads expire after UPLOAD_EXPIRY_HOURS of inactivity.
+	uploadExpiryHours := 24
+	if v := os.Getenv("UPLOAD_EXPIRY_HOURS"); v != "" {
+		if uploadExpiryHours, err = strconv.Atoi(v); err != nil || uploadExpiryHours < 1 {
+			log.Fatalf("Invalid UPLOAD_EXPIRY_HOURS: must be a positive integer")
+		}
+	}
+	uploadsService := uploads.NewService(queries, storageBackend, fileService, time.Duration(uploadExpiryHours)*time.Hour)
+	uploadsService.StartExpiryWorker(time.Hour)
+	adminService := admin.NewService(dbpool, queries, auditService, fileService, uploadsService, permissionResolver)
 
 	log.Println("Services initialized.")
 
 	// --- Gin Web Server Setup ---
-	router := api.SetupRouter(queries, dbpool, fileService, authService, sharesService, statsService, rbacService, adminService, searchService)
+	// TRUSTED_PROXIES lists the IP addresses or CIDR ranges of the reverse proxies in front of
+	// the server, whose X-Forwarded-For headers give the client's address. None by default.
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	router := api.SetupRouter(queries, dbpool, fileService, authService, sharesService, statsService, rbacService, adminService, searchService, uploadsService, folderService, trustedProxies)
 
-	log.Println("Starting server on port 8080...")
-	if err := router.Run(":8080"); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+	server := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		log.Println("Starting server on port 8080...")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to run server: %v", err)
+		}
+	}()
+
+	// On SIGINT or SIGTERM, finish the requests in flight, then write out the queued audit
+	// entries before the database pool closes.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("ERROR: failed to shut down the server cleanly: %v", err)
+	}
+	if err := auditService.Shutdown(shutdownCtx); err != nil {
+		log.Printf("ERROR: %v", err)
 	}
+	log.Println("Server stopped.")
 }
\ No newline at end of file
@@ -7,6 +7,9 @@ import {
 import { AuthProvider } from "./context/AuthContext";
 import Login from "./components/Login";
 import Register from "./components/Register";
+import ForgotPassword from "./components/ForgotPassword";
+import ResetPassword from "./components/ResetPassword";
+import VerifyEmail from "./components/VerifyEmail";
 import Dashboard from "./components/Dashboard";
 import SharedWithMe from "./components/SharedWithMe";
 import AdminDashboard from "./components/AdminDashboard";
@@ -57,6 +60,32 @@ function App() {
                 </PublicRoute>
               }
             />
+            <Route
+              path="/login/sso"
+              element={
+                <PublicRoute>
+                  <Login />
+                </PublicRoute>
+              }
+            />
+            <Route
+              path="/forgot-password"
+              element={
+                <PublicRoute>
+                  <ForgotPassword />
+                </PublicRoute>
+              }
+            />
+            <Route
+              path="/reset-password"
+              element={
+                <PublicRoute>
+                  <ResetPassword />
+                </PublicRoute>
+              }
+            />
+            {/* Works whether or not the user is logged in. */}
+            <Route path="/verify-email" element={<VerifyEmail />} />
             <Route
               path="/register"
               element={
@@ -19,8 +19,53 @@ baseClient.interceptors.request.use(
   }
 );
 
+// Access tokens are short-lived. When one is rejected, exchange the refresh token for a new
+// pair once and retry the request. Concurrent failures share a single refresh.
+let refreshing: Promise<string | null> | null = null;
+
+const refreshAccessToken = async (): Promise<string | null> => {
+  const refreshToken = localStorage.getItem("refreshToken");
+  if (!refreshToken) {
+    return null;
+  }
+  try {
+    const response = await axios.post(`${baseClient.defaults.baseURL}/auth/refresh`, {
+      refresh_token: refreshToken,
+    });
+    localStorage.setItem("authToken", response.data.token);
+    localStorage.setItem("refreshToken", response.data.refresh_token);
+    return response.data.token;
+  } catch {
+    localStorage.removeItem("authToken");
+    localStorage.removeItem("refreshToken");
+    return null;
+  }
+};
+
+baseClient.interceptors.response.use(
+  (response) => response,
+  async (error) => {
+    const original = error.config;
+    if (error.response?.status !== 401 || !original || original._retried) {
+      return Promise.reject(error);
+    }
+    original._retried = true;
+
+    refreshing = refreshing ?? refreshAccessToken().finally(() => (refreshing = null));
+    const token = await refreshing;
+    if (!token) {
+      return Promise.reject(error);
+    }
+    original.headers.Authorization = `Bearer ${token}`;
+    return baseClient(original);
+  }
+);
+
 // Rate-limited API client wrapper
 const apiClient = {
+  // For links the browser follows itself, such as single sign-on.
+  baseURL: baseClient.defaults.baseURL,
+
   get: <T = any>(url: string, config?: AxiosRequestConfig) =>
     rateLimiter.execute(() => baseClient.get<T>(url, config)),
 
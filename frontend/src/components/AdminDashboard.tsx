@@ -20,6 +20,7 @@ interface AllFiles {
 const AdminDashboard = () => {
   const [stats, setStats] = useState<SystemStats | null>(null);
   const [files, setFiles] = useState<AllFiles[]>([]);
+  const [nextCursor, setNextCursor] = useState("");
   const [error, setError] = useState("");
   const [loading, setLoading] = useState(true);
 
@@ -32,7 +33,10 @@ const AdminDashboard = () => {
           apiClient.get("/admin/files"),
         ]);
         setStats(statsRes.data);
-        setFiles(Array.isArray(filesRes.data) ? filesRes.data : []);
+        setFiles(
+          Array.isArray(filesRes.data?.items) ? filesRes.data.items : []
+        );
+        setNextCursor(filesRes.data?.next_cursor || "");
       } catch (err) {
         console.error("Failed to fetch admin data", err);
         setError(
@@ -45,6 +49,20 @@ const AdminDashboard = () => {
     fetchData();
   }, []);
 
+  const loadMoreFiles = async () => {
+    try {
+      const res = await apiClient.get("/admin/files", {
+        params: { cursor: nextCursor },
+      });
+      const items: AllFiles[] = res.data?.items || [];
+      setFiles((prev) => [...prev, ...items]);
+      setNextCursor(res.data?.next_cursor || "");
+    } catch (err) {
+      console.error("Failed to fetch more files", err);
+      setError("Could not load more files.");
+    }
+  };
+
   const formatBytes = (bytes: number) => {
     if (bytes === 0) return "0 Bytes";
     const k = 1024;
@@ -229,6 +247,16 @@ const AdminDashboard = () => {
                   ))}
                 </tbody>
               </table>
+              {nextCursor && (
+                <div className="flex justify-center p-4">
+                  <button
+                    onClick={loadMoreFiles}
+                    className="text-blue-500 hover:underline"
+                  >
+                    Load more
+                  </button>
+                </div>
+              )}
             </div>
           )}
         </div>
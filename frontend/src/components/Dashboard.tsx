@@ -36,10 +36,11 @@ const Dashboard = () => {
   const [isLoading, setIsLoading] = useState(false);
   const [currentFilters, setCurrentFilters] = useState<FilterOptions>({});
   const [searchTerm, setSearchTerm] = useState("");
+  const [nextCursor, setNextCursor] = useState("");
 
   // Debounced search function
   const fetchFilteredFiles = useCallback(
-    async (filters: FilterOptions, search?: string) => {
+    async (filters: FilterOptions, search?: string, cursor?: string) => {
       setIsLoading(true);
       setError("");
 
@@ -59,6 +60,11 @@ const Dashboard = () => {
           }
         });
 
+        // Continue from the last page when loading more
+        if (cursor) {
+          params.append("cursor", cursor);
+        }
+
         let url = "/search";
         if (params.toString()) {
           url += `?${params.toString()}`;
@@ -66,14 +72,18 @@ const Dashboard = () => {
 
         const response = await apiClient.get(url);
         console.log("Search response:", response.data); // Debug log
-        const filesData = Array.isArray(response.data) ? response.data : [];
-        setFiles(filesData);
+        const filesData = Array.isArray(response.data?.items)
+          ? response.data.items
+          : [];
+        setFiles((prev) => (cursor ? [...prev, ...filesData] : filesData));
+        setNextCursor(response.data?.next_cursor || "");
       } catch (error: any) {
         console.error("Error fetching files:", error);
         console.log("Error response:", error.response); // Debug log
 
         // Always set files to empty array when there's an error or no data
         setFiles([]);
+        setNextCursor("");
 
         if (error.response?.status === 404) {
           // 404 likely means no files found, which is normal
@@ -287,6 +297,20 @@ const Dashboard = () => {
           ))}
         </div>
 
+        {/* Load More */}
+        {!isLoading && nextCursor && (
+          <div className="flex justify-center mt-6">
+            <button
+              onClick={() =>
+                fetchFilteredFiles(currentFilters, searchTerm, nextCursor)
+              }
+              className="px-4 py-2 bg-blue-500 hover:bg-blue-600 text-white rounded-md transition-colors"
+            >
+              Load more
+            </button>
+          </div>
+        )}
+
         {/* Empty State */}
         {!isLoading && files.length === 0 && (
           <div className="text-center py-12">
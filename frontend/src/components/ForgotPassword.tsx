@@ -0,0 +1,61 @@
+import { useState } from "react";
+import { Link } from "react-router-dom";
+import apiClient from "../api/apiClient";
+import { AxiosError } from "axios";
+
+const ForgotPassword = () => {
+  const [email, setEmail] = useState("");
+  const [message, setMessage] = useState("");
+  const [error, setError] = useState("");
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+    try {
+      const response = await apiClient.post("/auth/password/forgot", { email });
+      setMessage(response.data.message);
+    } catch (err) {
+      if (err instanceof AxiosError && err.response) {
+        setError(err.response.data.error || "Something went wrong.");
+      } else {
+        setError("An unexpected error occurred. Please try again.");
+      }
+    }
+  };
+
+  return (
+    <div className="flex items-center justify-center h-screen bg-gray-100">
+      <form
+        onSubmit={handleSubmit}
+        className="p-8 bg-white rounded shadow-md w-96"
+      >
+        <h2 className="text-2xl font-bold mb-4">Forgot password</h2>
+        {error && <p className="text-red-500 mb-4">{error}</p>}
+        {message ? (
+          <p className="mb-4">{message}</p>
+        ) : (
+          <>
+            <input
+              type="email"
+              value={email}
+              onChange={(e) => setEmail(e.target.value)}
+              placeholder="Email"
+              className="w-full p-2 mb-4 border rounded"
+            />
+            <button
+              type="submit"
+              className="w-full p-2 text-white bg-blue-500 rounded"
+            >
+              Send reset link
+            </button>
+          </>
+        )}
+        <Link to="/login" className="block mt-4 text-blue-500">
+          Back to login
+        </Link>
+      </form>
+    </div>
+  );
+};
+
+export default ForgotPassword;
@@ -1,22 +1,94 @@
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { useAuth } from "../context/AuthContext";
 import apiClient from "../api/apiClient";
-import { useNavigate } from "react-router-dom";
+import { Link, useNavigate } from "react-router-dom";
 import { AxiosError } from "axios";
 
 const Login = () => {
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
   const [error, setError] = useState(""); // State for error messages
+  // Second step for accounts with two-factor authentication.
+  const [challengeToken, setChallengeToken] = useState("");
+  const [setupSecret, setSetupSecret] = useState("");
+  const [code, setCode] = useState("");
+  const [recoveryCodes, setRecoveryCodes] = useState<string[]>([]);
+  const [ssoEnabled, setSsoEnabled] = useState(false);
   const { login } = useAuth();
   const navigate = useNavigate();
 
+  useEffect(() => {
+    apiClient
+      .get("/auth/oidc")
+      .then((response) => setSsoEnabled(response.data.enabled))
+      .catch(() => setSsoEnabled(false));
+  }, []);
+
+  // After single sign-on the backend redirects to /login/sso with the result in the fragment.
+  useEffect(() => {
+    if (!window.location.hash) return;
+    const result = new URLSearchParams(window.location.hash.slice(1));
+    window.history.replaceState(null, "", window.location.pathname);
+    if (result.get("error")) {
+      setError(result.get("error") || "Single sign-on failed.");
+      return;
+    }
+    const challenge = result.get("challenge_token");
+    if (challenge) {
+      if (result.get("two_factor_setup_required") === "true") {
+        apiClient
+          .post("/login/2fa/setup", { challenge_token: challenge })
+          .then((setup) => {
+            setSetupSecret(setup.data.secret);
+            setChallengeToken(challenge);
+          })
+          .catch(() => setError("Single sign-on failed. Please try again."));
+      } else {
+        setChallengeToken(challenge);
+      }
+      return;
+    }
+    const token = result.get("token");
+    const refreshToken = result.get("refresh_token");
+    if (token && refreshToken) {
+      login(token, refreshToken);
+      navigate("/dashboard");
+    }
+  }, [login, navigate]);
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     setError(""); // Clear previous errors
     try {
+      if (challengeToken) {
+        const url = setupSecret ? "/login/2fa/setup/confirm" : "/login/2fa";
+        const response = await apiClient.post(url, {
+          challenge_token: challengeToken,
+          code,
+        });
+        login(response.data.token, response.data.refresh_token);
+        if (response.data.recovery_codes) {
+          setRecoveryCodes(response.data.recovery_codes);
+          return;
+        }
+        navigate("/dashboard");
+        return;
+      }
+
       const response = await apiClient.post("/login", { email, password });
-      login(response.data.token);
+      if (response.data.two_factor_required) {
+        setChallengeToken(response.data.challenge_token);
+        return;
+      }
+      if (response.data.two_factor_setup_required) {
+        const setup = await apiClient.post("/login/2fa/setup", {
+          challenge_token: response.data.challenge_token,
+        });
+        setSetupSecret(setup.data.secret);
+        setChallengeToken(response.data.challenge_token);
+        return;
+      }
+      login(response.data.token, response.data.refresh_token);
       navigate("/dashboard");
     } catch (err) {
       console.error("Login failed", err);
@@ -31,6 +103,70 @@ const Login = () => {
     }
   };
 
+  if (recoveryCodes.length > 0) {
+    return (
+      <div className="flex items-center justify-center h-screen bg-gray-100">
+        <div className="p-8 bg-white rounded shadow-md w-96">
+          <h2 className="text-2xl font-bold mb-4">Recovery codes</h2>
+          <p className="mb-4">
+            Save these codes somewhere safe. Each one can be used once to log
+            in without your authenticator app. They will not be shown again.
+          </p>
+          <ul className="mb-4 font-mono">
+            {recoveryCodes.map((c) => (
+              <li key={c}>{c}</li>
+            ))}
+          </ul>
+          <button
+            onClick={() => navigate("/dashboard")}
+            className="w-full p-2 text-white bg-blue-500 rounded"
+          >
+            Continue
+          </button>
+        </div>
+      </div>
+    );
+  }
+
+  if (challengeToken) {
+    return (
+      <div className="flex items-center justify-center h-screen bg-gray-100">
+        <form
+          onSubmit={handleSubmit}
+          className="p-8 bg-white rounded shadow-md w-96"
+        >
+          <h2 className="text-2xl font-bold mb-4">Two-factor authentication</h2>
+          {error && <p className="text-red-500 mb-4">{error}</p>}
+          {setupSecret ? (
+            <p className="mb-4">
+              Your account requires two-factor authentication. Add this key to
+              your authenticator app, then enter the code it shows:{" "}
+              <span className="font-mono break-all">{setupSecret}</span>
+            </p>
+          ) : (
+            <p className="mb-4">
+              Enter the code from your authenticator app, or a recovery code.
+            </p>
+          )}
+          <input
+            type="text"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            placeholder="Code"
+            autoComplete="one-time-code"
+            className="w-full p-2 mb-4 border rounded"
+          />
+          <button
+            type="submit"
+            className="w-full p-2 text-white bg-blue-500 rounded"
+          >
+            Verify
+          </button>
+        </form>
+      </div>
+    );
+  }
+
   return (
     <div className="flex items-center justify-center h-screen bg-gray-100">
       <form
@@ -59,6 +195,17 @@ const Login = () => {
         >
           Login
         </button>
+        <Link to="/forgot-password" className="block mt-4 text-blue-500">
+          Forgot your password?
+        </Link>
+        {ssoEnabled && (
+          <a
+            href={`${apiClient.baseURL}/auth/oidc/login`}
+            className="block w-full p-2 mt-4 text-center border rounded"
+          >
+            Sign in with SSO
+          </a>
+        )}
       </form>
     </div>
   );
@@ -0,0 +1,60 @@
+import { useState } from "react";
+import { Link, useNavigate, useSearchParams } from "react-router-dom";
+import apiClient from "../api/apiClient";
+import { AxiosError } from "axios";
+
+const ResetPassword = () => {
+  const [searchParams] = useSearchParams();
+  const [password, setPassword] = useState("");
+  const [error, setError] = useState("");
+  const navigate = useNavigate();
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+    try {
+      await apiClient.post("/auth/password/reset", {
+        token: searchParams.get("token"),
+        password,
+      });
+      navigate("/login");
+    } catch (err) {
+      if (err instanceof AxiosError && err.response) {
+        setError(err.response.data.error || "Could not reset your password.");
+      } else {
+        setError("An unexpected error occurred. Please try again.");
+      }
+    }
+  };
+
+  return (
+    <div className="flex items-center justify-center h-screen bg-gray-100">
+      <form
+        onSubmit={handleSubmit}
+        className="p-8 bg-white rounded shadow-md w-96"
+      >
+        <h2 className="text-2xl font-bold mb-4">Choose a new password</h2>
+        {error && <p className="text-red-500 mb-4">{error}</p>}
+        <input
+          type="password"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+          placeholder="New password"
+          autoComplete="new-password"
+          className="w-full p-2 mb-4 border rounded"
+        />
+        <button
+          type="submit"
+          className="w-full p-2 text-white bg-blue-500 rounded"
+        >
+          Reset password
+        </button>
+        <Link to="/forgot-password" className="block mt-4 text-blue-500">
+          Send a new link
+        </Link>
+      </form>
+    </div>
+  );
+};
+
+export default ResetPassword;
@@ -13,17 +13,23 @@ interface SharedFile {
 const SharedWithMe = () => {
   const [files, setFiles] = useState<SharedFile[]>([]);
   const [error, setError] = useState("");
+  const [nextCursor, setNextCursor] = useState("");
+
+  const fetchSharedFiles = async (cursor?: string) => {
+    try {
+      const response = await apiClient.get("/files/shared-with-me", {
+        params: cursor ? { cursor } : undefined,
+      });
+      const items: SharedFile[] = response.data?.items || [];
+      setFiles((prev) => (cursor ? [...prev, ...items] : items));
+      setNextCursor(response.data?.next_cursor || "");
+    } catch (err) {
+      console.error("Failed to fetch shared files", err);
+      setError("Could not load files shared with you.");
+    }
+  };
 
   useEffect(() => {
-    const fetchSharedFiles = async () => {
-      try {
-        const response = await apiClient.get("/files/shared-with-me");
-        setFiles(response.data || []);
-      } catch (err) {
-        console.error("Failed to fetch shared files", err);
-        setError("Could not load files shared with you.");
-      }
-    };
     fetchSharedFiles();
   }, []);
 
@@ -108,6 +114,16 @@ const SharedWithMe = () => {
                 ))}
               </tbody>
             </table>
+            {nextCursor && (
+              <div className="flex justify-center p-4">
+                <button
+                  onClick={() => fetchSharedFiles(nextCursor)}
+                  className="text-blue-500 hover:underline"
+                >
+                  Load more
+                </button>
+              </div>
+            )}
           </div>
         )}
       </div>
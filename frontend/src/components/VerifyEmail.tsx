@@ -0,0 +1,43 @@
+import { useEffect, useRef, useState } from "react";
+import { Link, useSearchParams } from "react-router-dom";
+import apiClient from "../api/apiClient";
+import { AxiosError } from "axios";
+
+const VerifyEmail = () => {
+  const [searchParams] = useSearchParams();
+  const [message, setMessage] = useState("Verifying your email address...");
+  const [error, setError] = useState("");
+  // The token can only be used once, so don't send it twice in development's strict mode.
+  const sent = useRef(false);
+
+  useEffect(() => {
+    if (sent.current) return;
+    sent.current = true;
+    apiClient
+      .post("/auth/email/verify", { token: searchParams.get("token") })
+      .then((response) => setMessage(response.data.message))
+      .catch((err) => {
+        setMessage("");
+        if (err instanceof AxiosError && err.response) {
+          setError(err.response.data.error || "Could not verify your email.");
+        } else {
+          setError("An unexpected error occurred. Please try again.");
+        }
+      });
+  }, [searchParams]);
+
+  return (
+    <div className="flex items-center justify-center h-screen bg-gray-100">
+      <div className="p-8 bg-white rounded shadow-md w-96">
+        <h2 className="text-2xl font-bold mb-4">Email verification</h2>
+        {error && <p className="text-red-500 mb-4">{error}</p>}
+        {message && <p className="mb-4">{message}</p>}
+        <Link to="/" className="block text-blue-500">
+          Continue
+        </Link>
+      </div>
+    </div>
+  );
+};
+
+export default VerifyEmail;
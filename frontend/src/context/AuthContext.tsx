@@ -1,9 +1,9 @@
 import React, { createContext, useState, useContext } from "react";
-// import apiClient from "../api/apiClient";
+import apiClient from "../api/apiClient";
 
 interface AuthContextType {
   isAuthenticated: boolean;
-  login: (token: string) => void;
+  login: (token: string, refreshToken: string) => void;
   logout: () => void;
 }
 
@@ -14,13 +14,17 @@ export const AuthProvider = ({ children }: { children: React.ReactNode }) => {
     !!localStorage.getItem("authToken")
   );
 
-  const login = (token: string) => {
+  const login = (token: string, refreshToken: string) => {
     localStorage.setItem("authToken", token);
+    localStorage.setItem("refreshToken", refreshToken);
     setIsAuthenticated(true);
   };
 
   const logout = () => {
+    // End the session on the server too, so the refresh token can't be used again.
+    apiClient.post("/auth/logout").catch(() => {});
     localStorage.removeItem("authToken");
+    localStorage.removeItem("refreshToken");
     setIsAuthenticated(false);
   };
 
@@ -1,9 +1,20 @@
 package api
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/karanbihani/file-vault/internal/core/admin"
+	"github.com/karanbihani/file-vault/internal/core/audit"
+	"github.com/karanbihani/file-vault/internal/core/rbac"
+	"github.com/karanbihani/file-vault/internal/pagination"
 )
 
 type AdminHandler struct {
@@ -14,8 +25,15 @@ func NewAdminHandler(service *admin.Service) *AdminHandler {
 	return &AdminHandler{adminService: service}
 }
 
+// ListAllFiles handles GET /admin/files. It is paged with limit and cursor and can be sorted
+// with sort (name, size, upload_date or mime) and order (asc or desc).
 func (h *AdminHandler) ListAllFiles(c *gin.Context) {
-	files, err := h.adminService.ListAllFiles(c.Request.Context())
+	page, ok := pageRequest(c, pagination.FileSorts, pagination.SortUploadDate)
+	if !ok {
+		return
+	}
+
+	files, err := h.adminService.ListAllFiles(c.Request.Context(), page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -32,11 +50,411 @@ func (h *AdminHandler) GetSystemStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+// auditLogExportFlushEvery is how many exported entries are written between flushes.
+const auditLogExportFlushEvery = 500
+
+// auditLogFilter reads the audit log filters: user_id, action (exact, or a prefix such as
+// "share:*"), file_id, request_id, and start_date and end_date in RFC 3339. If one is invalid
+// it responds with 400 and returns false.
+func auditLogFilter(c *gin.Context) (admin.AuditLogFilter, bool) {
+	filter := admin.AuditLogFilter{Action: c.Query("action"), RequestID: c.Query("request_id")}
+	for name, dst := range map[string]**int64{"user_id": &filter.UserID, "file_id": &filter.FileID} {
+		if v := c.Query(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+				return admin.AuditLogFilter{}, false
+			}
+			*dst = &id
+		}
+	}
+	var ok bool
+	if filter.StartDate, filter.EndDate, ok = dateRange(c); !ok {
+		return admin.AuditLogFilter{}, false
+	}
+	return filter, true
+}
+
+// dateRange reads the start_date and end_date query parameters, in RFC 3339, either of which
+// may be missing. If one is invalid it responds with 400 and returns false.
+func dateRange(c *gin.Context) (*time.Time, *time.Time, bool) {
+	var start, end *time.Time
+	for name, dst := range map[string]**time.Time{"start_date": &start, "end_date": &end} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + "; use RFC 3339, e.g. 2024-01-31T00:00:00Z"})
+				return nil, nil, false
+			}
+			*dst = &t
+		}
+	}
+	return start, end, true
+}
+
+// ListAuditLogs handles GET /admin/logs. It takes the filters read by auditLogFilter, and is
+// paged with limit and cursor, newest first unless order is asc.
 func (h *AdminHandler) ListAuditLogs(c *gin.Context) {
-	logs, err := h.adminService.ListAuditLogs(c.Request.Context())
+	filter, ok := auditLogFilter(c)
+	if !ok {
+		return
+	}
+	page, ok := pageRequest(c, pagination.AuditLogSorts, pagination.SortTimestamp)
+	if !ok {
+		return
+	}
+
+	logs, err := h.adminService.ListAuditLogs(c.Request.Context(), filter, page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, logs)
 }
+
+// ExportAuditLogs handles GET /admin/logs/export. It takes the same filters as ListAuditLogs
+// and streams every matching entry, oldest first, as CSV, or with format=ndjson as one JSON
+// object per line.
+func (h *AdminHandler) ExportAuditLogs(c *gin.Context) {
+	filter, ok := auditLogFilter(c)
+	if !ok {
+		return
+	}
+
+	var write func(admin.AuditLogEntry) error
+	var flush func() error
+	format := c.DefaultQuery("format", "csv")
+	switch format {
+	case "csv":
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write([]string{"id", "timestamp", "user_id", "action", "details", "prev_hash", "hash", "ip_address", "user_agent", "request_id"}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		write = func(e admin.AuditLogEntry) error {
+			userID := ""
+			if e.UserID != nil {
+				userID = strconv.FormatInt(*e.UserID, 10)
+			}
+			prevHash, hash := "", ""
+			if e.Hash != nil {
+				prevHash, hash = *e.PrevHash, *e.Hash
+			}
+			return w.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.Timestamp.UTC().Format(time.RFC3339Nano),
+				userID,
+				e.Action,
+				string(e.Details),
+				prevHash,
+				hash,
+				e.IPAddress,
+				e.UserAgent,
+				e.RequestID,
+			})
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	case "ndjson":
+		enc := json.NewEncoder(c.Writer)
+		write = func(e admin.AuditLogEntry) error { return enc.Encode(e) }
+		flush = func() error { return nil }
+		c.Header("Content-Type", "application/x-ndjson")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'csv' or 'ndjson'"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-logs-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), format))
+
+	exported := 0
+	err := h.adminService.ExportAuditLogs(c.Request.Context(), filter, func(e admin.AuditLogEntry) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		exported++
+		if exported%auditLogExportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// Once the export has started streaming, the status can't change; the client sees a
+		// truncated file.
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("ERROR: audit log export failed after %d entries: %v", exported, err)
+	}
+}
+
+// VerifyAuditLog handles GET /admin/logs/verify. It checks the audit log's hash chain and
+// checkpoints; if they don't hold, "valid" is false and "break" says where.
+func (h *AdminHandler) VerifyAuditLog(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	result, err := h.adminService.VerifyAuditLog(c.Request.Context(), adminID.(int64))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ListAuditArchive handles GET /admin/logs/archive. It lists the segments of old entries that
+// were moved to object storage, optionally only those with entries between start_date and
+// end_date.
+func (h *AdminHandler) ListAuditArchive(c *gin.Context) {
+	start, end, ok := dateRange(c)
+	if !ok {
+		return
+	}
+
+	segments, err := h.adminService.ListAuditArchive(c.Request.Context(), start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": segments})
+}
+
+// auditArchiveErrorStatus maps errors from reading archived segments to HTTP status codes.
+func auditArchiveErrorStatus(err error) int {
+	if errors.Is(err, audit.ErrSegmentNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// DownloadAuditArchiveSegment handles GET /admin/logs/archive/:segmentId. It serves the
+// segment as it is stored: gzipped NDJSON, one entry per line.
+func (h *AdminHandler) DownloadAuditArchiveSegment(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	segmentID, err := strconv.ParseInt(c.Param("segmentId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid segment ID"})
+		return
+	}
+
+	seg, object, err := h.adminService.OpenAuditArchiveSegment(c.Request.Context(), adminID.(int64), segmentID)
+	if err != nil {
+		c.JSON(auditArchiveErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer object.Close()
+
+	filename := fmt.Sprintf("audit-log-%d-%d.ndjson.gz", seg.FirstEntryID, seg.LastEntryID)
+	serveDownload(c, object, filename, "application/gzip", seg.Sha256Hash)
+}
+
+// QueryAuditArchiveSegment handles GET /admin/logs/archive/:segmentId/entries. It takes the
+// same filters as ListAuditLogs and returns every matching entry in the segment, oldest first.
+func (h *AdminHandler) QueryAuditArchiveSegment(c *gin.Context) {
+	segmentID, err := strconv.ParseInt(c.Param("segmentId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid segment ID"})
+		return
+	}
+	filter, ok := auditLogFilter(c)
+	if !ok {
+		return
+	}
+
+	entries, err := h.adminService.QueryAuditArchiveSegment(c.Request.Context(), segmentID, filter)
+	if err != nil {
+		c.JSON(auditArchiveErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+// adminErrorStatus maps errors from admin user management to HTTP status codes.
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, admin.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, admin.ErrInvalidQuota), errors.Is(err, admin.ErrInvalidTransferTarget):
+		return http.StatusBadRequest
+	case errors.Is(err, admin.ErrCannotModifySelf):
+		return http.StatusForbidden
+	case errors.Is(err, rbac.ErrLastAdmin), errors.Is(err, admin.ErrFilesAddedDuringDelete):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ListUsers handles GET /admin/users. It takes optional email (substring), role and
+// status ("active" or "suspended") filters, and limit and offset for paging.
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	filter := admin.UserFilter{
+		Email: c.Query("email"),
+		Role:  c.Query("role"),
+	}
+	switch status := c.Query("status"); status {
+	case "":
+	case "active", "suspended":
+		suspended := status == "suspended"
+		filter.Suspended = &suspended
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be 'active' or 'suspended'"})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		filter.Limit = int32(limit)
+	}
+	if v := c.Query("offset"); v != "" {
+		offset, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+			return
+		}
+		filter.Offset = int32(offset)
+	}
+
+	page, err := h.adminService.ListUsers(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	user, err := h.adminService.GetUser(c.Request.Context(), targetUserID)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// SetUserQuota handles PUT /admin/users/:id/quota.
+func (h *AdminHandler) SetUserQuota(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	var req struct {
+		StorageQuotaBytes *int64 `json:"storage_quota_bytes" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	user, err := h.adminService.SetUserQuota(c.Request.Context(), adminID.(int64), targetUserID, *req.StorageQuotaBytes)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// SuspendUser handles POST /admin/users/:id/suspend.
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	user, err := h.adminService.SuspendUser(c.Request.Context(), adminID.(int64), targetUserID)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ReactivateUser handles POST /admin/users/:id/reactivate.
+func (h *AdminHandler) ReactivateUser(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	user, err := h.adminService.ReactivateUser(c.Request.Context(), adminID.(int64), targetUserID)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser handles DELETE /admin/users/:id. With ?transfer_to=<user ID> the user's files are
+// given to that user; otherwise they are purged.
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+	var params admin.DeleteUserParams
+	if v := c.Query("transfer_to"); v != "" {
+		if params.TransferTo, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transfer_to user ID"})
+			return
+		}
+	}
+
+	result, err := h.adminService.DeleteUser(c.Request.Context(), adminID.(int64), targetUserID, params)
+	if err != nil {
+		c.JSON(adminErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/karanbihani/file-vault/internal/auth" // Adjust to your module path
@@ -33,7 +36,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	
 	user, err := h.authService.RegisterUser(c.Request.Context(), params)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(passwordErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -42,6 +45,29 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	c.JSON(http.StatusCreated, user)
 }
 
+// passwordErrorStatus maps an error from setting a password to its HTTP status code.
+func passwordErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrPasswordTooShort), errors.Is(err, auth.ErrPasswordTooLong),
+		errors.Is(err, auth.ErrPasswordBreached):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// setRetryAfter sets the Retry-After header if err means the client has to wait before
+// trying to log in again, and reports whether it did.
+func setRetryAfter(c *gin.Context, err error) bool {
+	var throttled *auth.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	seconds := int64(throttled.RetryAfter.Seconds()) + 1
+	c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	return true
+}
+
 // Login handles the POST /login endpoint.
 func (h *AuthHandler) Login(c *gin.Context) {
 	var params auth.LoginUserParams
@@ -49,13 +75,656 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
 		return
 	}
+	params.UserAgent = c.Request.UserAgent()
+	params.IPAddress = c.ClientIP()
+
+	result, err := h.authService.LoginUser(c.Request.Context(), params)
+	if err != nil {
+		if setRetryAfter(c, err) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			// Send a 401 Unauthorized status for invalid credentials.
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Refresh handles the POST /auth/refresh endpoint. The refresh token in the body is
+// exchanged for a new access token and a new refresh token.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	tokens, err := h.authService.RefreshSession(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout handles the POST /auth/logout endpoint, ending the session the request was made with.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID, exists := c.Get("sessionID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session ID not found in context"})
+		return
+	}
+
+	if err := h.authService.Logout(c.Request.Context(), userID.(int64), sessionID.(int64)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// ListSessions handles the GET /auth/sessions endpoint.
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID, exists := c.Get("sessionID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session ID not found in context"})
+		return
+	}
 
-	token, err := h.authService.LoginUser(c.Request.Context(), params)
+	sessions, err := h.authService.ListSessions(c.Request.Context(), userID.(int64), sessionID.(int64))
 	if err != nil {
-		// Send a 401 Unauthorized status for invalid credentials.
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession handles the DELETE /auth/sessions/:id endpoint.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
 		return
 	}
+	if rejectAPIKey(c) {
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		return
+	}
+
+	if err := h.authService.RevokeSession(c.Request.Context(), userID.(int64), sessionID); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+// UnlockUser handles the POST /admin/users/:id/unlock endpoint, ending a lockout after too
+// many failed logins.
+func (h *AuthHandler) UnlockUser(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	wasLocked, err := h.authService.UnlockAccount(c.Request.Context(), adminID.(int64), targetUserID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"was_locked": wasLocked})
+}
+
+// RevokeUserSessions handles the DELETE /admin/users/:id/sessions endpoint, signing a user
+// out everywhere.
+func (h *AuthHandler) RevokeUserSessions(c *gin.Context) {
+	adminID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	revoked, err := h.authService.RevokeAllSessions(c.Request.Context(), adminID.(int64), targetUserID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrAPIKeyNameTaken):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrInvalidAPIKeyName), errors.Is(err, auth.ErrNoScopes),
+		errors.Is(err, auth.ErrInvalidScope), errors.Is(err, auth.ErrInvalidKeyExpiry):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// rejectAPIKey stops requests made with an API key from changing how the user authenticates,
+// e.g. minting more keys or turning off two-factor authentication.
+func rejectAPIKey(c *gin.Context) bool {
+	if _, ok := c.Get("apiKeyID"); ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "this cannot be done with an API key; log in instead"})
+		return true
+	}
+	return false
+}
+
+// ListAPIKeys handles the GET /auth/api-keys endpoint.
+func (h *AuthHandler) ListAPIKeys(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	keys, err := h.authService.ListAPIKeys(c.Request.Context(), userID.(int64))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey handles the POST /auth/api-keys endpoint. The key is only ever shown in this response.
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	if rejectAPIKey(c) {
+		return
+	}
+
+	var params auth.CreateAPIKeyParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	key, err := h.authService.CreateAPIKey(c.Request.Context(), userID.(int64), params)
+	if err != nil {
+		c.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// DeleteAPIKey handles the DELETE /auth/api-keys/:id endpoint.
+func (h *AuthHandler) DeleteAPIKey(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	if rejectAPIKey(c) {
+		return
+	}
+
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key ID"})
+		return
+	}
+
+	if err := h.authService.DeleteAPIKey(c.Request.Context(), userID.(int64), keyID); err != nil {
+		c.JSON(apiKeyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+func twoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrInvalidChallenge), errors.Is(err, auth.ErrInvalidTwoFactorCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrTwoFactorRequiredByRole), errors.Is(err, auth.ErrAccountSuspended):
+		return http.StatusForbidden
+	case errors.Is(err, auth.ErrTwoFactorAlreadyEnabled):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrTwoFactorNotEnabled), errors.Is(err, auth.ErrTwoFactorNotPending):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CompleteTwoFactorLogin handles the POST /login/2fa endpoint, the second step of logging in
+// for users with two-factor authentication on.
+func (h *AuthHandler) CompleteTwoFactorLogin(c *gin.Context) {
+	var params auth.CompleteLoginParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	params.UserAgent = c.Request.UserAgent()
+	params.IPAddress = c.ClientIP()
+
+	tokens, err := h.authService.CompleteTwoFactorLogin(c.Request.Context(), params)
+	if err != nil {
+		if setRetryAfter(c, err) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// BeginTwoFactorSetup handles the POST /login/2fa/setup endpoint, for users who must enroll
+// before they can log in.
+func (h *AuthHandler) BeginTwoFactorSetup(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	enrollment, err := h.authService.BeginTwoFactorSetup(c.Request.Context(), req.ChallengeToken)
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// CompleteTwoFactorSetup handles the POST /login/2fa/setup/confirm endpoint.
+func (h *AuthHandler) CompleteTwoFactorSetup(c *gin.Context) {
+	var params auth.CompleteLoginParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	params.UserAgent = c.Request.UserAgent()
+	params.IPAddress = c.ClientIP()
+
+	result, err := h.authService.CompleteTwoFactorSetup(c.Request.Context(), params)
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetTwoFactorStatus handles the GET /auth/2fa endpoint.
+func (h *AuthHandler) GetTwoFactorStatus(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	status, err := h.authService.GetTwoFactorStatus(c.Request.Context(), userID.(int64))
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// BeginTwoFactorEnrollment handles the POST /auth/2fa/enroll endpoint. The returned URI can
+// be shown as a QR code for authenticator apps.
+func (h *AuthHandler) BeginTwoFactorEnrollment(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	if rejectAPIKey(c) {
+		return
+	}
+
+	enrollment, err := h.authService.BeginTwoFactorEnrollment(c.Request.Context(), userID.(int64))
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// bindTwoFactorCode reads the 'code' field that confirms a two-factor change.
+func bindTwoFactorCode(c *gin.Context) (string, bool) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return "", false
+	}
+	return req.Code, true
+}
+
+// ConfirmTwoFactorEnrollment handles the POST /auth/2fa/confirm endpoint. The response holds
+// the user's recovery codes, which are not shown again.
+func (h *AuthHandler) ConfirmTwoFactorEnrollment(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	if rejectAPIKey(c) {
+		return
+	}
+	code, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.authService.ConfirmTwoFactorEnrollment(c.Request.Context(), userID.(int64), code)
+	if err != nil {
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor handles the POST /auth/2fa/disable endpoint.
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	if rejectAPIKey(c) {
+		return
+	}
+	code, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	if err := h.authService.DisableTwoFactor(c.Request.Context(), userID.(int64), code, c.ClientIP()); err != nil {
+		if setRetryAfter(c, err) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes handles the POST /auth/2fa/recovery-codes endpoint.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	if rejectAPIKey(c) {
+		return
+	}
+	code, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.authService.RegenerateRecoveryCodes(c.Request.Context(), userID.(int64), code, c.ClientIP())
+	if err != nil {
+		if setRetryAfter(c, err) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(twoFactorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// ssoStateCookie holds the signed SSO state between the redirect to the identity provider
+// and the redirect back. It is only sent to the OIDC endpoints.
+const (
+	ssoStateCookie     = "oidc_state"
+	ssoStateCookiePath = "/api/v1/auth/oidc"
+)
+
+func ssoErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrSSONotConfigured):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrSSOInvalidState), errors.Is(err, auth.ErrSSOFailed):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrSSOEmailNotVerified):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrAccountSuspended):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// SSOStatus handles the GET /auth/oidc endpoint, so the login page knows whether to offer
+// single sign-on.
+func (h *AuthHandler) SSOStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": h.authService.SSOEnabled()})
+}
+
+// BeginSSOLogin handles the GET /auth/oidc/login endpoint by redirecting the browser to the
+// identity provider.
+func (h *AuthHandler) BeginSSOLogin(c *gin.Context) {
+	start, err := h.authService.BeginSSOLogin(c.Request.Context())
+	if err != nil {
+		c.JSON(ssoErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// Lax, not Strict: the cookie must come back on the provider's top-level redirect.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, start.StateCookie, 600, ssoStateCookiePath, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, start.AuthURL)
+}
+
+// SSOCallback handles the GET /auth/oidc/callback endpoint the identity provider redirects
+// back to. The browser is sent on to the frontend with the login result in the URL fragment,
+// which is never sent to a server.
+func (h *AuthHandler) SSOCallback(c *gin.Context) {
+	stateCookie, _ := c.Cookie(ssoStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, "", -1, ssoStateCookiePath, "", c.Request.TLS != nil, true)
+
+	var result *auth.LoginResult
+	var err error
+	if providerErr := c.Query("error"); providerErr != "" {
+		err = fmt.Errorf("%w: %s %s", auth.ErrSSOFailed, providerErr, c.Query("error_description"))
+	} else {
+		result, err = h.authService.CompleteSSOLogin(c.Request.Context(), auth.SSOCallbackParams{
+			Code:        c.Query("code"),
+			State:       c.Query("state"),
+			StateCookie: stateCookie,
+			UserAgent:   c.Request.UserAgent(),
+			IPAddress:   c.ClientIP(),
+		})
+	}
+
+	frontendURL := h.authService.SSOFrontendURL()
+	if frontendURL == "" {
+		if err != nil {
+			c.JSON(ssoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	fragment := url.Values{}
+	switch {
+	case err != nil:
+		fragment.Set("error", err.Error())
+	case result.TokenPair != nil:
+		fragment.Set("token", result.AccessToken)
+		fragment.Set("refresh_token", result.RefreshToken)
+		fragment.Set("expires_in", strconv.FormatInt(result.ExpiresIn, 10))
+	default:
+		fragment.Set("challenge_token", result.ChallengeToken)
+		fragment.Set("two_factor_required", strconv.FormatBool(result.TwoFactorRequired))
+		fragment.Set("two_factor_setup_required", strconv.FormatBool(result.TwoFactorSetupRequired))
+	}
+	c.Redirect(http.StatusFound, frontendURL+"#"+fragment.Encode())
+}
+
+func accountTokenErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrInvalidAccountToken):
+		return http.StatusBadRequest
+	case errors.Is(err, auth.ErrEmailAlreadyVerified):
+		return http.StatusConflict
+	case errors.Is(err, auth.ErrUserNotFound):
+		return http.StatusNotFound
+	default:
+		return passwordErrorStatus(err)
+	}
+}
+
+// SendVerificationEmail handles the POST /auth/email/verification endpoint, which emails the
+// user a new link to verify their address.
+func (h *AuthHandler) SendVerificationEmail(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	if err := h.authService.SendVerificationEmail(c.Request.Context(), userID.(int64)); err != nil {
+		c.JSON(accountTokenErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+}
+
+// VerifyEmail handles the POST /auth/email/verify endpoint with the token from a
+// verification email. It doesn't need the user to be logged in.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.authService.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		c.JSON(accountTokenErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email address verified"})
+}
+
+// ForgotPassword handles the POST /auth/password/forgot endpoint. The response is the same
+// whether or not the email belongs to an account.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.authService.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "if an account with this email exists, a password reset link has been sent to it"})
+}
+
+// ResetPassword handles the POST /auth/password/reset endpoint with the token from a
+// password reset email and the new password.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var params auth.ResetPasswordParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	keysDeleted, err := h.authService.ResetPassword(c.Request.Context(), params)
+	if err != nil {
+		c.JSON(accountTokenErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	message := "password changed; log in with your new password"
+	if keysDeleted > 0 {
+		message = fmt.Sprintf("password changed and %d API key(s) deleted; log in with your new password and create new keys", keysDeleted)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "api_keys_deleted": keysDeleted})
 }
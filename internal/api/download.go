@@ -0,0 +1,45 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveDownload streams a stored object as an attachment. It is built on http.ServeContent,
+// which handles single and multi-range requests (206 Partial Content, 416 for unsatisfiable
+// ranges) as well as If-None-Match, If-Range and the other conditional request headers.
+//
+// The ETag is the content's SHA-256, so it changes exactly when the bytes do and is identical
+// for every copy of the same content.
+func serveDownload(c *gin.Context, content io.ReadSeeker, filename, mimeType, sha256Hash string) {
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Header("Content-Type", mimeType)
+	c.Header("ETag", `"`+sha256Hash+`"`)
+	c.Header("Cache-Control", "private, no-cache")
+
+	// Stored objects are immutable and addressed by hash, so the ETag is the only validator.
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, content)
+}
+
+// serveArchive streams a ZIP archive produced by write as an attachment named name + ".zip".
+// The archive is built on the fly, so its length isn't known and range requests aren't
+// supported. Once streaming has started an error can no longer be reported to the client;
+// it is logged and the connection is cut short, leaving the client with a truncated ZIP.
+func serveArchive(c *gin.Context, name string, write func(w io.Writer) error) {
+	c.Header("Content-Disposition", "attachment; filename="+name+".zip")
+	c.Header("Content-Type", "application/zip")
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+
+	if err := write(c.Writer); err != nil {
+		log.Printf("ERROR: failed to stream archive %q: %v", name, err)
+		c.Abort()
+	}
+}
@@ -1,17 +1,48 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/auth"
 	"github.com/karanbihani/file-vault/internal/core/files" // Adjust path
 	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/pagination"
+	"github.com/karanbihani/file-vault/internal/storage"
 )
 
+// maxFormFieldBytes caps how much of a non-file form field is read into memory.
+const maxFormFieldBytes = 64 << 10
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// remaining is how much of a body of length contentLength is left to read, or -1 if the length
+// isn't known. It may fall short by what the multipart reader has buffered, which only makes
+// the quota check it is used for more lenient.
+func (b *countingBody) remaining(contentLength int64) int64 {
+	if contentLength < 0 {
+		return -1
+	}
+	return contentLength - b.n
+}
+
 // ... (FilesHandler struct and NewFilesHandler are the same)
 type FilesHandler struct {
 	fileService *files.Service
@@ -30,63 +61,109 @@ func (h *FilesHandler) Upload(c *gin.Context) {
 		return
 	}
 
-	// --- START OF FIX ---
-	// Use MultipartForm to handle multiple files.
-	form, err := c.MultipartForm()
+	// Read the multipart body part by part instead of letting Gin spool the whole form
+	// to memory or disk first. Each file part is handed to the service as a stream, so the
+	// 'description', 'tags' and 'folder_id' fields must come before the first file; they apply
+	// to every file in the request.
+	body := &countingBody{ReadCloser: c.Request.Body}
+	c.Request.Body = body
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form: " + err.Error()})
 		return
 	}
-	// The "files" key can now contain multiple file parts.
-	formFiles := form.File["files"]
-
-	if len(formFiles) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one file is required in the 'files' form field"})
-		return
-	}
 
+	var description string
+	var tags []string
+	var folderID pgtype.Int8
 	var uploadedFiles []db.UserFile
+	sawFile := false
 
-	// Loop through each file from the form.
-	for _, header := range formFiles {
-		file, err := header.Open()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			// Skip this file and continue with the next ones.
-			log.Printf("ERROR: could not open file %s: %v", header.Filename, err)
-			continue
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form: " + err.Error()})
+			return
 		}
-		defer file.Close()
 
-		description := c.PostForm("description") // Description will be the same for all files in the batch
-		tags := c.PostFormArray("tags")
-		if len(tags) == 1 && strings.Contains(tags[0], ",") {
-			tags = strings.Split(tags[0], ",")
+		if sawFile && isUploadMetadataField(part.FormName()) {
+			part.Close()
+			// The files before it have already been stored without it.
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":    fmt.Sprintf("the '%s' field must come before the first file", part.FormName()),
+				"uploaded": uploadedFiles,
+			})
+			return
 		}
 
-		uploadParams := files.UploadFileParams{
-			File:        file,
-			Filename:    header.Filename,
-			ContentType: header.Header.Get("Content-Type"),
-			OwnerID:     userID.(int64),
-			Description: description,
-			Tags:        tags,
-		}
+		switch part.FormName() {
+		case "description":
+			value, _ := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			description = string(value)
+		case "tags":
+			value, _ := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			for _, tag := range strings.Split(string(value), ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		case "folder_id":
+			value, _ := io.ReadAll(io.LimitReader(part, maxFormFieldBytes))
+			id, err := strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+			if err != nil {
+				part.Close()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+				return
+			}
+			folderID = pgtype.Int8{Int64: id, Valid: true}
+		case "files":
+			if part.FileName() == "" {
+				break
+			}
+			sawFile = true
 
-		userFile, err := h.fileService.UploadFile(c.Request.Context(), uploadParams)
-		if err != nil {
-			// If one file fails, we can decide to stop or continue.
-			// Here, we'll log the error and continue with the other files.
-			log.Printf("ERROR: failed to upload file %s: %v", header.Filename, err)
-			continue
+			uploadParams := files.UploadFileParams{
+				File:        part,
+				Filename:    part.FileName(),
+				ContentType: part.Header.Get("Content-Type"),
+				// The rest of the request is the most this file can be, so a request that
+				// can't fit is rejected before the file is read.
+				DeclaredSize: body.remaining(c.Request.ContentLength),
+				OwnerID:      userID.(int64),
+				Description:  description,
+				Tags:         tags,
+				FolderID:     folderID,
+			}
+
+			userFile, err := h.fileService.UploadFile(c.Request.Context(), uploadParams)
+			if err != nil {
+				// If one file fails, we log the error and continue with the other files.
+				log.Printf("ERROR: failed to upload file %s: %v", part.FileName(), err)
+				break
+			}
+			uploadedFiles = append(uploadedFiles, *userFile)
 		}
-		uploadedFiles = append(uploadedFiles, *userFile)
+		part.Close()
+	}
+
+	if !sawFile {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one file is required in the 'files' form field"})
+		return
 	}
-	// --- END OF FIX ---
 
 	c.JSON(http.StatusOK, uploadedFiles)
 }
 
-// List now gets the ownerID from the context.
+// isUploadMetadataField reports whether an upload form field describes the files in it.
+func isUploadMetadataField(name string) bool {
+	return name == "description" || name == "tags" || name == "folder_id"
+}
+
+// List now gets the ownerID from the context. It returns a page of the user's files; see
+// pageRequest for the paging and sorting parameters.
 func (h *FilesHandler) List(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -94,7 +171,12 @@ func (h *FilesHandler) List(c *gin.Context) {
 		return
 	}
 
-	files, err := h.fileService.ListFiles(c.Request.Context(), userID.(int64))
+	page, ok := pageRequest(c, pagination.FileSorts, pagination.SortUploadDate)
+	if !ok {
+		return
+	}
+
+	files, err := h.fileService.ListFiles(c.Request.Context(), userID.(int64), page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -124,10 +206,7 @@ func (h *FilesHandler) Download(c *gin.Context) {
 	}
 	defer downloadData.Data.Close()
 
-	c.Header("Content-Disposition", "attachment; filename="+downloadData.Filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", downloadData.Size))
-	c.DataFromReader(http.StatusOK, downloadData.Size, "application/octet-stream", downloadData.Data, nil)
+	serveDownload(c, downloadData.Data, downloadData.Filename, downloadData.MimeType, downloadData.Sha256Hash)
 }
 
 // Delete now gets the ownerID from the context.
@@ -146,11 +225,15 @@ func (h *FilesHandler) Delete(c *gin.Context) {
 
 	err = h.fileService.DeleteFile(c.Request.Context(), fileID, userID.(int64))
 	if err != nil {
+		if errors.Is(err, files.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "file deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "file moved to trash"})
 }
 
 // ListSharedWithMe is the handler for the GET /files/shared-with-me endpoint.
@@ -161,7 +244,12 @@ func (h *FilesHandler) ListSharedWithMe(c *gin.Context) {
 		return
 	}
 
-	files, err := h.fileService.ListFilesSharedWithMe(c.Request.Context(), userID.(int64))
+	page, ok := pageRequest(c, pagination.FileSorts, pagination.SortUploadDate)
+	if !ok {
+		return
+	}
+
+	files, err := h.fileService.ListFilesSharedWithMe(c.Request.Context(), userID.(int64), page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -191,7 +279,7 @@ func (h *FilesHandler) AddTag(c *gin.Context) {
    }
    // Call service
    if err := h.fileService.AddTag(c.Request.Context(), fileID, userID.(int64), body.Tag); err != nil {
-	   c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	   c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
 	   return
    }
    c.JSON(http.StatusOK, gin.H{"message": "tag added successfully"})
@@ -215,8 +303,277 @@ func (h *FilesHandler) RemoveTag(c *gin.Context) {
 	   return
    }
    if err := h.fileService.RemoveTag(c.Request.Context(), fileID, userID.(int64), body.Tag); err != nil {
-	   c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	   c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
 	   return
    }
    c.JSON(http.StatusOK, gin.H{"message": "tag removed successfully"})
-}
\ No newline at end of file
+}
+
+// Update handles PATCH /files/:id and renames a file and/or changes its description.
+// Omitted fields are left unchanged.
+func (h *FilesHandler) Update(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	var requestBody struct {
+		Filename    *string `json:"filename"`
+		Description *string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	userFile, err := h.fileService.UpdateFileDetails(c.Request.Context(), fileID, userID.(int64), requestBody.Filename, requestBody.Description)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, userFile)
+}
+
+// parseVersionParams reads the file ID and version number from the URL.
+func parseVersionParams(c *gin.Context) (int64, int32, bool) {
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return 0, 0, false
+	}
+	version, err := strconv.ParseInt(c.Param("version"), 10, 32)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version number"})
+		return 0, 0, false
+	}
+	return fileID, int32(version), true
+}
+
+// fileErrorStatus maps a files service error to its HTTP status code.
+func fileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, files.ErrFileNotFound), errors.Is(err, files.ErrVersionNotFound), errors.Is(err, storage.ErrObjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, files.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, auth.ErrInsufficientRole):
+		return http.StatusForbidden
+	case errors.Is(err, files.ErrInvalidFilename):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UploadVersion handles POST /files/:id/versions.
+// The first file part of the multipart body becomes the file's new current version.
+func (h *FilesHandler) UploadVersion(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	body := &countingBody{ReadCloser: c.Request.Body}
+	c.Request.Body = body
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form: " + err.Error()})
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form: " + err.Error()})
+			return
+		}
+		if part.FileName() == "" || (part.FormName() != "file" && part.FormName() != "files") {
+			part.Close()
+			continue
+		}
+
+		userFile, err := h.fileService.UploadFile(c.Request.Context(), files.UploadFileParams{
+			File:         part,
+			Filename:     part.FileName(),
+			ContentType:  part.Header.Get("Content-Type"),
+			// Only one file is read, so what is left of the body is at most its size.
+			DeclaredSize: body.remaining(c.Request.ContentLength),
+			OwnerID:      userID.(int64),
+			FileID:       fileID,
+		})
+		part.Close()
+		if err != nil {
+			c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, userFile)
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": "a file is required in the 'file' form field"})
+}
+
+// ListVersions handles GET /files/:id/versions.
+func (h *FilesHandler) ListVersions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	versions, err := h.fileService.ListVersions(c.Request.Context(), fileID, userID.(int64))
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// DownloadVersion handles GET /files/:id/versions/:version/download.
+func (h *FilesHandler) DownloadVersion(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, version, ok := parseVersionParams(c)
+	if !ok {
+		return
+	}
+
+	downloadData, err := h.fileService.DownloadVersion(c.Request.Context(), fileID, userID.(int64), version)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer downloadData.Data.Close()
+
+	serveDownload(c, downloadData.Data, downloadData.Filename, downloadData.MimeType, downloadData.Sha256Hash)
+}
+
+// RestoreVersion handles POST /files/:id/versions/:version/restore.
+func (h *FilesHandler) RestoreVersion(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, version, ok := parseVersionParams(c)
+	if !ok {
+		return
+	}
+
+	userFile, err := h.fileService.RestoreVersion(c.Request.Context(), fileID, userID.(int64), version)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, userFile)
+}
+
+// ListTrash handles GET /trash.
+func (h *FilesHandler) ListTrash(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	trashed, err := h.fileService.ListTrash(c.Request.Context(), userID.(int64))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, trashed)
+}
+
+// RestoreFromTrash handles POST /trash/:id/restore.
+func (h *FilesHandler) RestoreFromTrash(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	userFile, err := h.fileService.RestoreFile(c.Request.Context(), fileID, userID.(int64))
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, userFile)
+}
+
+// PurgeFromTrash handles DELETE /trash/:id and permanently deletes a trashed file.
+func (h *FilesHandler) PurgeFromTrash(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	if err := h.fileService.PurgeFile(c.Request.Context(), fileID, userID.(int64)); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "file deleted permanently"})
+}
+
+// EmptyTrash handles DELETE /trash and permanently deletes every trashed file.
+func (h *FilesHandler) EmptyTrash(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	deleted, err := h.fileService.EmptyTrash(c.Request.Context(), userID.(int64))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "trash emptied", "deleted_files": deleted})
+}
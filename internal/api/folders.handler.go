@@ -0,0 +1,264 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/core/archive"
+	"github.com/karanbihani/file-vault/internal/core/files"
+	"github.com/karanbihani/file-vault/internal/core/folders"
+)
+
+type FoldersHandler struct {
+	folderService *folders.Service
+}
+
+func NewFoldersHandler(service *folders.Service) *FoldersHandler {
+	return &FoldersHandler{
+		folderService: service,
+	}
+}
+
+// folderErrorStatus maps a folder service error to its HTTP status code.
+func folderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, folders.ErrFolderNotFound), errors.Is(err, files.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, folders.ErrFolderExists), errors.Is(err, folders.ErrFolderNotEmpty):
+		return http.StatusConflict
+	case errors.Is(err, folders.ErrInvalidName), errors.Is(err, folders.ErrInvalidMove):
+		return http.StatusBadRequest
+	case errors.Is(err, archive.ErrNoFiles), errors.Is(err, archive.ErrTooManyFiles):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// optionalID converts a nullable JSON ID into its database form.
+func optionalID(id *int64) pgtype.Int8 {
+	if id == nil {
+		return pgtype.Int8{}
+	}
+	return pgtype.Int8{Int64: *id, Valid: true}
+}
+
+// Create handles POST /folders. A missing or null 'parent_id' creates the folder at the root.
+func (h *FoldersHandler) Create(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	var requestBody struct {
+		Name     string `json:"name" binding:"required"`
+		ParentID *int64 `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: 'name' field is required"})
+		return
+	}
+
+	folder, err := h.folderService.CreateFolder(c.Request.Context(), userID.(int64), optionalID(requestBody.ParentID), requestBody.Name)
+	if err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, folder)
+}
+
+// ListRoot handles GET /folders and lists the folders and files at the user's root.
+func (h *FoldersHandler) ListRoot(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	contents, err := h.folderService.ListRoot(c.Request.Context(), userID.(int64))
+	if err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contents)
+}
+
+// List handles GET /folders/:id and lists a folder's subfolders and files.
+func (h *FoldersHandler) List(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	contents, err := h.folderService.ListFolder(c.Request.Context(), folderID, userID.(int64))
+	if err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, contents)
+}
+
+// Rename handles POST /folders/:id/rename.
+func (h *FoldersHandler) Rename(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	var requestBody struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: 'name' field is required"})
+		return
+	}
+
+	folder, err := h.folderService.RenameFolder(c.Request.Context(), folderID, userID.(int64), requestBody.Name)
+	if err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+// Move handles POST /folders/:id/move. A null 'parent_id' moves the folder to the root.
+func (h *FoldersHandler) Move(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	var requestBody struct {
+		ParentID *int64 `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	folder, err := h.folderService.MoveFolder(c.Request.Context(), folderID, userID.(int64), optionalID(requestBody.ParentID))
+	if err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+// Delete handles DELETE /folders/:id. Non-empty folders are only deleted with '?recursive=true'.
+func (h *FoldersHandler) Delete(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	recursive := c.Query("recursive") == "true"
+	if err := h.folderService.DeleteFolder(c.Request.Context(), folderID, userID.(int64), recursive); err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "folder deleted successfully"})
+}
+
+// MoveFile handles POST /files/:id/move. A null 'folder_id' moves the file to the root.
+func (h *FoldersHandler) MoveFile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	var requestBody struct {
+		FolderID *int64 `json:"folder_id"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.folderService.MoveFile(c.Request.Context(), fileID, userID.(int64), optionalID(requestBody.FolderID)); err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "file moved successfully"})
+}
+
+// Archive handles POST /files/archive and streams a ZIP of either the files listed in
+// 'file_ids' or everything below 'folder_id'. Access is checked for every file before the
+// first byte is sent, so a file the user can't read fails the whole request with a 404.
+func (h *FoldersHandler) Archive(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	var requestBody struct {
+		FileIDs  []int64 `json:"file_ids"`
+		FolderID *int64  `json:"folder_id"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if (len(requestBody.FileIDs) > 0) == (requestBody.FolderID != nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of 'file_ids' or 'folder_id' is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	name, entries, err := h.folderService.Archive(ctx, userID.(int64), requestBody.FileIDs, optionalID(requestBody.FolderID))
+	if err != nil {
+		c.JSON(folderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	serveArchive(c, name, func(w io.Writer) error {
+		return h.folderService.WriteArchive(ctx, w, entries, userID.(int64))
+	})
+}
@@ -1,31 +1,61 @@
 package api
 
 import (
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"net/http"
-	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/karanbihani/file-vault/internal/auth"
+	"github.com/karanbihani/file-vault/internal/core/audit"
 )
 
-// getJWTSecret retrieves the JWT secret from an environment variable.
-func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET_KEY")
-	if secret == "" {
-		panic("JWT_SECRET environment variable is not set")
+// requestIDPattern is what a request ID passed in by a proxy must look like to be kept.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestContext creates a Gin middleware that gives every request an ID, returned in the
+// X-Request-ID response header, and records the client's IP address, user agent and that ID
+// on the request context for the audit log. A valid X-Request-ID from a proxy in front of us
+// is kept, so that its logs and ours can be matched up.
+func RequestContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to generate request ID"})
+				return
+			}
+			requestID = hex.EncodeToString(b)
+		}
+		c.Header("X-Request-ID", requestID)
+		c.Set("requestID", requestID)
+
+		c.Request = c.Request.WithContext(audit.WithRequestInfo(c.Request.Context(), audit.RequestInfo{
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			RequestID: requestID,
+		}))
+		c.Next()
 	}
-	return []byte(secret)
 }
 
-var jwtSecret = getJWTSecret()
-
-// AuthMiddleware creates a Gin middleware for JWT authentication.
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware creates a Gin middleware that authenticates requests. A request carries either
+// an access token ("Authorization: Bearer <token>"), which must be correctly signed, unexpired and
+// belong to a session that is still active, or an API key ("Authorization: ApiKey <key>" or
+// "X-API-Key: <key>"), whose scopes then limit the permissions the request can use.
+func AuthMiddleware(authService *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			authenticateAPIKey(c, authService, key)
+			return
+		}
+
 		// Get the Authorization header from the request.
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -33,47 +63,67 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// The header should be in the format "Bearer <token>". We split it to get the token part.
+		// The header should be in the format "Bearer <token>" or "ApiKey <key>". We split it to get the token part.
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		if len(parts) != 2 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
+			return
+		}
+		switch strings.ToLower(parts[0]) {
+		case "bearer":
+		case "apikey":
+			authenticateAPIKey(c, authService, parts[1])
+			return
+		default:
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
 			return
 		}
 		tokenString := parts[1]
 
-		// Now, we parse and validate the token.
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Ensure the signing method is what we expect (HS256).
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtSecret, nil
-		})
-
+		userID, sessionID, err := authService.ValidateAccessToken(c.Request.Context(), tokenString)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
+			if errors.Is(err, auth.ErrInvalidAccessToken) || errors.Is(err, auth.ErrSessionRevoked) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, auth.ErrAccountSuspended) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// The token is valid. We extract the user ID ("sub" claim) from the claims.
-			userIDFloat, ok := claims["sub"].(float64)
-			if !ok {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid subject claim in token"})
-				return
-			}
-			userID := int64(userIDFloat)
+		// CRITICAL: We add the authenticated user's ID to the Gin context.
+		// This is how our downstream handlers will know who the user is.
+		c.Set("userID", userID)
+		c.Set("sessionID", sessionID)
 
-			// CRITICAL: We add the authenticated user's ID to the Gin context.
-			// This is how our downstream handlers will know who the user is.
-			c.Set("userID", userID)
+		// c.Next() passes control to the next handler in the chain.
+		c.Next()
+	}
+}
 
-			// c.Next() passes control to the next handler in the chain.
-			c.Next()
-		} else {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+func authenticateAPIKey(c *gin.Context, authService *auth.Service, key string) {
+	apiKey, err := authService.AuthenticateAPIKey(c.Request.Context(), key, c.Request.Method, c.FullPath())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidAPIKey) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
 		}
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+
+	c.Set("userID", apiKey.UserID)
+	c.Set("apiKeyID", apiKey.ID)
+	// Permission checks further down read the scopes from the request context.
+	c.Request = c.Request.WithContext(auth.WithScopes(c.Request.Context(), apiKey.Scopes))
+	c.Next()
 }
 
 type client struct {
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/karanbihani/file-vault/internal/pagination"
+)
+
+// pageRequest reads the limit, cursor, sort and order query parameters of a list endpoint.
+// sorts maps the endpoint's sort keys to their default order. If the parameters are invalid it
+// responds with 400 and returns false.
+func pageRequest(c *gin.Context, sorts map[string]bool, defaultSort string) (pagination.Request, bool) {
+	req, err := pagination.ParseRequest(c.Query("limit"), c.Query("cursor"), c.Query("sort"), c.Query("order"), sorts, defaultSort)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return pagination.Request{}, false
+	}
+	return req, true
+}
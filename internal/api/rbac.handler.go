@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -8,6 +9,20 @@ import (
 	"github.com/karanbihani/file-vault/internal/core/rbac"
 )
 
+// rbacErrorStatus maps errors from the RBAC service to HTTP status codes.
+func rbacErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, rbac.ErrRoleNotFound), errors.Is(err, rbac.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, rbac.ErrInvalidRoleName):
+		return http.StatusBadRequest
+	case errors.Is(err, rbac.ErrRoleNameTaken), errors.Is(err, rbac.ErrDefaultRole), errors.Is(err, rbac.ErrLastAdmin):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 type RBACHandler struct {
 	rbacService *rbac.Service
 }
@@ -52,6 +67,11 @@ func (h *RBACHandler) GetPermissionsForRole(c *gin.Context) {
 }
 
 func (h *RBACHandler) AddPermissionToRole(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
 	roleID, err := strconv.ParseInt(c.Param("roleId"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role ID"})
@@ -63,7 +83,7 @@ func (h *RBACHandler) AddPermissionToRole(c *gin.Context) {
 		return
 	}
 
-	err = h.rbacService.AddPermissionToRole(c.Request.Context(), int32(roleID), int32(permissionID))
+	err = h.rbacService.AddPermissionToRole(c.Request.Context(), userID.(int64), int32(roleID), int32(permissionID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -72,6 +92,11 @@ func (h *RBACHandler) AddPermissionToRole(c *gin.Context) {
 }
 
 func (h *RBACHandler) RemovePermissionFromRole(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
 	roleID, err := strconv.ParseInt(c.Param("roleId"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role ID"})
@@ -83,10 +108,177 @@ func (h *RBACHandler) RemovePermissionFromRole(c *gin.Context) {
 		return
 	}
 
-	err = h.rbacService.RemovePermissionFromRole(c.Request.Context(), int32(roleID), int32(permissionID))
+	err = h.rbacService.RemovePermissionFromRole(c.Request.Context(), userID.(int64), int32(roleID), int32(permissionID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(rbacErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "permission removed from role successfully"})
-}
\ No newline at end of file
+}
+
+func (h *RBACHandler) SetRoleRequireTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	roleID, err := strconv.ParseInt(c.Param("roleId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role ID"})
+		return
+	}
+
+	var req struct {
+		Required *bool `json:"required" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	role, err := h.rbacService.SetRoleRequireTwoFactor(c.Request.Context(), userID.(int64), int32(roleID), *req.Required)
+	if err != nil {
+		if errors.Is(err, rbac.ErrRoleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func (h *RBACHandler) CreateRole(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	role, err := h.rbacService.CreateRole(c.Request.Context(), userID.(int64), req.Name)
+	if err != nil {
+		c.JSON(rbacErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, role)
+}
+
+func (h *RBACHandler) RenameRole(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	roleID, err := strconv.ParseInt(c.Param("roleId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role ID"})
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	role, err := h.rbacService.RenameRole(c.Request.Context(), userID.(int64), int32(roleID), req.Name)
+	if err != nil {
+		c.JSON(rbacErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func (h *RBACHandler) DeleteRole(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	roleID, err := strconv.ParseInt(c.Param("roleId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role ID"})
+		return
+	}
+
+	if err := h.rbacService.DeleteRole(c.Request.Context(), userID.(int64), int32(roleID)); err != nil {
+		c.JSON(rbacErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "role deleted successfully"})
+}
+
+func (h *RBACHandler) ListUserRoles(c *gin.Context) {
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	roles, err := h.rbacService.ListUserRoles(c.Request.Context(), targetUserID)
+	if err != nil {
+		c.JSON(rbacErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func (h *RBACHandler) GrantUserRole(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+	roleID, err := strconv.ParseInt(c.Param("roleId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role ID"})
+		return
+	}
+
+	roles, err := h.rbacService.GrantUserRole(c.Request.Context(), userID.(int64), targetUserID, int32(roleID))
+	if err != nil {
+		c.JSON(rbacErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func (h *RBACHandler) RevokeUserRole(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+	targetUserID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+	roleID, err := strconv.ParseInt(c.Param("roleId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role ID"})
+		return
+	}
+
+	roles, err := h.rbacService.RevokeUserRole(c.Request.Context(), userID.(int64), targetUserID, int32(roleID))
+	if err != nil {
+		c.JSON(rbacErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
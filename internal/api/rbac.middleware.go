@@ -4,12 +4,13 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/auth"
 )
 
 // PermissionMiddleware is a factory that creates a Gin middleware to enforce a required permission.
-// It queries the database on each request to get the user's current permissions.
-func PermissionMiddleware(queries *db.Queries, requiredPermission string) gin.HandlerFunc {
+// It asks the permission resolver for the user's current permissions, limited to the key's
+// scopes when the request was made with an API key.
+func PermissionMiddleware(permissions *auth.PermissionResolver, requiredPermission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("userID")
 		if !exists {
@@ -17,17 +18,14 @@ func PermissionMiddleware(queries *db.Queries, requiredPermission string) gin.Ha
 			return
 		}
 
-		permissions, err := queries.GetUserPermissions(c.Request.Context(), userID.(int64))
+		allowed, err := permissions.HasPermission(c.Request.Context(), userID.(int64), requiredPermission)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not retrieve user permissions"})
 			return
 		}
-
-		for _, p := range permissions {
-			if p == requiredPermission {
-				c.Next()
-				return
-			}
+		if allowed {
+			c.Next()
+			return
 		}
 
 		errorMsg := gin.H{"error": "access denied: you do not have the required permission (" + requiredPermission + ")"}
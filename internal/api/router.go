@@ -1,6 +1,7 @@
 package api
 
 import (
+	"log"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -9,26 +10,40 @@ import (
 	"github.com/karanbihani/file-vault/internal/auth"       // Adjust path
 	"github.com/karanbihani/file-vault/internal/core/admin" // <-- Add this import for admin service
 	"github.com/karanbihani/file-vault/internal/core/files" // Adjust path
+	"github.com/karanbihani/file-vault/internal/core/folders"
 	"github.com/karanbihani/file-vault/internal/core/rbac"
 	"github.com/karanbihani/file-vault/internal/core/search"
 	"github.com/karanbihani/file-vault/internal/core/shares" // Add this import
 	"github.com/karanbihani/file-vault/internal/core/stats"  // Add this import
+	"github.com/karanbihani/file-vault/internal/core/uploads"
 	"github.com/karanbihani/file-vault/internal/db"          // <-- Add this import for db.Queries
 )
 
 func SetupRouter(queries *db.Queries, dbpool *pgxpool.Pool, fileService *files.Service, authService *auth.Service, sharesService *shares.Service,
-	statsService *stats.Service, rbacService *rbac.Service, adminService *admin.Service, searchService *search.Service) *gin.Engine {
+	statsService *stats.Service, rbacService *rbac.Service, adminService *admin.Service, searchService *search.Service,
+	uploadsService *uploads.Service, folderService *folders.Service, trustedProxies []string) *gin.Engine {
 	router := gin.Default()
 
+	// ClientIP only believes X-Forwarded-For from these proxies, so that a client can't choose
+	// the IP address that logins are throttled by and audit entries record.
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	router.Use(RequestContext())
+
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Range", "If-None-Match", "If-Range", "X-Share-Password", "X-API-Key", "X-Request-ID"},
+		ExposeHeaders:    []string{"Content-Length", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Upload-Offset", "Upload-Length", "Upload-Expires", "File-ID", "ETag", "Accept-Ranges", "Content-Range", "Content-Disposition", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Every permission check goes through the auth service's cached resolver.
+	permissions := authService.Permissions()
+
 	fileHandler := NewFilesHandler(fileService)
 	authHandler := NewAuthHandler(authService)
 	sharesHandler := NewSharesHandler(sharesService)
@@ -36,6 +51,8 @@ func SetupRouter(queries *db.Queries, dbpool *pgxpool.Pool, fileService *files.S
 	rbacHandler := NewRBACHandler(rbacService) // <-- Initialize the new RBAC handler
 	adminHandler := NewAdminHandler(adminService) // <-- Initialize the new Admin handler
 	searchHandler := NewSearchHandler(searchService) // <-- Initialize the new handler
+	uploadsHandler := NewUploadsHandler(uploadsService)
+	foldersHandler := NewFoldersHandler(folderService)
 
 	router.Use(RateLimiter(2, time.Second))
 
@@ -45,31 +62,100 @@ func SetupRouter(queries *db.Queries, dbpool *pgxpool.Pool, fileService *files.S
 		v1.GET("/health", HealthCheckHandler(dbpool))
 		v1.POST("/register", authHandler.Register)
 		v1.POST("/login", authHandler.Login)
+		v1.POST("/login/2fa", authHandler.CompleteTwoFactorLogin)
+		v1.POST("/login/2fa/setup", authHandler.BeginTwoFactorSetup)
+		v1.POST("/login/2fa/setup/confirm", authHandler.CompleteTwoFactorSetup)
+		v1.POST("/auth/refresh", authHandler.Refresh)
+		v1.GET("/auth/oidc", authHandler.SSOStatus)
+		v1.GET("/auth/oidc/login", authHandler.BeginSSOLogin)
+		v1.GET("/auth/oidc/callback", authHandler.SSOCallback)
+		v1.POST("/auth/email/verify", authHandler.VerifyEmail)
+		v1.POST("/auth/password/forgot", authHandler.ForgotPassword)
+		v1.POST("/auth/password/reset", authHandler.ResetPassword)
 		v1.GET("/share/:token", sharesHandler.PublicDownload)
 
+		// --- Resumable Upload Routes (tus protocol) ---
+		// Capability discovery is unauthenticated; everything else requires the upload permission.
+		v1.OPTIONS("/uploads", TusResumable(), uploadsHandler.Options)
+		resumable := v1.Group("/uploads")
+		resumable.Use(TusResumable(), AuthMiddleware(authService), PermissionMiddleware(permissions, auth.PermissionFilesUpload))
+		{
+			resumable.POST("", uploadsHandler.Create)
+			resumable.HEAD("/:id", uploadsHandler.Head)
+			resumable.PATCH("/:id", uploadsHandler.Patch)
+			resumable.DELETE("/:id", uploadsHandler.Terminate)
+		}
+
 		// --- Protected User Routes ---
 		// All routes in this group require authentication first.
 		// Then, each route has a specific permission check.
 		protected := v1.Group("/")
-		protected.Use(AuthMiddleware())
+		protected.Use(AuthMiddleware(authService))
 		{
+			// Session Routes
+			protected.POST("/auth/logout", authHandler.Logout)
+			protected.GET("/auth/sessions", authHandler.ListSessions)
+			protected.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
+			protected.GET("/auth/api-keys", authHandler.ListAPIKeys)
+			protected.POST("/auth/api-keys", authHandler.CreateAPIKey)
+			protected.DELETE("/auth/api-keys/:id", authHandler.DeleteAPIKey)
+
+			// Two-Factor Authentication Routes
+			protected.GET("/auth/2fa", authHandler.GetTwoFactorStatus)
+			protected.POST("/auth/2fa/enroll", authHandler.BeginTwoFactorEnrollment)
+			protected.POST("/auth/2fa/confirm", authHandler.ConfirmTwoFactorEnrollment)
+			protected.POST("/auth/2fa/disable", authHandler.DisableTwoFactor)
+			protected.POST("/auth/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+			protected.POST("/auth/email/verification", authHandler.SendVerificationEmail)
+
 			// File Management Routes
-			protected.POST("/files", PermissionMiddleware(queries, auth.PermissionFilesUpload), fileHandler.Upload)
+			protected.POST("/files", PermissionMiddleware(permissions, auth.PermissionFilesUpload), fileHandler.Upload)
 			protected.GET("/files", fileHandler.List) // Listing own files doesn't need a specific perm
-			protected.GET("/files/:id/download", PermissionMiddleware(queries, auth.PermissionFilesDownload), fileHandler.Download)
-			protected.DELETE("/files/:id", PermissionMiddleware(queries, auth.PermissionFilesDelete), fileHandler.Delete)
-			protected.GET("/files/shared-with-me", PermissionMiddleware(queries, auth.PermissionFilesReadShared), fileHandler.ListSharedWithMe) // Assuming List handler can be adapted
+			protected.GET("/files/:id/download", PermissionMiddleware(permissions, auth.PermissionFilesDownload), fileHandler.Download)
+			protected.DELETE("/files/:id", PermissionMiddleware(permissions, auth.PermissionFilesDelete), fileHandler.Delete)
+			protected.PATCH("/files/:id", PermissionMiddleware(permissions, auth.PermissionFilesUpload), fileHandler.Update)
+			protected.POST("/files/:id/versions", PermissionMiddleware(permissions, auth.PermissionFilesUpload), fileHandler.UploadVersion)
+			protected.GET("/files/:id/versions", PermissionMiddleware(permissions, auth.PermissionFilesDownload), fileHandler.ListVersions)
+			protected.GET("/files/:id/versions/:version/download", PermissionMiddleware(permissions, auth.PermissionFilesDownload), fileHandler.DownloadVersion)
+			protected.POST("/files/:id/versions/:version/restore", PermissionMiddleware(permissions, auth.PermissionFilesUpload), fileHandler.RestoreVersion)
+			protected.GET("/files/shared-with-me", PermissionMiddleware(permissions, auth.PermissionFilesReadShared), fileHandler.ListSharedWithMe) // Assuming List handler can be adapted
+
+			protected.POST("/files/archive", PermissionMiddleware(permissions, auth.PermissionFilesDownload), foldersHandler.Archive)
+
+			protected.POST("/files/:id/move", PermissionMiddleware(permissions, auth.PermissionFilesUpload), foldersHandler.MoveFile)
+
+			// Trash Routes
+			protected.GET("/trash", fileHandler.ListTrash)
+			protected.POST("/trash/:id/restore", PermissionMiddleware(permissions, auth.PermissionFilesDelete), fileHandler.RestoreFromTrash)
+			protected.DELETE("/trash/:id", PermissionMiddleware(permissions, auth.PermissionFilesDelete), fileHandler.PurgeFromTrash)
+			protected.DELETE("/trash", PermissionMiddleware(permissions, auth.PermissionFilesDelete), fileHandler.EmptyTrash)
+
+			// Folder Routes
+			protected.GET("/folders", foldersHandler.ListRoot)
+			protected.POST("/folders", PermissionMiddleware(permissions, auth.PermissionFilesUpload), foldersHandler.Create)
+			protected.GET("/folders/shared-with-me", PermissionMiddleware(permissions, auth.PermissionFilesReadShared), sharesHandler.ListFoldersSharedWithMe)
+			protected.GET("/folders/:id", foldersHandler.List)
+			protected.POST("/folders/:id/rename", PermissionMiddleware(permissions, auth.PermissionFilesUpload), foldersHandler.Rename)
+			protected.POST("/folders/:id/move", PermissionMiddleware(permissions, auth.PermissionFilesUpload), foldersHandler.Move)
+			protected.DELETE("/folders/:id", PermissionMiddleware(permissions, auth.PermissionFilesDelete), foldersHandler.Delete)
+			protected.POST("/folders/:id/share-to-user", PermissionMiddleware(permissions, auth.PermissionSharesCreateUser), sharesHandler.ShareFolderWithUser)
+			protected.DELETE("/folders/:id/share-to-user", PermissionMiddleware(permissions, auth.PermissionSharesRevokeUser), sharesHandler.UnshareFolderWithUser)
+			protected.GET("/folders/:id/shares", sharesHandler.GetSharesForFolder)
+			protected.POST("/folders/:id/share", PermissionMiddleware(permissions, auth.PermissionSharesCreatePublic), sharesHandler.CreatePublicFolderLink)
+			protected.DELETE("/folders/:id/share", PermissionMiddleware(permissions, auth.PermissionSharesRevokePublic), sharesHandler.RevokePublicFolderLinks)
+			protected.GET("/folders/:id/public-share", sharesHandler.GetPublicFolderShareInfo)
 
 			// Sharing Management Routes
-			protected.POST("/files/:id/share", PermissionMiddleware(queries, auth.PermissionSharesCreatePublic), sharesHandler.CreatePublicLink)
-			protected.POST("/files/:id/share-to-user", PermissionMiddleware(queries, auth.PermissionSharesCreateUser), sharesHandler.ShareWithUser)
-			protected.DELETE("/files/:id/share", PermissionMiddleware(queries, auth.PermissionSharesRevokePublic), sharesHandler.RevokePublicLinks)
-			protected.DELETE("/files/:id/share-to-user", PermissionMiddleware(queries, auth.PermissionSharesRevokeUser), sharesHandler.UnshareWithUser)
+			protected.POST("/files/:id/share", PermissionMiddleware(permissions, auth.PermissionSharesCreatePublic), sharesHandler.CreatePublicLink)
+			protected.POST("/files/:id/share-to-user", PermissionMiddleware(permissions, auth.PermissionSharesCreateUser), sharesHandler.ShareWithUser)
+			protected.DELETE("/files/:id/share", PermissionMiddleware(permissions, auth.PermissionSharesRevokePublic), sharesHandler.RevokePublicLinks)
+			protected.DELETE("/files/:id/share-to-user", PermissionMiddleware(permissions, auth.PermissionSharesRevokeUser), sharesHandler.UnshareWithUser)
 			protected.GET("/files/:id/shares", sharesHandler.GetSharesForFile) 
 			protected.GET("/files/:id/public-share", sharesHandler.GetPublicShareInfo) // New endpoint 
+			protected.PATCH("/shares/:token", PermissionMiddleware(permissions, auth.PermissionSharesCreatePublic), sharesHandler.UpdatePublicLink)
 
 			// Stats Route
-			protected.GET("/stats", PermissionMiddleware(queries, auth.PermissionStatsReadSelf), statsHandler.GetUserDashboardStats)
+			protected.GET("/stats", PermissionMiddleware(permissions, auth.PermissionStatsReadSelf), statsHandler.GetUserDashboardStats)
 
 			// Search Route
 			protected.GET("/search", searchHandler.Search)
@@ -82,19 +168,40 @@ func SetupRouter(queries *db.Queries, dbpool *pgxpool.Pool, fileService *files.S
 
 		// --- Protected Admin & RBAC Management Routes ---
 		admin := v1.Group("/admin")
-		admin.Use(AuthMiddleware())
+		admin.Use(AuthMiddleware(authService))
 		{
 			// RBAC Management APIs
-			admin.GET("/roles", PermissionMiddleware(queries, auth.PermissionAdminManageRoles), rbacHandler.ListRoles)
+			admin.GET("/roles", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.ListRoles)
+			admin.POST("/roles", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.CreateRole)
+			admin.PATCH("/roles/:roleId", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.RenameRole)
+			admin.DELETE("/roles/:roleId", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.DeleteRole)
 			
-			admin.GET("/permissions", PermissionMiddleware(queries, auth.PermissionAdminManageRoles), rbacHandler.ListPermissions)
-			admin.GET("/roles/:roleId/permissions", PermissionMiddleware(queries, auth.PermissionAdminManageRoles), rbacHandler.GetPermissionsForRole)
-			admin.POST("/roles/:roleId/permissions/:permissionId", PermissionMiddleware(queries, auth.PermissionAdminManageRoles), rbacHandler.AddPermissionToRole)
-			admin.DELETE("/roles/:roleId/permissions/:permissionId", PermissionMiddleware(queries, auth.PermissionAdminManageRoles), rbacHandler.RemovePermissionFromRole)
+			admin.GET("/permissions", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.ListPermissions)
+			admin.GET("/roles/:roleId/permissions", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.GetPermissionsForRole)
+			admin.POST("/roles/:roleId/permissions/:permissionId", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.AddPermissionToRole)
+			admin.DELETE("/roles/:roleId/permissions/:permissionId", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.RemovePermissionFromRole)
+			admin.PUT("/roles/:roleId/require-2fa", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.SetRoleRequireTwoFactor)
+			admin.GET("/users/:id/roles", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.ListUserRoles)
+			admin.POST("/users/:id/roles/:roleId", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.GrantUserRole)
+			admin.DELETE("/users/:id/roles/:roleId", PermissionMiddleware(permissions, auth.PermissionAdminManageRoles), rbacHandler.RevokeUserRole)
 			
-			admin.GET("/files", PermissionMiddleware(queries, auth.PermissionAdminViewAllFiles), adminHandler.ListAllFiles)
-			admin.GET("/stats", PermissionMiddleware(queries, auth.PermissionAdminViewAllStats), adminHandler.GetSystemStats)
-			admin.GET("/logs", PermissionMiddleware(queries, auth.PermissionAdminViewAuditLogs), adminHandler.ListAuditLogs)
+			admin.GET("/files", PermissionMiddleware(permissions, auth.PermissionAdminViewAllFiles), adminHandler.ListAllFiles)
+			admin.GET("/stats", PermissionMiddleware(permissions, auth.PermissionAdminViewAllStats), adminHandler.GetSystemStats)
+			admin.GET("/logs", PermissionMiddleware(permissions, auth.PermissionAdminViewAuditLogs), adminHandler.ListAuditLogs)
+			admin.GET("/logs/export", PermissionMiddleware(permissions, auth.PermissionAdminViewAuditLogs), adminHandler.ExportAuditLogs)
+			admin.GET("/logs/verify", PermissionMiddleware(permissions, auth.PermissionAdminViewAuditLogs), adminHandler.VerifyAuditLog)
+			admin.GET("/logs/archive", PermissionMiddleware(permissions, auth.PermissionAdminViewAuditLogs), adminHandler.ListAuditArchive)
+			admin.GET("/logs/archive/:segmentId", PermissionMiddleware(permissions, auth.PermissionAdminViewAuditLogs), adminHandler.DownloadAuditArchiveSegment)
+			admin.GET("/logs/archive/:segmentId/entries", PermissionMiddleware(permissions, auth.PermissionAdminViewAuditLogs), adminHandler.QueryAuditArchiveSegment)
+
+			admin.GET("/users", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), adminHandler.ListUsers)
+			admin.GET("/users/:id", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), adminHandler.GetUser)
+			admin.PUT("/users/:id/quota", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), adminHandler.SetUserQuota)
+			admin.POST("/users/:id/suspend", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), adminHandler.SuspendUser)
+			admin.POST("/users/:id/reactivate", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), adminHandler.ReactivateUser)
+			admin.DELETE("/users/:id", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), adminHandler.DeleteUser)
+			admin.DELETE("/users/:id/sessions", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), authHandler.RevokeUserSessions)
+			admin.POST("/users/:id/unlock", PermissionMiddleware(permissions, auth.PermissionAdminManageUsers), authHandler.UnlockUser)
 		}
 	}
 	return router
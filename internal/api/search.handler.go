@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/karanbihani/file-vault/internal/core/search"
 	"github.com/karanbihani/file-vault/internal/db"
 	"github.com/karanbihani/file-vault/internal/auth" 
+	"github.com/karanbihani/file-vault/internal/pagination"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -63,6 +65,9 @@ func (h *SearchHandler) Search(c *gin.Context) {
 	// it's added to the params struct. Otherwise, the field remains nil
 	// and the SQL query will ignore it.
 
+	if q, ok := c.GetQuery("q"); ok {
+		params.Query = pgtype.Text{String: q, Valid: true}
+	}
 	if filename, ok := c.GetQuery("filename"); ok {
 		params.Filename = pgtype.Text{String: filename, Valid: true}
 	}
@@ -95,10 +100,30 @@ func (h *SearchHandler) Search(c *gin.Context) {
 	if uploader, ok := c.GetQuery("uploader"); ok {
 		params.UploaderEmail = pgtype.Text{String: uploader, Valid: true}
 	}
+	if folderID, ok := c.GetQuery("folder_id"); ok {
+		if id, err := strconv.ParseInt(folderID, 10, 64); err == nil {
+			params.FolderID = pgtype.Int8{Int64: id, Valid: true}
+		}
+	}
+
+	// Searches for text are sorted best match first unless asked otherwise.
+	defaultSort := pagination.SortUploadDate
+	if params.Query.Valid {
+		defaultSort = pagination.SortRelevance
+	}
+	page, ok := pageRequest(c, pagination.SearchSorts, defaultSort)
+	if !ok {
+		return
+	}
 
-	results, err := h.searchService.SearchFiles(c.Request.Context(), params)
+	results, err := h.searchService.SearchFiles(c.Request.Context(), params, page)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch {
+		case errors.Is(err, search.ErrEmptyQuery), errors.Is(err, search.ErrQueryTooLong), errors.Is(err, search.ErrRelevanceWithoutQuery):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
@@ -1,12 +1,19 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/auth"
+	"github.com/karanbihani/file-vault/internal/core/archive"
 	"github.com/karanbihani/file-vault/internal/core/shares" // Adjust path
+	"github.com/karanbihani/file-vault/internal/storage"
 )
 
 type SharesHandler struct {
@@ -19,6 +26,57 @@ func NewSharesHandler(service *shares.Service) *SharesHandler {
 	}
 }
 
+// shareErrorStatus maps a sharing service error to its HTTP status code.
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, shares.ErrShareNotFound), errors.Is(err, shares.ErrFileNotFound), errors.Is(err, shares.ErrFolderNotFound),
+		errors.Is(err, storage.ErrObjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrInsufficientRole), errors.Is(err, shares.ErrSharerUnverified),
+		errors.Is(err, shares.ErrRecipientUnverified):
+		return http.StatusForbidden
+	case errors.Is(err, shares.ErrShareExpired), errors.Is(err, shares.ErrDownloadLimitReached):
+		return http.StatusGone
+	case errors.Is(err, shares.ErrPasswordRequired):
+		return http.StatusUnauthorized
+	case errors.Is(err, shares.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, shares.ErrInvalidExpiry), errors.Is(err, shares.ErrExpiryRequired), errors.Is(err, shares.ErrInvalidMaxDownloads),
+		errors.Is(err, archive.ErrNoFiles), errors.Is(err, archive.ErrTooManyFiles):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// bindLinkSettings reads the optional 'expires_at' (RFC 3339), 'never_expires', 'max_downloads'
+// and 'password' fields of a share link request. An empty body means "all defaults".
+func bindLinkSettings(c *gin.Context) (shares.LinkSettings, error) {
+	var requestBody struct {
+		ExpiresAt    *time.Time `json:"expires_at"`
+		NeverExpires bool       `json:"never_expires"`
+		MaxDownloads *int64     `json:"max_downloads"`
+		Password     *string    `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil && !errors.Is(err, io.EOF) {
+		return shares.LinkSettings{}, err
+	}
+	return shares.LinkSettings{
+		ExpiresAt:    requestBody.ExpiresAt,
+		NeverExpires: requestBody.NeverExpires,
+		MaxDownloads: requestBody.MaxDownloads,
+		Password:     requestBody.Password,
+	}, nil
+}
+
+// optionalTime is a nullable time as JSON: null if it isn't set.
+func optionalTime(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
 // CreatePublicLink is the PROTECTED handler for POST /files/:id/share
 func (h *SharesHandler) CreatePublicLink(c *gin.Context) {
 	userID, exists := c.Get("userID")
@@ -33,19 +91,68 @@ func (h *SharesHandler) CreatePublicLink(c *gin.Context) {
 		return
 	}
 
-	share, err := h.sharesService.CreatePublicLink(c.Request.Context(), fileID, userID.(int64))
+	settings, err := bindLinkSettings(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	share, err := h.sharesService.CreatePublicLink(c.Request.Context(), fileID, userID.(int64), settings)
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	// Return the full public URL to the user.
 	// NOTE: In production, you would use a frontend URL, not the API host.
 	fullURL := fmt.Sprintf("http://%s/api/v1/share/%s", c.Request.Host, share.ShareToken)
-	c.JSON(http.StatusOK, gin.H{"share_url": fullURL})
+	c.JSON(http.StatusOK, gin.H{"share_url": fullURL, "expires_at": optionalTime(share.ExpiresAt)})
 }
 
-// PublicDownload is the PUBLIC handler for GET /share/:token
+// UpdatePublicLink is the PROTECTED handler for PATCH /shares/:token. Omitted fields are left
+// unchanged; a 'max_downloads' of 0 removes the limit and an empty 'password' removes the password.
+func (h *SharesHandler) UpdatePublicLink(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	settings, err := bindLinkSettings(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	share, err := h.sharesService.UpdatePublicLink(c.Request.Context(), c.Param("token"), userID.(int64), settings)
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, publicShareInfo(share.ShareToken, share.DownloadCount, share.ExpiresAt, share.MaxDownloads, share.PasswordHash.Valid))
+}
+
+// publicShareInfo is the JSON description of a public link's settings. The password hash is never exposed.
+func publicShareInfo(token string, downloadCount pgtype.Int8, expiresAt pgtype.Timestamptz, maxDownloads pgtype.Int8, passwordProtected bool) gin.H {
+	info := gin.H{
+		"share_token":        token,
+		"download_count":     downloadCount.Int64,
+		"expires_at":         optionalTime(expiresAt),
+		"password_protected": passwordProtected,
+		"max_downloads":      nil,
+	}
+	if maxDownloads.Valid {
+		info["max_downloads"] = maxDownloads.Int64
+	}
+	return info
+}
+
+// shareGrantCookie holds the download grant of a shared file, scoped to the link's path.
+const shareGrantCookie = "share_grant"
+
+// PublicDownload is the PUBLIC handler for GET /share/:token. Password-protected links
+// expect the password in the 'X-Share-Password' header.
 func (h *SharesHandler) PublicDownload(c *gin.Context) {
 	token := c.Param("token")
 	if token == "" {
@@ -53,23 +160,57 @@ func (h *SharesHandler) PublicDownload(c *gin.Context) {
 		return
 	}
 
-	downloadData, err := h.sharesService.ProcessPublicDownload(c.Request.Context(), token)
+	ctx := c.Request.Context()
+	share, err := h.sharesService.AuthorizePublicShare(ctx, token, c.GetHeader("X-Share-Password"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if share.FolderID.Valid {
+		publicArchive, err := h.sharesService.ProcessPublicArchive(ctx, share)
+		if err != nil {
+			c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		serveArchive(c, publicArchive.Name, func(w io.Writer) error {
+			return h.sharesService.WritePublicArchive(ctx, w, publicArchive)
+		})
+		return
+	}
+
+	grant, _ := c.Cookie(shareGrantCookie)
+	downloadData, err := h.sharesService.ProcessPublicDownload(ctx, share, shares.PublicDownloadRequest{
+		Range:       c.GetHeader("Range"),
+		IfRange:     c.GetHeader("If-Range"),
+		IfMatch:     c.GetHeader("If-Match"),
+		IfNoneMatch: c.GetHeader("If-None-Match"),
+		Grant:       grant,
+	})
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if downloadData.NotModified {
+		c.Header("ETag", `"`+downloadData.Sha256Hash+`"`)
+		c.Status(http.StatusNotModified)
 		return
 	}
 	defer downloadData.Data.Close()
 
-	c.Header("Content-Disposition", "attachment; filename="+downloadData.Filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", downloadData.Size))
-	c.DataFromReader(http.StatusOK, downloadData.Size, "application/octet-stream", downloadData.Data, nil)
+	if downloadData.Grant != "" {
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(shareGrantCookie, downloadData.Grant, int(shares.DownloadGrantLifetime.Seconds()), "/api/v1/share/"+token, "", c.Request.TLS != nil, true)
+	}
+
+	serveDownload(c, downloadData.Data, downloadData.Filename, downloadData.MimeType, downloadData.Sha256Hash)
 }
 
 func (h *SharesHandler) ShareWithUser(c *gin.Context) {
 	// Define a struct to bind the incoming JSON request body.
 	var requestBody struct {
 		Email string `json:"email" binding:"required,email"`
+		Role  string `json:"role"` // viewer (default), commenter, editor or co-owner
 	}
 
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
@@ -89,14 +230,20 @@ func (h *SharesHandler) ShareWithUser(c *gin.Context) {
 		return
 	}
 
-	err = h.sharesService.ShareFileWithUser(c.Request.Context(), fileID, userID.(int64), requestBody.Email)
+	role, err := auth.ParseShareRole(requestBody.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.sharesService.ShareFileWithUser(c.Request.Context(), fileID, userID.(int64), requestBody.Email, role)
 	if err != nil {
 		// We can check for specific error messages to return better status codes in the future.
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("file successfully shared with %s", requestBody.Email)})
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("file successfully shared with %s as %s", requestBody.Email, role)})
 }
 
 func (h *SharesHandler) RevokePublicLinks(c *gin.Context) {
@@ -105,7 +252,7 @@ func (h *SharesHandler) RevokePublicLinks(c *gin.Context) {
 
 	err := h.sharesService.RevokePublicLinks(c.Request.Context(), fileID, userID.(int64))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -127,7 +274,7 @@ func (h *SharesHandler) UnshareWithUser(c *gin.Context) {
 
 	err := h.sharesService.UnshareFileWithUser(c.Request.Context(), fileID, userID.(int64), requestBody.RecipientID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -140,7 +287,7 @@ func (h *SharesHandler) GetSharesForFile(c *gin.Context) {
 
 	shares, err := h.sharesService.GetSharesForFile(c.Request.Context(), fileID, userID.(int64))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, shares)
@@ -157,16 +304,170 @@ func (h *SharesHandler) GetPublicShareInfo(c *gin.Context) {
 		return
 	}
 
-	// Convert pgtype.Int8 to a regular int64 for JSON serialization
-	downloadCount := int64(0)
-	if publicShare.DownloadCount.Valid {
-		downloadCount = publicShare.DownloadCount.Int64
+	c.JSON(http.StatusOK, publicShareInfo(publicShare.ShareToken, publicShare.DownloadCount, publicShare.ExpiresAt,
+		publicShare.MaxDownloads, publicShare.PasswordProtected))
+}
+
+// ShareFolderWithUser is the PROTECTED handler for POST /folders/:id/share-to-user
+func (h *SharesHandler) ShareFolderWithUser(c *gin.Context) {
+	var requestBody struct {
+		Email string `json:"email" binding:"required,email"`
+		Role  string `json:"role"` // viewer (default), commenter, editor or co-owner
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: 'email' field is required"})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	role, err := auth.ParseShareRole(requestBody.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.sharesService.ShareFolderWithUser(c.Request.Context(), folderID, userID.(int64), requestBody.Email, role)
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("folder successfully shared with %s as %s", requestBody.Email, role)})
+}
+
+// UnshareFolderWithUser is the PROTECTED handler for DELETE /folders/:id/share-to-user
+func (h *SharesHandler) UnshareFolderWithUser(c *gin.Context) {
+	var requestBody struct {
+		RecipientID int64 `json:"recipient_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: 'recipient_id' is required"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
 	}
 
-	response := map[string]interface{}{
-		"share_token":    publicShare.ShareToken,
-		"download_count": downloadCount,
+	err = h.sharesService.UnshareFolderWithUser(c.Request.Context(), folderID, userID.(int64), requestBody.RecipientID)
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "share access has been revoked for the specified user"})
+}
+
+// GetSharesForFolder is the PROTECTED handler for GET /folders/:id/shares
+func (h *SharesHandler) GetSharesForFolder(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
-}
\ No newline at end of file
+	shares, err := h.sharesService.GetSharesForFolder(c.Request.Context(), folderID, userID.(int64))
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, shares)
+}
+
+// ListFoldersSharedWithMe is the handler for GET /folders/shared-with-me
+func (h *SharesHandler) ListFoldersSharedWithMe(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	sharedFolders, err := h.sharesService.ListFoldersSharedWithMe(c.Request.Context(), userID.(int64))
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sharedFolders)
+}
+
+// CreatePublicFolderLink is the PROTECTED handler for POST /folders/:id/share
+func (h *SharesHandler) CreatePublicFolderLink(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	settings, err := bindLinkSettings(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	share, err := h.sharesService.CreatePublicFolderLink(c.Request.Context(), folderID, userID.(int64), settings)
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	fullURL := fmt.Sprintf("http://%s/api/v1/share/%s", c.Request.Host, share.ShareToken)
+	c.JSON(http.StatusOK, gin.H{"share_url": fullURL, "expires_at": optionalTime(share.ExpiresAt)})
+}
+
+// RevokePublicFolderLinks is the PROTECTED handler for DELETE /folders/:id/share
+func (h *SharesHandler) RevokePublicFolderLinks(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	err = h.sharesService.RevokePublicFolderLinks(c.Request.Context(), folderID, userID.(int64))
+	if err != nil {
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "all public share links for the folder have been revoked"})
+}
+
+// GetPublicFolderShareInfo is the PROTECTED handler for GET /folders/:id/public-share
+func (h *SharesHandler) GetPublicFolderShareInfo(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	folderID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	publicShare, err := h.sharesService.GetPublicFolderShareInfo(c.Request.Context(), folderID, userID.(int64))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no public share found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, publicShareInfo(publicShare.ShareToken, publicShare.DownloadCount, publicShare.ExpiresAt,
+		publicShare.MaxDownloads, publicShare.PasswordProtected))
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/karanbihani/file-vault/internal/core/files"
+	"github.com/karanbihani/file-vault/internal/core/uploads"
+)
+
+// tus protocol constants. See https://tus.io/protocols/resumable-upload for the specification.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusChunkType  = "application/offset+octet-stream"
+)
+
+// UploadsHandler exposes resumable uploads using the tus 1.0 core protocol
+// together with the creation, termination and expiration extensions.
+type UploadsHandler struct {
+	uploadsService *uploads.Service
+}
+
+func NewUploadsHandler(service *uploads.Service) *UploadsHandler {
+	return &UploadsHandler{
+		uploadsService: service,
+	}
+}
+
+// TusResumable is a middleware that enforces the Tus-Resumable request header on every
+// tus request except OPTIONS, and sets it on every response.
+func TusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus protocol version"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Options is the handler for OPTIONS /uploads and advertises the server's tus capabilities.
+func (h *UploadsHandler) Options(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Status(http.StatusNoContent)
+}
+
+// parseUploadMetadata decodes the tus Upload-Metadata header:
+// a comma-separated list of "key base64(value)" pairs.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if parts[0] == "" {
+			continue
+		}
+		if len(parts) == 1 {
+			metadata[parts[0]] = ""
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 value for metadata key '%s'", parts[0])
+		}
+		metadata[parts[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+// setUploadHeaders writes the headers describing an upload's current state.
+func setUploadHeaders(c *gin.Context, offset, length int64, expiresAt time.Time) {
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(length, 10))
+	c.Header("Upload-Expires", expiresAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-store")
+}
+
+// Create is the handler for POST /uploads (tus creation extension).
+// The file's name and MIME type are passed as 'filename' and 'filetype' metadata;
+// 'description' and comma-separated 'tags' are optional.
+func (h *UploadsHandler) Create(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a positive Upload-Length header is required"})
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if metadata["filename"] == "" || metadata["filetype"] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'filename' and 'filetype' upload metadata are required"})
+		return
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(metadata["tags"], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	upload, err := h.uploadsService.CreateUpload(c.Request.Context(), uploads.CreateUploadParams{
+		OwnerID:     userID.(int64),
+		Length:      length,
+		Filename:    metadata["filename"],
+		ContentType: metadata["filetype"],
+		Description: metadata["description"],
+		Tags:        tags,
+	})
+	if err != nil {
+		if errors.Is(err, files.ErrQuotaExceeded) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("%s/%s", strings.TrimSuffix(c.Request.URL.Path, "/"), upload.ID))
+	c.Header("Upload-Expires", upload.ExpiresAt.Time.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// Head is the handler for HEAD /uploads/:id and reports how many bytes have been received.
+func (h *UploadsHandler) Head(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	upload, err := h.uploadsService.GetUpload(c.Request.Context(), c.Param("id"), userID.(int64))
+	if err != nil {
+		switch {
+		case errors.Is(err, uploads.ErrUploadNotFound):
+			c.Status(http.StatusNotFound)
+		case errors.Is(err, uploads.ErrUploadExpired):
+			c.Status(http.StatusGone)
+		default:
+			c.Status(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setUploadHeaders(c, upload.UploadOffset, upload.UploadLength, upload.ExpiresAt.Time)
+	if upload.UserFileID.Valid {
+		c.Header("File-ID", strconv.FormatInt(upload.UserFileID.Int64, 10))
+	}
+	c.Status(http.StatusOK)
+}
+
+// Patch is the handler for PATCH /uploads/:id and appends one chunk at Upload-Offset.
+// The response to the chunk that completes the upload carries the new file's ID in File-ID.
+func (h *UploadsHandler) Patch(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	if c.ContentType() != tusChunkType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusChunkType})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a valid Upload-Offset header is required"})
+		return
+	}
+
+	upload, err := h.uploadsService.WriteChunk(c.Request.Context(), c.Param("id"), userID.(int64), offset, c.Request.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, uploads.ErrUploadNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, uploads.ErrUploadExpired):
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		case errors.Is(err, uploads.ErrOffsetMismatch), errors.Is(err, uploads.ErrUploadCompleted):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, uploads.ErrUploadAssembling):
+			c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+		case errors.Is(err, uploads.ErrUploadTooLarge), errors.Is(err, files.ErrQuotaExceeded):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		default:
+			log.Printf("ERROR: failed to write chunk for upload %s: %v", c.Param("id"), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	setUploadHeaders(c, upload.UploadOffset, upload.UploadLength, upload.ExpiresAt.Time)
+	if upload.UserFileID.Valid {
+		c.Header("File-ID", strconv.FormatInt(upload.UserFileID.Int64, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate is the handler for DELETE /uploads/:id (tus termination extension).
+func (h *UploadsHandler) Terminate(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user ID not found in context"})
+		return
+	}
+
+	err := h.uploadsService.TerminateUpload(c.Request.Context(), c.Param("id"), userID.(int64))
+	if err != nil {
+		if errors.Is(err, uploads.ErrUploadNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
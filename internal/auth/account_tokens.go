@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/mail"
+)
+
+// Account token purposes, also stored in user_tokens.purpose.
+const (
+	tokenPurposeVerifyEmail   = "verify_email"
+	tokenPurposeResetPassword = "reset_password"
+)
+
+const (
+	verifyEmailTokenLifetime   = 48 * time.Hour
+	resetPasswordTokenLifetime = time.Hour
+)
+
+var (
+	ErrInvalidAccountToken  = errors.New("this link is invalid, has expired or has already been used")
+	ErrEmailAlreadyVerified = errors.New("your email address is already verified")
+)
+
+// issueAccountToken signs a single-use token for userID. The token names the email address it
+// was sent to, so it stops working if that address is no longer the user's.
+func (s *Service) issueAccountToken(ctx context.Context, user db.User, purpose string, lifetime time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(lifetime)
+	if err := s.queries.CreateUserToken(ctx, db.CreateUserTokenParams{
+		ID:        jti,
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	}); err != nil {
+		return "", fmt.Errorf("failed to save token: %w", err)
+	}
+
+	claims := jwt.MapClaims{
+		"purpose": purpose,
+		"sub":     user.ID,
+		"email":   user.Email,
+		"jti":     jti,
+		"iat":     now.Unix(),
+		"exp":     expiresAt.Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.jwtSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+	return token, nil
+}
+
+// useAccountToken checks a token's signature and purpose and marks it used. It returns the
+// user the token was issued to.
+func (s *Service) useAccountToken(ctx context.Context, q *db.Queries, tokenString, purpose string) (db.User, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return db.User{}, ErrInvalidAccountToken
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	sub, _ := claims["sub"].(float64)
+	jti, _ := claims["jti"].(string)
+	email, _ := claims["email"].(string)
+	if claims["purpose"] != purpose || jti == "" {
+		return db.User{}, ErrInvalidAccountToken
+	}
+
+	userID, err := q.UseUserToken(ctx, db.UseUserTokenParams{ID: jti, UserID: int64(sub), Purpose: purpose})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return db.User{}, ErrInvalidAccountToken
+		}
+		return db.User{}, fmt.Errorf("failed to check token: %w", err)
+	}
+	user, err := q.GetUserByID(ctx, userID)
+	if err != nil {
+		return db.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Email != email {
+		return db.User{}, ErrInvalidAccountToken
+	}
+	return user, nil
+}
+
+// SendVerificationEmail emails userID a link to confirm their address.
+func (s *Service) SendVerificationEmail(ctx context.Context, userID int64) error {
+	user, err := s.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.EmailVerifiedAt.Valid {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := s.issueAccountToken(ctx, user, tokenPurposeVerifyEmail, verifyEmailTokenLifetime)
+	if err != nil {
+		return err
+	}
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Confirm that this is your email address by opening the link below:\n\n%s/verify-email?token=%s\n\n"+
+			"The link expires in %d hours. If you didn't create an account, you can ignore this email.\n",
+			s.appURL, token, int(verifyEmailTokenLifetime/time.Hour)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+	return nil
+}
+
+// VerifyEmail marks the address a verification token was sent to as verified.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	user, err := s.useAccountToken(ctx, s.queries, token, tokenPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	if err := s.queries.MarkUserEmailVerified(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, user.ID, "auth:email_verified", map[string]interface{}{
+		"email": user.Email,
+	})
+	return nil
+}
+
+// RequestPasswordReset emails a password reset link if an account with this email exists.
+// The caller gets no indication either way, so the endpoint can't be used to find accounts;
+// for the same reason the email is sent in the background.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.queries.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	go func() {
+		ctx := context.Background()
+		// Only the newest link works.
+		if err := s.queries.InvalidateUserTokens(ctx, db.InvalidateUserTokensParams{UserID: user.ID, Purpose: tokenPurposeResetPassword}); err != nil {
+			log.Printf("ERROR: failed to invalidate password reset tokens for user %d: %v", user.ID, err)
+			return
+		}
+		token, err := s.issueAccountToken(ctx, user, tokenPurposeResetPassword, resetPasswordTokenLifetime)
+		if err != nil {
+			log.Printf("ERROR: failed to issue password reset token for user %d: %v", user.ID, err)
+			return
+		}
+		err = s.mailer.Send(ctx, mail.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Someone asked to reset the password for your account. To choose a new password, open the link below:\n\n%s/reset-password?token=%s\n\n"+
+				"The link expires in %d minutes and can be used once. If you didn't ask for this, you can ignore this email.\n",
+				s.appURL, token, int(resetPasswordTokenLifetime/time.Minute)),
+		})
+		if err != nil {
+			log.Printf("ERROR: failed to send password reset email to user %d: %v", user.ID, err)
+			return
+		}
+		s.auditService.LogActivity(ctx, user.ID, "auth:password_reset_requested", map[string]interface{}{})
+	}()
+	return nil
+}
+
+type ResetPasswordParams struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ResetPassword sets a new password using a reset token. Every session is signed out and every
+// API key deleted, since whoever asked for the reset may not trust them. Following the link
+// also proves the user owns the email address, so it is marked verified. It returns how many
+// API keys were deleted, so the user knows to create new ones.
+func (s *Service) ResetPassword(ctx context.Context, params ResetPasswordParams) (int64, error) {
+	if err := s.passwordPolicy.check(params.Password); err != nil {
+		return 0, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	user, err := s.useAccountToken(ctx, qtx, params.Token, tokenPurposeResetPassword)
+	if err != nil {
+		return 0, err
+	}
+	if err := qtx.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{ID: user.ID, PasswordHash: string(hashedPassword)}); err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := qtx.MarkUserEmailVerified(ctx, user.ID); err != nil {
+		return 0, fmt.Errorf("failed to verify email: %w", err)
+	}
+	revoked, err := qtx.RevokeAllUserSessions(ctx, user.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	keysDeleted, err := qtx.DeleteUserAPIKeys(ctx, user.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete API keys: %w", err)
+	}
+	if err := s.auditService.LogActivityTx(ctx, tx, user.ID, "auth:password_reset", map[string]interface{}{
+		"sessions_revoked": revoked,
+		"api_keys_deleted": keysDeleted,
+	}); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Proving ownership of the email is as good as an admin unlock.
+	s.clearAccountThrottle(ctx, user.Email)
+	return keysDeleted, nil
+}
+
+// PurgeStaleAccountTokens deletes verification and reset tokens that expired more than
+// retention ago and returns how many were deleted.
+func (s *Service) PurgeStaleAccountTokens(ctx context.Context, retention time.Duration) (int64, error) {
+	before := pgtype.Timestamptz{Time: time.Now().Add(-retention), Valid: true}
+	purged, err := s.queries.DeleteStaleUserTokens(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete stale account tokens: %w", err)
+	}
+	return purged, nil
+}
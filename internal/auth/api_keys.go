@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+// apiKeyPrefix marks vault API keys so they are easy to recognise, e.g. in secret scanners.
+const apiKeyPrefix = "fvk_"
+
+var (
+	ErrInvalidAPIKey     = errors.New("invalid or expired API key")
+	ErrAPIKeyNotFound    = errors.New("API key not found")
+	ErrAPIKeyNameTaken   = errors.New("you already have an API key with this name")
+	ErrInvalidAPIKeyName = errors.New("API key name must be between 1 and 100 characters")
+	ErrNoScopes          = errors.New("an API key needs at least one scope")
+	ErrInvalidScope      = errors.New("you do not have this permission")
+	ErrInvalidKeyExpiry  = errors.New("expiry must be in the future")
+)
+
+// APIKeyInfo describes an API key without the key itself.
+type APIKeyInfo struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewAPIKey is returned once, when a key is created. The key can't be retrieved again.
+type NewAPIKey struct {
+	APIKeyInfo
+	Key string `json:"key"`
+}
+
+type CreateAPIKeyParams struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func optionalTime(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func apiKeyInfo(key db.ApiKey) APIKeyInfo {
+	return APIKeyInfo{
+		ID:         key.ID,
+		Name:       key.Name,
+		KeyPrefix:  key.KeyPrefix,
+		Scopes:     key.Scopes,
+		ExpiresAt:  optionalTime(key.ExpiresAt),
+		LastUsedAt: optionalTime(key.LastUsedAt),
+		CreatedAt:  key.CreatedAt.Time,
+	}
+}
+
+// CreateAPIKey mints a new API key for userID. The scopes must be permissions the user
+// currently holds; if the user later loses one, the key loses it too.
+func (s *Service) CreateAPIKey(ctx context.Context, userID int64, params CreateAPIKeyParams) (*NewAPIKey, error) {
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > 100 {
+		return nil, ErrInvalidAPIKeyName
+	}
+	if len(params.Scopes) == 0 {
+		return nil, ErrNoScopes
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidKeyExpiry
+	}
+
+	permissions, err := s.permissions.rolePermissions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve user permissions: %w", err)
+	}
+	scopes := make([]string, 0, len(params.Scopes))
+	for _, scope := range params.Scopes {
+		if !hasPermission(permissions, scope) {
+			return nil, fmt.Errorf("invalid scope %q: %w", scope, ErrInvalidScope)
+		}
+		if !hasPermission(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := apiKeyPrefix + secret
+
+	expiresAt := pgtype.Timestamptz{}
+	if params.ExpiresAt != nil {
+		expiresAt = pgtype.Timestamptz{Time: *params.ExpiresAt, Valid: true}
+	}
+	created, err := s.queries.CreateAPIKey(ctx, db.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		KeyPrefix: key[:len(apiKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrAPIKeyNameTaken
+		}
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "api_key:create", map[string]interface{}{
+		"api_key_id": created.ID,
+		"name":       created.Name,
+		"scopes":     created.Scopes,
+	})
+	return &NewAPIKey{APIKeyInfo: apiKeyInfo(created), Key: key}, nil
+}
+
+// ListAPIKeys returns a user's API keys, newest first.
+func (s *Service) ListAPIKeys(ctx context.Context, userID int64) ([]APIKeyInfo, error) {
+	keys, err := s.queries.ListUserAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	infos := make([]APIKeyInfo, len(keys))
+	for i, key := range keys {
+		infos[i] = apiKeyInfo(key)
+	}
+	return infos, nil
+}
+
+// DeleteAPIKey revokes one of the user's API keys. It stops working immediately.
+func (s *Service) DeleteAPIKey(ctx context.Context, userID, keyID int64) error {
+	deleted, err := s.queries.DeleteAPIKey(ctx, db.DeleteAPIKeyParams{ID: keyID, UserID: userID})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrAPIKeyNotFound
+		}
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "api_key:revoke", map[string]interface{}{
+		"api_key_id": deleted.ID,
+		"name":       deleted.Name,
+	})
+	return nil
+}
+
+// AuthenticateAPIKey looks up an unexpired API key and records that it was used for
+// method and path. It returns the key, whose UserID and Scopes the request runs with.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key, method, path string) (*db.ApiKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.queries.GetActiveAPIKeyByHash(ctx, hashToken(key))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("failed to check API key: %w", err)
+	}
+	if err := s.checkNotSuspended(ctx, apiKey.UserID); err != nil {
+		return nil, err
+	}
+
+	if err := s.queries.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		return nil, fmt.Errorf("failed to record API key use: %w", err)
+	}
+	s.auditService.LogActivity(ctx, apiKey.UserID, "api_key:use", map[string]interface{}{
+		"api_key_id": apiKey.ID,
+		"method":     method,
+		"path":       path,
+	})
+	return &apiKey, nil
+}
+
+type scopesKey struct{}
+
+// WithScopes returns a context for a request made with an API key. Permission checks made
+// with that context only see permissions that are also in scopes.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+func hasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
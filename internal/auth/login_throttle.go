@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+// Throttle scopes, also stored in login_throttles.scope.
+const (
+	throttleScopeAccount = "account"
+	throttleScopeIP      = "ip"
+)
+
+const (
+	// loginBackoffStart is the failure count at which each further attempt on an account has
+	// to wait, starting at one second and doubling. Earlier failures are just typos. An IP
+	// address, which may be shared by many users, only backs off after half its limit.
+	loginBackoffStart = 3
+	// loginFailureWindow is how long failures are remembered. Lockouts grow for as long as
+	// failures keep coming within it.
+	loginFailureWindow = 24 * time.Hour
+	maxLoginLockout    = 24 * time.Hour
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrLoginThrottled     = errors.New("too many failed login attempts")
+)
+
+// LoginThrottledError is returned instead of checking credentials while an account or IP
+// address has to wait before trying again.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%v; try again in %v", ErrLoginThrottled, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// loginThrottleConfig sets how many failures an account or IP address may have before it is
+// locked out, and for how long the first lockout lasts. Each later lockout is twice as long.
+type loginThrottleConfig struct {
+	maxAccountFailures int
+	maxIPFailures      int
+	lockout            time.Duration
+}
+
+// delay is how long to wait after the nth failure, when backoff starts at start failures and
+// max failures mean a lockout.
+func (c loginThrottleConfig) delay(n, start, max int) time.Duration {
+	switch {
+	case n < start:
+		return 0
+	case n < max:
+		d := time.Second
+		for i := start; i < n && d < c.lockout; i++ {
+			d *= 2
+		}
+		if d > c.lockout {
+			d = c.lockout
+		}
+		return d
+	}
+	d := c.lockout
+	for i := max; i < n && d < maxLoginLockout; i++ {
+		d *= 2
+	}
+	if d > maxLoginLockout {
+		d = maxLoginLockout
+	}
+	return d
+}
+
+func throttleSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginThrottle returns a LoginThrottledError if the account or the IP address is
+// waiting out a backoff or lockout.
+func (s *Service) checkLoginThrottle(ctx context.Context, email, ipAddress string) error {
+	throttles, err := s.queries.GetLoginThrottles(ctx, db.GetLoginThrottlesParams{
+		Email:     throttleSubject(email),
+		IpAddress: ipAddress,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check login attempts: %w", err)
+	}
+
+	var wait time.Duration
+	for _, t := range throttles {
+		if t.LockedUntil.Valid {
+			if d := time.Until(t.LockedUntil.Time); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the account and the IP address, backs
+// both off, and locks out whichever has failed too often. userID is 0 if the email has no
+// account.
+func (s *Service) recordLoginFailure(ctx context.Context, email, ipAddress string, userID int64, reason string) {
+	details := map[string]interface{}{
+		"email":      email,
+		"ip_address": ipAddress,
+		"reason":     reason,
+	}
+	logEvent := func(action string, details map[string]interface{}) {
+		if userID != 0 {
+			s.auditService.LogActivity(ctx, userID, action, details)
+		} else {
+			s.auditService.LogAnonymousActivity(ctx, action, details)
+		}
+	}
+
+	windowStart := pgtype.Timestamptz{Time: time.Now().Add(-loginFailureWindow), Valid: true}
+	targets := []struct {
+		scope, subject string
+		start, max     int
+	}{
+		{throttleScopeAccount, throttleSubject(email), loginBackoffStart, s.loginThrottle.maxAccountFailures},
+		{throttleScopeIP, ipAddress, s.loginThrottle.maxIPFailures / 2, s.loginThrottle.maxIPFailures},
+	}
+	for _, target := range targets {
+		if target.subject == "" {
+			continue
+		}
+		throttle, err := s.queries.RecordLoginFailure(ctx, db.RecordLoginFailureParams{
+			Scope:       target.scope,
+			Subject:     target.subject,
+			WindowStart: windowStart,
+		})
+		if err != nil {
+			log.Printf("ERROR: failed to record login failure: %v", err)
+			continue
+		}
+		details[target.scope+"_failures"] = throttle.Failures
+
+		delay := s.loginThrottle.delay(int(throttle.Failures), target.start, target.max)
+		if delay == 0 {
+			continue
+		}
+		lockedUntil := time.Now().Add(delay)
+		if err := s.queries.LockLogin(ctx, db.LockLoginParams{
+			Scope:       target.scope,
+			Subject:     target.subject,
+			LockedUntil: pgtype.Timestamptz{Time: lockedUntil, Valid: true},
+		}); err != nil {
+			log.Printf("ERROR: failed to lock login: %v", err)
+			continue
+		}
+		if int(throttle.Failures) >= target.max {
+			logEvent("auth:"+target.scope+"_locked", map[string]interface{}{
+				"email":        email,
+				"ip_address":   ipAddress,
+				"failures":     throttle.Failures,
+				"locked_until": lockedUntil,
+			})
+		}
+	}
+
+	logEvent("auth:login_failed", details)
+}
+
+// clearAccountThrottle forgets an account's failed logins after it logs in successfully.
+// The IP address's failures are kept, so that one good account can't be used to reset them.
+func (s *Service) clearAccountThrottle(ctx context.Context, email string) {
+	if _, err := s.queries.ClearLoginThrottle(ctx, db.ClearLoginThrottleParams{
+		Scope:   throttleScopeAccount,
+		Subject: throttleSubject(email),
+	}); err != nil {
+		log.Printf("ERROR: failed to clear login failures: %v", err)
+	}
+}
+
+// UnlockAccount lets an administrator end a user's lockout and reset their failed logins.
+// It returns whether the account was locked out.
+func (s *Service) UnlockAccount(ctx context.Context, actorID, targetUserID int64) (bool, error) {
+	user, err := s.queries.GetUserByID(ctx, targetUserID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, ErrUserNotFound
+		}
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	throttles, err := s.queries.GetLoginThrottles(ctx, db.GetLoginThrottlesParams{Email: throttleSubject(user.Email)})
+	if err != nil {
+		return false, fmt.Errorf("failed to check login attempts: %w", err)
+	}
+	locked := false
+	for _, t := range throttles {
+		if t.Scope == throttleScopeAccount && t.LockedUntil.Valid && t.LockedUntil.Time.After(time.Now()) {
+			locked = true
+		}
+	}
+
+	if _, err := s.queries.ClearLoginThrottle(ctx, db.ClearLoginThrottleParams{
+		Scope:   throttleScopeAccount,
+		Subject: throttleSubject(user.Email),
+	}); err != nil {
+		return false, fmt.Errorf("failed to unlock account: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, actorID, "auth:account_unlock", map[string]interface{}{
+		"target_user_id": targetUserID,
+		"email":          user.Email,
+		"was_locked":     locked,
+	})
+	return locked, nil
+}
+
+// PurgeStaleLoginThrottles deletes failed-login records that are no longer counted.
+func (s *Service) PurgeStaleLoginThrottles(ctx context.Context) (int64, error) {
+	before := pgtype.Timestamptz{Time: time.Now().Add(-loginFailureWindow), Valid: true}
+	purged, err := s.queries.DeleteStaleLoginThrottles(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete stale login throttles: %w", err)
+	}
+	return purged, nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is the longest password bcrypt can hash.
+const maxPasswordBytes = 72
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordBreached = errors.New("this password has appeared in a data breach; choose another")
+)
+
+// passwordPolicy is what a new password must satisfy.
+type passwordPolicy struct {
+	minLength int
+	// breached holds the upper-case SHA-1 hex digest of every known breached password.
+	breached map[string]struct{}
+}
+
+// loadBreachedPasswords reads a breached-password list with one entry per line. An entry is
+// either a password or its SHA-1 hex digest, optionally followed by ":count" as in the
+// Have I Been Pwned downloads.
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); isSHA1Hex(digest) {
+			breached[strings.ToUpper(digest)] = struct{}{}
+			continue
+		}
+		breached[passwordDigest(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return breached, nil
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func passwordDigest(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// check returns why password doesn't satisfy the policy, or nil if it does.
+func (p passwordPolicy) check(password string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return fmt.Errorf("%w: use at least %d characters", ErrPasswordTooShort, p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: use at most %d bytes", ErrPasswordTooLong, maxPasswordBytes)
+	}
+	if _, ok := p.breached[passwordDigest(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+// invalidateAllPayload is the permissions_changed payload that means every user's cache entry
+// is stale, e.g. because a role's permissions changed.
+const invalidateAllPayload = "*"
+
+// maxCachedUsers bounds the cache; expired entries are swept once it is reached.
+const maxCachedUsers = 10000
+
+// listenerRetryDelay is how long the listener waits before reconnecting after an error.
+const listenerRetryDelay = 5 * time.Second
+
+type cachedPermissions struct {
+	permissions []string
+	expiresAt   time.Time
+}
+
+// PermissionResolver answers every permission check. It caches each user's permissions for
+// a short while, and forgets them when roles change, whether in this process or, through
+// Postgres LISTEN/NOTIFY, in another replica.
+type PermissionResolver struct {
+	db      *pgxpool.Pool
+	queries *db.Queries
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	cache map[int64]cachedPermissions
+	// generation changes on every invalidation, so that a lookup which raced with one doesn't
+	// put what it read into the cache.
+	generation uint64
+}
+
+// NewPermissionResolver creates a resolver that caches permissions for ttl. A ttl of zero
+// turns the cache off.
+func NewPermissionResolver(dbpool *pgxpool.Pool, queries *db.Queries, ttl time.Duration) *PermissionResolver {
+	return &PermissionResolver{
+		db:      dbpool,
+		queries: queries,
+		ttl:     ttl,
+		cache:   make(map[int64]cachedPermissions),
+	}
+}
+
+// rolePermissions returns all the permissions userID's roles grant.
+func (r *PermissionResolver) rolePermissions(ctx context.Context, userID int64) ([]string, error) {
+	if r.ttl <= 0 {
+		return r.queries.GetUserPermissions(ctx, userID)
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	entry, ok := r.cache[userID]
+	generation := r.generation
+	r.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.permissions, nil
+	}
+
+	permissions, err := r.queries.GetUserPermissions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.generation == generation {
+		if len(r.cache) >= maxCachedUsers {
+			for id, e := range r.cache {
+				if !now.Before(e.expiresAt) {
+					delete(r.cache, id)
+				}
+			}
+		}
+		if len(r.cache) < maxCachedUsers {
+			r.cache[userID] = cachedPermissions{permissions: permissions, expiresAt: now.Add(r.ttl)}
+		}
+	}
+	return permissions, nil
+}
+
+// Permissions returns the permissions userID can use in ctx: all the permissions their roles
+// grant, or for a request made with an API key, only those the key is scoped to.
+func (r *PermissionResolver) Permissions(ctx context.Context, userID int64) ([]string, error) {
+	permissions, err := r.rolePermissions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, ok := ctx.Value(scopesKey{}).([]string)
+	if !ok {
+		return permissions, nil
+	}
+	allowed := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		if hasPermission(scopes, p) {
+			allowed = append(allowed, p)
+		}
+	}
+	return allowed, nil
+}
+
+// HasPermission reports whether userID can use permission in ctx.
+func (r *PermissionResolver) HasPermission(ctx context.Context, userID int64, permission string) (bool, error) {
+	permissions, err := r.Permissions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return hasPermission(permissions, permission), nil
+}
+
+func (r *PermissionResolver) forgetUser(userID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, userID)
+	r.generation++
+}
+
+func (r *PermissionResolver) forgetAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = make(map[int64]cachedPermissions)
+	r.generation++
+}
+
+// InvalidateUser forgets userID's cached permissions here and in every other replica. Call it
+// after their roles change.
+func (r *PermissionResolver) InvalidateUser(ctx context.Context, userID int64) {
+	r.forgetUser(userID)
+	r.notify(ctx, strconv.FormatInt(userID, 10))
+}
+
+// InvalidateAll forgets every cached permission here and in every other replica. Call it after
+// a role's permissions change or a role is deleted.
+func (r *PermissionResolver) InvalidateAll(ctx context.Context) {
+	r.forgetAll()
+	r.notify(ctx, invalidateAllPayload)
+}
+
+// notify tells the other replicas. If it fails they catch up when their entries expire.
+func (r *PermissionResolver) notify(ctx context.Context, payload string) {
+	if err := r.queries.NotifyPermissionsChanged(ctx, payload); err != nil {
+		log.Printf("ERROR: failed to notify other replicas of a permission change: %v", err)
+	}
+}
+
+// handleNotification applies an invalidation received from any replica, this one included.
+func (r *PermissionResolver) handleNotification(payload string) {
+	if payload == invalidateAllPayload {
+		r.forgetAll()
+		return
+	}
+	userID, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		log.Printf("WARNING: ignoring invalid permissions_changed payload %q", payload)
+		return
+	}
+	r.forgetUser(userID)
+}
+
+// StartListener listens for invalidations from other replicas in the background, reconnecting
+// if the connection is lost. Without it, other replicas' changes only show up here once the
+// cached entries expire.
+func (r *PermissionResolver) StartListener() {
+	if r.ttl <= 0 {
+		return
+	}
+	go func() {
+		for {
+			err := r.listen(context.Background())
+			log.Printf("ERROR: permission change listener stopped, reconnecting in %v: %v", listenerRetryDelay, err)
+			time.Sleep(listenerRetryDelay)
+		}
+	}()
+}
+
+// listen holds a connection of its own, taken out of the pool, until it fails.
+func (r *PermissionResolver) listen(ctx context.Context) error {
+	pooled, err := r.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("could not acquire connection: %w", err)
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(ctx)
+
+	if err := db.New(conn).ListenPermissionsChanged(ctx); err != nil {
+		return fmt.Errorf("could not listen for permission changes: %w", err)
+	}
+	// Changes made while nobody was listening were missed.
+	r.forgetAll()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		r.handleNotification(notification.Payload)
+	}
+}
@@ -27,5 +27,6 @@ const (
     PermissionAdminViewAllStats = "admin:view_all_stats" // <-- ADD THIS
     PermissionAdminDownloadAnyFile = "admin:download_any_file" // <-- ADD THIS
     PermissionAdminViewAuditLogs = "admin:view_audit_logs"
+    PermissionAdminManageUsers = "admin:manage_users"
 
 )
\ No newline at end of file
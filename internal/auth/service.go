@@ -6,43 +6,162 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/karanbihani/file-vault/internal/core/audit"
 	"github.com/karanbihani/file-vault/internal/db" // Adjust to your module path
+	"github.com/karanbihani/file-vault/internal/mail"
 )
 
 type Service struct {
-	db          *pgxpool.Pool 
-	queries     *db.Queries
-	jwtSecret   []byte
-	jwtLifetime time.Duration
+	db           *pgxpool.Pool 
+	queries      *db.Queries
+	auditService *audit.Service
+	jwtSecret    []byte
+
+	// Access tokens are short-lived and can't be revoked on their own; the session they name is
+	// checked on every request. Refresh tokens last longer and are rotated on every use.
+	accessTokenLifetime  time.Duration
+	refreshTokenLifetime time.Duration
+
+	// totpIssuer is the name authenticator apps show next to the user's email.
+	totpIssuer string
+
+	// oidc is nil unless single sign-on is configured. After an SSO login the browser is
+	// sent to ssoFrontendURL.
+	oidc           *oidcProvider
+	ssoFrontendURL string
+
+	// mailer sends verification and password reset emails, whose links point at appURL.
+	mailer mail.Sender
+	appURL string
+
+	loginThrottle  loginThrottleConfig
+	passwordPolicy passwordPolicy
+
+	permissions *PermissionResolver
 }
 
-func NewService(dbpool *pgxpool.Pool, queries *db.Queries) *Service {
+func NewService(dbpool *pgxpool.Pool, queries *db.Queries, auditService *audit.Service, mailer mail.Sender, permissions *PermissionResolver) *Service {
 	secret := os.Getenv("JWT_SECRET_KEY")
 	if secret == "" {
 		log.Fatal("JWT_SECRET_KEY environment variable is not set")
 	}
 
-	lifetimeHoursStr := os.Getenv("JWT_LIFETIME_HOURS")
-	if lifetimeHoursStr == "" {
-		lifetimeHoursStr = "24" // Default to 24 hours
+	accessMinutesStr := os.Getenv("ACCESS_TOKEN_LIFETIME_MINUTES")
+	if accessMinutesStr == "" {
+		accessMinutesStr = "15" // Default to 15 minutes
 	}
-	lifetimeHours, err := strconv.Atoi(lifetimeHoursStr)
+	accessMinutes, err := strconv.Atoi(accessMinutesStr)
 	if err != nil {
-		log.Fatalf("Invalid JWT_LIFETIME_HOURS: %v", err)
+		log.Fatalf("Invalid ACCESS_TOKEN_LIFETIME_MINUTES: %v", err)
+	}
+
+	refreshDaysStr := os.Getenv("REFRESH_TOKEN_LIFETIME_DAYS")
+	if refreshDaysStr == "" {
+		refreshDaysStr = "30" // Default to 30 days
+	}
+	refreshDays, err := strconv.Atoi(refreshDaysStr)
+	if err != nil {
+		log.Fatalf("Invalid REFRESH_TOKEN_LIFETIME_DAYS: %v", err)
+	}
+
+	totpIssuer := os.Getenv("TOTP_ISSUER")
+	if totpIssuer == "" {
+		totpIssuer = "File Vault"
+	}
+
+	// Brute-force protection: an account is locked out after LOGIN_MAX_FAILURES failed logins
+	// and an IP address after LOGIN_IP_MAX_FAILURES, for LOGIN_LOCKOUT_MINUTES at first.
+	loginThrottle := loginThrottleConfig{
+		maxAccountFailures: envInt("LOGIN_MAX_FAILURES", 5),
+		maxIPFailures:      envInt("LOGIN_IP_MAX_FAILURES", 50),
+		lockout:            time.Minute * time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)),
+	}
+
+	// New passwords must be at least PASSWORD_MIN_LENGTH characters and, if
+	// BREACHED_PASSWORDS_FILE is set, not on that list.
+	policy := passwordPolicy{minLength: envInt("PASSWORD_MIN_LENGTH", 8)}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := loadBreachedPasswords(path)
+		if err != nil {
+			log.Fatalf("Failed to load BREACHED_PASSWORDS_FILE: %v", err)
+		}
+		policy.breached = breached
+		log.Printf("Loaded %d breached passwords.", len(breached))
+	}
+
+	// APP_BASE_URL is the frontend address used in links sent by email.
+	appURL := strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/")
+	if appURL == "" {
+		appURL = "http://localhost:3000"
+	}
+
+	// Single sign-on is enabled by setting OIDC_ISSUER.
+	var oidc *oidcProvider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		roleMapping, err := ParseRoleMapping(os.Getenv("OIDC_ROLE_MAPPING"))
+		if err != nil {
+			log.Fatalf("Invalid OIDC_ROLE_MAPPING: %v", err)
+		}
+		scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+		groupsClaim := os.Getenv("OIDC_GROUPS_CLAIM")
+		if groupsClaim == "" {
+			groupsClaim = "groups"
+		}
+		oidc = newOIDCProvider(OIDCConfig{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       scopes,
+			GroupsClaim:  groupsClaim,
+			RoleMapping:  roleMapping,
+		})
 	}
 
 	return &Service{
-		db:          dbpool, 
-		queries:     queries,
-		jwtSecret:   []byte(secret),
-		jwtLifetime: time.Hour * time.Duration(lifetimeHours),
+		db:                   dbpool, 
+		queries:              queries,
+		auditService:         auditService,
+		jwtSecret:            []byte(secret),
+		accessTokenLifetime:  time.Minute * time.Duration(accessMinutes),
+		refreshTokenLifetime: 24 * time.Hour * time.Duration(refreshDays),
+		totpIssuer:           totpIssuer,
+		oidc:                 oidc,
+		ssoFrontendURL:       os.Getenv("OIDC_FRONTEND_REDIRECT_URL"),
+		mailer:               mailer,
+		appURL:               appURL,
+		loginThrottle:        loginThrottle,
+		passwordPolicy:       policy,
+		permissions:          permissions,
+	}
+}
+
+// Permissions returns the resolver that all permission checks go through.
+func (s *Service) Permissions() *PermissionResolver {
+	return s.permissions
+}
+
+// envInt reads a positive integer setting, exiting if it is invalid.
+func envInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s: must be a positive integer", name)
 	}
+	return n
 }
 
 type RegisterUserParams struct {
@@ -51,9 +170,13 @@ type RegisterUserParams struct {
 }
 
 // RegisterUser creates a new user, hashes their password, and saves it to the database.
+// The new user is sent an email to verify their address.
 func (s *Service) RegisterUser(ctx context.Context, params RegisterUserParams) (*db.User, error) {
 	log.Println("Starting user registration process")
-	log.Printf("Input params: %+v", params)
+
+	if err := s.passwordPolicy.check(params.Password); err != nil {
+		return nil, err
+	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -106,39 +229,64 @@ func (s *Service) RegisterUser(ctx context.Context, params RegisterUserParams) (
 	}
 
 	log.Println("User registration successful")
+
+	// The account is usable without verification, so a mail failure shouldn't fail the
+	// registration; the user can ask for another email.
+	if err := s.SendVerificationEmail(ctx, user.ID); err != nil {
+		log.Printf("ERROR: failed to send verification email to user %d: %v", user.ID, err)
+	}
 	return &user, nil
 }
 
 type LoginUserParams struct {
 	Email    string
 	Password string
+
+	// Recorded on the session so users can tell their logins apart. Set by the handler.
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
 }
 
-func (s *Service) LoginUser(ctx context.Context, params LoginUserParams) (string, error) {
+// LoginUser checks a user's credentials and starts a new session for them. If the user has
+// two-factor authentication on, or one of their roles requires it, no session is started yet;
+// the result carries a challenge token for the second step instead.
+// Repeated failures for an account or from an IP address make further attempts wait, and
+// eventually lock them out; see login_throttle.go.
+func (s *Service) LoginUser(ctx context.Context, params LoginUserParams) (*LoginResult, error) {
+	if err := s.checkLoginThrottle(ctx, params.Email, params.IPAddress); err != nil {
+		return nil, err
+	}
+
 	user, err := s.queries.GetUserByEmail(ctx, params.Email)
 	if err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		if err != pgx.ErrNoRows {
+			return nil, fmt.Errorf("failed to look up user: %w", err)
+		}
+		s.recordLoginFailure(ctx, params.Email, params.IPAddress, 0, "unknown_email")
+		return nil, ErrInvalidCredentials
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(params.Password))
 	if err != nil {
-		return "", fmt.Errorf("invalid credentials")
+		s.recordLoginFailure(ctx, params.Email, params.IPAddress, user.ID, "wrong_password")
+		return nil, ErrInvalidCredentials
 	}
-
-	claims := jwt.MapClaims{
-		"sub": user.ID,
-		"iat": time.Now().Unix(),
-		// Use the configured lifetime from the service.
-		"exp": time.Now().Add(s.jwtLifetime).Unix(),
+	s.clearAccountThrottle(ctx, params.Email)
+	if user.SuspendedAt.Valid {
+		return nil, ErrAccountSuspended
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Use the configured secret from the service.
-	tokenString, err := token.SignedString(s.jwtSecret)
+	challenge, err := s.loginChallenge(ctx, user.ID)
 	if err != nil {
-		return "", fmt.Errorf("failed to create token: %w", err)
+		return nil, err
+	}
+	if challenge != nil {
+		return challenge, nil
 	}
 
-	return tokenString, nil
-}
\ No newline at end of file
+	tokens, err := s.startSession(ctx, user.ID, params.UserAgent, params.IPAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
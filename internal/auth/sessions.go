@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrInvalidAccessToken  = errors.New("invalid token")
+	ErrSessionRevoked      = errors.New("session has been revoked or has expired")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrAccountSuspended    = errors.New("this account has been suspended")
+)
+
+// TokenPair is what a client receives when it logs in or refreshes its session.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Seconds until AccessToken expires.
+}
+
+// SessionInfo describes one of a user's active sessions.
+type SessionInfo struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session the request was made with.
+}
+
+// randomToken returns n random bytes encoded for use in URLs and headers.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how refresh tokens are stored. They are long and random, so a plain SHA-256
+// is enough; there is nothing to brute-force.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+
+// checkNotSuspended returns ErrAccountSuspended if an admin has suspended userID.
+func (s *Service) checkNotSuspended(ctx context.Context, userID int64) error {
+	suspendedAt, err := s.queries.GetUserSuspension(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to check account status: %w", err)
+	}
+	if suspendedAt.Valid {
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+// startSession records a new session for userID and issues its first pair of tokens.
+func (s *Service) startSession(ctx context.Context, userID int64, userAgent, ipAddress string) (*TokenPair, error) {
+	if err := s.checkNotSuspended(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	session, err := s.queries.CreateSession(ctx, db.CreateSessionParams{
+		UserID:           userID,
+		RefreshTokenHash: hashToken(refreshToken),
+		UserAgent:        optionalText(userAgent),
+		IpAddress:        optionalText(ipAddress),
+		ExpiresAt:        pgtype.Timestamptz{Time: time.Now().Add(s.refreshTokenLifetime), Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return s.issueTokens(userID, session.ID, refreshToken)
+}
+
+func (s *Service) issueTokens(userID, sessionID int64, refreshToken string) (*TokenPair, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"sid": sessionID,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(s.accessTokenLifetime).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString(s.jwtSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.accessTokenLifetime / time.Second),
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new refresh token.
+// The old refresh token stops working. Presenting it again after that means it has leaked,
+// so the whole session is revoked.
+func (s *Service) RefreshSession(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newRefreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	hash := hashToken(refreshToken)
+	session, err := s.queries.RotateSessionRefreshToken(ctx, db.RotateSessionRefreshTokenParams{
+		NewRefreshTokenHash: hashToken(newRefreshToken),
+		ExpiresAt:           pgtype.Timestamptz{Time: time.Now().Add(s.refreshTokenLifetime), Valid: true},
+		RefreshTokenHash:    hash,
+	})
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			return nil, fmt.Errorf("failed to refresh session: %w", err)
+		}
+
+		reused, err := s.queries.RevokeSessionByPreviousRefreshToken(ctx, pgtype.Text{String: hash, Valid: true})
+		if err == nil {
+			log.Printf("WARNING: refresh token for session %d was reused; session revoked", reused.ID)
+			s.auditService.LogActivity(ctx, reused.UserID, "session:refresh_token_reused", map[string]interface{}{
+				"session_id": reused.ID,
+			})
+		} else if err != pgx.ErrNoRows {
+			return nil, fmt.Errorf("failed to check for refresh token reuse: %w", err)
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if err := s.checkNotSuspended(ctx, session.UserID); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(session.UserID, session.ID, newRefreshToken)
+}
+
+// ValidateAccessToken checks an access token's signature and expiry and that the session it
+// belongs to is still active. It returns the user and session the token was issued for.
+func (s *Service) ValidateAccessToken(ctx context.Context, tokenString string) (int64, int64, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Ensure the signing method is what we expect (HS256).
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.jwtSecret, nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %v", ErrInvalidAccessToken, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return 0, 0, ErrInvalidAccessToken
+	}
+	userIDFloat, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: invalid subject claim in token", ErrInvalidAccessToken)
+	}
+	// Tokens issued before sessions existed have no "sid" and are rejected here.
+	sessionIDFloat, ok := claims["sid"].(float64)
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: invalid session claim in token", ErrInvalidAccessToken)
+	}
+	userID, sessionID := int64(userIDFloat), int64(sessionIDFloat)
+
+	if _, err := s.queries.GetActiveSession(ctx, db.GetActiveSessionParams{ID: sessionID, UserID: userID}); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, 0, ErrSessionRevoked
+		}
+		return 0, 0, fmt.Errorf("failed to check session: %w", err)
+	}
+	if err := s.checkNotSuspended(ctx, userID); err != nil {
+		return 0, 0, err
+	}
+
+	return userID, sessionID, nil
+}
+
+// Logout ends the session the request was made with. Its refresh token stops working
+// immediately and its access token is rejected from the next request on.
+func (s *Service) Logout(ctx context.Context, userID, sessionID int64) error {
+	if _, err := s.queries.RevokeSession(ctx, db.RevokeSessionParams{ID: sessionID, UserID: userID}); err != nil {
+		if err == pgx.ErrNoRows {
+			// Already ended, e.g. by a logout racing this one.
+			return nil
+		}
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "session:logout", map[string]interface{}{
+		"session_id": sessionID,
+	})
+	return nil
+}
+
+// ListSessions returns a user's active sessions, most recently used first.
+func (s *Service) ListSessions(ctx context.Context, userID, currentSessionID int64) ([]SessionInfo, error) {
+	sessions, err := s.queries.ListUserSessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	infos := make([]SessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[i] = SessionInfo{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent.String,
+			IPAddress:  session.IpAddress.String,
+			CreatedAt:  session.CreatedAt.Time,
+			LastUsedAt: session.LastUsedAt.Time,
+			ExpiresAt:  session.ExpiresAt.Time,
+			Current:    session.ID == currentSessionID,
+		}
+	}
+	return infos, nil
+}
+
+// RevokeSession ends one of the user's own sessions, e.g. a login on a lost device.
+func (s *Service) RevokeSession(ctx context.Context, userID, sessionID int64) error {
+	if _, err := s.queries.RevokeSession(ctx, db.RevokeSessionParams{ID: sessionID, UserID: userID}); err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "session:revoke", map[string]interface{}{
+		"session_id": sessionID,
+	})
+	return nil
+}
+
+// RevokeAllSessions ends every session of targetUserID, signing them out everywhere.
+// It returns how many sessions were ended.
+func (s *Service) RevokeAllSessions(ctx context.Context, actorID, targetUserID int64) (int64, error) {
+	if _, err := s.queries.GetUserByID(ctx, targetUserID); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrUserNotFound
+		}
+		return 0, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	revoked, err := s.queries.RevokeAllUserSessions(ctx, targetUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, actorID, "session:revoke_all", map[string]interface{}{
+		"target_user_id": targetUserID,
+		"revoked":        revoked,
+	})
+	return revoked, nil
+}
+
+// PurgeStaleSessions deletes sessions that ended more than retention ago and returns how
+// many were deleted.
+func (s *Service) PurgeStaleSessions(ctx context.Context, retention time.Duration) (int64, error) {
+	before := pgtype.Timestamptz{Time: time.Now().Add(-retention), Valid: true}
+	purged, err := s.queries.DeleteStaleSessions(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete stale sessions: %w", err)
+	}
+	return purged, nil
+}
+
+// StartSessionPurger starts a background goroutine that deletes sessions that ended, and
+// account tokens that expired, more than retention ago every interval. Failed logins that
+// no longer count are deleted too.
+func (s *Service) StartSessionPurger(retention, interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			purged, err := s.PurgeStaleSessions(context.Background(), retention)
+			if err != nil {
+				log.Printf("ERROR: failed to purge stale sessions: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d stale sessions", purged)
+			}
+
+			purged, err = s.PurgeStaleAccountTokens(context.Background(), retention)
+			if err != nil {
+				log.Printf("ERROR: failed to purge stale account tokens: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d stale account tokens", purged)
+			}
+
+			purged, err = s.PurgeStaleLoginThrottles(context.Background())
+			if err != nil {
+				log.Printf("ERROR: failed to purge stale login throttles: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d stale login throttles", purged)
+			}
+		}
+	}()
+}
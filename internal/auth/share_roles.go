@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+// ShareRole is the access a user-to-user share grants on a file or folder. Roles are ordered:
+// each one can do everything the roles before it can.
+type ShareRole string
+
+const (
+	ShareRoleViewer    ShareRole = "viewer"    // download
+	ShareRoleCommenter ShareRole = "commenter" // download; reserved for comments
+	ShareRoleEditor    ShareRole = "editor"    // upload and restore versions, rename, edit tags and description
+	ShareRoleCoOwner   ShareRole = "co-owner"  // share with other users and manage public links
+
+	// ShareRoleOwner is never stored on a share; it is the role of the file or folder's owner.
+	ShareRoleOwner ShareRole = "owner"
+)
+
+var shareRoleRank = map[ShareRole]int{
+	ShareRoleViewer:    1,
+	ShareRoleCommenter: 2,
+	ShareRoleEditor:    3,
+	ShareRoleCoOwner:   4,
+	ShareRoleOwner:     5,
+}
+
+// ErrInsufficientRole is returned when a user can see a file or folder but their share role
+// doesn't allow the requested action.
+var ErrInsufficientRole = errors.New("your share role does not allow this action")
+
+// ParseShareRole validates a role given when sharing. An empty string means viewer.
+func ParseShareRole(role string) (ShareRole, error) {
+	if role == "" {
+		return ShareRoleViewer, nil
+	}
+	r := ShareRole(role)
+	if _, ok := shareRoleRank[r]; !ok || r == ShareRoleOwner {
+		return "", fmt.Errorf("invalid share role %q: must be viewer, commenter, editor or co-owner", role)
+	}
+	return r, nil
+}
+
+// Includes reports whether r allows everything min allows.
+func (r ShareRole) Includes(min ShareRole) bool {
+	return shareRoleRank[r] >= shareRoleRank[min]
+}
+
+// Access is a user's effective role on a file or folder, and who owns it.
+type Access struct {
+	Role    ShareRole
+	OwnerID int64
+}
+
+// FileAccess returns userID's strongest role on a file, counting ownership, a direct share
+// and shares of any folder above it. It returns nil when the user has no access at all.
+func FileAccess(ctx context.Context, queries *db.Queries, fileID, userID int64) (*Access, error) {
+	row, err := queries.GetFileAccessRole(ctx, db.GetFileAccessRoleParams{FileID: fileID, UserID: userID})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to check file access: %w", err)
+	}
+	return &Access{Role: ShareRole(row.Role), OwnerID: row.OwnerID}, nil
+}
+
+// FolderAccess returns userID's strongest role on a folder, counting ownership and shares of
+// the folder or any folder above it. It returns nil when the user has no access at all.
+func FolderAccess(ctx context.Context, queries *db.Queries, folderID, userID int64) (*Access, error) {
+	row, err := queries.GetFolderAccessRole(ctx, db.GetFolderAccessRoleParams{FolderID: folderID, UserID: userID})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to check folder access: %w", err)
+	}
+	return &Access{Role: ShareRole(row.Role), OwnerID: row.OwnerID}, nil
+}
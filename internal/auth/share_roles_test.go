@@ -0,0 +1,48 @@
+package auth
+
+import "testing"
+
+func TestParseShareRole(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ShareRole
+		wantErr bool
+	}{
+		{"", ShareRoleViewer, false},
+		{"viewer", ShareRoleViewer, false},
+		{"commenter", ShareRoleCommenter, false},
+		{"editor", ShareRoleEditor, false},
+		{"co-owner", ShareRoleCoOwner, false},
+		// Ownership comes from owning the file; it can't be granted by a share.
+		{"owner", "", true},
+		{"Editor", "", true},
+		{"admin", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseShareRole(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseShareRole(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseShareRole(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShareRoleIncludes(t *testing.T) {
+	ordered := []ShareRole{ShareRoleViewer, ShareRoleCommenter, ShareRoleEditor, ShareRoleCoOwner, ShareRoleOwner}
+	for i, r := range ordered {
+		for j, min := range ordered {
+			if got, want := r.Includes(min), i >= j; got != want {
+				t.Errorf("%s.Includes(%s) = %v, want %v", r, min, got, want)
+			}
+		}
+	}
+
+	// A role that isn't known, e.g. read from a bad row, allows nothing.
+	if ShareRole("unknown").Includes(ShareRoleViewer) {
+		t.Error("an unknown role should not include viewer")
+	}
+}
@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+// ssoStateLifetime is how long a user has to log in at the identity provider.
+const ssoStateLifetime = 10 * time.Minute
+
+var (
+	ErrSSONotConfigured    = errors.New("single sign-on is not configured")
+	ErrSSOFailed           = errors.New("single sign-on failed")
+	ErrSSOInvalidState     = errors.New("single sign-on session expired or was tampered with; try again")
+	ErrSSOEmailNotVerified = errors.New("an account with this email already exists, and the identity provider has not verified the email")
+)
+
+// SSOStart is how a single sign-on login begins: the browser is sent to AuthURL, and
+// StateCookie is kept in a cookie until the provider sends the browser back.
+type SSOStart struct {
+	AuthURL     string
+	StateCookie string
+}
+
+type SSOCallbackParams struct {
+	Code        string
+	State       string
+	StateCookie string
+	UserAgent   string
+	IPAddress   string
+}
+
+// SSOEnabled reports whether an OpenID Connect provider is configured.
+func (s *Service) SSOEnabled() bool {
+	return s.oidc != nil
+}
+
+// SSOFrontendURL is the page the browser is sent to after a single sign-on login, with the
+// outcome in the URL fragment.
+func (s *Service) SSOFrontendURL() string {
+	return s.ssoFrontendURL
+}
+
+// BeginSSOLogin starts an authorization code login with PKCE. The state, nonce and code
+// verifier are signed into the state cookie so that no server-side storage is needed.
+func (s *Service) BeginSSOLogin(ctx context.Context) (*SSOStart, error) {
+	if s.oidc == nil {
+		return nil, ErrSSONotConfigured
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := randomToken(32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start single sign-on: %w", err)
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := s.oidc.authCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"purpose":  "oidc_state",
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"iat":      now.Unix(),
+		"exp":      now.Add(ssoStateLifetime).Unix(),
+	}
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.jwtSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start single sign-on: %w", err)
+	}
+
+	return &SSOStart{AuthURL: authURL, StateCookie: cookie}, nil
+}
+
+// CompleteSSOLogin handles the provider's redirect back. It verifies the ID token, finds or
+// creates the vault user, brings their mapped roles in line with their groups, and logs them in.
+func (s *Service) CompleteSSOLogin(ctx context.Context, params SSOCallbackParams) (*LoginResult, error) {
+	if s.oidc == nil {
+		return nil, ErrSSONotConfigured
+	}
+
+	token, err := jwt.Parse(params.StateCookie, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrSSOInvalidState
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	state, _ := claims["state"].(string)
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+	if claims["purpose"] != "oidc_state" || state == "" || state != params.State {
+		return nil, ErrSSOInvalidState
+	}
+
+	identity, err := s.oidc.exchange(ctx, params.Code, verifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user, provisioned, err := s.ssoUser(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	if user.SuspendedAt.Valid {
+		return nil, ErrAccountSuspended
+	}
+	granted, revoked, err := s.syncSSORoles(ctx, user.ID, identity.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auditService.LogActivity(ctx, user.ID, "auth:sso_login", map[string]interface{}{
+		"issuer":        s.oidc.config.Issuer,
+		"subject":       identity.Subject,
+		"provisioned":   provisioned,
+		"roles_granted": granted,
+		"roles_revoked": revoked,
+	})
+
+	challenge, err := s.loginChallenge(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return challenge, nil
+	}
+	tokens, err := s.startSession(ctx, user.ID, params.UserAgent, params.IPAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
+
+// ssoUser returns the vault user for a provider identity, linking it to an existing account
+// with the same (verified) email or provisioning a new account on first login. When the
+// provider vouches for the email address and it is the account's, the account's address is
+// marked verified too, as if the user had followed a verification link.
+func (s *Service) ssoUser(ctx context.Context, identity *oidcIdentity) (db.User, bool, error) {
+	issuer := s.oidc.config.Issuer
+	email := pgtype.Text{String: identity.Email, Valid: identity.Email != ""}
+
+	user, err := s.queries.GetUserByIdentity(ctx, db.GetUserByIdentityParams{Issuer: issuer, Subject: identity.Subject})
+	if err == nil {
+		if err := s.queries.TouchUserIdentity(ctx, db.TouchUserIdentityParams{Issuer: issuer, Subject: identity.Subject, Email: email}); err != nil {
+			return db.User{}, false, fmt.Errorf("failed to update identity: %w", err)
+		}
+		if err := markSSOEmailVerified(ctx, s.queries, user, identity); err != nil {
+			return db.User{}, false, err
+		}
+		return user, false, nil
+	}
+	if err != pgx.ErrNoRows {
+		return db.User{}, false, fmt.Errorf("failed to look up identity: %w", err)
+	}
+	if identity.Email == "" {
+		return db.User{}, false, fmt.Errorf("%w: the identity provider did not return an email address", ErrSSOFailed)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return db.User{}, false, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	provisioned := false
+	user, err = qtx.GetUserByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		// Linking to an account someone else may have registered is only safe if the
+		// provider vouches that this user owns the address.
+		if !identity.EmailVerified {
+			return db.User{}, false, ErrSSOEmailNotVerified
+		}
+	case err == pgx.ErrNoRows:
+		user, err = s.provisionSSOUser(ctx, qtx, identity.Email)
+		if err != nil {
+			return db.User{}, false, err
+		}
+		provisioned = true
+	default:
+		return db.User{}, false, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	if err := qtx.CreateUserIdentity(ctx, db.CreateUserIdentityParams{
+		UserID:  user.ID,
+		Issuer:  issuer,
+		Subject: identity.Subject,
+		Email:   email,
+	}); err != nil {
+		return db.User{}, false, fmt.Errorf("failed to link identity: %w", err)
+	}
+	if err := markSSOEmailVerified(ctx, qtx, user, identity); err != nil {
+		return db.User{}, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return db.User{}, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return user, provisioned, nil
+}
+
+// markSSOEmailVerified marks the user's email address verified if the provider vouches for it.
+func markSSOEmailVerified(ctx context.Context, q *db.Queries, user db.User, identity *oidcIdentity) error {
+	if user.EmailVerifiedAt.Valid || !identity.EmailVerified || !strings.EqualFold(identity.Email, user.Email) {
+		return nil
+	}
+	if err := q.MarkUserEmailVerified(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	return nil
+}
+
+// provisionSSOUser creates a user with the default 'user' role. Their password is random and
+// never revealed, so they can only log in through the provider.
+func (s *Service) provisionSSOUser(ctx context.Context, qtx *db.Queries, email string) (db.User, error) {
+	password, err := randomToken(32)
+	if err != nil {
+		return db.User{}, fmt.Errorf("failed to generate password: %w", err)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return db.User{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user, err := qtx.CreateUser(ctx, db.CreateUserParams{Email: email, PasswordHash: string(hashedPassword)})
+	if err != nil {
+		return db.User{}, fmt.Errorf("failed to create user: %w", err)
+	}
+	userRole, err := qtx.GetRoleByName(ctx, "user")
+	if err != nil {
+		return db.User{}, fmt.Errorf("default 'user' role not found: %w", err)
+	}
+	if err := qtx.LinkUserToRole(ctx, db.LinkUserToRoleParams{UserID: user.ID, RoleID: userRole.ID}); err != nil {
+		return db.User{}, fmt.Errorf("failed to assign role to user: %w", err)
+	}
+	return user, nil
+}
+
+// syncSSORoles grants the user every role mapped from one of their groups and revokes mapped
+// roles none of their groups lead to, all in one transaction. It returns the names of the
+// roles that changed. Like an administrator's revocation, it never revokes a role if that
+// would leave nobody able to manage roles; the role is kept and a warning logged instead.
+func (s *Service) syncSSORoles(ctx context.Context, userID int64, groups []string) ([]string, []string, error) {
+	mapping := s.oidc.config.RoleMapping
+	if len(mapping) == 0 {
+		return nil, nil, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, group := range groups {
+		for _, role := range mapping[group] {
+			wanted[role] = true
+		}
+	}
+	managed := make(map[string]bool)
+	for _, roles := range mapping {
+		for _, role := range roles {
+			managed[role] = true
+		}
+	}
+	names := make([]string, 0, len(managed))
+	for name := range managed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+	if err := qtx.LockRoleAssignments(ctx); err != nil {
+		return nil, nil, fmt.Errorf("could not lock role assignments: %w", err)
+	}
+
+	granted, revoked := []string{}, []string{}
+	for _, name := range names {
+		role, err := qtx.GetRoleByName(ctx, name)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				log.Printf("WARNING: OIDC role mapping names unknown role %q", name)
+				continue
+			}
+			return nil, nil, fmt.Errorf("failed to get role %q: %w", name, err)
+		}
+
+		if wanted[name] {
+			added, err := qtx.AddUserRole(ctx, db.AddUserRoleParams{UserID: userID, RoleID: role.ID})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to grant role %q: %w", name, err)
+			}
+			if added > 0 {
+				granted = append(granted, name)
+			}
+		} else {
+			removed, err := s.revokeSSORole(ctx, tx, userID, role)
+			if err != nil {
+				return nil, nil, err
+			}
+			if removed {
+				revoked = append(revoked, name)
+			}
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if len(granted) > 0 || len(revoked) > 0 {
+		s.permissions.InvalidateUser(ctx, userID)
+	}
+	return granted, revoked, nil
+}
+
+// revokeSSORole takes role away from the user within tx, unless that leaves nobody able to
+// manage roles. It reports whether the role was revoked.
+func (s *Service) revokeSSORole(ctx context.Context, tx pgx.Tx, userID int64, role db.Role) (bool, error) {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("could not begin savepoint: %w", err)
+	}
+	defer savepoint.Rollback(ctx)
+	qtx := s.queries.WithTx(savepoint)
+
+	removed, err := qtx.RemoveUserRole(ctx, db.RemoveUserRoleParams{UserID: userID, RoleID: role.ID})
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role %q: %w", role.Name, err)
+	}
+	if removed == 0 {
+		return false, nil
+	}
+	admins, err := qtx.CountUsersWithPermission(ctx, PermissionAdminManageRoles)
+	if err != nil {
+		return false, fmt.Errorf("could not count administrators: %w", err)
+	}
+	if admins == 0 {
+		log.Printf("WARNING: not revoking role %q from user %d on single sign-on: nobody else can manage roles", role.Name, userID)
+		return false, nil
+	}
+	if err := savepoint.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return true, nil
+}
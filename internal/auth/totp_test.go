@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key from the RFC 6238 test vectors, "12345678901234567890",
+// base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTPMatchesRFC6238(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The RFC lists 8-digit codes; a 6-digit code is their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		step := uint64(tt.unix / int64(totpPeriod/time.Second))
+		if got := hotp(key, step); got != tt.want {
+			t.Errorf("hotp at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / int64(totpPeriod/time.Second)
+	codeAt := func(offset int64) string { return hotp(key, uint64(current+offset)) }
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+		step   int64
+	}{
+		{"current step", rfc6238Secret, codeAt(0), true, current},
+		{"previous step", rfc6238Secret, codeAt(-1), true, current - 1},
+		{"next step", rfc6238Secret, codeAt(1), true, current + 1},
+		{"two steps back", rfc6238Secret, codeAt(-2), false, 0},
+		{"two steps ahead", rfc6238Secret, codeAt(2), false, 0},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", codeAt(0), true, current},
+		{"too short", rfc6238Secret, codeAt(0)[:5], false, 0},
+		{"too long", rfc6238Secret, codeAt(0) + "0", false, 0},
+		{"invalid secret", "not base32!", codeAt(0), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := verifyTOTP(tt.secret, tt.code, now)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("verifyTOTP = (%d, %v), want (%d, %v)", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+const (
+	// challengeLifetime is how long a user has to enter their code after giving their password.
+	challengeLifetime = 5 * time.Minute
+	recoveryCodeCount = 10
+
+	challengeTwoFactor      = "2fa"
+	challengeTwoFactorSetup = "2fa_setup"
+)
+
+var (
+	ErrInvalidChallenge        = errors.New("invalid or expired login challenge; log in again")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotPending     = errors.New("start two-factor enrollment first")
+	ErrTwoFactorRequiredByRole = errors.New("one of your roles requires two-factor authentication")
+)
+
+// LoginResult is the outcome of checking a user's password. Either the tokens are set, or the user
+// has to complete a second step with ChallengeToken: entering a code when TwoFactorRequired,
+// or enrolling an authenticator when TwoFactorSetupRequired.
+type LoginResult struct {
+	*TokenPair
+	TwoFactorRequired      bool   `json:"two_factor_required,omitempty"`
+	TwoFactorSetupRequired bool   `json:"two_factor_setup_required,omitempty"`
+	ChallengeToken         string `json:"challenge_token,omitempty"`
+}
+
+// TwoFactorStatus describes a user's two-factor setup.
+type TwoFactorStatus struct {
+	Enabled                bool  `json:"enabled"`
+	Required               bool  `json:"required"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorEnrollment is what the user needs to add the vault to their authenticator app.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TwoFactorSetupResult is returned when a user enrolls as part of logging in.
+type TwoFactorSetupResult struct {
+	*TokenPair
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type CompleteLoginParams struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
+}
+
+// loginChallenge decides whether a user who has just given the right password still has to
+// pass a second step. It returns nil if they can be logged in straight away.
+func (s *Service) loginChallenge(ctx context.Context, userID int64) (*LoginResult, error) {
+	enabled, err := s.twoFactorEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		token, err := s.issueChallenge(userID, challengeTwoFactor)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{TwoFactorRequired: true, ChallengeToken: token}, nil
+	}
+
+	required, err := s.queries.UserRequiresTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check two-factor requirement: %w", err)
+	}
+	if required {
+		token, err := s.issueChallenge(userID, challengeTwoFactorSetup)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{TwoFactorSetupRequired: true, ChallengeToken: token}, nil
+	}
+	return nil, nil
+}
+
+// issueChallenge signs a short-lived token proving userID has given their password. It has no
+// session, so AuthMiddleware never accepts it.
+func (s *Service) issueChallenge(userID int64, purpose string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":     userID,
+		"purpose": purpose,
+		"iat":     now.Unix(),
+		"exp":     now.Add(challengeLifetime).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.jwtSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return token, nil
+}
+
+func (s *Service) parseChallenge(tokenString, purpose string) (int64, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return 0, ErrInvalidChallenge
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return 0, ErrInvalidChallenge
+	}
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, ErrInvalidChallenge
+	}
+	return int64(userID), nil
+}
+
+// CompleteTwoFactorLogin finishes a login that needed a second factor. The code is either the
+// current code from the user's authenticator app or one of their recovery codes.
+func (s *Service) CompleteTwoFactorLogin(ctx context.Context, params CompleteLoginParams) (*TokenPair, error) {
+	userID, err := s.parseChallenge(params.ChallengeToken, challengeTwoFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyThrottledSecondFactor(ctx, userID, params.Code, params.IPAddress); err != nil {
+		return nil, err
+	}
+	return s.startSession(ctx, userID, params.UserAgent, params.IPAddress)
+}
+
+// BeginTwoFactorSetup starts enrollment for a user whose role requires two-factor
+// authentication but who hasn't set it up, using the challenge from LoginUser.
+func (s *Service) BeginTwoFactorSetup(ctx context.Context, challengeToken string) (*TwoFactorEnrollment, error) {
+	userID, err := s.parseChallenge(challengeToken, challengeTwoFactorSetup)
+	if err != nil {
+		return nil, err
+	}
+	return s.BeginTwoFactorEnrollment(ctx, userID)
+}
+
+// CompleteTwoFactorSetup confirms enrollment started with BeginTwoFactorSetup and logs the user in.
+func (s *Service) CompleteTwoFactorSetup(ctx context.Context, params CompleteLoginParams) (*TwoFactorSetupResult, error) {
+	userID, err := s.parseChallenge(params.ChallengeToken, challengeTwoFactorSetup)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := s.ConfirmTwoFactorEnrollment(ctx, userID, params.Code)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.startSession(ctx, userID, params.UserAgent, params.IPAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactorSetupResult{TokenPair: tokens, RecoveryCodes: codes}, nil
+}
+
+func (s *Service) twoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	totp, err := s.queries.GetUserTOTP(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check two-factor status: %w", err)
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+// GetTwoFactorStatus reports whether userID has two-factor authentication on, whether their
+// roles require it, and how many unused recovery codes they have left.
+func (s *Service) GetTwoFactorStatus(ctx context.Context, userID int64) (*TwoFactorStatus, error) {
+	enabled, err := s.twoFactorEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := s.queries.UserRequiresTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check two-factor requirement: %w", err)
+	}
+	remaining, err := s.queries.CountUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return &TwoFactorStatus{Enabled: enabled, Required: required, RecoveryCodesRemaining: remaining}, nil
+}
+
+// BeginTwoFactorEnrollment generates a new authenticator secret for userID. It only takes
+// effect once confirmed with ConfirmTwoFactorEnrollment; calling this again replaces it.
+func (s *Service) BeginTwoFactorEnrollment(ctx context.Context, userID int64) (*TwoFactorEnrollment, error) {
+	user, err := s.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate two-factor secret: %w", err)
+	}
+	if _, err := s.queries.UpsertPendingTOTP(ctx, db.UpsertPendingTOTPParams{UserID: userID, Secret: secret}); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTwoFactorAlreadyEnabled
+		}
+		return nil, fmt.Errorf("failed to save two-factor secret: %w", err)
+	}
+
+	return &TwoFactorEnrollment{Secret: secret, OTPAuthURI: totpURI(s.totpIssuer, user.Email, secret)}, nil
+}
+
+// ConfirmTwoFactorEnrollment turns two-factor authentication on once the user has shown their
+// authenticator produces the right codes. It returns the user's recovery codes, which are not
+// shown again.
+func (s *Service) ConfirmTwoFactorEnrollment(ctx context.Context, userID int64, code string) ([]string, error) {
+	totp, err := s.queries.GetUserTOTP(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTwoFactorNotPending
+		}
+		return nil, fmt.Errorf("failed to get two-factor secret: %w", err)
+	}
+	if totp.ConfirmedAt.Valid {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if err := s.acceptTOTP(ctx, totp, code); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+	if err := qtx.ConfirmUserTOTP(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	codes, err := s.replaceRecoveryCodes(ctx, qtx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.auditService.LogActivityTx(ctx, tx, userID, "2fa:enroll", map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off. The user has to prove they still
+// have a second factor, and can't turn it off while one of their roles requires it.
+func (s *Service) DisableTwoFactor(ctx context.Context, userID int64, code, ipAddress string) error {
+	required, err := s.queries.UserRequiresTwoFactor(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to check two-factor requirement: %w", err)
+	}
+	if required {
+		return ErrTwoFactorRequiredByRole
+	}
+	if err := s.verifyThrottledSecondFactor(ctx, userID, code, ipAddress); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+	if err := qtx.DeleteUserTOTP(ctx, userID); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	if err := qtx.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if err := s.auditService.LogActivityTx(ctx, tx, userID, "2fa:disable", map[string]interface{}{}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all of a user's recovery codes with new ones.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID int64, code, ipAddress string) ([]string, error) {
+	if err := s.verifyThrottledSecondFactor(ctx, userID, code, ipAddress); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := s.replaceRecoveryCodes(ctx, s.queries.WithTx(tx), userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.auditService.LogActivityTx(ctx, tx, userID, "2fa:recovery_codes_regenerate", map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+func (s *Service) replaceRecoveryCodes(ctx context.Context, qtx *db.Queries, userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		// Ten random base32 characters, e.g. "k3x7q-m2vaz".
+		secret, err := newTOTPSecret()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+		}
+		code := strings.ToLower(secret[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	if err := qtx.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if err := qtx.CreateRecoveryCodes(ctx, db.CreateRecoveryCodesParams{UserID: userID, CodeHashes: hashes}); err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// verifyThrottledSecondFactor is verifySecondFactor for codes entered by the user. Codes are
+// short, so wrong ones count towards the same lockout as wrong passwords.
+func (s *Service) verifyThrottledSecondFactor(ctx context.Context, userID int64, code, ipAddress string) error {
+	user, err := s.queries.GetUserByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if err := s.checkLoginThrottle(ctx, user.Email, ipAddress); err != nil {
+		return err
+	}
+	if err := s.verifySecondFactor(ctx, userID, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			s.recordLoginFailure(ctx, user.Email, ipAddress, userID, "wrong_2fa_code")
+		}
+		return err
+	}
+	s.clearAccountThrottle(ctx, user.Email)
+	return nil
+}
+
+// verifySecondFactor checks a code from the user's authenticator app, or failing that one of
+// their recovery codes, which is then used up.
+func (s *Service) verifySecondFactor(ctx context.Context, userID int64, code string) error {
+	totp, err := s.queries.GetUserTOTP(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrTwoFactorNotEnabled
+		}
+		return fmt.Errorf("failed to get two-factor secret: %w", err)
+	}
+	if !totp.ConfirmedAt.Valid {
+		return ErrTwoFactorNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return s.acceptTOTP(ctx, totp, code)
+	}
+
+	codeID, err := s.queries.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrInvalidTwoFactorCode
+		}
+		return fmt.Errorf("failed to check recovery code: %w", err)
+	}
+	s.auditService.LogActivity(ctx, userID, "2fa:recovery_code_use", map[string]interface{}{
+		"recovery_code_id": codeID,
+	})
+	return nil
+}
+
+// acceptTOTP checks an authenticator code and marks its time step as used.
+func (s *Service) acceptTOTP(ctx context.Context, totp db.UserTotp, code string) error {
+	step, ok := verifyTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	if _, err := s.queries.UseTOTPStep(ctx, db.UseTOTPStepParams{Step: pgtype.Int8{Int64: step, Valid: true}, UserID: totp.UserID}); err != nil {
+		if err == pgx.ErrNoRows {
+			// This code, or a later one, has already been used.
+			return ErrInvalidTwoFactorCode
+		}
+		return fmt.Errorf("failed to record two-factor code: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/karanbihani/file-vault/internal/core/audit"
+	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/pagination"
+	"github.com/karanbihani/file-vault/internal/storage"
+)
+
+// auditExportBatchSize is how many entries an export reads at a time.
+const auditExportBatchSize = 1000
+
+// AuditLogEntry is an audit log entry as shown to admins.
+type AuditLogEntry = audit.Entry
+
+// AuditLogFilter narrows an audit log listing or export. Empty fields match every entry.
+// Action is either an exact action, such as "file:upload", or a prefix ending in "*", such as
+// "share:*". FileID matches entries whose details name that file_id, and RequestID those
+// logged for that request.
+type AuditLogFilter struct {
+	UserID    *int64
+	Action    string
+	FileID    *int64
+	RequestID string
+	StartDate *time.Time
+	EndDate   *time.Time
+}
+
+// escapeLike escapes the characters LIKE treats specially, so s only matches itself.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func optionalInt(v *int64) pgtype.Int8 {
+	if v == nil {
+		return pgtype.Int8{}
+	}
+	return pgtype.Int8{Int64: *v, Valid: true}
+}
+
+func optionalTimestamp(t *time.Time) pgtype.Timestamptz {
+	if t == nil {
+		return pgtype.Timestamptz{}
+	}
+	return pgtype.Timestamptz{Time: *t, Valid: true}
+}
+
+// listAuditLogs queries up to limit entries matching filter, after the entry at after if it
+// isn't nil.
+func (s *Service) listAuditLogs(ctx context.Context, filter AuditLogFilter, descending bool, after *pagination.Key, limit int32) ([]db.AuditLog, error) {
+	params := db.ListAuditLogsParams{
+		UserID:    optionalInt(filter.UserID),
+		FileID:    optionalInt(filter.FileID),
+		StartDate: optionalTimestamp(filter.StartDate),
+		EndDate:   optionalTimestamp(filter.EndDate),
+		RequestID: pgtype.Text{String: filter.RequestID, Valid: filter.RequestID != ""},
+		PageLimit: limit,
+	}
+	if prefix, ok := strings.CutSuffix(filter.Action, "*"); ok {
+		params.ActionPrefix = pgtype.Text{String: escapeLike(prefix), Valid: true}
+	} else if filter.Action != "" {
+		params.Action = pgtype.Text{String: filter.Action, Valid: true}
+	}
+	if after != nil {
+		params.CursorID = pgtype.Int8{Int64: after.ID, Valid: true}
+		params.CursorTime = pgtype.Timestamptz{Time: after.Time, Valid: true}
+	}
+
+	var rows []db.AuditLog
+	var err error
+	if descending {
+		rows, err = s.queries.ListAuditLogsDescending(ctx, db.ListAuditLogsDescendingParams(params))
+	} else {
+		rows, err = s.queries.ListAuditLogs(ctx, params)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+	return rows, nil
+}
+
+// ListAuditLogs returns a page of the audit log entries that match filter.
+func (s *Service) ListAuditLogs(ctx context.Context, filter AuditLogFilter, page pagination.Request) (pagination.Page[AuditLogEntry], error) {
+	rows, err := s.listAuditLogs(ctx, filter, page.Sort.Descending, page.After, page.FetchLimit())
+	if err != nil {
+		return pagination.Page[AuditLogEntry]{}, err
+	}
+	logs := pagination.NewPage(page, rows, func(l db.AuditLog) pagination.Key {
+		return pagination.Key{Time: l.Timestamp.Time, ID: l.ID}
+	})
+
+	entries := make([]AuditLogEntry, 0, len(logs.Items))
+	for _, l := range logs.Items {
+		entries = append(entries, audit.NewEntry(l))
+	}
+	return pagination.Page[AuditLogEntry]{Items: entries, NextCursor: logs.NextCursor}, nil
+}
+
+// ExportAuditLogs calls fn with every audit log entry that matches filter, oldest first, and
+// stops at the first error fn returns. Entries are read in batches, so an export of the whole
+// log doesn't have to fit in memory.
+func (s *Service) ExportAuditLogs(ctx context.Context, filter AuditLogFilter, fn func(AuditLogEntry) error) error {
+	var after *pagination.Key
+	for {
+		rows, err := s.listAuditLogs(ctx, filter, false, after, auditExportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, l := range rows {
+			if err := fn(audit.NewEntry(l)); err != nil {
+				return err
+			}
+		}
+		if len(rows) < auditExportBatchSize {
+			return nil
+		}
+		last := rows[len(rows)-1]
+		after = &pagination.Key{Time: last.Timestamp.Time, ID: last.ID}
+	}
+}
+
+// VerifyAuditLog checks the audit log against its hash chain and checkpoints, and records that
+// actorID did so.
+func (s *Service) VerifyAuditLog(ctx context.Context, actorID int64) (*audit.VerifyResult, error) {
+	result, err := s.auditService.Verify(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{
+		"valid":            result.Valid,
+		"entries_verified": result.EntriesVerified,
+		"last_entry_id":    result.LastEntryID,
+	}
+	if result.Break != nil {
+		details["broken_entry_id"] = result.Break.EntryID
+		details["reason"] = result.Break.Reason
+	}
+	s.auditService.LogActivity(ctx, actorID, "audit:verify", details)
+	return result, nil
+}
+
+// matches reports whether an entry read back from an archived segment passes the filter, in
+// the same way the database applies it to entries that haven't been archived.
+func (f AuditLogFilter) matches(e AuditLogEntry) bool {
+	if f.UserID != nil && (e.UserID == nil || *e.UserID != *f.UserID) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(f.Action, "*"); ok {
+		if !strings.HasPrefix(e.Action, prefix) {
+			return false
+		}
+	} else if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.FileID != nil {
+		var details struct {
+			FileID *int64 `json:"file_id"`
+		}
+		if json.Unmarshal(e.Details, &details) != nil || details.FileID == nil || *details.FileID != *f.FileID {
+			return false
+		}
+	}
+	if f.RequestID != "" && e.RequestID != f.RequestID {
+		return false
+	}
+	if f.StartDate != nil && e.Timestamp.Before(*f.StartDate) {
+		return false
+	}
+	if f.EndDate != nil && e.Timestamp.After(*f.EndDate) {
+		return false
+	}
+	return true
+}
+
+// AuditArchiveSegment describes a segment of old audit log entries that was moved out of the
+// database into object storage.
+type AuditArchiveSegment struct {
+	ID             int64     `json:"id"`
+	FirstEntryID   int64     `json:"first_entry_id"`
+	LastEntryID    int64     `json:"last_entry_id"`
+	FirstTimestamp time.Time `json:"first_timestamp"`
+	LastTimestamp  time.Time `json:"last_timestamp"`
+	EntryCount     int64     `json:"entry_count"`
+	SizeBytes      int64     `json:"size_bytes"`
+	Sha256Hash     string    `json:"sha256_hash"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func newAuditArchiveSegment(seg db.AuditArchiveSegment) AuditArchiveSegment {
+	return AuditArchiveSegment{
+		ID:             seg.ID,
+		FirstEntryID:   seg.FirstEntryID,
+		LastEntryID:    seg.LastEntryID,
+		FirstTimestamp: seg.FirstTimestamp.Time,
+		LastTimestamp:  seg.LastTimestamp.Time,
+		EntryCount:     seg.EntryCount,
+		SizeBytes:      seg.SizeBytes,
+		Sha256Hash:     seg.Sha256Hash,
+		CreatedAt:      seg.CreatedAt.Time,
+	}
+}
+
+// ListAuditArchive lists the archived segments with entries between start and end, either of
+// which may be nil, oldest first.
+func (s *Service) ListAuditArchive(ctx context.Context, start, end *time.Time) ([]AuditArchiveSegment, error) {
+	segments, err := s.auditService.ListSegments(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]AuditArchiveSegment, 0, len(segments))
+	for _, seg := range segments {
+		result = append(result, newAuditArchiveSegment(seg))
+	}
+	return result, nil
+}
+
+// OpenAuditArchiveSegment opens an archived segment for actorID to download, as gzipped
+// NDJSON with one entry per line. The caller must close it.
+func (s *Service) OpenAuditArchiveSegment(ctx context.Context, actorID, segmentID int64) (*AuditArchiveSegment, storage.Object, error) {
+	seg, err := s.auditService.GetSegment(ctx, segmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	object, err := s.auditService.OpenSegment(ctx, *seg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.auditService.LogActivity(ctx, actorID, "audit:archive_download", map[string]interface{}{
+		"segment_id": seg.ID,
+	})
+	info := newAuditArchiveSegment(*seg)
+	return &info, object, nil
+}
+
+// QueryAuditArchiveSegment returns the entries in an archived segment that match filter. The
+// segment is checked against its record while it is read.
+func (s *Service) QueryAuditArchiveSegment(ctx context.Context, segmentID int64, filter AuditLogFilter) ([]AuditLogEntry, error) {
+	seg, err := s.auditService.GetSegment(ctx, segmentID)
+	if err != nil {
+		return nil, err
+	}
+	entries := []AuditLogEntry{}
+	err = s.auditService.ReadSegment(ctx, *seg, func(e AuditLogEntry) error {
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -2,25 +2,57 @@ package admin
 
 import (
 	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/karanbihani/file-vault/internal/auth"
+	"github.com/karanbihani/file-vault/internal/core/audit"
+	"github.com/karanbihani/file-vault/internal/core/files"
+	"github.com/karanbihani/file-vault/internal/core/uploads"
 	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/pagination"
 )
 
 type Service struct {
-	queries *db.Queries
+	db             *pgxpool.Pool
+	queries        *db.Queries
+	auditService   *audit.Service
+	fileService    *files.Service
+	uploadsService *uploads.Service
+	permissions    *auth.PermissionResolver
 }
 
-func NewService(queries *db.Queries) *Service {
-	return &Service{queries: queries}
+func NewService(dbpool *pgxpool.Pool, queries *db.Queries, auditService *audit.Service, fileService *files.Service,
+	uploadsService *uploads.Service, permissions *auth.PermissionResolver) *Service {
+	return &Service{
+		db:             dbpool,
+		queries:        queries,
+		auditService:   auditService,
+		fileService:    fileService,
+		uploadsService: uploadsService,
+		permissions:    permissions,
+	}
 }
 
-func (s *Service) ListAllFiles(ctx context.Context) ([]db.ListAllFilesRow, error) {
-	return s.queries.ListAllFiles(ctx)
+// ListAllFiles returns a page of every user's files.
+func (s *Service) ListAllFiles(ctx context.Context, page pagination.Request) (pagination.Page[db.ListAllFilesRow], error) {
+	rows, err := s.queries.ListAllFiles(ctx, db.ListAllFilesParams{
+		CursorID:   page.CursorID(),
+		SortKey:    page.Sort.Key,
+		Descending: page.Sort.Descending,
+		CursorText: page.CursorText(),
+		CursorInt:  page.CursorInt(),
+		CursorTime: page.CursorTime(),
+		PageLimit:  page.FetchLimit(),
+	})
+	if err != nil {
+		return pagination.Page[db.ListAllFilesRow]{}, fmt.Errorf("failed to list files: %w", err)
+	}
+	return pagination.NewPage(page, rows, func(f db.ListAllFilesRow) pagination.Key {
+		return pagination.FileKey(page.Sort.Key, f.ID, f.Filename, f.MimeType, f.SizeBytes, f.UploadDate)
+	}), nil
 }
 
 func (s *Service) GetSystemStats(ctx context.Context) (db.GetSystemStatsRow, error) {
 	return s.queries.GetSystemStats(ctx)
-}
-
-func (s *Service) ListAuditLogs(ctx context.Context) ([]db.AuditLog, error) {
-	return s.queries.ListAuditLogs(ctx)
 }
\ No newline at end of file
@@ -0,0 +1,409 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/karanbihani/file-vault/internal/auth"
+	"github.com/karanbihani/file-vault/internal/core/rbac"
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+var (
+	ErrUserNotFound           = errors.New("user not found")
+	ErrInvalidQuota           = errors.New("storage quota must be zero or more bytes")
+	ErrCannotModifySelf       = errors.New("you can't suspend or delete your own account")
+	ErrInvalidTransferTarget  = errors.New("files can only be transferred to another existing user")
+	ErrFilesAddedDuringDelete = errors.New("the user gained files while being deleted; try again")
+)
+
+// UserInfo is a user as shown to admins.
+type UserInfo struct {
+	ID                int64      `json:"id"`
+	Email             string     `json:"email"`
+	StorageQuotaBytes int64      `json:"storage_quota_bytes"`
+	StorageUsedBytes  int64      `json:"storage_used_bytes"`
+	CreatedAt         time.Time  `json:"created_at"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at"`
+	SuspendedAt       *time.Time `json:"suspended_at"`
+	Roles             []string   `json:"roles"`
+}
+
+// UserDetail adds what a user stores to UserInfo.
+type UserDetail struct {
+	UserInfo
+	FileCount        int64 `json:"file_count"`
+	TrashedFileCount int64 `json:"trashed_file_count"`
+}
+
+// UserPage is one page of a user listing. Total counts every user matching the filters.
+type UserPage struct {
+	Users []UserInfo `json:"users"`
+	Total int64      `json:"total"`
+}
+
+// UserFilter narrows a user listing. Empty fields match everyone.
+type UserFilter struct {
+	Email     string
+	Role      string
+	Suspended *bool
+	Limit     int32
+	Offset    int32
+}
+
+// DeleteUserParams says what happens to a deleted user's files: with TransferTo set they are
+// given to that user, otherwise they are purged.
+type DeleteUserParams struct {
+	TransferTo int64
+}
+
+type DeleteUserResult struct {
+	FilesPurged      int   `json:"files_purged"`
+	FilesTransferred int64 `json:"files_transferred"`
+	// TransferFolderID is the folder in the new owner's root that holds the transferred files.
+	TransferFolderID *int64 `json:"transfer_folder_id,omitempty"`
+}
+
+func optionalTime(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func userInfo(user db.User, roles []string) UserInfo {
+	return UserInfo{
+		ID:                user.ID,
+		Email:             user.Email,
+		StorageQuotaBytes: user.StorageQuotaBytes,
+		StorageUsedBytes:  user.StorageUsedBytes,
+		CreatedAt:         user.CreatedAt.Time,
+		EmailVerifiedAt:   optionalTime(user.EmailVerifiedAt),
+		SuspendedAt:       optionalTime(user.SuspendedAt),
+		Roles:             roles,
+	}
+}
+
+func (s *Service) getUser(ctx context.Context, q *db.Queries, userID int64) (db.User, error) {
+	user, err := q.GetUserByID(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return db.User{}, ErrUserNotFound
+		}
+		return db.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// ListUsers returns a page of users, ordered by ID.
+func (s *Service) ListUsers(ctx context.Context, filter UserFilter) (*UserPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultUserPageSize
+	}
+	if filter.Limit > maxUserPageSize {
+		filter.Limit = maxUserPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	email := strings.TrimSpace(filter.Email)
+	params := db.ListUsersParams{
+		Email:      pgtype.Text{String: email, Valid: email != ""},
+		Role:       pgtype.Text{String: filter.Role, Valid: filter.Role != ""},
+		PageLimit:  filter.Limit,
+		PageOffset: filter.Offset,
+	}
+	if filter.Suspended != nil {
+		params.Suspended = pgtype.Bool{Bool: *filter.Suspended, Valid: true}
+	}
+
+	rows, err := s.queries.ListUsers(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	page := &UserPage{Users: make([]UserInfo, 0, len(rows))}
+	for _, row := range rows {
+		page.Total = row.TotalCount
+		page.Users = append(page.Users, UserInfo{
+			ID:                row.ID,
+			Email:             row.Email,
+			StorageQuotaBytes: row.StorageQuotaBytes,
+			StorageUsedBytes:  row.StorageUsedBytes,
+			CreatedAt:         row.CreatedAt.Time,
+			EmailVerifiedAt:   optionalTime(row.EmailVerifiedAt),
+			SuspendedAt:       optionalTime(row.SuspendedAt),
+			Roles:             row.Roles,
+		})
+	}
+	return page, nil
+}
+
+// GetUser returns a user with their roles, storage usage and file counts.
+func (s *Service) GetUser(ctx context.Context, userID int64) (*UserDetail, error) {
+	user, err := s.getUser(ctx, s.queries, userID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.queries.ListUserRoles(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+	counts, err := s.queries.GetUserFileCounts(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count user files: %w", err)
+	}
+
+	return &UserDetail{
+		UserInfo:         userInfo(user, roleNames),
+		FileCount:        counts.FileCount,
+		TrashedFileCount: counts.TrashedFileCount,
+	}, nil
+}
+
+// SetUserQuota changes how many bytes a user may store. A quota below what they already use
+// keeps their files but stops further uploads.
+func (s *Service) SetUserQuota(ctx context.Context, actorID, userID, quotaBytes int64) (*UserDetail, error) {
+	if quotaBytes < 0 {
+		return nil, ErrInvalidQuota
+	}
+	old, err := s.getUser(ctx, s.queries, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.queries.SetUserStorageQuota(ctx, db.SetUserStorageQuotaParams{ID: userID, StorageQuotaBytes: quotaBytes}); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to set storage quota: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, actorID, "user:set_quota", map[string]interface{}{
+		"target_user_id": userID,
+		"old_quota":      old.StorageQuotaBytes,
+		"new_quota":      quotaBytes,
+	})
+	return s.GetUser(ctx, userID)
+}
+
+// SuspendUser stops a user from logging in or using their API keys, and signs them out
+// everywhere. It fails with rbac.ErrLastAdmin if nobody else could then manage roles.
+func (s *Service) SuspendUser(ctx context.Context, actorID, userID int64) (*UserDetail, error) {
+	if actorID == userID {
+		return nil, ErrCannotModifySelf
+	}
+
+	suspended, revoked, err := s.suspend(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if suspended {
+		s.auditService.LogActivity(ctx, actorID, "user:suspend", map[string]interface{}{
+			"target_user_id":   userID,
+			"sessions_revoked": revoked,
+		})
+	}
+	return s.GetUser(ctx, userID)
+}
+
+// suspend suspends userID and revokes their sessions. It reports whether they were active.
+func (s *Service) suspend(ctx context.Context, userID int64) (bool, int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	if err := qtx.LockRoleAssignments(ctx); err != nil {
+		return false, 0, fmt.Errorf("could not lock role assignments: %w", err)
+	}
+	if _, err := s.getUser(ctx, qtx, userID); err != nil {
+		return false, 0, err
+	}
+	suspended, err := qtx.SuspendUser(ctx, userID)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to suspend user: %w", err)
+	}
+	if err := checkAdminsRemain(ctx, qtx); err != nil {
+		return false, 0, err
+	}
+	revoked, err := qtx.RevokeAllUserSessions(ctx, userID)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return suspended > 0, revoked, nil
+}
+
+// ReactivateUser lifts a suspension. The user has to log in again.
+func (s *Service) ReactivateUser(ctx context.Context, actorID, userID int64) (*UserDetail, error) {
+	if _, err := s.getUser(ctx, s.queries, userID); err != nil {
+		return nil, err
+	}
+	reactivated, err := s.queries.ReactivateUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+	if reactivated > 0 {
+		s.auditService.LogActivity(ctx, actorID, "user:reactivate", map[string]interface{}{
+			"target_user_id": userID,
+		})
+	}
+	return s.GetUser(ctx, userID)
+}
+
+// checkAdminsRemain returns rbac.ErrLastAdmin if nobody active can manage roles any more.
+func checkAdminsRemain(ctx context.Context, qtx *db.Queries) error {
+	admins, err := qtx.CountUsersWithPermission(ctx, auth.PermissionAdminManageRoles)
+	if err != nil {
+		return fmt.Errorf("could not count administrators: %w", err)
+	}
+	if admins == 0 {
+		return rbac.ErrLastAdmin
+	}
+	return nil
+}
+
+// DeleteUser deletes a user's account. Their files are either transferred to another user,
+// into a new folder at that user's root, or purged, releasing their physical files. Shares of
+// the files with other users survive a transfer; shares with the deleted user disappear.
+//
+// Purging happens before the account is deleted, so the user is suspended first to keep them
+// from adding files in the meantime. If deleting fails part way they stay suspended.
+func (s *Service) DeleteUser(ctx context.Context, actorID, userID int64, params DeleteUserParams) (*DeleteUserResult, error) {
+	if actorID == userID {
+		return nil, ErrCannotModifySelf
+	}
+	user, err := s.getUser(ctx, s.queries, userID)
+	if err != nil {
+		return nil, err
+	}
+	if params.TransferTo != 0 {
+		if params.TransferTo == userID {
+			return nil, ErrInvalidTransferTarget
+		}
+		if _, err := s.getUser(ctx, s.queries, params.TransferTo); err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				return nil, ErrInvalidTransferTarget
+			}
+			return nil, err
+		}
+	}
+
+	// Suspending also checks that someone else can still manage roles.
+	if _, _, err := s.suspend(ctx, userID); err != nil {
+		return nil, err
+	}
+	if _, err := s.uploadsService.TerminateUserUploads(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	result := &DeleteUserResult{}
+	if params.TransferTo == 0 {
+		if result.FilesPurged, err = s.fileService.PurgeUserFiles(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	if err := qtx.LockRoleAssignments(ctx); err != nil {
+		return nil, fmt.Errorf("could not lock role assignments: %w", err)
+	}
+	// Re-read inside the transaction for an up-to-date storage usage.
+	if user, err = s.getUser(ctx, qtx, userID); err != nil {
+		return nil, err
+	}
+	counts, err := qtx.GetUserFileCounts(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count user files: %w", err)
+	}
+
+	if params.TransferTo != 0 {
+		folderID, err := transferFiles(ctx, qtx, user, params.TransferTo)
+		if err != nil {
+			return nil, err
+		}
+		result.FilesTransferred = counts.FileCount + counts.TrashedFileCount
+		result.TransferFolderID = &folderID
+	} else if counts.FileCount+counts.TrashedFileCount > 0 {
+		return nil, ErrFilesAddedDuringDelete
+	}
+
+	if _, err := qtx.DeleteUser(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+	if err := checkAdminsRemain(ctx, qtx); err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{
+		"target_user_id": userID,
+		"email":          user.Email,
+		"files_purged":   result.FilesPurged,
+	}
+	if params.TransferTo != 0 {
+		details["transfer_to"] = params.TransferTo
+		details["files_transferred"] = result.FilesTransferred
+	}
+	if err := s.auditService.LogActivityTx(ctx, tx, actorID, "user:delete", details); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.permissions.InvalidateUser(ctx, userID)
+	return result, nil
+}
+
+// transferFiles gives all of user's folders and files to toUserID, under a new folder at their
+// root, along with the storage the files are charged for. It returns the new folder's ID.
+func transferFiles(ctx context.Context, qtx *db.Queries, user db.User, toUserID int64) (int64, error) {
+	name := "Transferred from " + user.Email
+	if len(name) > 255 {
+		name = fmt.Sprintf("Transferred from user %d", user.ID)
+	}
+	folder, err := qtx.CreateFolder(ctx, db.CreateFolderParams{OwnerID: toUserID, Name: name})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create folder for transferred files: %w", err)
+	}
+	root := pgtype.Int8{Int64: folder.ID, Valid: true}
+
+	if err := qtx.TransferUserFolders(ctx, db.TransferUserFoldersParams{ToUserID: toUserID, RootFolderID: root, FromUserID: user.ID}); err != nil {
+		return 0, fmt.Errorf("failed to transfer folders: %w", err)
+	}
+	if err := qtx.TransferUserFiles(ctx, db.TransferUserFilesParams{ToUserID: toUserID, RootFolderID: root, FromUserID: user.ID}); err != nil {
+		return 0, fmt.Errorf("failed to transfer files: %w", err)
+	}
+	// Anything the deleted user had shared with the new owner is now theirs outright.
+	if err := qtx.DeleteSharesWithOwner(ctx, toUserID); err != nil {
+		return 0, fmt.Errorf("failed to remove shares with the new owner: %w", err)
+	}
+	if err := qtx.UpdateUserStorageUsage(ctx, db.UpdateUserStorageUsageParams{Amount: user.StorageUsedBytes, ID: toUserID}); err != nil {
+		return 0, fmt.Errorf("failed to update storage usage: %w", err)
+	}
+	return folder.ID, nil
+}
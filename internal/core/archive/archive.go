@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/storage"
+)
+
+// MaxEntries caps how many files a single archive may contain.
+const MaxEntries = 1000
+
+var (
+	ErrNoFiles      = errors.New("no files to archive")
+	ErrTooManyFiles = fmt.Errorf("an archive can contain at most %d files", MaxEntries)
+)
+
+// Entry is one file to put in an archive.
+type Entry struct {
+	// Dir is the folder path inside the archive, e.g. "reports/2024/". Empty means the top level.
+	Dir         string
+	Filename    string
+	StoragePath string
+}
+
+// FolderEntries lists every file in a folder's subtree, laid out by its path below that folder.
+// Trashed files are left out.
+func FolderEntries(ctx context.Context, queries *db.Queries, folderID int64) ([]Entry, error) {
+	rows, err := queries.ListFilesInSubtreeForArchive(ctx, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in folder: %w", err)
+	}
+	if len(rows) > MaxEntries {
+		return nil, ErrTooManyFiles
+	}
+
+	entries := make([]Entry, len(rows))
+	for i, row := range rows {
+		entries[i] = Entry{Dir: row.Path, Filename: row.Filename, StoragePath: row.StoragePath}
+	}
+	return entries, nil
+}
+
+// sanitize makes a filename safe to use as a single ZIP path component.
+func sanitize(filename string) string {
+	filename = strings.NewReplacer("/", "_", "\\", "_").Replace(filename)
+	if filename == "" || filename == "." || filename == ".." {
+		return "_"
+	}
+	return filename
+}
+
+// sanitizeDir sanitizes each component of a folder path such as "reports/2024/". Folders
+// named before backslashes were rejected may still contain one.
+func sanitizeDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSuffix(dir, "/"), "/")
+	for i, part := range parts {
+		parts[i] = sanitize(part)
+	}
+	return strings.Join(parts, "/") + "/"
+}
+
+// Names returns the path each entry is stored under. Entries that would otherwise share a
+// path get a numbered suffix before the extension: "report.pdf", "report (2).pdf", ...
+func Names(entries []Entry) []string {
+	used := make(map[string]bool, len(entries))
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		dir, filename := sanitizeDir(e.Dir), sanitize(e.Filename)
+		name := dir + filename
+		if used[name] {
+			ext := path.Ext(filename)
+			base := strings.TrimSuffix(filename, ext)
+			for n := 2; used[name]; n++ {
+				name = fmt.Sprintf("%s%s (%d)%s", dir, base, n, ext)
+			}
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// Write streams a ZIP archive of entries to w, reading each file from backend as it goes.
+// Nothing is buffered beyond the current file's compression window, so the response can
+// start before the last file has been fetched.
+func Write(ctx context.Context, w io.Writer, backend storage.Backend, entries []Entry) error {
+	zw := zip.NewWriter(w)
+	modified := time.Now()
+
+	for i, name := range Names(entries) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeEntry(ctx, zw, backend, name, entries[i].StoragePath, modified); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeEntry(ctx context.Context, zw *zip.Writer, backend storage.Backend, name, storagePath string, modified time.Time) error {
+	object, err := backend.Get(ctx, storagePath)
+	if err != nil {
+		return fmt.Errorf("could not retrieve %q from storage: %w", name, err)
+	}
+	defer object.Close()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("failed to add %q to archive: %w", name, err)
+	}
+	if _, err := io.Copy(fw, object); err != nil {
+		return fmt.Errorf("failed to write %q to archive: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package archive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+		want    []string
+	}{
+		{
+			name:    "top level",
+			entries: []Entry{{Filename: "a.txt"}, {Dir: "reports/2024/", Filename: "b.txt"}},
+			want:    []string{"a.txt", "reports/2024/b.txt"},
+		},
+		{
+			name:    "duplicates",
+			entries: []Entry{{Filename: "report.pdf"}, {Filename: "report.pdf"}, {Dir: "x/", Filename: "report.pdf"}},
+			want:    []string{"report.pdf", "report (2).pdf", "x/report.pdf"},
+		},
+		{
+			name:    "separators in filenames",
+			entries: []Entry{{Filename: "../etc/passwd"}, {Filename: `..\evil.exe`}, {Filename: ".."}},
+			want:    []string{".._etc_passwd", ".._evil.exe", "_"},
+		},
+		{
+			name:    "separators in folder names",
+			entries: []Entry{{Dir: `..\..\windows/`, Filename: "a.txt"}, {Dir: "../x/", Filename: "b.txt"}},
+			want:    []string{".._.._windows/a.txt", "_/x/b.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Names(tt.entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Names = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
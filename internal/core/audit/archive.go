@@ -0,0 +1,317 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/storage"
+)
+
+// archiveSegmentSize is the most entries one archived segment holds.
+const archiveSegmentSize = 10000
+
+var (
+	ErrSegmentNotFound = errors.New("archived audit log segment not found")
+	// ErrSegmentCorrupted is returned when an archived object doesn't match its record.
+	ErrSegmentCorrupted = errors.New("archived audit log segment doesn't match its record")
+)
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// segmentSignature signs everything recorded about a segment.
+func (s *Service) segmentSignature(seg db.AuditArchiveSegment) string {
+	payload, _ := json.Marshal([]interface{}{
+		"segment",
+		seg.ObjectKey,
+		seg.FirstEntryID,
+		seg.LastEntryID,
+		formatTime(seg.FirstTimestamp.Time),
+		formatTime(seg.LastTimestamp.Time),
+		seg.EntryCount,
+		seg.SizeBytes,
+		seg.Sha256Hash,
+		seg.FirstPrevHash.String,
+		seg.LastHash.String,
+		formatTime(seg.CreatedAt.Time),
+	})
+	return s.digest(payload)
+}
+
+// ArchiveExpired moves every entry older than retention out of the database into archived
+// segments, oldest first, and returns how many segments and entries it archived.
+func (s *Service) ArchiveExpired(ctx context.Context, retention time.Duration) (int, int64, error) {
+	cutoff := time.Now().Add(-retention)
+	var segments int
+	var entries int64
+	for {
+		seg, err := s.archiveSegment(ctx, cutoff)
+		if err != nil {
+			return segments, entries, err
+		}
+		if seg == nil {
+			return segments, entries, nil
+		}
+		segments++
+		entries += seg.EntryCount
+	}
+}
+
+// archiveSegment archives the oldest entries logged before cutoff, up to archiveSegmentSize of
+// them, and returns the new segment, or nil if there was nothing to archive.
+//
+// Only a run of the oldest entries is archived, so that the entries left in the database still
+// continue the chain where the archive ends. The entries are only deleted once the object has
+// been written and read back, in the same transaction that records the segment.
+func (s *Service) archiveSegment(ctx context.Context, cutoff time.Time) (*db.AuditArchiveSegment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	if err := qtx.LockAuditArchive(ctx); err != nil {
+		return nil, fmt.Errorf("failed to lock audit archive: %w", err)
+	}
+	var latest *db.AuditArchiveSegment
+	if seg, err := qtx.GetLatestAuditArchiveSegment(ctx); err == nil {
+		latest = &seg
+	} else if err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to get latest audit archive segment: %w", err)
+	}
+
+	var afterID int64
+	if latest != nil {
+		afterID = latest.LastEntryID
+	}
+	entries, err := qtx.ListAuditChain(ctx, db.ListAuditChainParams{AfterID: afterID, BatchSize: archiveSegmentSize})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	n := 0
+	for n < len(entries) && entries[n].Timestamp.Time.Before(cutoff) {
+		n++
+	}
+	entries = entries[:n]
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// A broken chain is left where it is, so that verification can still point at it.
+	w := s.newChainWalker(latest)
+	for _, e := range entries {
+		if reason := w.next(e); reason != "" {
+			return nil, fmt.Errorf("refusing to archive audit log entry %d: %s", e.ID, reason)
+		}
+	}
+
+	first, last := entries[0], entries[len(entries)-1]
+	seg := db.AuditArchiveSegment{
+		ObjectKey:      fmt.Sprintf("segments/%020d-%020d.ndjson.gz", first.ID, last.ID),
+		FirstEntryID:   first.ID,
+		LastEntryID:    last.ID,
+		FirstTimestamp: first.Timestamp,
+		LastTimestamp:  last.Timestamp,
+		EntryCount:     int64(len(entries)),
+		FirstPrevHash:  first.PrevHash,
+		LastHash:       last.Hash,
+		// Postgres keeps microseconds; the signature has to cover the time as stored.
+		CreatedAt: pgtype.Timestamptz{Time: time.Now().UTC().Truncate(time.Microsecond), Valid: true},
+	}
+	for _, e := range entries {
+		if e.Timestamp.Time.Before(seg.FirstTimestamp.Time) {
+			seg.FirstTimestamp = e.Timestamp
+		}
+		if e.Timestamp.Time.After(seg.LastTimestamp.Time) {
+			seg.LastTimestamp = e.Timestamp
+		}
+	}
+
+	var buf bytes.Buffer
+	sum := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(&buf, sum))
+	enc := json.NewEncoder(gz)
+	for _, e := range entries {
+		if err := enc.Encode(NewEntry(e)); err != nil {
+			return nil, fmt.Errorf("failed to encode audit log entry %d: %w", e.ID, err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress audit log segment: %w", err)
+	}
+	seg.SizeBytes = int64(buf.Len())
+	seg.Sha256Hash = hex.EncodeToString(sum.Sum(nil))
+
+	if err := s.archive.Save(ctx, seg.ObjectKey, &buf, seg.SizeBytes, "application/gzip"); err != nil {
+		return nil, fmt.Errorf("failed to save audit log segment: %w", err)
+	}
+	// Read the object back, so that nothing is deleted unless the archive really holds it.
+	i := 0
+	err = s.ReadSegment(ctx, seg, func(e Entry) error {
+		if i >= len(entries) || e.ID != entries[i].ID {
+			return ErrSegmentCorrupted
+		}
+		i++
+		return nil
+	})
+	if err == nil && i != len(entries) {
+		err = ErrSegmentCorrupted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify audit log segment %s: %w", seg.ObjectKey, err)
+	}
+
+	created, err := qtx.CreateAuditArchiveSegment(ctx, db.CreateAuditArchiveSegmentParams{
+		ObjectKey:      seg.ObjectKey,
+		FirstEntryID:   seg.FirstEntryID,
+		LastEntryID:    seg.LastEntryID,
+		FirstTimestamp: seg.FirstTimestamp,
+		LastTimestamp:  seg.LastTimestamp,
+		EntryCount:     seg.EntryCount,
+		SizeBytes:      seg.SizeBytes,
+		Sha256Hash:     seg.Sha256Hash,
+		FirstPrevHash:  seg.FirstPrevHash,
+		LastHash:       seg.LastHash,
+		Signature:      s.segmentSignature(seg),
+		CreatedAt:      seg.CreatedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record audit log segment: %w", err)
+	}
+	// Every entry appended since was appended under the chain lock, after the ones read here,
+	// so the range holds exactly the entries just archived.
+	deleted, err := qtx.DeleteAuditLogRange(ctx, db.DeleteAuditLogRangeParams{FirstEntryID: seg.FirstEntryID, LastEntryID: seg.LastEntryID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete archived audit log entries: %w", err)
+	}
+	if deleted != seg.EntryCount {
+		return nil, fmt.Errorf("archived %d audit log entries but %d matched for deletion", seg.EntryCount, deleted)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &created, nil
+}
+
+// StartArchiver archives entries older than retention every interval in the background.
+func (s *Service) StartArchiver(retention, interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			segments, entries, err := s.ArchiveExpired(context.Background(), retention)
+			if err != nil {
+				log.Printf("ERROR: failed to archive audit log: %v", err)
+			}
+			if segments > 0 {
+				log.Printf("Archived %d audit log entries in %d segments", entries, segments)
+			}
+		}
+	}()
+}
+
+// GetSegment retrieves the record of an archived segment.
+func (s *Service) GetSegment(ctx context.Context, segmentID int64) (*db.AuditArchiveSegment, error) {
+	seg, err := s.queries.GetAuditArchiveSegment(ctx, segmentID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrSegmentNotFound
+		}
+		return nil, fmt.Errorf("failed to get audit archive segment: %w", err)
+	}
+	return &seg, nil
+}
+
+// ListSegments retrieves the archived segments with entries between start and end, either of
+// which may be nil, oldest first.
+func (s *Service) ListSegments(ctx context.Context, start, end *time.Time) ([]db.AuditArchiveSegment, error) {
+	params := db.ListAuditArchiveSegmentsParams{}
+	if start != nil {
+		params.StartDate = pgtype.Timestamptz{Time: *start, Valid: true}
+	}
+	if end != nil {
+		params.EndDate = pgtype.Timestamptz{Time: *end, Valid: true}
+	}
+	segments, err := s.queries.ListAuditArchiveSegments(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit archive segments: %w", err)
+	}
+	return segments, nil
+}
+
+// OpenSegment opens the gzipped NDJSON object of an archived segment. The caller must close it.
+func (s *Service) OpenSegment(ctx context.Context, seg db.AuditArchiveSegment) (storage.Object, error) {
+	object, err := s.archive.Get(ctx, seg.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log segment %s: %w", seg.ObjectKey, err)
+	}
+	return object, nil
+}
+
+// ReadSegment calls fn with every entry in an archived segment, in order, and stops at the
+// first error fn returns. Once the whole object has been read it is checked against its
+// record, and ErrSegmentCorrupted is returned if it doesn't match, so callers should hold on
+// to what fn was given until ReadSegment returns nil.
+func (s *Service) ReadSegment(ctx context.Context, seg db.AuditArchiveSegment, fn func(Entry) error) error {
+	object, err := s.OpenSegment(ctx, seg)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	sum := sha256.New()
+	counted := &countingHash{Hash: sum}
+	gz, err := gzip.NewReader(io.TeeReader(object, counted))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSegmentCorrupted, err)
+	}
+	lines := bufio.NewScanner(gz)
+	lines.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lines.Scan() {
+		var e Entry
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			return fmt.Errorf("%w: %v", ErrSegmentCorrupted, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrSegmentCorrupted, err)
+	}
+	// Make sure the whole object has gone through the hash.
+	if _, err := io.Copy(counted, object); err != nil {
+		return fmt.Errorf("failed to read audit log segment %s: %w", seg.ObjectKey, err)
+	}
+	if counted.n != seg.SizeBytes || hex.EncodeToString(sum.Sum(nil)) != seg.Sha256Hash {
+		return ErrSegmentCorrupted
+	}
+	return nil
+}
+
+// countingHash is a hash that also counts the bytes written to it.
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (h *countingHash) Write(p []byte) (int, error) {
+	h.n += int64(len(p))
+	return h.Hash.Write(p)
+}
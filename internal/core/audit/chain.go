@@ -0,0 +1,345 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+// verifyBatchSize is how many entries Verify reads at a time.
+const verifyBatchSize = 1000
+
+// digest hashes payload, with HMAC-SHA256 if there is a key and SHA-256 otherwise, as hex.
+func (s *Service) digest(payload []byte) string {
+	if len(s.hmacKey) == 0 {
+		sum := sha256.Sum256(payload)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, s.hmacKey)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// entryHash is the hash of an entry as stored: its ID, user, action, details as Postgres
+// returns them, timestamp and prev_hash, followed by the request it was logged for if there
+// was one. Entries without a request hash the same as they did before requests were recorded.
+func (s *Service) entryHash(e db.AuditLog) string {
+	var userID interface{}
+	if e.UserID.Valid {
+		userID = e.UserID.Int64
+	}
+	fields := []interface{}{
+		e.ID,
+		userID,
+		e.Action,
+		string(e.Details),
+		e.Timestamp.Time.UTC().Format(time.RFC3339Nano),
+		e.PrevHash.String,
+	}
+	if e.IpAddress.Valid || e.UserAgent.Valid || e.RequestID.Valid {
+		fields = append(fields, e.IpAddress.String, e.UserAgent.String, e.RequestID.String)
+	}
+	payload, _ := json.Marshal(fields)
+	return s.digest(payload)
+}
+
+func (s *Service) checkpointSignature(lastEntryID int64, lastHash string, createdAt time.Time) string {
+	payload, _ := json.Marshal([]interface{}{"checkpoint", lastEntryID, lastHash, createdAt.UTC().Format(time.RFC3339Nano)})
+	return s.digest(payload)
+}
+
+// appendEntry adds an entry to the end of the hash chain in a transaction of its own.
+func (s *Service) appendEntry(ctx context.Context, e pendingEntry) error {
+	s.appendMu.Lock()
+	defer s.appendMu.Unlock()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := s.appendEntryTx(ctx, s.queries.WithTx(tx), e); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// appendEntryTx adds an entry to the end of the hash chain in qtx's transaction, which holds
+// the chain's lock from then on. The entry is inserted first, because its hash has to cover
+// its details as Postgres stores them.
+func (s *Service) appendEntryTx(ctx context.Context, qtx *db.Queries, e pendingEntry) error {
+	if err := qtx.LockAuditChain(ctx); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+	_, prevHash, _, err := chainHead(ctx, qtx)
+	if err != nil {
+		return err
+	}
+
+	created, err := qtx.CreateAuditLog(ctx, db.CreateAuditLogParams{
+		UserID:    e.userID,
+		Action:    e.action,
+		Details:   e.details,
+		PrevHash:  pgtype.Text{String: prevHash, Valid: true},
+		IpAddress: optionalText(e.request.IPAddress),
+		UserAgent: optionalText(e.request.UserAgent),
+		RequestID: optionalText(e.request.RequestID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert audit log: %w", err)
+	}
+	if err := qtx.SetAuditLogHash(ctx, db.SetAuditLogHashParams{ID: created.ID, Hash: s.entryHash(created)}); err != nil {
+		return fmt.Errorf("failed to set audit log hash: %w", err)
+	}
+	return nil
+}
+
+// chainHead returns the ID and hash of the newest entry in the hash chain, or ok false if the
+// chain is empty. Once the archiver has moved every chained entry out of audit_logs, that is
+// the last entry of the newest archived segment.
+func chainHead(ctx context.Context, qtx *db.Queries) (id int64, hash string, ok bool, err error) {
+	head, err := qtx.GetAuditChainHead(ctx)
+	if err == nil {
+		return head.ID, head.Hash, true, nil
+	}
+	if err != pgx.ErrNoRows {
+		return 0, "", false, fmt.Errorf("failed to get audit chain head: %w", err)
+	}
+	seg, err := qtx.GetLatestAuditArchiveSegment(ctx)
+	if err == pgx.ErrNoRows || (err == nil && !seg.LastHash.Valid) {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, fmt.Errorf("failed to get latest audit archive segment: %w", err)
+	}
+	return seg.LastEntryID, seg.LastHash.String, true, nil
+}
+
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+
+// chainWalker follows the hash chain one entry at a time, in order.
+type chainWalker struct {
+	s        *Service
+	prevHash string
+	// chained is set once the walk reaches the first entry with a hash.
+	chained   bool
+	unchained int64
+}
+
+// newChainWalker starts a walk after the last archived segment, or at the very beginning if
+// nothing has been archived.
+func (s *Service) newChainWalker(latest *db.AuditArchiveSegment) *chainWalker {
+	w := &chainWalker{s: s}
+	if latest != nil && latest.LastHash.Valid {
+		w.prevHash = latest.LastHash.String
+		w.chained = true
+	}
+	return w
+}
+
+// next checks the entry that follows the last one walked. It returns why the entry breaks the
+// chain, or "" if it doesn't.
+func (w *chainWalker) next(e db.AuditLog) string {
+	if !e.Hash.Valid {
+		if !w.chained {
+			w.unchained++
+			return ""
+		}
+		return "the entry has no hash"
+	}
+	if e.PrevHash.String != w.prevHash {
+		if !w.chained {
+			return "the first entry in the chain follows one that is missing"
+		}
+		return "the entry's previous hash doesn't match the entry before it, so an entry was inserted or deleted"
+	}
+	if !hmac.Equal([]byte(w.s.entryHash(e)), []byte(e.Hash.String)) {
+		return "the entry doesn't match its hash, so it was modified"
+	}
+	w.chained = true
+	w.prevHash = e.Hash.String
+	return ""
+}
+
+// ChainBreak is the first place where the audit log doesn't match its hash chain, checkpoints
+// or archived segments.
+type ChainBreak struct {
+	EntryID      int64  `json:"entry_id"`
+	CheckpointID int64  `json:"checkpoint_id,omitempty"`
+	SegmentID    int64  `json:"segment_id,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// VerifyResult is the outcome of checking the audit log. UnchainedEntries counts entries from
+// before the hash chain was introduced, which can't be checked. ArchivedEntries counts the
+// entries in archived segments, whose records are checked but whose contents aren't read.
+type VerifyResult struct {
+	Valid               bool        `json:"valid"`
+	EntriesVerified     int64       `json:"entries_verified"`
+	UnchainedEntries    int64       `json:"unchained_entries"`
+	CheckpointsVerified int64       `json:"checkpoints_verified"`
+	SegmentsVerified    int64       `json:"segments_verified"`
+	ArchivedEntries     int64       `json:"archived_entries"`
+	LastEntryID         int64       `json:"last_entry_id,omitempty"`
+	LastHash            string      `json:"last_hash,omitempty"`
+	Break               *ChainBreak `json:"break,omitempty"`
+}
+
+// Verify walks the hash chain from the first entry to the last, recomputing every hash, and
+// checks every checkpoint against it. Archived segments stand in for the entries they hold:
+// their signatures are checked and the chain has to run through them. It stops at the first
+// broken link.
+func (s *Service) Verify(ctx context.Context) (*VerifyResult, error) {
+	result := &VerifyResult{}
+
+	// Checkpoints are read first: every entry they name was committed before them.
+	checkpoints, err := s.queries.ListAuditCheckpoints(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit checkpoints: %w", err)
+	}
+	pending := make(map[int64][]db.AuditCheckpoint)
+	for _, cp := range checkpoints {
+		if !hmac.Equal([]byte(s.checkpointSignature(cp.LastEntryID, cp.LastHash, cp.CreatedAt.Time)), []byte(cp.Signature)) {
+			result.Break = &ChainBreak{EntryID: cp.LastEntryID, CheckpointID: cp.ID, Reason: "the checkpoint's signature is invalid"}
+			return result, nil
+		}
+		pending[cp.LastEntryID] = append(pending[cp.LastEntryID], cp)
+	}
+
+	segments, err := s.queries.ListAuditArchiveSegments(ctx, db.ListAuditArchiveSegmentsParams{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit archive segments: %w", err)
+	}
+	w := s.newChainWalker(nil)
+	var archivedThrough int64
+	for _, seg := range segments {
+		if !hmac.Equal([]byte(s.segmentSignature(seg)), []byte(seg.Signature)) {
+			result.Break = &ChainBreak{EntryID: seg.FirstEntryID, SegmentID: seg.ID, Reason: "the archived segment's signature is invalid"}
+			return result, nil
+		}
+		if seg.FirstEntryID <= archivedThrough || (seg.FirstPrevHash.Valid && seg.FirstPrevHash.String != w.prevHash) || (!seg.FirstPrevHash.Valid && w.chained) {
+			result.Break = &ChainBreak{EntryID: seg.FirstEntryID, SegmentID: seg.ID, Reason: "the archived segment doesn't follow the one before it"}
+			return result, nil
+		}
+		for _, cp := range pending[seg.LastEntryID] {
+			if cp.LastHash != seg.LastHash.String {
+				result.Break = &ChainBreak{EntryID: seg.LastEntryID, CheckpointID: cp.ID, SegmentID: seg.ID, Reason: "the archived segment's last hash doesn't match the checkpoint"}
+				return result, nil
+			}
+			result.CheckpointsVerified++
+		}
+		if seg.LastHash.Valid {
+			w = s.newChainWalker(&seg)
+		}
+		archivedThrough = seg.LastEntryID
+		result.SegmentsVerified++
+		result.ArchivedEntries += seg.EntryCount
+	}
+	// Checkpoints within a segment can't be checked without reading it.
+	for id := range pending {
+		if id <= archivedThrough {
+			delete(pending, id)
+		}
+	}
+
+	afterID := archivedThrough
+	for {
+		entries, err := s.queries.ListAuditChain(ctx, db.ListAuditChainParams{AfterID: afterID, BatchSize: verifyBatchSize})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		for _, e := range entries {
+			afterID = e.ID
+			if reason := w.next(e); reason != "" {
+				result.Break = &ChainBreak{EntryID: e.ID, Reason: reason}
+				return result, nil
+			}
+			if !e.Hash.Valid {
+				continue
+			}
+			for _, cp := range pending[e.ID] {
+				if cp.LastHash != e.Hash.String {
+					result.Break = &ChainBreak{EntryID: e.ID, CheckpointID: cp.ID, Reason: "the entry's hash doesn't match the checkpoint"}
+					return result, nil
+				}
+				result.CheckpointsVerified++
+			}
+			delete(pending, e.ID)
+
+			result.EntriesVerified++
+			result.LastEntryID = e.ID
+			result.LastHash = e.Hash.String
+		}
+		if len(entries) < verifyBatchSize {
+			break
+		}
+	}
+	result.UnchainedEntries = w.unchained
+
+	for _, cp := range checkpoints {
+		if _, ok := pending[cp.LastEntryID]; ok {
+			result.Break = &ChainBreak{EntryID: cp.LastEntryID, CheckpointID: cp.ID, Reason: "an entry named by a checkpoint is missing, so entries were deleted"}
+			return result, nil
+		}
+	}
+	result.Valid = true
+	return result, nil
+}
+
+// CreateCheckpoint signs and records the newest entry in the chain, which may be archived
+// already. It returns nil if the chain is empty or hasn't grown since the last checkpoint.
+func (s *Service) CreateCheckpoint(ctx context.Context) (*db.AuditCheckpoint, error) {
+	headID, headHash, ok, err := chainHead(ctx, s.queries)
+	if err != nil || !ok {
+		return nil, err
+	}
+	latest, err := s.queries.GetLatestAuditCheckpoint(ctx)
+	if err == nil && latest.LastEntryID == headID {
+		return nil, nil
+	} else if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to get latest audit checkpoint: %w", err)
+	}
+
+	// Postgres keeps microseconds; the signature has to cover the time as stored.
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+	checkpoint, err := s.queries.CreateAuditCheckpoint(ctx, db.CreateAuditCheckpointParams{
+		LastEntryID: headID,
+		LastHash:    headHash,
+		Signature:   s.checkpointSignature(headID, headHash, createdAt),
+		CreatedAt:   pgtype.Timestamptz{Time: createdAt, Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit checkpoint: %w", err)
+	}
+	return &checkpoint, nil
+}
+
+// StartCheckpointer creates a checkpoint every interval in the background. Each one is also
+// written to the server log, so that a copy is kept outside the database.
+func (s *Service) StartCheckpointer(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			checkpoint, err := s.CreateCheckpoint(context.Background())
+			if err != nil {
+				log.Printf("ERROR: failed to create audit checkpoint: %v", err)
+			} else if checkpoint != nil {
+				log.Printf("Audit checkpoint %d: entry %d, hash %s, signature %s",
+					checkpoint.ID, checkpoint.LastEntryID, checkpoint.LastHash, checkpoint.Signature)
+			}
+		}
+	}()
+}
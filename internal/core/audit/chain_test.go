@@ -0,0 +1,286 @@
+package audit
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+// buildChain returns entries 1..n the way appendEntryTx stores them, after legacy entries
+// from before the chain that have no hash.
+func buildChain(s *Service, legacy, n int) []db.AuditLog {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 678901000, time.UTC)
+	var entries []db.AuditLog
+	prevHash := ""
+	for i := 0; i < legacy+n; i++ {
+		e := db.AuditLog{
+			ID:        int64(i + 1),
+			UserID:    pgtype.Int8{Int64: 7, Valid: true},
+			Action:    "file:upload",
+			Details:   []byte(`{"file_id": ` + string(rune('0'+i%10)) + `}`),
+			Timestamp: pgtype.Timestamptz{Time: start.Add(time.Duration(i) * time.Second), Valid: true},
+		}
+		if i >= legacy {
+			e.PrevHash = pgtype.Text{String: prevHash, Valid: true}
+			e.RequestID = optionalText("req-1")
+			e.Hash = pgtype.Text{String: s.entryHash(e), Valid: true}
+			prevHash = e.Hash.String
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// walk runs entries through a chain walker and returns the ID of the first entry that breaks
+// the chain, and why, or 0 if none does.
+func walk(s *Service, entries []db.AuditLog) (int64, string) {
+	w := s.newChainWalker(nil)
+	for _, e := range entries {
+		if reason := w.next(e); reason != "" {
+			return e.ID, reason
+		}
+	}
+	return 0, ""
+}
+
+func TestChainWalker(t *testing.T) {
+	s := &Service{hmacKey: []byte("test-key")}
+
+	tests := []struct {
+		name       string
+		legacy     int
+		tamper     func([]db.AuditLog) []db.AuditLog
+		wantBreak  int64
+		wantReason string
+	}{
+		{
+			name:   "intact",
+			tamper: func(e []db.AuditLog) []db.AuditLog { return e },
+		},
+		{
+			name:   "intact after unchained entries",
+			legacy: 2,
+			tamper: func(e []db.AuditLog) []db.AuditLog { return e },
+		},
+		{
+			name: "modified details",
+			tamper: func(e []db.AuditLog) []db.AuditLog {
+				e[2].Details = []byte(`{"file_id": 99}`)
+				return e
+			},
+			wantBreak:  3,
+			wantReason: "modified",
+		},
+		{
+			name: "modified request",
+			tamper: func(e []db.AuditLog) []db.AuditLog {
+				e[1].RequestID = optionalText("req-2")
+				return e
+			},
+			wantBreak:  2,
+			wantReason: "modified",
+		},
+		{
+			name: "modified and rehashed",
+			tamper: func(e []db.AuditLog) []db.AuditLog {
+				e[1].Action = "file:delete"
+				e[1].Hash.String = s.entryHash(e[1])
+				return e
+			},
+			wantBreak:  3,
+			wantReason: "inserted or deleted",
+		},
+		{
+			name: "deleted entry",
+			tamper: func(e []db.AuditLog) []db.AuditLog {
+				return append(e[:2], e[3:]...)
+			},
+			wantBreak:  4,
+			wantReason: "inserted or deleted",
+		},
+		{
+			name: "deleted first entry",
+			tamper: func(e []db.AuditLog) []db.AuditLog {
+				return e[1:]
+			},
+			wantBreak:  2,
+			wantReason: "missing",
+		},
+		{
+			name: "hash removed",
+			tamper: func(e []db.AuditLog) []db.AuditLog {
+				e[3].Hash = pgtype.Text{}
+				return e
+			},
+			wantBreak:  4,
+			wantReason: "no hash",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := tt.tamper(buildChain(s, tt.legacy, 5))
+			id, reason := walk(s, entries)
+			if id != tt.wantBreak {
+				t.Fatalf("break at entry %d (%q), want %d", id, reason, tt.wantBreak)
+			}
+			if !strings.Contains(reason, tt.wantReason) {
+				t.Errorf("reason %q, want it to mention %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestChainWalkerCountsUnchainedEntries(t *testing.T) {
+	s := &Service{hmacKey: []byte("test-key")}
+	w := s.newChainWalker(nil)
+	for _, e := range buildChain(s, 3, 2) {
+		if reason := w.next(e); reason != "" {
+			t.Fatalf("entry %d: %s", e.ID, reason)
+		}
+	}
+	if w.unchained != 3 {
+		t.Errorf("unchained = %d, want 3", w.unchained)
+	}
+}
+
+func TestChainWalkerRejectsOtherKey(t *testing.T) {
+	entries := buildChain(&Service{hmacKey: []byte("test-key")}, 0, 3)
+	for _, s := range []*Service{{hmacKey: []byte("other-key")}, {}} {
+		if id, _ := walk(s, entries); id != 1 {
+			t.Errorf("with key %q: break at entry %d, want 1", s.hmacKey, id)
+		}
+	}
+}
+
+func TestChainWalkerAfterSegment(t *testing.T) {
+	s := &Service{hmacKey: []byte("test-key")}
+	entries := buildChain(s, 0, 6)
+	segment := &db.AuditArchiveSegment{LastEntryID: 3, LastHash: entries[2].Hash}
+
+	w := s.newChainWalker(segment)
+	for _, e := range entries[3:] {
+		if reason := w.next(e); reason != "" {
+			t.Fatalf("entry %d: %s", e.ID, reason)
+		}
+	}
+
+	// The entry after the segment must link to the segment's last hash.
+	w = s.newChainWalker(segment)
+	if reason := w.next(entries[4]); !strings.Contains(reason, "inserted or deleted") {
+		t.Errorf("skipping an entry after the segment: reason %q", reason)
+	}
+}
+
+// fakeRow is a query result row, or pgx.ErrNoRows if values is nil.
+type fakeRow struct{ values []interface{} }
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.values == nil {
+		return pgx.ErrNoRows
+	}
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r.values[i]))
+	}
+	return nil
+}
+
+// chainTables answers the queries chainHead makes from a chain head in audit_logs and the
+// latest archived segment, either of which may be missing.
+type chainTables struct {
+	head    *db.GetAuditChainHeadRow
+	segment *db.AuditArchiveSegment
+}
+
+func (c chainTables) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	panic("unexpected Exec")
+}
+
+func (c chainTables) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	panic("unexpected Query")
+}
+
+func (c chainTables) QueryRow(_ context.Context, sql string, _ ...interface{}) pgx.Row {
+	switch {
+	case strings.Contains(sql, "GetAuditChainHead") && c.head != nil:
+		return fakeRow{[]interface{}{c.head.ID, c.head.Hash}}
+	case strings.Contains(sql, "GetLatestAuditArchiveSegment") && c.segment != nil:
+		seg := c.segment
+		return fakeRow{[]interface{}{seg.ID, seg.ObjectKey, seg.FirstEntryID, seg.LastEntryID, seg.FirstTimestamp,
+			seg.LastTimestamp, seg.EntryCount, seg.SizeBytes, seg.Sha256Hash, seg.FirstPrevHash, seg.LastHash,
+			seg.Signature, seg.CreatedAt}}
+	}
+	return fakeRow{}
+}
+
+func TestChainHeadAfterArchiving(t *testing.T) {
+	s := &Service{hmacKey: []byte("test-key")}
+	entries := buildChain(s, 0, 6)
+	segment := &db.AuditArchiveSegment{FirstEntryID: 1, LastEntryID: 3, LastHash: entries[2].Hash}
+	head := &db.GetAuditChainHeadRow{ID: 6, Hash: entries[5].Hash.String}
+
+	tests := []struct {
+		name     string
+		tables   chainTables
+		wantID   int64
+		wantHash string
+		wantOK   bool
+	}{
+		{"empty", chainTables{}, 0, "", false},
+		{"nothing archived", chainTables{head: head}, 6, entries[5].Hash.String, true},
+		{"some entries archived", chainTables{head: head, segment: segment}, 6, entries[5].Hash.String, true},
+		{"every entry archived", chainTables{segment: segment}, 3, entries[2].Hash.String, true},
+		{"only unchained entries archived", chainTables{segment: &db.AuditArchiveSegment{LastEntryID: 3}}, 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, hash, ok, err := chainHead(context.Background(), db.New(tt.tables))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.wantID || hash != tt.wantHash || ok != tt.wantOK {
+				t.Errorf("chainHead = (%d, %q, %v), want (%d, %q, %v)", id, hash, ok, tt.wantID, tt.wantHash, tt.wantOK)
+			}
+		})
+	}
+
+	// An entry appended once every entry has been archived continues the chain from the segment.
+	_, prevHash, _, err := chainHead(context.Background(), db.New(chainTables{segment: segment}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := entries[3]
+	next.PrevHash = pgtype.Text{String: prevHash, Valid: true}
+	next.Hash = pgtype.Text{String: s.entryHash(next), Valid: true}
+	if reason := s.newChainWalker(segment).next(next); reason != "" {
+		t.Errorf("entry appended after archiving: %s", reason)
+	}
+}
+
+func TestCheckpointSignature(t *testing.T) {
+	s := &Service{hmacKey: []byte("test-key")}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	sig := s.checkpointSignature(10, "abc", at)
+
+	if got := s.checkpointSignature(10, "abc", at.In(time.FixedZone("UTC+2", 2*60*60))); got != sig {
+		t.Error("the signature should not depend on the time zone")
+	}
+	for name, got := range map[string]string{
+		"entry":  s.checkpointSignature(11, "abc", at),
+		"hash":   s.checkpointSignature(10, "abd", at),
+		"time":   s.checkpointSignature(10, "abc", at.Add(time.Microsecond)),
+		"key":    (&Service{hmacKey: []byte("other-key")}).checkpointSignature(10, "abc", at),
+		"no key": (&Service{}).checkpointSignature(10, "abc", at),
+	} {
+		if got == sig {
+			t.Errorf("changing the %s should change the signature", name)
+		}
+	}
+}
@@ -3,41 +3,242 @@ package audit
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/storage"
+)
+
+const (
+	// maxWriteAttempts is how many times a queued entry is written before it is given up on.
+	maxWriteAttempts = 5
+	// writeRetryDelay is how long the first retry waits. Each retry waits twice as long.
+	writeRetryDelay = 500 * time.Millisecond
 )
 
 type Service struct {
+	db      *pgxpool.Pool
 	queries *db.Queries
+	// hmacKey signs the hash chain and checkpoints. Without it they are plain SHA-256 hashes,
+	// which anyone who can edit the database can recompute.
+	hmacKey []byte
+	// archive holds the segments of old entries moved out of the database.
+	archive storage.Backend
+	// appendMu queues this process's appends to the chain, so that they wait here rather than
+	// each holding a database connection while waiting for the chain lock.
+	appendMu sync.Mutex
+
+	// queue holds entries logged with LogActivity until the writer gets to them. closeMu
+	// guards closing it against concurrent sends.
+	queue   chan pendingEntry
+	closeMu sync.RWMutex
+	closed  bool
+	// writeCtx is cancelled when Shutdown runs out of time, which stops the writer retrying.
+	writeCtx     context.Context
+	cancelWrites context.CancelFunc
+	// drained is closed once the writer has finished with the queue.
+	drained chan struct{}
+}
+
+// NewService creates the audit service and starts the writer that empties its queue. Up to
+// queueSize entries can be waiting to be written at once. Archived segments are kept in
+// archive, which may be nil if the service is only used to verify the log.
+func NewService(dbpool *pgxpool.Pool, queries *db.Queries, hmacKey []byte, queueSize int, archive storage.Backend) *Service {
+	writeCtx, cancelWrites := context.WithCancel(context.Background())
+	s := &Service{
+		db:           dbpool,
+		queries:      queries,
+		hmacKey:      hmacKey,
+		archive:      archive,
+		queue:        make(chan pendingEntry, queueSize),
+		writeCtx:     writeCtx,
+		cancelWrites: cancelWrites,
+		drained:      make(chan struct{}),
+	}
+	go s.runWriter()
+	return s
+}
+
+// RequestInfo describes the HTTP request an audit entry was logged for.
+type RequestInfo struct {
+	IPAddress string
+	UserAgent string
+	RequestID string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a context whose audit entries record info.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
 }
 
-func NewService(queries *db.Queries) *Service {
-	return &Service{queries: queries}
+func requestInfoFrom(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
 }
 
-// LogActivity creates a new audit log entry in a background goroutine.
+// Entry is an audit log entry as shown to admins and written to archive segments. UserID is
+// nil for anonymous events. It may name a user who has since been deleted.
+type Entry struct {
+	ID        int64           `json:"id"`
+	UserID    *int64          `json:"user_id"`
+	Action    string          `json:"action"`
+	Details   json.RawMessage `json:"details"`
+	Timestamp time.Time       `json:"timestamp"`
+	// PrevHash and Hash link the entry into the hash chain. Entries from before the chain was
+	// introduced have neither.
+	PrevHash *string `json:"prev_hash"`
+	Hash     *string `json:"hash"`
+	// The request the entry was logged for. Entries from background jobs have none.
+	IPAddress string `json:"ip_address,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NewEntry converts a stored audit log entry.
+func NewEntry(l db.AuditLog) Entry {
+	e := Entry{
+		ID:        l.ID,
+		Action:    l.Action,
+		Details:   json.RawMessage(l.Details),
+		Timestamp: l.Timestamp.Time,
+		IPAddress: l.IpAddress.String,
+		UserAgent: l.UserAgent.String,
+		RequestID: l.RequestID.String,
+	}
+	if l.UserID.Valid {
+		e.UserID = &l.UserID.Int64
+	}
+	if l.Hash.Valid {
+		e.PrevHash = &l.PrevHash.String
+		e.Hash = &l.Hash.String
+	}
+	return e
+}
+
+// pendingEntry is an audit log entry that hasn't been written yet.
+type pendingEntry struct {
+	userID  pgtype.Int8
+	action  string
+	details []byte
+	request RequestInfo
+}
+
+func newPendingEntry(ctx context.Context, userID pgtype.Int8, action string, details map[string]interface{}) (pendingEntry, error) {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return pendingEntry{}, fmt.Errorf("failed to marshal audit log details: %w", err)
+	}
+	return pendingEntry{userID: userID, action: action, details: detailsJSON, request: requestInfoFrom(ctx)}, nil
+}
+
+// LogActivity queues an audit log entry to be written in the background, retrying if the
+// database is unavailable. It is meant for events that don't have to be recorded together
+// with a change; use LogActivityTx for those that do.
 func (s *Service) LogActivity(ctx context.Context, userID int64, action string, details map[string]interface{}) {
-	go func() {
-		detailsJSON, err := json.Marshal(details)
-		if err != nil {
-			log.Printf("ERROR: failed to marshal audit log details: %v", err)
-			return
+	s.enqueue(ctx, pgtype.Int8{Int64: userID, Valid: true}, action, details)
+}
+
+// LogAnonymousActivity records an event that no known user is behind, such as a failed login
+// for an email that has no account.
+func (s *Service) LogAnonymousActivity(ctx context.Context, action string, details map[string]interface{}) {
+	s.enqueue(ctx, pgtype.Int8{}, action, details)
+}
+
+// LogActivityTx writes an audit log entry in tx, so that it is committed or rolled back with
+// the change it records. It holds the hash chain's lock until tx ends, so callers should log
+// as the last step before committing.
+func (s *Service) LogActivityTx(ctx context.Context, tx pgx.Tx, userID int64, action string, details map[string]interface{}) error {
+	e, err := newPendingEntry(ctx, pgtype.Int8{Int64: userID, Valid: true}, action, details)
+	if err != nil {
+		return err
+	}
+	return s.appendEntryTx(ctx, s.queries.WithTx(tx), e)
+}
+
+func (s *Service) enqueue(ctx context.Context, userID pgtype.Int8, action string, details map[string]interface{}) {
+	e, err := newPendingEntry(ctx, userID, action, details)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		// Anything still running after shutdown is written straight away.
+		if err := s.appendEntry(context.Background(), e); err != nil {
+			lose(e, err)
 		}
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		lose(e, fmt.Errorf("the queue is full"))
+	}
+}
 
-		// --- THIS IS THE FIX ---
-		// We construct a pgtype.Int8 struct from our int64 userID.
-		// Since we know the userID will always be valid here, we set Valid to true.
-		err = s.queries.CreateAuditLog(context.Background(), db.CreateAuditLogParams{
-			UserID:  pgtype.Int8{Int64: userID, Valid: true},
-			Action:  action,
-			Details: detailsJSON,
-		})
-		// --- END OF FIX ---
-		
-		if err != nil {
-			log.Printf("ERROR: failed to create audit log: %v", err)
+// lose logs an entry that couldn't be written in full, so that it can at least be recovered
+// from the server log.
+func lose(e pendingEntry, err error) {
+	log.Printf("ERROR: audit log entry lost (%v): user_id=%v action=%s details=%s ip_address=%q user_agent=%q request_id=%q",
+		err, e.userID.Int64, e.action, e.details, e.request.IPAddress, e.request.UserAgent, e.request.RequestID)
+}
+
+// runWriter writes queued entries until the queue is closed and empty.
+func (s *Service) runWriter() {
+	defer close(s.drained)
+	for e := range s.queue {
+		s.write(e)
+	}
+}
+
+// write writes e, retrying with a growing delay if that fails.
+func (s *Service) write(e pendingEntry) {
+	delay := writeRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := s.appendEntry(s.writeCtx, e)
+		if err == nil {
+			return
+		}
+		if attempt == maxWriteAttempts || s.writeCtx.Err() != nil {
+			lose(e, err)
+			return
+		}
+		log.Printf("ERROR: failed to create audit log (attempt %d of %d), retrying in %s: %v", attempt, maxWriteAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-s.writeCtx.Done():
 		}
-	}()
-}
\ No newline at end of file
+		delay *= 2
+	}
+}
+
+// Shutdown stops queueing entries and waits for the ones already queued to be written. If ctx
+// ends first, the rest are written to the server log instead and an error is returned.
+// Entries logged afterwards are written synchronously.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.closeMu.Unlock()
+
+	select {
+	case <-s.drained:
+		return nil
+	case <-ctx.Done():
+		s.cancelWrites()
+		<-s.drained
+		return fmt.Errorf("audit log queue was not drained: %w", ctx.Err())
+	}
+}
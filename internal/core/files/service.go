@@ -11,10 +11,16 @@ import (
 	"log"
 	"mime"
 	"errors"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/karanbihani/file-vault/internal/auth"
 	"github.com/karanbihani/file-vault/internal/db"      
 	"github.com/karanbihani/file-vault/internal/storage" 
 	"github.com/karanbihani/file-vault/internal/core/audit" 
+	"github.com/karanbihani/file-vault/internal/core/archive"
+	"github.com/karanbihani/file-vault/internal/pagination"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/jackc/pgx/v5"
@@ -25,16 +31,18 @@ import (
 type Service struct {
 	db      *pgxpool.Pool
 	queries *db.Queries
-	storage *storage.Client
+	storage storage.Backend
 	auditService   *audit.Service
+	permissions    *auth.PermissionResolver
 }
 
-func NewService(dbpool *pgxpool.Pool, queries *db.Queries, storageClient *storage.Client, auditService *audit.Service) *Service {
+func NewService(dbpool *pgxpool.Pool, queries *db.Queries, storageBackend storage.Backend, auditService *audit.Service, permissions *auth.PermissionResolver) *Service {
 	return &Service{
 		db:      dbpool,
 		queries: queries,
-		storage: storageClient,
+		storage: storageBackend,
 		auditService:   auditService,
+		permissions:    permissions,
 	}
 }
 
@@ -42,79 +50,157 @@ type UploadFileParams struct {
 	File        io.Reader
 	Filename    string
 	ContentType string
+	// DeclaredSize is the size announced by the client (e.g. the request's Content-Length).
+	// It is only used for the up-front quota check; -1 means unknown.
+	DeclaredSize int64
+	// OwnerID is the uploading user. For a new version this may be an editor rather than the owner.
 	OwnerID     int64
 	Description string
 	Tags        []string
+	// FileID, when set, uploads the content as a new version of this existing file
+	// instead of creating a new one. Filename, Description and Tags are then ignored.
+	FileID int64
+	// FolderID is the folder a new file is created in. When not set the file goes to the owner's root.
+	FolderID pgtype.Int8
 }
 
 var ErrQuotaExceeded = errors.New("storage quota exceeded")
+var ErrFileNotFound = errors.New("file not found or access denied")
+var ErrVersionNotFound = errors.New("file version not found")
+var ErrInvalidFilename = errors.New("filename must be 1-255 characters and must not contain '/'")
+
+// sniffLen is the number of leading bytes used for MIME detection.
+// It matches mimetype's default read limit, so detection is identical to sniffing the whole file.
+const sniffLen = 3072
+
+// stagedUpload is an upload that has been streamed to a temporary file on disk.
+// Its hash and size are only known once the whole stream has been consumed.
+type stagedUpload struct {
+	file     *os.File
+	hash     string
+	size     int64
+	mimeType string
+}
 
-func (s *Service) UploadFile(ctx context.Context, params UploadFileParams) (*db.UserFile, error) {
-	
-	user, err := s.queries.GetUserByID(ctx, params.OwnerID)
-	if err != nil {
-		return nil, fmt.Errorf("could not retrieve user for quota check: %w", err)
-	}
-	
-	var buf bytes.Buffer
-	hasher := sha256.New()
-	teeReader := io.TeeReader(params.File, hasher)
-
-	size, err := io.Copy(&buf, teeReader)
-	if err != nil {
-		return nil, fmt.Errorf("could not copy file content to buffer: %w", err)
-	}
+// cleanup closes and removes the temporary file.
+func (u *stagedUpload) cleanup() {
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
 
-	if user.StorageUsedBytes+size > user.StorageQuotaBytes {
-		log.Printf("QUOTA EXCEEDED for user %d. Used: %d, File: %d, Quota: %d", 
-			params.OwnerID, user.StorageUsedBytes, size, user.StorageQuotaBytes)
-		return nil, ErrQuotaExceeded // Use the existing error type
+// stageUpload sniffs the MIME type from the first bytes of r, rejects it if it doesn't match
+// the declared content type, and then streams the rest to a temporary file while hashing it.
+// Memory use is bounded by sniffLen regardless of the upload size, and disk use by maxSize:
+// it stops with ErrQuotaExceeded as soon as the upload is larger.
+func stageUpload(r io.Reader, declaredContentType string, maxSize int64) (*stagedUpload, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("could not read file content: %w", err)
 	}
+	head = head[:n]
 
-	mtype := mimetype.Detect(buf.Bytes())
-	finalMimeType := mtype.String()
+	finalMimeType := mimetype.Detect(head).String()
 
-	clientBaseMime, _, _ := mime.ParseMediaType(params.ContentType)
+	clientBaseMime, _, _ := mime.ParseMediaType(declaredContentType)
 	detectedBaseMime, _, _ := mime.ParseMediaType(finalMimeType)
 
 	if clientBaseMime != detectedBaseMime {
 		return nil, fmt.Errorf("mime type mismatch: client declared '%s', but content is detected as '%s'", clientBaseMime, detectedBaseMime)
 	}
 
-	hash := hex.EncodeToString(hasher.Sum(nil))
+	tmp, err := os.CreateTemp("", "file-vault-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary upload file: %w", err)
+	}
+	staged := &stagedUpload{file: tmp, mimeType: finalMimeType}
 
-	createUserFileParams := db.CreateUserFileParams{
-		OwnerID:     params.OwnerID,
-		Filename:    params.Filename,
-		MimeType:    finalMimeType,
-		Description: pgtype.Text{String: params.Description, Valid: params.Description != ""},
-		Tags:        params.Tags,
+	hasher := sha256.New()
+	content := io.LimitReader(io.MultiReader(bytes.NewReader(head), r), maxSize+1)
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), content)
+	if err != nil {
+		staged.cleanup()
+		return nil, fmt.Errorf("could not stream file content to temporary file: %w", err)
+	}
+	if size > maxSize {
+		staged.cleanup()
+		return nil, ErrQuotaExceeded
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		staged.cleanup()
+		return nil, fmt.Errorf("could not rewind temporary upload file: %w", err)
 	}
 
-	existingPhysicalFile, err := s.queries.GetPhysicalFileByHash(ctx, hash)
-	if err == nil {
-		log.Printf("Duplicate file detected. Hash: %s. Incrementing ref count.", hash)
-		if _, err := s.queries.IncrementPhysicalFileRefCount(ctx, existingPhysicalFile.ID); err != nil {
-			return nil, fmt.Errorf("failed to increment ref count: %w", err)
-		}
-		
-		createUserFileParams.PhysicalFileID = existingPhysicalFile.ID
-		newUserFile, err := s.queries.CreateUserFile(ctx, createUserFileParams)
+	staged.hash = hex.EncodeToString(hasher.Sum(nil))
+	staged.size = size
+	return staged, nil
+}
+
+func (s *Service) UploadFile(ctx context.Context, params UploadFileParams) (*db.UserFile, error) {
+	// A new file is charged to the uploader. A new version is charged to the file's owner,
+	// whose storage the whole version history counts against.
+	chargedUserID := params.OwnerID
+	if params.FileID != 0 {
+		access, err := s.requireFileRole(ctx, params.FileID, params.OwnerID, auth.ShareRoleEditor)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create user_file for duplicate: %w", err)
+			return nil, err
 		}
-		return &newUserFile, nil
+		chargedUserID = access.OwnerID
 	}
-	if err != pgx.ErrNoRows {
-		return nil, fmt.Errorf("failed to check for existing file: %w", err)
+
+	user, err := s.queries.GetUserByID(ctx, chargedUserID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve user for quota check: %w", err)
+	}
+
+	// Reject obviously oversized uploads before reading a single byte.
+	if params.DeclaredSize > 0 && user.StorageUsedBytes+params.DeclaredSize > user.StorageQuotaBytes {
+		log.Printf("QUOTA EXCEEDED for user %d. Used: %d, Declared: %d, Quota: %d",
+			chargedUserID, user.StorageUsedBytes, params.DeclaredSize, user.StorageQuotaBytes)
+		return nil, ErrQuotaExceeded
 	}
 
-	fileReader := bytes.NewReader(buf.Bytes())
-	err = s.storage.Save(ctx, hash, fileReader, size, finalMimeType)
+	if params.FileID == 0 && params.FolderID.Valid {
+		folder, err := s.queries.GetFolderByID(ctx, params.FolderID.Int64)
+		if err != nil && err != pgx.ErrNoRows {
+			return nil, fmt.Errorf("failed to get folder: %w", err)
+		}
+		if err == pgx.ErrNoRows || folder.OwnerID != params.OwnerID {
+			return nil, fmt.Errorf("folder not found or access denied")
+		}
+	}
+
+	// The declared size can't be trusted, so staging stops as soon as the file outgrows the
+	// space left.
+	staged, err := stageUpload(params.File, params.ContentType, max(user.StorageQuotaBytes-user.StorageUsedBytes, 0))
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload file to object storage: %w", err)
+		if errors.Is(err, ErrQuotaExceeded) {
+			log.Printf("QUOTA EXCEEDED for user %d. Used: %d, Quota: %d",
+				chargedUserID, user.StorageUsedBytes, user.StorageQuotaBytes)
+		}
+		return nil, err
+	}
+	defer staged.cleanup()
+
+	finalMimeType := staged.mimeType
+	hash := staged.hash
+	size := staged.size
+
+	existingPhysicalFile, err := s.queries.GetPhysicalFileByHash(ctx, hash)
+	isNewPhysicalFile := err == pgx.ErrNoRows
+	if err != nil && !isNewPhysicalFile {
+		return nil, fmt.Errorf("failed to check for existing file: %w", err)
+	}
+
+	if isNewPhysicalFile {
+		err = s.storage.Save(ctx, hash, staged.file, size, finalMimeType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload file to object storage: %w", err)
+		}
+		log.Printf("Successfully uploaded new file to MinIO. Object name: %s", hash)
+	} else {
+		log.Printf("Duplicate file detected. Hash: %s. Incrementing ref count.", hash)
 	}
-	log.Printf("Successfully uploaded new file to MinIO. Object name: %s", hash)
 
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
@@ -124,183 +210,690 @@ func (s *Service) UploadFile(ctx context.Context, params UploadFileParams) (*db.
 
 	qtx := s.queries.WithTx(tx)
 
-	newPhysicalFile, err := qtx.CreatePhysicalFile(ctx, db.CreatePhysicalFileParams{
-		Sha256Hash:  hash,
-		SizeBytes:   size,
-		StoragePath: hash,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create physical_file: %w", err)
+	var physicalFileID int64
+	if isNewPhysicalFile {
+		newPhysicalFile, err := qtx.CreatePhysicalFile(ctx, db.CreatePhysicalFileParams{
+			Sha256Hash:  hash,
+			SizeBytes:   size,
+			StoragePath: hash,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create physical_file: %w", err)
+		}
+
+		// Atomically update the user's storage usage since this is a new physical file.
+		if err := qtx.UpdateUserStorageUsage(ctx, db.UpdateUserStorageUsageParams{
+			Amount: size,
+			ID:     chargedUserID,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to update user storage on upload: %w", err)
+		}
+		physicalFileID = newPhysicalFile.ID
+	} else {
+		if _, err := qtx.IncrementPhysicalFileRefCount(ctx, existingPhysicalFile.ID); err != nil {
+			return nil, fmt.Errorf("failed to increment ref count: %w", err)
+		}
+		physicalFileID = existingPhysicalFile.ID
 	}
 
-	// Atomically update the user's storage usage since this is a new physical file.
-	// CORRECTED: Using the new named parameters from our updated SQL.
-	if err := qtx.UpdateUserStorageUsage(ctx, db.UpdateUserStorageUsageParams{
-		Amount: size,
-		ID:     params.OwnerID,
-	}); err != nil {
-		return nil, fmt.Errorf("failed to update user storage on upload: %w", err)
+	var userFile db.UserFile
+	if params.FileID == 0 {
+		userFile, err = qtx.CreateUserFile(ctx, db.CreateUserFileParams{
+			OwnerID:        params.OwnerID,
+			PhysicalFileID: physicalFileID,
+			Filename:       params.Filename,
+			MimeType:       finalMimeType,
+			Description:    pgtype.Text{String: params.Description, Valid: params.Description != ""},
+			Tags:           params.Tags,
+			FolderID:       params.FolderID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user_file: %w", err)
+		}
+		if _, err := qtx.CreateFileVersion(ctx, db.CreateFileVersionParams{
+			UserFileID:     userFile.ID,
+			VersionNumber:  1,
+			PhysicalFileID: physicalFileID,
+			MimeType:       finalMimeType,
+			UploadedBy:     pgtype.Int8{Int64: params.OwnerID, Valid: true},
+		}); err != nil {
+			return nil, fmt.Errorf("failed to create initial file version: %w", err)
+		}
+	} else {
+		existingFile, err := lockUserFile(ctx, qtx, params.FileID)
+		if err != nil {
+			return nil, err
+		}
+		userFile, err = appendVersion(ctx, qtx, existingFile, physicalFileID, finalMimeType, params.OwnerID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	createUserFileParams.PhysicalFileID = newPhysicalFile.ID
-	newUserFile, err := qtx.CreateUserFile(ctx, createUserFileParams)
+	if params.FileID == 0 {
+		err = s.auditService.LogActivityTx(ctx, tx, params.OwnerID, "file:upload", map[string]interface{}{
+			"file_id": userFile.ID,
+			"filename": userFile.Filename,
+		})
+	} else {
+		err = s.auditService.LogActivityTx(ctx, tx, params.OwnerID, "file:version_upload", map[string]interface{}{
+			"file_id": userFile.ID,
+			"version": userFile.CurrentVersion,
+		})
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user_file: %w", err)
+		return nil, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	s.auditService.LogActivity(ctx, newUserFile.OwnerID, "file:upload", map[string]interface{}{
-		"file_id": newUserFile.ID,
-		"filename": newUserFile.Filename,
-	})
-
-	return &newUserFile, nil
+	return &userFile, nil
 }
 
-func (s *Service) ListFiles(ctx context.Context, ownerID int64) ([]db.ListUserFilesRow, error) {
-	return s.queries.ListUserFiles(ctx, ownerID)
+// ListFiles returns a page of the user's files.
+func (s *Service) ListFiles(ctx context.Context, ownerID int64, page pagination.Request) (pagination.Page[db.ListUserFilesRow], error) {
+	rows, err := s.queries.ListUserFiles(ctx, db.ListUserFilesParams{
+		OwnerID:    ownerID,
+		CursorID:   page.CursorID(),
+		SortKey:    page.Sort.Key,
+		Descending: page.Sort.Descending,
+		CursorText: page.CursorText(),
+		CursorInt:  page.CursorInt(),
+		CursorTime: page.CursorTime(),
+		PageLimit:  page.FetchLimit(),
+	})
+	if err != nil {
+		return pagination.Page[db.ListUserFilesRow]{}, fmt.Errorf("failed to list files: %w", err)
+	}
+	return pagination.NewPage(page, rows, func(f db.ListUserFilesRow) pagination.Key {
+		return pagination.FileKey(page.Sort.Key, f.ID, f.Filename, f.MimeType, f.SizeBytes, f.UploadDate)
+	}), nil
 }
 
+// DownloadFileResponse is an open stored object together with what a client needs to cache it.
+// Data is seekable so that byte ranges can be served from it.
 type DownloadFileResponse struct {
-	Data     io.ReadCloser
-	Filename string
-	Size     int64
+	Data       storage.Object
+	Filename   string
+	Size       int64
+	MimeType   string
+	Sha256Hash string
 }
 
-// ListFilesSharedWithMe retrieves all files that have been shared with a given user.
-func (s *Service) ListFilesSharedWithMe(ctx context.Context, userID int64) ([]db.UserFile, error) {
-	return s.queries.ListFilesSharedWithUser(ctx, userID)
-}
-
-func (s *Service) DownloadFile(ctx context.Context, fileID, userID int64) (*DownloadFileResponse, error) {
-	permissions, err := s.queries.GetUserPermissions(ctx, userID)
+// ListFilesSharedWithMe retrieves a page of the files that have been shared with a given user.
+func (s *Service) ListFilesSharedWithMe(ctx context.Context, userID int64, page pagination.Request) (pagination.Page[db.ListFilesSharedWithUserRow], error) {
+	rows, err := s.queries.ListFilesSharedWithUser(ctx, db.ListFilesSharedWithUserParams{
+		SharedWithUserID: userID,
+		CursorID:         page.CursorID(),
+		SortKey:          page.Sort.Key,
+		Descending:       page.Sort.Descending,
+		CursorText:       page.CursorText(),
+		CursorInt:        page.CursorInt(),
+		CursorTime:       page.CursorTime(),
+		PageLimit:        page.FetchLimit(),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not check user permissions: %w", err)
+		return pagination.Page[db.ListFilesSharedWithUserRow]{}, fmt.Errorf("failed to list shared files: %w", err)
 	}
+	return pagination.NewPage(page, rows, func(f db.ListFilesSharedWithUserRow) pagination.Key {
+		return pagination.FileKey(page.Sort.Key, f.ID, f.Filename, f.MimeType, f.SizeBytes, f.UploadDate)
+	}), nil
+}
 
-	hasAdminDownloadPerm := false
-	for _, p := range permissions {
-		if p == "admin:download_any_file" { // Use the constant here
-			hasAdminDownloadPerm = true
-			break
-		}
+// fileMeta is the name and content location of a file a user is allowed to read.
+type fileMeta struct {
+	Filename    string
+	MimeType    string
+	StoragePath string
+	SizeBytes   int64
+	Sha256Hash  string
+}
+
+// canReadAnyFile reports whether userID holds the admin download permission.
+func (s *Service) canReadAnyFile(ctx context.Context, userID int64) (bool, error) {
+	allowed, err := s.permissions.HasPermission(ctx, userID, auth.PermissionAdminDownloadAnyFile)
+	if err != nil {
+		return false, fmt.Errorf("could not check user permissions: %w", err)
 	}
+	return allowed, nil
+}
 
-	var fileMeta struct {
-		Filename    string
-		StoragePath string
-		SizeBytes   int64
+// getReadableFile returns the current content of a file if userID owns it, has it shared
+// with them, or holds the admin download permission. Otherwise it returns ErrFileNotFound.
+func (s *Service) getReadableFile(ctx context.Context, fileID, userID int64) (*fileMeta, error) {
+	hasAdminDownloadPerm, err := s.canReadAnyFile(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
+	return s.lookupReadableFile(ctx, fileID, userID, hasAdminDownloadPerm)
+}
 
+func (s *Service) lookupReadableFile(ctx context.Context, fileID, userID int64, hasAdminDownloadPerm bool) (*fileMeta, error) {
 	if hasAdminDownloadPerm {
 		adminFileMeta, err := s.queries.GetFileMetadataByID(ctx, fileID)
 		if err != nil {
-			if err == pgx.ErrNoRows { return nil, fmt.Errorf("file not found") }
+			if err == pgx.ErrNoRows { return nil, ErrFileNotFound }
 			return nil, fmt.Errorf("failed to get file metadata for admin: %w", err)
 		}
-		fileMeta.Filename = adminFileMeta.Filename
-		fileMeta.StoragePath = adminFileMeta.StoragePath
-		fileMeta.SizeBytes = adminFileMeta.SizeBytes
-	} else {
-		userFileMeta, err := s.queries.GetFileForUserDownload(ctx, db.GetFileForUserDownloadParams{
-			FileID: fileID, RequestingUserID: userID,
-		})
-		if err != nil {
-			if err == pgx.ErrNoRows { return nil, fmt.Errorf("file not found or access denied") }
-			return nil, fmt.Errorf("failed to get file metadata: %w", err)
-		}
-		fileMeta.Filename = userFileMeta.Filename
-		fileMeta.StoragePath = userFileMeta.StoragePath
-		fileMeta.SizeBytes = userFileMeta.SizeBytes
+		return &fileMeta{
+			Filename: adminFileMeta.Filename, MimeType: adminFileMeta.MimeType, StoragePath: adminFileMeta.StoragePath,
+			SizeBytes: adminFileMeta.SizeBytes, Sha256Hash: adminFileMeta.Sha256Hash,
+		}, nil
 	}
 
-	object, err := s.storage.Get(ctx, fileMeta.StoragePath)
+	userFileMeta, err := s.queries.GetFileForUserDownload(ctx, db.GetFileForUserDownloadParams{
+		FileID: fileID, RequestingUserID: userID,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows { return nil, ErrFileNotFound }
+		return nil, fmt.Errorf("failed to get file metadata: %w", err)
+	}
+	return &fileMeta{
+		Filename: userFileMeta.Filename, MimeType: userFileMeta.MimeType, StoragePath: userFileMeta.StoragePath,
+		SizeBytes: userFileMeta.SizeBytes, Sha256Hash: userFileMeta.Sha256Hash,
+	}, nil
+}
+
+func (s *Service) DownloadFile(ctx context.Context, fileID, userID int64) (*DownloadFileResponse, error) {
+	meta, err := s.getReadableFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := s.storage.Get(ctx, meta.StoragePath)
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve file from storage: %w", err)
 	}
 
 	return &DownloadFileResponse{
-		Data: object, Filename: fileMeta.Filename, Size: fileMeta.SizeBytes,
+		Data: object, Filename: meta.Filename, Size: meta.SizeBytes, MimeType: meta.MimeType, Sha256Hash: meta.Sha256Hash,
 	}, nil
 }
 
+// ArchiveEntries resolves a list of file IDs into archive entries, checking each file with
+// the same rules as DownloadFile. If any file is unreadable the whole request fails, so an
+// archive never silently misses files. Repeated IDs are only included once.
+func (s *Service) ArchiveEntries(ctx context.Context, fileIDs []int64, userID int64) ([]archive.Entry, error) {
+	if len(fileIDs) == 0 {
+		return nil, archive.ErrNoFiles
+	}
+	if len(fileIDs) > archive.MaxEntries {
+		return nil, archive.ErrTooManyFiles
+	}
 
-func (s *Service) DeleteFile(ctx context.Context, fileID, ownerID int64) error {
-	// CORRECTED: Using the new, more secure query that requires both fileID and ownerID.
-	fileInfo, err := s.queries.GetFileOwnerAndPhysicalFile(ctx, db.GetFileOwnerAndPhysicalFileParams{ID: fileID, OwnerID: ownerID})
+	hasAdminDownloadPerm, err := s.canReadAnyFile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool, len(fileIDs))
+	entries := make([]archive.Entry, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		if seen[fileID] {
+			continue
+		}
+		seen[fileID] = true
+
+		meta, err := s.lookupReadableFile(ctx, fileID, userID, hasAdminDownloadPerm)
+		if err != nil {
+			if errors.Is(err, ErrFileNotFound) {
+				return nil, fmt.Errorf("file %d: %w", fileID, err)
+			}
+			return nil, err
+		}
+		entries = append(entries, archive.Entry{Filename: meta.Filename, StoragePath: meta.StoragePath})
+	}
+	return entries, nil
+}
+
+// WriteArchive streams a ZIP archive of entries to w and records the download.
+// Entries must come from ArchiveEntries or archive.FolderEntries, which check access.
+func (s *Service) WriteArchive(ctx context.Context, w io.Writer, entries []archive.Entry, userID int64) error {
+	if err := archive.Write(ctx, w, s.storage, entries); err != nil {
+		return err
+	}
+
+	s.auditService.LogActivity(ctx, userID, "file:archive_download", map[string]interface{}{
+		"file_count": len(entries),
+	})
+
+	return nil
+}
+
+// ListVersions returns the version history of a file, newest first.
+// Anyone who can download the file can list its versions.
+func (s *Service) ListVersions(ctx context.Context, fileID, userID int64) ([]db.ListFileVersionsRow, error) {
+	if _, err := s.getReadableFile(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+	return s.queries.ListFileVersions(ctx, fileID)
+}
+
+// DownloadVersion streams the content of one specific version of a file.
+func (s *Service) DownloadVersion(ctx context.Context, fileID, userID int64, versionNumber int32) (*DownloadFileResponse, error) {
+	meta, err := s.getReadableFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := s.queries.GetFileVersion(ctx, db.GetFileVersionParams{UserFileID: fileID, VersionNumber: versionNumber})
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return fmt.Errorf("file not found or access denied")
+			return nil, ErrVersionNotFound
 		}
-		return fmt.Errorf("failed to get file info: %w", err)
+		return nil, fmt.Errorf("failed to get file version: %w", err)
+	}
+
+	object, err := s.storage.Get(ctx, version.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve file from storage: %w", err)
+	}
+
+	return &DownloadFileResponse{
+		Data: object, Filename: meta.Filename, Size: version.SizeBytes, MimeType: version.MimeType, Sha256Hash: version.Sha256Hash,
+	}, nil
+}
+
+// RestoreVersion makes an old version current again. The old version is not moved; instead a
+// new version pointing at the same content is appended, so the history is never rewritten.
+// No new bytes are stored, so the owner's storage usage does not change. Editors may restore too.
+func (s *Service) RestoreVersion(ctx context.Context, fileID, userID int64, versionNumber int32) (*db.UserFile, error) {
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleEditor); err != nil {
+		return nil, err
 	}
 
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("could not begin transaction: %w", err)
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
 	qtx := s.queries.WithTx(tx)
 
-	if err := qtx.DeleteUserFile(ctx, fileID); err != nil {
-		return fmt.Errorf("failed to delete user file record: %w", err)
+	existingFile, err := lockUserFile(ctx, qtx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := qtx.GetFileVersion(ctx, db.GetFileVersionParams{UserFileID: fileID, VersionNumber: versionNumber})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("failed to get file version: %w", err)
+	}
+
+	if _, err := qtx.IncrementPhysicalFileRefCount(ctx, version.PhysicalFileID); err != nil {
+		return nil, fmt.Errorf("failed to increment ref count: %w", err)
 	}
 
-	newRefCount, err := qtx.DecrementPhysicalFileRefCount(ctx, fileInfo.PhysicalFileID)
+	userFile, err := appendVersion(ctx, qtx, existingFile, version.PhysicalFileID, version.MimeType, userID)
 	if err != nil {
-		return fmt.Errorf("failed to decrement ref count: %w", err)
+		return nil, err
 	}
 
-	log.Printf("Decremented ref count for physical file ID %d to %d", fileInfo.PhysicalFileID, newRefCount)
+	if err := s.auditService.LogActivityTx(ctx, tx, userID, "file:version_restore", map[string]interface{}{
+		"file_id": fileID,
+		"restored_version": versionNumber,
+		"version": userFile.CurrentVersion,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
-	if newRefCount <= 0 {
-		log.Printf("Ref count is zero. Deleting physical file and object from storage.")
+	return &userFile, nil
+}
 
-		// CORRECTED: fileInfo now correctly contains the StoragePath.
-		if err := s.storage.Delete(ctx, fileInfo.StoragePath); err != nil {
-			return fmt.Errorf("failed to delete object from storage: %w", err)
+// lockUserFile loads a file and locks its row until the transaction ends, so concurrent
+// uploads to the same file can't pick the same version number. Callers check access first.
+func lockUserFile(ctx context.Context, qtx *db.Queries, fileID int64) (db.UserFile, error) {
+	userFile, err := qtx.GetUserFileForUpdate(ctx, fileID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return db.UserFile{}, ErrFileNotFound
 		}
+		return db.UserFile{}, fmt.Errorf("failed to lock user file: %w", err)
+	}
+	return userFile, nil
+}
+
+// appendVersion records physicalFileID as the next version of a locked file and makes it current.
+// The caller must already hold a reference on the physical file for the new version.
+func appendVersion(ctx context.Context, qtx *db.Queries, userFile db.UserFile, physicalFileID int64, mimeType string, uploadedBy int64) (db.UserFile, error) {
+	nextVersion := userFile.CurrentVersion + 1
+	if _, err := qtx.CreateFileVersion(ctx, db.CreateFileVersionParams{
+		UserFileID:     userFile.ID,
+		VersionNumber:  nextVersion,
+		PhysicalFileID: physicalFileID,
+		MimeType:       mimeType,
+		UploadedBy:     pgtype.Int8{Int64: uploadedBy, Valid: true},
+	}); err != nil {
+		return db.UserFile{}, fmt.Errorf("failed to create file version: %w", err)
+	}
+
+	updated, err := qtx.SetCurrentFileVersion(ctx, db.SetCurrentFileVersionParams{
+		PhysicalFileID: physicalFileID,
+		MimeType:       mimeType,
+		CurrentVersion: nextVersion,
+		ID:             userFile.ID,
+	})
+	if err != nil {
+		return db.UserFile{}, fmt.Errorf("failed to update current file version: %w", err)
+	}
+	return updated, nil
+}
+
+// releasePhysicalFile drops one reference to a physical file. When the last reference is gone
+// the record is deleted and its size is credited back to userID. It reports whether that
+// happened; the caller must then remove the object from storage once the transaction commits.
+func releasePhysicalFile(ctx context.Context, qtx *db.Queries, physicalFileID, sizeBytes, userID int64) (bool, error) {
+	newRefCount, err := qtx.DecrementPhysicalFileRefCount(ctx, physicalFileID)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrement ref count: %w", err)
+	}
+
+	log.Printf("Decremented ref count for physical file ID %d to %d", physicalFileID, newRefCount)
 
-		if err := qtx.DeletePhysicalFile(ctx, fileInfo.PhysicalFileID); err != nil {
-			return fmt.Errorf("failed to delete physical file record: %w", err)
+	if newRefCount > 0 {
+		return false, nil
+	}
+
+	if err := qtx.DeletePhysicalFile(ctx, physicalFileID); err != nil {
+		return false, fmt.Errorf("failed to delete physical file record: %w", err)
+	}
+
+	if err := qtx.UpdateUserStorageUsage(ctx, db.UpdateUserStorageUsageParams{
+		Amount: -sizeBytes,
+		ID:     userID,
+	}); err != nil {
+		return false, fmt.Errorf("failed to update user storage usage: %w", err)
+	}
+	return true, nil
+}
+
+// deleteObjects removes objects whose physical file records are already gone.
+// Failures only leave orphaned objects behind, so they are logged rather than returned.
+func (s *Service) deleteObjects(ctx context.Context, storagePaths []string) {
+	for _, path := range storagePaths {
+		if err := s.storage.Delete(ctx, path); err != nil {
+			log.Printf("ERROR: failed to delete object %s from storage: %v", path, err)
 		}
+	}
+}
 
-		// CORRECTED: Using the new named parameters from our updated SQL.
-		if err := qtx.UpdateUserStorageUsage(ctx, db.UpdateUserStorageUsageParams{
-			Amount: -fileInfo.SizeBytes,
-			ID:     ownerID,
-		}); err != nil {
-			return fmt.Errorf("failed to update user storage usage: %w", err)
+// DeleteFile moves a file to its owner's trash. Nothing is released until the file is purged,
+// either explicitly or by the trash purger once the retention period has passed.
+func (s *Service) DeleteFile(ctx context.Context, fileID, ownerID int64) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := s.TrashFileTx(ctx, tx, fileID, ownerID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// TrashFileTx moves a file to its owner's trash as part of tx, so callers that trash several
+// files can commit or roll back all of them together.
+func (s *Service) TrashFileTx(ctx context.Context, tx pgx.Tx, fileID, ownerID int64) error {
+	trashed, err := s.queries.WithTx(tx).TrashUserFile(ctx, db.TrashUserFileParams{ID: fileID, OwnerID: ownerID})
+	if err != nil {
+		return fmt.Errorf("failed to move file to trash: %w", err)
+	}
+	if trashed == 0 {
+		return ErrFileNotFound
+	}
+
+	return s.auditService.LogActivityTx(ctx, tx, ownerID, "file:trash", map[string]interface{}{
+		"file_id": fileID,
+	})
+}
+
+// ListTrash lists the files in a user's trash.
+func (s *Service) ListTrash(ctx context.Context, ownerID int64) ([]db.ListTrashedFilesRow, error) {
+	return s.queries.ListTrashedFiles(ctx, ownerID)
+}
+
+// RestoreFile takes a file out of the trash. If its folder has been deleted in the meantime
+// the file is restored to the owner's root.
+func (s *Service) RestoreFile(ctx context.Context, fileID, ownerID int64) (*db.UserFile, error) {
+	userFile, err := s.queries.RestoreUserFile(ctx, db.RestoreUserFileParams{ID: fileID, OwnerID: ownerID})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrFileNotFound
 		}
+		return nil, fmt.Errorf("failed to restore file: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, ownerID, "file:restore", map[string]interface{}{
+		"file_id": fileID,
+	})
+
+	return &userFile, nil
+}
+
+// PurgeFile permanently deletes a file that is in the trash.
+func (s *Service) PurgeFile(ctx context.Context, fileID, ownerID int64) error {
+	if err := s.purgeFile(ctx, fileID, ownerID); err != nil {
+		return err
 	}
 
 	s.auditService.LogActivity(ctx, ownerID, "file:delete", map[string]interface{}{
 		"file_id": fileID,
 	})
-	
-	return tx.Commit(ctx)
+
+	return nil
+}
+
+// EmptyTrash permanently deletes every file in a user's trash and returns how many were deleted.
+func (s *Service) EmptyTrash(ctx context.Context, ownerID int64) (int, error) {
+	fileIDs, err := s.queries.ListTrashedFileIDs(ctx, ownerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list trashed files: %w", err)
+	}
+
+	for i, fileID := range fileIDs {
+		if err := s.purgeFile(ctx, fileID, ownerID); err != nil {
+			return i, err
+		}
+	}
+
+	s.auditService.LogActivity(ctx, ownerID, "file:empty_trash", map[string]interface{}{
+		"deleted_files": len(fileIDs),
+	})
+
+	return len(fileIDs), nil
+}
+
+// PurgeUserFiles permanently deletes every file a user owns, in the trash or not, and returns
+// how many were deleted. It is used when the user's account is deleted.
+func (s *Service) PurgeUserFiles(ctx context.Context, ownerID int64) (int, error) {
+	if _, err := s.queries.TrashAllUserFiles(ctx, ownerID); err != nil {
+		return 0, fmt.Errorf("failed to move files to trash: %w", err)
+	}
+	fileIDs, err := s.queries.ListTrashedFileIDs(ctx, ownerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list trashed files: %w", err)
+	}
+
+	for i, fileID := range fileIDs {
+		if err := s.purgeFile(ctx, fileID, ownerID); err != nil {
+			return i, err
+		}
+	}
+	return len(fileIDs), nil
+}
+
+// PurgeExpiredTrash permanently deletes every file that has been in the trash for longer than
+// retention and returns how many were deleted.
+func (s *Service) PurgeExpiredTrash(ctx context.Context, retention time.Duration) (int, error) {
+	expired, err := s.queries.ListExpiredTrashedFiles(ctx, pgtype.Timestamptz{Time: time.Now().Add(-retention), Valid: true})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired trashed files: %w", err)
+	}
+
+	purged := 0
+	for _, file := range expired {
+		if err := s.purgeFile(ctx, file.ID, file.OwnerID); err != nil {
+			if err == ErrFileNotFound {
+				// Restored or purged by its owner since we listed it.
+				continue
+			}
+			return purged, fmt.Errorf("failed to purge trashed file %d: %w", file.ID, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartTrashPurger starts a background goroutine that purges files older than retention
+// from every user's trash every interval.
+func (s *Service) StartTrashPurger(retention, interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			purged, err := s.PurgeExpiredTrash(context.Background(), retention)
+			if err != nil {
+				log.Printf("ERROR: failed to purge trash: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d files from the trash", purged)
+			}
+		}
+	}()
+}
+
+// purgeFile deletes a trashed file together with all of its versions, releasing the
+// reference each version holds on its physical file.
+func (s *Service) purgeFile(ctx context.Context, fileID, ownerID int64) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+
+	if _, err := qtx.GetTrashedFileForUpdate(ctx, db.GetTrashedFileForUpdateParams{ID: fileID, OwnerID: ownerID}); err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrFileNotFound
+		}
+		return fmt.Errorf("failed to lock trashed file: %w", err)
+	}
+
+	versions, err := qtx.ListFileVersionPhysicalFiles(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to list file versions: %w", err)
+	}
+
+	// Versions are removed by the cascade, which must happen before their physical files can go.
+	if err := qtx.DeleteUserFile(ctx, fileID); err != nil {
+		return fmt.Errorf("failed to delete user file record: %w", err)
+	}
+
+	var orphaned []string
+	for _, version := range versions {
+		deleted, err := releasePhysicalFile(ctx, qtx, version.PhysicalFileID, version.SizeBytes, ownerID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			log.Printf("Ref count is zero. Deleting physical file and object from storage.")
+			orphaned = append(orphaned, version.StoragePath)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.deleteObjects(ctx, orphaned)
+	return nil
+}
+
+// requireFileRole checks that userID has at least the min role on a file, as its owner or
+// through a share. Users with no access at all get ErrFileNotFound.
+func (s *Service) requireFileRole(ctx context.Context, fileID, userID int64, min auth.ShareRole) (*auth.Access, error) {
+	access, err := auth.FileAccess(ctx, s.queries, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if access == nil {
+		return nil, ErrFileNotFound
+	}
+	if !access.Role.Includes(min) {
+		return nil, auth.ErrInsufficientRole
+	}
+	return access, nil
 }
 
-func (s *Service) AddTag(ctx context.Context, fileID, ownerID int64, tag string) error {
-	// --- THIS IS THE FIX ---
-	// Use the new, correctly named fields from the generated struct.
+// AddTag adds a tag to a file. The owner and editors may change tags.
+func (s *Service) AddTag(ctx context.Context, fileID, userID int64, tag string) error {
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleEditor); err != nil {
+		return err
+	}
 	return s.queries.AddTagToFile(ctx, db.AddTagToFileParams{
-		FileID:  fileID,
-		Tag:     tag,
-		OwnerID: ownerID,
+		FileID: fileID,
+		Tag:    tag,
 	})
 }
 
-func (s *Service) RemoveTag(ctx context.Context, fileID, ownerID int64, tag string) error {
-	// --- THIS IS THE FIX ---
-	// Use the new, correctly named fields.
+// RemoveTag removes a tag from a file. The owner and editors may change tags.
+func (s *Service) RemoveTag(ctx context.Context, fileID, userID int64, tag string) error {
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleEditor); err != nil {
+		return err
+	}
 	return s.queries.RemoveTagFromFile(ctx, db.RemoveTagFromFileParams{
-		FileID:  fileID,
-		Tag:     tag,
-		OwnerID: ownerID,
+		FileID: fileID,
+		Tag:    tag,
 	})
-}
\ No newline at end of file
+}
+
+// UpdateFileDetails renames a file and/or changes its description. A nil argument leaves that
+// field unchanged and an empty description clears it. The owner and editors may do this.
+func (s *Service) UpdateFileDetails(ctx context.Context, fileID, userID int64, filename, description *string) (*db.UserFile, error) {
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleEditor); err != nil {
+		return nil, err
+	}
+
+	params := db.UpdateUserFileDetailsParams{ID: fileID}
+	if filename != nil {
+		name := strings.TrimSpace(*filename)
+		if name == "" || len(name) > 255 || strings.Contains(name, "/") {
+			return nil, ErrInvalidFilename
+		}
+		params.Filename = pgtype.Text{String: name, Valid: true}
+	}
+	if description != nil {
+		params.UpdateDescription = true
+		params.Description = pgtype.Text{String: *description, Valid: *description != ""}
+	}
+
+	userFile, err := s.queries.UpdateUserFileDetails(ctx, params)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to update file: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "file:update", map[string]interface{}{
+		"file_id": fileID,
+		"filename": userFile.Filename,
+	})
+
+	return &userFile, nil
+}
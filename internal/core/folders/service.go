@@ -0,0 +1,348 @@
+package folders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/karanbihani/file-vault/internal/core/archive"
+	"github.com/karanbihani/file-vault/internal/core/audit"
+	"github.com/karanbihani/file-vault/internal/core/files"
+	"github.com/karanbihani/file-vault/internal/db"
+)
+
+var (
+	ErrFolderNotFound = errors.New("folder not found or access denied")
+	ErrFolderExists   = errors.New("a folder with this name already exists in the destination")
+	ErrInvalidName    = errors.New("folder name must be 1-255 characters and must not contain '/' or '\\'")
+	ErrInvalidMove    = errors.New("a folder cannot be moved into itself or one of its subfolders")
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+)
+
+// Service manages the folder hierarchy that user files live in.
+type Service struct {
+	db           *pgxpool.Pool
+	queries      *db.Queries
+	fileService  *files.Service
+	auditService *audit.Service
+}
+
+// NewService creates a new folders service.
+func NewService(dbpool *pgxpool.Pool, queries *db.Queries, fileService *files.Service, auditService *audit.Service) *Service {
+	return &Service{
+		db:           dbpool,
+		queries:      queries,
+		fileService:  fileService,
+		auditService: auditService,
+	}
+}
+
+// Contents is the listing of a single folder, or of a user's root when Folder is nil.
+type Contents struct {
+	Folder  *db.Folder
+	Folders []db.Folder
+	Files   []db.ListFilesInFolderRow
+}
+
+func validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// getOwnedFolder retrieves a folder and checks that ownerID owns it.
+func (s *Service) getOwnedFolder(ctx context.Context, folderID, ownerID int64) (*db.Folder, error) {
+	return ownedFolder(ctx, s.queries, folderID, ownerID)
+}
+
+// ownedFolder is getOwnedFolder for callers that read through a transaction.
+func ownedFolder(ctx context.Context, queries *db.Queries, folderID, ownerID int64) (*db.Folder, error) {
+	folder, err := queries.GetFolderByID(ctx, folderID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrFolderNotFound
+		}
+		return nil, fmt.Errorf("failed to get folder: %w", err)
+	}
+	if folder.OwnerID != ownerID {
+		return nil, ErrFolderNotFound
+	}
+	return &folder, nil
+}
+
+// checkParent verifies that parentID, if set, is a folder owned by ownerID.
+func (s *Service) checkParent(ctx context.Context, parentID pgtype.Int8, ownerID int64) error {
+	if !parentID.Valid {
+		return nil
+	}
+	_, err := s.getOwnedFolder(ctx, parentID.Int64, ownerID)
+	return err
+}
+
+// CreateFolder creates a folder under parentID, or at the owner's root when parentID is not set.
+func (s *Service) CreateFolder(ctx context.Context, ownerID int64, parentID pgtype.Int8, name string) (*db.Folder, error) {
+	name, err := validateName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkParent(ctx, parentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	folder, err := s.queries.CreateFolder(ctx, db.CreateFolderParams{OwnerID: ownerID, ParentID: parentID, Name: name})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrFolderExists
+		}
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, ownerID, "folder:create", map[string]interface{}{
+		"folder_id": folder.ID,
+		"name": folder.Name,
+	})
+
+	return &folder, nil
+}
+
+// ListRoot lists the folders and files at a user's root.
+func (s *Service) ListRoot(ctx context.Context, ownerID int64) (*Contents, error) {
+	return s.listContents(ctx, ownerID, nil)
+}
+
+// ListFolder lists a folder's direct subfolders and files. The folder's owner and anyone
+// it (or a folder above it) has been shared with may list it.
+func (s *Service) ListFolder(ctx context.Context, folderID, userID int64) (*Contents, error) {
+	folder, err := s.getReadableFolder(ctx, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.listContents(ctx, folder.OwnerID, folder)
+}
+
+// getReadableFolder retrieves a folder that userID owns or has been shared, directly or
+// through a folder above it.
+func (s *Service) getReadableFolder(ctx context.Context, folderID, userID int64) (*db.Folder, error) {
+	folder, err := s.queries.GetFolderByID(ctx, folderID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrFolderNotFound
+		}
+		return nil, fmt.Errorf("failed to get folder: %w", err)
+	}
+
+	if folder.OwnerID != userID {
+		shared, err := s.queries.IsFolderSharedWithUser(ctx, db.IsFolderSharedWithUserParams{
+			FolderID: folderID, SharedWithUserID: userID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check folder access: %w", err)
+		}
+		if !shared {
+			return nil, ErrFolderNotFound
+		}
+	}
+
+	return &folder, nil
+}
+
+func (s *Service) listContents(ctx context.Context, ownerID int64, folder *db.Folder) (*Contents, error) {
+	var folderID pgtype.Int8
+	if folder != nil {
+		folderID = pgtype.Int8{Int64: folder.ID, Valid: true}
+	}
+
+	subfolders, err := s.queries.ListFoldersInFolder(ctx, db.ListFoldersInFolderParams{OwnerID: ownerID, ParentID: folderID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	folderFiles, err := s.queries.ListFilesInFolder(ctx, db.ListFilesInFolderParams{OwnerID: ownerID, FolderID: folderID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return &Contents{Folder: folder, Folders: subfolders, Files: folderFiles}, nil
+}
+
+// RenameFolder changes a folder's name. The new name must be unique within the folder's parent.
+func (s *Service) RenameFolder(ctx context.Context, folderID, ownerID int64, name string) (*db.Folder, error) {
+	name, err := validateName(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.getOwnedFolder(ctx, folderID, ownerID); err != nil {
+		return nil, err
+	}
+
+	folder, err := s.queries.RenameFolder(ctx, db.RenameFolderParams{Name: name, ID: folderID})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrFolderExists
+		}
+		return nil, fmt.Errorf("failed to rename folder: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, ownerID, "folder:rename", map[string]interface{}{
+		"folder_id": folderID,
+		"name": name,
+	})
+
+	return &folder, nil
+}
+
+// MoveFolder moves a folder, with its whole subtree, under parentID or to the owner's root.
+func (s *Service) MoveFolder(ctx context.Context, folderID, ownerID int64, parentID pgtype.Int8) (*db.Folder, error) {
+	if _, err := s.getOwnedFolder(ctx, folderID, ownerID); err != nil {
+		return nil, err
+	}
+	if err := s.checkParent(ctx, parentID, ownerID); err != nil {
+		return nil, err
+	}
+
+	if parentID.Valid {
+		cycle, err := s.queries.IsFolderInSubtree(ctx, db.IsFolderInSubtreeParams{RootID: folderID, FolderID: parentID.Int64})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check folder hierarchy: %w", err)
+		}
+		if cycle {
+			return nil, ErrInvalidMove
+		}
+	}
+
+	folder, err := s.queries.MoveFolder(ctx, db.MoveFolderParams{ParentID: parentID, ID: folderID})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrFolderExists
+		}
+		return nil, fmt.Errorf("failed to move folder: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, ownerID, "folder:move", map[string]interface{}{
+		"folder_id": folderID,
+		"parent_id": parentID.Int64,
+	})
+
+	return &folder, nil
+}
+
+// DeleteFolder deletes a folder and its subfolders. A folder that still contains anything
+// is only deleted when recursive is set, in which case every file in the subtree is moved to
+// the trash. Restoring one of those files later puts it back at the owner's root. Either the
+// whole subtree is deleted or, on error, nothing is.
+func (s *Service) DeleteFolder(ctx context.Context, folderID, ownerID int64, recursive bool) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	if _, err := ownedFolder(ctx, qtx, folderID, ownerID); err != nil {
+		return err
+	}
+
+	fileIDs, err := qtx.ListFileIDsInSubtree(ctx, folderID)
+	if err != nil {
+		return fmt.Errorf("failed to list files in folder: %w", err)
+	}
+
+	if !recursive {
+		subfolders, err := qtx.ListFoldersInFolder(ctx, db.ListFoldersInFolderParams{
+			OwnerID: ownerID, ParentID: pgtype.Int8{Int64: folderID, Valid: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list subfolders: %w", err)
+		}
+		if len(fileIDs) > 0 || len(subfolders) > 0 {
+			return ErrFolderNotEmpty
+		}
+	}
+
+	for _, fileID := range fileIDs {
+		if err := s.fileService.TrashFileTx(ctx, tx, fileID, ownerID); err != nil {
+			return fmt.Errorf("failed to delete file %d: %w", fileID, err)
+		}
+	}
+
+	if err := qtx.DeleteFolder(ctx, folderID); err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+
+	if err := s.auditService.LogActivityTx(ctx, tx, ownerID, "folder:delete", map[string]interface{}{
+		"folder_id": folderID,
+		"trashed_files": len(fileIDs),
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// MoveFile moves a file into folderID, or to the owner's root when folderID is not set.
+func (s *Service) MoveFile(ctx context.Context, fileID, ownerID int64, folderID pgtype.Int8) error {
+	if err := s.checkParent(ctx, folderID, ownerID); err != nil {
+		return err
+	}
+
+	moved, err := s.queries.MoveUserFile(ctx, db.MoveUserFileParams{FolderID: folderID, ID: fileID, OwnerID: ownerID})
+	if err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	if moved == 0 {
+		return files.ErrFileNotFound
+	}
+
+	s.auditService.LogActivity(ctx, ownerID, "file:move", map[string]interface{}{
+		"file_id": fileID,
+		"folder_id": folderID.Int64,
+	})
+
+	return nil
+}
+
+// Archive resolves what goes into a ZIP download: either the listed files, or everything below
+// folderID laid out by subfolder. It returns a name for the archive along with its entries.
+func (s *Service) Archive(ctx context.Context, userID int64, fileIDs []int64, folderID pgtype.Int8) (string, []archive.Entry, error) {
+	if !folderID.Valid {
+		entries, err := s.fileService.ArchiveEntries(ctx, fileIDs, userID)
+		if err != nil {
+			return "", nil, err
+		}
+		return "files", entries, nil
+	}
+
+	folder, err := s.getReadableFolder(ctx, folderID.Int64, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	entries, err := archive.FolderEntries(ctx, s.queries, folder.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(entries) == 0 {
+		return "", nil, archive.ErrNoFiles
+	}
+	return folder.Name, entries, nil
+}
+
+// WriteArchive streams the entries returned by Archive to w.
+func (s *Service) WriteArchive(ctx context.Context, w io.Writer, entries []archive.Entry, userID int64) error {
+	return s.fileService.WriteArchive(ctx, w, entries, userID)
+}
@@ -2,21 +2,96 @@ package rbac
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/karanbihani/file-vault/internal/auth"
+	"github.com/karanbihani/file-vault/internal/core/audit"
 	"github.com/karanbihani/file-vault/internal/db"
 )
 
+// defaultRoleName is the role every new user gets. It can't be renamed or deleted.
+const defaultRoleName = "user"
+
+// maxRoleNameLength matches roles.name.
+const maxRoleNameLength = 50
+
+var (
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrInvalidRoleName = errors.New("role name must be between 1 and 50 characters")
+	ErrRoleNameTaken   = errors.New("a role with this name already exists")
+	ErrDefaultRole     = errors.New("the default 'user' role can't be renamed or deleted")
+	ErrLastAdmin       = errors.New("this would leave no user able to manage roles")
+)
+
 // Service handles the business logic for RBAC.
 type Service struct {
-	queries *db.Queries
+	db           *pgxpool.Pool
+	queries      *db.Queries
+	auditService *audit.Service
+	// permissions caches what each user may do, and is told about every change made here.
+	permissions *auth.PermissionResolver
 }
 
 // NewService creates a new RBAC service.
-func NewService(queries *db.Queries) *Service {
+func NewService(dbpool *pgxpool.Pool, queries *db.Queries, auditService *audit.Service, permissions *auth.PermissionResolver) *Service {
 	return &Service{
-		queries: queries,
+		db:           dbpool,
+		queries:      queries,
+		auditService: auditService,
+		permissions:  permissions,
+	}
+}
+
+func normalizeRoleName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > maxRoleNameLength {
+		return "", ErrInvalidRoleName
+	}
+	return name, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// withAdminGuard runs fn in a transaction and commits it only if afterwards someone can still
+// manage roles, so that no change locks every administrator out of the RBAC APIs. logFn then
+// records the change in the same transaction.
+func (s *Service) withAdminGuard(ctx context.Context, fn func(qtx *db.Queries) error, logFn func(tx pgx.Tx) error) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := s.queries.WithTx(tx)
+
+	if err := qtx.LockRoleAssignments(ctx); err != nil {
+		return fmt.Errorf("could not lock role assignments: %w", err)
+	}
+	if err := fn(qtx); err != nil {
+		return err
+	}
+	admins, err := qtx.CountUsersWithPermission(ctx, auth.PermissionAdminManageRoles)
+	if err != nil {
+		return fmt.Errorf("could not count administrators: %w", err)
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+	if err := logFn(tx); err != nil {
+		return err
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
 // ListRoles retrieves all roles from the database.
@@ -35,7 +110,7 @@ func (s *Service) GetPermissionsForRole(ctx context.Context, roleID int32) ([]db
 }
 
 // AddPermissionToRole assigns a permission to a role.
-func (s *Service) AddPermissionToRole(ctx context.Context, roleID, permissionID int32) error {
+func (s *Service) AddPermissionToRole(ctx context.Context, actorID int64, roleID, permissionID int32) error {
 	err := s.queries.AddPermissionToRole(ctx, db.AddPermissionToRoleParams{
 		RoleID:       roleID,
 		PermissionID: permissionID,
@@ -43,17 +118,254 @@ func (s *Service) AddPermissionToRole(ctx context.Context, roleID, permissionID
 	if err != nil {
 		return fmt.Errorf("could not add permission to role: %w", err)
 	}
+	s.permissions.InvalidateAll(ctx)
+
+	s.auditService.LogActivity(ctx, actorID, "role:permission_add", map[string]interface{}{
+		"role_id":       roleID,
+		"permission_id": permissionID,
+	})
 	return nil
 }
 
-// RemovePermissionFromRole removes a permission from a role.
-func (s *Service) RemovePermissionFromRole(ctx context.Context, roleID, permissionID int32) error {
-	err := s.queries.RemovePermissionFromRole(ctx, db.RemovePermissionFromRoleParams{
-		RoleID:       roleID,
-		PermissionID: permissionID,
+// RemovePermissionFromRole removes a permission from a role. It fails with ErrLastAdmin if
+// that would leave nobody able to manage roles.
+func (s *Service) RemovePermissionFromRole(ctx context.Context, actorID int64, roleID, permissionID int32) error {
+	err := s.withAdminGuard(ctx, func(qtx *db.Queries) error {
+		err := qtx.RemovePermissionFromRole(ctx, db.RemovePermissionFromRoleParams{
+			RoleID:       roleID,
+			PermissionID: permissionID,
+		})
+		if err != nil {
+			return fmt.Errorf("could not remove permission from role: %w", err)
+		}
+		return nil
+	}, func(tx pgx.Tx) error {
+		return s.auditService.LogActivityTx(ctx, tx, actorID, "role:permission_remove", map[string]interface{}{
+			"role_id":       roleID,
+			"permission_id": permissionID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.permissions.InvalidateAll(ctx)
+	return nil
+}
+
+// SetRoleRequireTwoFactor sets whether members of a role must use two-factor authentication.
+// When it is turned on, members who haven't enrolled are signed out so that they have to
+// enroll on their next login.
+func (s *Service) SetRoleRequireTwoFactor(ctx context.Context, actorID int64, roleID int32, required bool) (db.Role, error) {
+	role, err := s.queries.SetRoleRequireTwoFactor(ctx, db.SetRoleRequireTwoFactorParams{ID: roleID, RequireTwoFactor: required})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return db.Role{}, ErrRoleNotFound
+		}
+		return db.Role{}, fmt.Errorf("could not update role: %w", err)
+	}
+
+	var signedOut int64
+	if required {
+		signedOut, err = s.queries.RevokeSessionsWithoutTwoFactorForRole(ctx, roleID)
+		if err != nil {
+			return db.Role{}, fmt.Errorf("could not sign out members without two-factor authentication: %w", err)
+		}
+	}
+
+	s.auditService.LogActivity(ctx, actorID, "role:require_2fa", map[string]interface{}{
+		"role_id":             roleID,
+		"role":                role.Name,
+		"required":            required,
+		"sessions_signed_out": signedOut,
+	})
+	return role, nil
+}
+
+// CreateRole creates a role with no permissions.
+func (s *Service) CreateRole(ctx context.Context, actorID int64, name string) (db.Role, error) {
+	name, err := normalizeRoleName(name)
+	if err != nil {
+		return db.Role{}, err
+	}
+	role, err := s.queries.CreateRole(ctx, name)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return db.Role{}, ErrRoleNameTaken
+		}
+		return db.Role{}, fmt.Errorf("could not create role: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, actorID, "role:create", map[string]interface{}{
+		"role_id": role.ID,
+		"role":    role.Name,
+	})
+	return role, nil
+}
+
+// RenameRole renames a role. Roles are referred to by name in the OIDC role mapping, which
+// has to be updated to match.
+func (s *Service) RenameRole(ctx context.Context, actorID int64, roleID int32, name string) (db.Role, error) {
+	name, err := normalizeRoleName(name)
+	if err != nil {
+		return db.Role{}, err
+	}
+	old, err := s.queries.GetRoleByID(ctx, roleID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return db.Role{}, ErrRoleNotFound
+		}
+		return db.Role{}, fmt.Errorf("could not get role: %w", err)
+	}
+	if old.Name == defaultRoleName {
+		return db.Role{}, ErrDefaultRole
+	}
+
+	role, err := s.queries.RenameRole(ctx, db.RenameRoleParams{ID: roleID, Name: name})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return db.Role{}, ErrRoleNotFound
+		}
+		if isUniqueViolation(err) {
+			return db.Role{}, ErrRoleNameTaken
+		}
+		return db.Role{}, fmt.Errorf("could not rename role: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, actorID, "role:rename", map[string]interface{}{
+		"role_id":  role.ID,
+		"old_name": old.Name,
+		"new_name": role.Name,
+	})
+	return role, nil
+}
+
+// DeleteRole deletes a role, taking it away from everyone who has it. It fails with
+// ErrLastAdmin if that would leave nobody able to manage roles.
+func (s *Service) DeleteRole(ctx context.Context, actorID int64, roleID int32) error {
+	var role db.Role
+	err := s.withAdminGuard(ctx, func(qtx *db.Queries) error {
+		existing, err := qtx.GetRoleByID(ctx, roleID)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("could not get role: %w", err)
+		}
+		if existing.Name == defaultRoleName {
+			return ErrDefaultRole
+		}
+		role, err = qtx.DeleteRole(ctx, roleID)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("could not delete role: %w", err)
+		}
+		return nil
+	}, func(tx pgx.Tx) error {
+		return s.auditService.LogActivityTx(ctx, tx, actorID, "role:delete", map[string]interface{}{
+			"role_id": role.ID,
+			"role":    role.Name,
+		})
 	})
 	if err != nil {
-		return fmt.Errorf("could not remove permission from role: %w", err)
+		return err
 	}
+	s.permissions.InvalidateAll(ctx)
 	return nil
-}
\ No newline at end of file
+}
+
+// ListUserRoles retrieves the roles a user has.
+func (s *Service) ListUserRoles(ctx context.Context, userID int64) ([]db.Role, error) {
+	if _, err := s.queries.GetUserByID(ctx, userID); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("could not get user: %w", err)
+	}
+	return s.queries.ListUserRoles(ctx, userID)
+}
+
+// GrantUserRole gives a user a role. If the role requires two-factor authentication and the
+// user hasn't enrolled, they are signed out so that they have to enroll on their next login.
+func (s *Service) GrantUserRole(ctx context.Context, actorID, userID int64, roleID int32) ([]db.Role, error) {
+	if _, err := s.queries.GetUserByID(ctx, userID); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("could not get user: %w", err)
+	}
+	role, err := s.queries.GetRoleByID(ctx, roleID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("could not get role: %w", err)
+	}
+
+	added, err := s.queries.AddUserRole(ctx, db.AddUserRoleParams{UserID: userID, RoleID: roleID})
+	if err != nil {
+		return nil, fmt.Errorf("could not grant role: %w", err)
+	}
+	if added > 0 {
+		s.permissions.InvalidateUser(ctx, userID)
+		if role.RequireTwoFactor {
+			// Members who haven't enrolled can't have logged in since the requirement was set,
+			// so this only signs out the new member.
+			if _, err := s.queries.RevokeSessionsWithoutTwoFactorForRole(ctx, roleID); err != nil {
+				return nil, fmt.Errorf("could not sign out user without two-factor authentication: %w", err)
+			}
+		}
+		s.auditService.LogActivity(ctx, actorID, "user_role:grant", map[string]interface{}{
+			"target_user_id": userID,
+			"role_id":        role.ID,
+			"role":           role.Name,
+		})
+	}
+	return s.queries.ListUserRoles(ctx, userID)
+}
+
+// RevokeUserRole takes a role away from a user. It fails with ErrLastAdmin if that would
+// leave nobody able to manage roles.
+func (s *Service) RevokeUserRole(ctx context.Context, actorID, userID int64, roleID int32) ([]db.Role, error) {
+	var role db.Role
+	var removed int64
+	err := s.withAdminGuard(ctx, func(qtx *db.Queries) error {
+		if _, err := qtx.GetUserByID(ctx, userID); err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("could not get user: %w", err)
+		}
+		var err error
+		role, err = qtx.GetRoleByID(ctx, roleID)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrRoleNotFound
+			}
+			return fmt.Errorf("could not get role: %w", err)
+		}
+		removed, err = qtx.RemoveUserRole(ctx, db.RemoveUserRoleParams{UserID: userID, RoleID: roleID})
+		if err != nil {
+			return fmt.Errorf("could not revoke role: %w", err)
+		}
+		return nil
+	}, func(tx pgx.Tx) error {
+		if removed == 0 {
+			return nil
+		}
+		return s.auditService.LogActivityTx(ctx, tx, actorID, "user_role:revoke", map[string]interface{}{
+			"target_user_id": userID,
+			"role_id":        role.ID,
+			"role":           role.Name,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if removed > 0 {
+		s.permissions.InvalidateUser(ctx, userID)
+	}
+	return s.queries.ListUserRoles(ctx, userID)
+}
@@ -0,0 +1,156 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxQueryLength is the longest search query accepted, in bytes.
+const maxQueryLength = 256
+
+var (
+	ErrEmptyQuery   = errors.New("search query must contain at least one word")
+	ErrQueryTooLong = errors.New("search query is too long")
+)
+
+// Query is a search query translated for the database. Match and Exclude are tsquery texts,
+// for files to find and files to leave out, and Fuzzy holds the words to look for in
+// filenames by trigram similarity. Any of them may be empty.
+type Query struct {
+	Match   string
+	Exclude string
+	Fuzzy   string
+}
+
+// ParseQuery translates a search query, written the way most search boxes take them:
+//
+//   - Words are matched as prefixes, so "rep" finds "report". A file must match every word.
+//   - "Quoted words" must appear together, in that order, and are matched whole.
+//   - A word or quoted phrase preceded by "-" excludes the files that match it.
+//   - OR between two words or phrases matches either of them.
+//
+// Words and phrases are matched against filenames, tags and descriptions, ignoring case.
+func ParseQuery(q string) (Query, error) {
+	if len(q) > maxQueryLength {
+		return Query{}, ErrQueryTooLong
+	}
+
+	// Each group is a run of terms joined by OR; the groups are joined by AND.
+	var groups [][]string
+	var excluded, fuzzy []string
+	joinNext := false
+	for _, t := range tokenize(q) {
+		if !t.phrase && !t.negated && strings.EqualFold(t.text, "or") && len(groups) > 0 {
+			joinNext = true
+			continue
+		}
+		words := queryWords(t.text)
+		if len(words) == 0 {
+			continue
+		}
+
+		if t.negated {
+			excluded = append(excluded, tsqueryTerm(words, t.phrase))
+			joinNext = false
+			continue
+		}
+		term := tsqueryTerm(words, t.phrase)
+		if joinNext {
+			groups[len(groups)-1] = append(groups[len(groups)-1], term)
+		} else {
+			groups = append(groups, []string{term})
+		}
+		joinNext = false
+		fuzzy = append(fuzzy, words...)
+	}
+
+	if len(groups) == 0 && len(excluded) == 0 {
+		return Query{}, ErrEmptyQuery
+	}
+
+	clauses := make([]string, 0, len(groups))
+	for _, g := range groups {
+		if len(g) == 1 {
+			clauses = append(clauses, g[0])
+		} else {
+			clauses = append(clauses, "("+strings.Join(g, " | ")+")")
+		}
+	}
+	return Query{
+		Match:   strings.Join(clauses, " & "),
+		Exclude: strings.Join(excluded, " | "),
+		Fuzzy:   strings.Join(fuzzy, " "),
+	}, nil
+}
+
+type token struct {
+	text    string
+	phrase  bool
+	negated bool
+}
+
+// tokenize splits a query into words and quoted phrases. An unterminated quote runs to the
+// end of the query.
+func tokenize(q string) []token {
+	var tokens []token
+	for {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			return tokens
+		}
+
+		var t token
+		if rest, ok := strings.CutPrefix(q, "-"); ok && rest != "" && !unicode.IsSpace(firstRune(rest)) {
+			t.negated = true
+			q = rest
+		}
+		if rest, ok := strings.CutPrefix(q, `"`); ok {
+			t.phrase = true
+			t.text, q, _ = strings.Cut(rest, `"`)
+		} else {
+			end := strings.IndexFunc(q, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+			if end < 0 {
+				end = len(q)
+			}
+			t.text, q = q[:end], q[end:]
+		}
+		tokens = append(tokens, t)
+	}
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+// queryWords splits text into words, leaving out those without a letter or digit. Full-text
+// search ignores them, and in a phrase they would leave a gap between the words around them.
+func queryWords(text string) []string {
+	var words []string
+	for _, w := range strings.Fields(text) {
+		if strings.IndexFunc(w, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// tsqueryTerm writes a word or phrase as tsquery text. A single word that isn't part of a
+// phrase matches as a prefix.
+func tsqueryTerm(words []string, phrase bool) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = quoteLexeme(w)
+	}
+	if !phrase && len(words) == 1 {
+		return quoted[0] + ":*"
+	}
+	return strings.Join(quoted, " <-> ")
+}
+
+// quoteLexeme quotes a word for tsquery text, so that it can't be read as an operator.
+func quoteLexeme(w string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(w) + "'"
+}
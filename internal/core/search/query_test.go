@@ -0,0 +1,57 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       string
+		want    Query
+		wantErr error
+	}{
+		{"word", "report", Query{Match: "'report':*", Fuzzy: "report"}, nil},
+		{"words", "annual report", Query{Match: "'annual':* & 'report':*", Fuzzy: "annual report"}, nil},
+		{"phrase", `"annual report"`, Query{Match: "'annual' <-> 'report'", Fuzzy: "annual report"}, nil},
+		{"one word phrase", `"report"`, Query{Match: "'report'", Fuzzy: "report"}, nil},
+		{"unterminated quote", `tax "annual summ`, Query{Match: "'tax':* & 'annual' <-> 'summ'", Fuzzy: "tax annual summ"}, nil},
+		{"words without letters", `"annual - report" ...`, Query{Match: "'annual' <-> 'report'", Fuzzy: "annual report"}, nil},
+
+		{"excluded word", "report -draft", Query{Match: "'report':*", Exclude: "'draft':*", Fuzzy: "report"}, nil},
+		{"excluded phrase", `report -"first draft"`, Query{Match: "'report':*", Exclude: "'first' <-> 'draft'", Fuzzy: "report"}, nil},
+		{"dash before a space", "report - draft", Query{Match: "'report':* & 'draft':*", Fuzzy: "report draft"}, nil},
+		{"only excluded", "-draft -old", Query{Exclude: "'draft':* | 'old':*"}, nil},
+
+		{"or", "pdf OR docx", Query{Match: "('pdf':* | 'docx':*)", Fuzzy: "pdf docx"}, nil},
+		{"lowercase or", "pdf or docx", Query{Match: "('pdf':* | 'docx':*)", Fuzzy: "pdf docx"}, nil},
+		{"or then and", "pdf OR docx invoice", Query{Match: "('pdf':* | 'docx':*) & 'invoice':*", Fuzzy: "pdf docx invoice"}, nil},
+		{"or phrases", `"q1 report" OR "q2 report"`, Query{Match: "('q1' <-> 'report' | 'q2' <-> 'report')", Fuzzy: "q1 report q2 report"}, nil},
+		{"leading or", "OR report", Query{Match: "'OR':* & 'report':*", Fuzzy: "OR report"}, nil},
+		{"trailing or", "report OR", Query{Match: "'report':*", Fuzzy: "report"}, nil},
+		{"or before an excluded word", "pdf OR -draft docx", Query{Match: "'pdf':* & 'docx':*", Exclude: "'draft':*", Fuzzy: "pdf docx"}, nil},
+		{"quoted or", `pdf "or" docx`, Query{Match: "'pdf':* & 'or' & 'docx':*", Fuzzy: "pdf or docx"}, nil},
+
+		{"quote in a word", "o'brien", Query{Match: "'o''brien':*", Fuzzy: "o'brien"}, nil},
+		{"backslash in a word", `c:\temp`, Query{Match: `'c:\\temp':*`, Fuzzy: `c:\temp`}, nil},
+		{"operators in a word", "a&b|!c", Query{Match: "'a&b|!c':*", Fuzzy: "a&b|!c"}, nil},
+
+		{"empty", "", Query{}, ErrEmptyQuery},
+		{"spaces", "   ", Query{}, ErrEmptyQuery},
+		{"empty phrase", `""`, Query{}, ErrEmptyQuery},
+		{"no letters", "- ... !!", Query{}, ErrEmptyQuery},
+		{"too long", strings.Repeat("a", maxQueryLength+1), Query{}, ErrQueryTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.q)
+			if err != tt.wantErr {
+				t.Fatalf("ParseQuery(%q) error = %v, want %v", tt.q, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
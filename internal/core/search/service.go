@@ -2,27 +2,73 @@ package search
 
 import (
 	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/auth"
 	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/pagination"
 )
 
+// ErrRelevanceWithoutQuery is returned when results are to be sorted by relevance but there is
+// no search query to rank them against.
+var ErrRelevanceWithoutQuery = errors.New("sorting by relevance requires a search query (q)")
+
 type Service struct {
-	queries *db.Queries
+	queries     *db.Queries
+	permissions *auth.PermissionResolver
 }
 
-func NewService(queries *db.Queries) *Service {
-	return &Service{queries: queries}
+func NewService(queries *db.Queries, permissions *auth.PermissionResolver) *Service {
+	return &Service{queries: queries, permissions: permissions}
 }
 
-// Pass through to the database query
+// GetUserPermissions returns the permissions the user can use for this request.
 func (s *Service) GetUserPermissions(ctx context.Context, userID int64) ([]string, error) {
-	return s.queries.GetUserPermissions(ctx, userID)
+	return s.permissions.Permissions(ctx, userID)
 }
 
-// SearchFiles converts API parameters into the format required by the sqlc query.
-func (s *Service) SearchFiles(ctx context.Context, params db.SearchFilesParams) ([]db.SearchFilesRow, error) {
+// SearchFiles converts API parameters into the format required by the sqlc query and returns
+// a page of the results. params.Query holds the search query as the user typed it; see
+// ParseQuery.
+func (s *Service) SearchFiles(ctx context.Context, params db.SearchFilesParams, page pagination.Request) (pagination.Page[db.SearchFilesRow], error) {
 	// Add wildcard '%' for ILIKE search on filename
 	if params.Filename.Valid {
 		params.Filename.String = "%" + params.Filename.String + "%"
 	}
-	return s.queries.SearchFiles(ctx, params)
+	if params.Query.Valid {
+		query, err := ParseQuery(params.Query.String)
+		if err != nil {
+			return pagination.Page[db.SearchFilesRow]{}, err
+		}
+		params.Query = optionalText(query.Match)
+		params.Exclude = optionalText(query.Exclude)
+		params.Fuzzy = optionalText(query.Fuzzy)
+	}
+	if page.Sort.Key == pagination.SortRelevance && !params.Query.Valid {
+		return pagination.Page[db.SearchFilesRow]{}, ErrRelevanceWithoutQuery
+	}
+	params.CursorID = page.CursorID()
+	params.SortKey = page.Sort.Key
+	params.Descending = page.Sort.Descending
+	params.CursorText = page.CursorText()
+	params.CursorInt = page.CursorInt()
+	params.CursorTime = page.CursorTime()
+	params.CursorFloat = page.CursorFloat()
+	params.PageLimit = page.FetchLimit()
+
+	rows, err := s.queries.SearchFiles(ctx, params)
+	if err != nil {
+		return pagination.Page[db.SearchFilesRow]{}, err
+	}
+	return pagination.NewPage(page, rows, func(f db.SearchFilesRow) pagination.Key {
+		if page.Sort.Key == pagination.SortRelevance {
+			return pagination.Key{Float: f.Relevance, ID: f.ID}
+		}
+		return pagination.FileKey(page.Sort.Key, f.ID, f.Filename, f.MimeType, f.SizeBytes, f.UploadDate)
+	}), nil
+}
+
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
 }
\ No newline at end of file
@@ -4,30 +4,158 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/auth"
+	"github.com/karanbihani/file-vault/internal/core/archive"
 	"github.com/karanbihani/file-vault/internal/core/audit"
 	"github.com/karanbihani/file-vault/internal/db"      // Adjust to your module path
 	"github.com/karanbihani/file-vault/internal/storage" // Adjust to your module path
+	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrShareNotFound        = errors.New("invalid or expired share link")
+	ErrShareExpired         = errors.New("share link has expired")
+	ErrDownloadLimitReached = errors.New("share link has reached its download limit")
+	ErrPreconditionFailed   = errors.New("the shared file does not match If-Match")
+	ErrPasswordRequired     = errors.New("a valid password is required for this share link")
+	ErrInvalidExpiry        = errors.New("expiry must be in the future and within the maximum link lifetime")
+	ErrExpiryRequired       = errors.New("public links must expire")
+	ErrInvalidMaxDownloads  = errors.New("max_downloads must not be negative")
+	ErrFileNotFound         = errors.New("file not found or access denied")
+	ErrFolderNotFound       = errors.New("folder not found or access denied")
+	ErrSharerUnverified     = errors.New("verify your email address before sharing with other users")
+	ErrRecipientUnverified  = errors.New("the recipient has not verified their email address")
+)
+
+// DownloadGrantLifetime is how long after a counted download of a shared file the client may
+// go on fetching parts of it, to resume or seek, without using up the link's download limit.
+const DownloadGrantLifetime = time.Hour
+
+// resumeSlack is how far before the end of what a grant has already served a continuation may
+// start and still be free. A client that was cut off has received less than the server sent,
+// since the rest was still in buffers along the way, and resumes from what it received.
+const resumeSlack = 1 << 20
+
 // Service handles the business logic for file sharing.
 type Service struct {
 	queries *db.Queries
-	storage *storage.Client
+	storage storage.Backend
 	auditService *audit.Service 
+	maxLinkLifetime time.Duration
+	requireVerifiedEmail bool
+	// grantSecret signs download grants.
+	grantSecret []byte
 }
 
-// NewService creates a new sharing service.
-func NewService(queries *db.Queries, storageClient *storage.Client, auditService *audit.Service) *Service {
+// NewService creates a new sharing service. Public links expire after at most maxLinkLifetime,
+// or may never expire if it is 0.
+// If requireVerifiedEmail is set, only users with verified email addresses can share with
+// each other.
+func NewService(queries *db.Queries, storageBackend storage.Backend, auditService *audit.Service, maxLinkLifetime time.Duration, requireVerifiedEmail bool) *Service {
+	secret := os.Getenv("JWT_SECRET_KEY")
+	if secret == "" {
+		log.Fatal("JWT_SECRET_KEY environment variable is not set")
+	}
 	return &Service{
 		queries: queries,
-		storage: storageClient,
-		auditService: audit.NewService(queries),
+		storage: storageBackend,
+		auditService: auditService,
+		maxLinkLifetime: maxLinkLifetime,
+		requireVerifiedEmail: requireVerifiedEmail,
+		grantSecret: []byte(secret),
+	}
+}
+
+// checkVerified enforces the verified email requirement, if it is on, for a share from
+// sharerID to recipient.
+func (s *Service) checkVerified(ctx context.Context, sharerID int64, recipient db.User) error {
+	if !s.requireVerifiedEmail {
+		return nil
+	}
+	sharer, err := s.queries.GetUserByID(ctx, sharerID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if !sharer.EmailVerifiedAt.Valid {
+		return ErrSharerUnverified
+	}
+	if !recipient.EmailVerifiedAt.Valid {
+		return ErrRecipientUnverified
+	}
+	return nil
+}
+
+// LinkSettings are the restrictions on a public share link. A nil field means "use the
+// default" when creating a link and "leave unchanged" when updating one.
+type LinkSettings struct {
+	// ExpiresAt defaults to the maximum link lifetime from now. If the service has no maximum,
+	// links without it never expire, and NeverExpires removes the expiry of an existing link.
+	ExpiresAt    *time.Time
+	NeverExpires bool
+	// MaxDownloads limits how many times the link can be downloaded. 0 removes the limit.
+	MaxDownloads *int64
+	// Password protects the link. An empty string removes the password.
+	Password *string
+}
+
+// linkRestrictions is the database form of LinkSettings.
+type linkRestrictions struct {
+	ExpiresAt    pgtype.Timestamptz
+	MaxDownloads pgtype.Int8
+	PasswordHash pgtype.Text
+}
+
+// apply validates settings and merges them into current.
+func (s *Service) apply(settings LinkSettings, current linkRestrictions) (linkRestrictions, error) {
+	now := time.Now()
+	limited := s.maxLinkLifetime > 0
+	switch {
+	case settings.NeverExpires:
+		if limited {
+			return current, ErrExpiryRequired
+		}
+		current.ExpiresAt = pgtype.Timestamptz{}
+	case settings.ExpiresAt != nil:
+		if !settings.ExpiresAt.After(now) || (limited && settings.ExpiresAt.After(now.Add(s.maxLinkLifetime))) {
+			return current, ErrInvalidExpiry
+		}
+		current.ExpiresAt = pgtype.Timestamptz{Time: *settings.ExpiresAt, Valid: true}
+	case limited && !current.ExpiresAt.Valid:
+		// This also gives links from before the limit was set an expiry when they are updated.
+		current.ExpiresAt = pgtype.Timestamptz{Time: now.Add(s.maxLinkLifetime), Valid: true}
+	}
+
+	if settings.MaxDownloads != nil {
+		if *settings.MaxDownloads < 0 {
+			return current, ErrInvalidMaxDownloads
+		}
+		current.MaxDownloads = pgtype.Int8{Int64: *settings.MaxDownloads, Valid: *settings.MaxDownloads > 0}
 	}
+
+	if settings.Password != nil {
+		current.PasswordHash = pgtype.Text{}
+		if *settings.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(*settings.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return current, fmt.Errorf("failed to hash share password: %w", err)
+			}
+			current.PasswordHash = pgtype.Text{String: string(hash), Valid: true}
+		}
+	}
+
+	return current, nil
 }
 
 // generateShareToken creates a cryptographically secure, random token.
@@ -39,90 +167,455 @@ func generateShareToken(length int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// CreatePublicLink now includes a critical ownership verification check.
-func (s *Service) CreatePublicLink(ctx context.Context, fileID, ownerID int64) (*db.Share, error) {
-	// --- SECURITY FIX: Verify Ownership ---
-	// Before creating a share link, we query the database to ensure the user making
-	// the request is the actual owner of the file.
-	// We can reuse the GetUserFileForDownload query as it performs this exact check.
-	_, err := s.queries.GetUserFileForDownload(ctx, db.GetUserFileForDownloadParams{ID: fileID, OwnerID: ownerID})
+// requireFileRole checks that userID has at least the min role on a file, as its owner or
+// through a share. Users with no access at all get ErrFileNotFound.
+func (s *Service) requireFileRole(ctx context.Context, fileID, userID int64, min auth.ShareRole) (*auth.Access, error) {
+	access, err := auth.FileAccess(ctx, s.queries, fileID, userID)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			// This error now correctly means "file not found OR you don't own it".
-			return nil, fmt.Errorf("file not found or access denied")
-		}
-		// Handle other potential database errors.
-		return nil, fmt.Errorf("failed to verify file ownership: %w", err)
+		return nil, err
+	}
+	if access == nil {
+		return nil, ErrFileNotFound
+	}
+	if !access.Role.Includes(min) {
+		return nil, auth.ErrInsufficientRole
+	}
+	return access, nil
+}
+
+// requireFolderRole is requireFileRole for folders.
+func (s *Service) requireFolderRole(ctx context.Context, folderID, userID int64, min auth.ShareRole) (*auth.Access, error) {
+	access, err := auth.FolderAccess(ctx, s.queries, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if access == nil {
+		return nil, ErrFolderNotFound
+	}
+	if !access.Role.Includes(min) {
+		return nil, auth.ErrInsufficientRole
+	}
+	return access, nil
+}
+
+// CreatePublicLink creates a public link to a file. The owner and co-owners may do this.
+func (s *Service) CreatePublicLink(ctx context.Context, fileID, userID int64, settings LinkSettings) (*db.Share, error) {
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleCoOwner); err != nil {
+		return nil, err
 	}
-	// --- END SECURITY FIX ---
 
 	// If the check above passes, we can safely proceed.
+	restrictions, err := s.apply(settings, linkRestrictions{})
+	if err != nil {
+		return nil, err
+	}
+
 	token, err := generateShareToken(16)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate share token: %w", err)
 	}
 
 	share, err := s.queries.CreatePublicShareLink(ctx, db.CreatePublicShareLinkParams{
-		UserFileID: fileID,
-		ShareToken: token,
+		UserFileID:   pgtype.Int8{Int64: fileID, Valid: true},
+		ShareToken:   token,
+		ExpiresAt:    restrictions.ExpiresAt,
+		MaxDownloads: restrictions.MaxDownloads,
+		PasswordHash: restrictions.PasswordHash,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create share link in database: %w", err)
 	}
 
-	s.auditService.LogActivity(ctx, ownerID, "share:create_public", map[string]interface{}{
+	s.auditService.LogActivity(ctx, userID, "share:create_public", map[string]interface{}{
 		"file_id": fileID,
 		"share_token": token,
+		"expires_at": expiryDetail(restrictions.ExpiresAt),
+		"max_downloads": restrictions.MaxDownloads.Int64,
+		"password_protected": restrictions.PasswordHash.Valid,
 	})
 	
 	return &share, nil
 }
 
-// PublicDownloadResponse holds the data for a public file download.
-type PublicDownloadResponse struct {
-	Data     io.ReadCloser
-	Filename string
-	Size     int64
-}
+// PublicShare is a public share link whose token and password have been checked.
+type PublicShare = db.GetShareTargetByTokenRow
 
-// ProcessPublicDownload verifies a token, gets the file, and increments the download count.
-func (s *Service) ProcessPublicDownload(ctx context.Context, token string) (*PublicDownloadResponse, error) {
-	shareMeta, err := s.queries.GetShareByToken(ctx, token)
+// AuthorizePublicShare looks up a public share link and checks that it hasn't expired and that
+// password matches, if the link has one. It doesn't count a download.
+func (s *Service) AuthorizePublicShare(ctx context.Context, token, password string) (*PublicShare, error) {
+	share, err := s.queries.GetShareTargetByToken(ctx, token)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("invalid or expired share link")
+			return nil, ErrShareNotFound
 		}
 		return nil, fmt.Errorf("failed to retrieve share link: %w", err)
 	}
 
-	object, err := s.storage.Get(ctx, shareMeta.StoragePath)
+	if expired(s.effectiveExpiry(&share)) {
+		return nil, ErrShareExpired
+	}
+	if share.PasswordHash.Valid {
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(share.PasswordHash.String), []byte(password)) != nil {
+			return nil, ErrPasswordRequired
+		}
+	}
+
+	return &share, nil
+}
+
+// effectiveExpiry is when a link expires. Links from before expiry was introduced have none
+// recorded; while there is a maximum link lifetime, they expire that long after they were
+// created.
+func (s *Service) effectiveExpiry(share *PublicShare) pgtype.Timestamptz {
+	if share.ExpiresAt.Valid || s.maxLinkLifetime <= 0 || !share.CreatedAt.Valid {
+		return share.ExpiresAt
+	}
+	return pgtype.Timestamptz{Time: share.CreatedAt.Time.Add(s.maxLinkLifetime), Valid: true}
+}
+
+// expired reports whether a link with the given expiry has expired. Links without one never do.
+func expired(expiresAt pgtype.Timestamptz) bool {
+	return expiresAt.Valid && !expiresAt.Time.After(time.Now())
+}
+
+// expiryDetail is a link's expiry as recorded in the audit log: nil if it never expires.
+func expiryDetail(expiresAt pgtype.Timestamptz) *time.Time {
+	if !expiresAt.Valid {
+		return nil
+	}
+	return &expiresAt.Time
+}
+
+// claimDownload atomically counts one download against a share, failing once the link has
+// expired or reached its download limit. Concurrent downloads can never exceed the limit.
+func (s *Service) claimDownload(ctx context.Context, share *PublicShare) error {
+	_, err := s.queries.ClaimShareDownload(ctx, share.ID)
+	if err == nil {
+		return nil
+	}
+	if err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to record download: %w", err)
+	}
+	if expired(s.effectiveExpiry(share)) {
+		return ErrShareExpired
+	}
+	return ErrDownloadLimitReached
+}
+
+// newDownloadGrant records a grant, reserved up to reservedThrough, for a download of a
+// shared file that was just counted, and signs it for the client.
+func (s *Service) newDownloadGrant(ctx context.Context, shareID, reservedThrough int64) (int64, string, error) {
+	now := time.Now()
+	expiresAt := now.Add(DownloadGrantLifetime)
+	grantID, err := s.queries.CreateShareDownloadGrant(ctx, db.CreateShareDownloadGrantParams{
+		ShareID:       shareID,
+		ServedThrough: reservedThrough,
+		ExpiresAt:     pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve file from storage: %w", err)
+		return 0, "", err
+	}
+	claims := jwt.MapClaims{
+		"purpose":  "share_download",
+		"grant_id": grantID,
+		"share_id": shareID,
+		"iat":      now.Unix(),
+		"exp":      expiresAt.Unix(),
 	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.grantSecret)
+	return grantID, signed, err
+}
+
+// downloadGrantID returns the ID of the grant in a signed, unexpired download grant for the
+// share. Whether the grant still allows a continuation is up to ReserveShareDownloadGrant.
+func (s *Service) downloadGrantID(grant string, shareID int64) (int64, bool) {
+	if grant == "" {
+		return 0, false
+	}
+	token, err := jwt.Parse(grant, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.grantSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	id, _ := claims["share_id"].(float64)
+	grantID, _ := claims["grant_id"].(float64)
+	if claims["purpose"] != "share_download" || int64(id) != shareID || grantID <= 0 {
+		return 0, false
+	}
+	return int64(grantID), true
+}
 
-	// Increment the download count in a background goroutine so it doesn't slow down the user.
+// reserveContinuation reserves the grant for a free continuation of a download from start to
+// end, and returns how far the grant had already served. It fails if the grant has expired or
+// has served more than resumeSlack past start.
+func (s *Service) reserveContinuation(ctx context.Context, grantID, shareID, start, end int64) (int64, bool, error) {
+	served, err := s.queries.ReserveShareDownloadGrant(ctx, db.ReserveShareDownloadGrantParams{
+		RangeEnd:         end,
+		ID:               grantID,
+		ShareID:          shareID,
+		MaxServedThrough: start + resumeSlack,
+	})
+	if err == pgx.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to check download grant: %w", err)
+	}
+	return served, true, nil
+}
+
+// StartGrantPurger starts a background goroutine that deletes expired download grants every
+// interval.
+func (s *Service) StartGrantPurger(interval time.Duration) {
 	go func() {
-		err := s.queries.IncrementShareDownloadCount(context.Background(), shareMeta.ID)
-		if err != nil {
-			log.Printf("ERROR: failed to increment download count for share ID %d: %v", shareMeta.ID, err)
+		for {
+			time.Sleep(interval)
+			purged, err := s.queries.DeleteExpiredShareDownloadGrants(context.Background())
+			if err != nil {
+				log.Printf("ERROR: failed to purge expired download grants: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d expired download grants", purged)
+			}
 		}
 	}()
+}
+
+// grantedObject is a shared file being served under a download grant. It tracks how far into
+// the file the response has read, and on Close records that on the grant, in place of the
+// reservation made before serving.
+type grantedObject struct {
+	storage.Object
+	pos      int64
+	served   int64
+	reserved int64
+	settle   func(served, reserved int64)
+}
 
-	return &PublicDownloadResponse{
-		Data:     object,
-		Filename: shareMeta.Filename,
-		Size:     shareMeta.SizeBytes,
-	}, nil
+func (o *grantedObject) Read(p []byte) (int, error) {
+	n, err := o.Object.Read(p)
+	o.pos += int64(n)
+	o.served = max(o.served, o.pos)
+	return n, err
 }
 
-func (s *Service) ShareFileWithUser(ctx context.Context, fileID, ownerID int64, recipientEmail string) error {
-	// 1. Verify the user owns the file they are trying to share.
-	_, err := s.queries.GetUserFileForDownload(ctx, db.GetUserFileForDownloadParams{ID: fileID, OwnerID: ownerID})
+func (o *grantedObject) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.Object.Seek(offset, whence)
+	if err == nil {
+		o.pos = pos
+	}
+	return pos, err
+}
+
+func (o *grantedObject) Close() error {
+	o.settle(o.served, o.reserved)
+	return o.Object.Close()
+}
+
+// PublicDownloadRequest is what ProcessPublicDownload needs to know about a request for a
+// shared file: its Range and conditional headers, and the download grant it brought.
+type PublicDownloadRequest struct {
+	Range       string
+	IfRange     string
+	IfMatch     string
+	IfNoneMatch string
+	Grant       string
+}
+
+// PublicDownloadResponse holds the data for a public file download. Grant is set when the
+// download was counted, and lets the client continue it; see ProcessPublicDownload. When
+// NotModified is set the client's copy is current and there is no Data.
+type PublicDownloadResponse struct {
+	Data        storage.Object
+	Filename    string
+	Size        int64
+	MimeType    string
+	Sha256Hash  string
+	Grant       string
+	NotModified bool
+}
+
+// ProcessPublicDownload opens the file behind an authorized share link. Every request that
+// will be sent the file's content is counted against the link's limit and issued a new grant,
+// except a continuation (see ContinuationRange) under the grant of an earlier download that
+// starts where that grant's responses left off. Conditional requests are answered first, so a
+// 304 Not Modified is never counted. The download is claimed before the file is opened, and
+// given back if it can't be.
+//
+// The returned Data records how far it was read on the grant when it is closed.
+func (s *Service) ProcessPublicDownload(ctx context.Context, share *PublicShare, req PublicDownloadRequest) (*PublicDownloadResponse, error) {
+	shareMeta, err := s.queries.GetShareByToken(ctx, share.ShareToken)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return fmt.Errorf("file not found or access denied")
+			return nil, ErrShareNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve share link: %w", err)
+	}
+
+	// The same checks http.ServeContent makes, with the content's hash as its ETag.
+	etag := `"` + shareMeta.Sha256Hash + `"`
+	if req.IfMatch != "" && !etagListMatches(req.IfMatch, etag, false) {
+		return nil, ErrPreconditionFailed
+	}
+	if req.IfNoneMatch != "" && etagListMatches(req.IfNoneMatch, etag, true) {
+		return &PublicDownloadResponse{Sha256Hash: shareMeta.Sha256Hash, NotModified: true}, nil
+	}
+
+	var served, reserved int64
+	free := false
+	grantID, granted := s.downloadGrantID(req.Grant, share.ID)
+	if start, end, ok := ContinuationRange(req.Range, req.IfRange, etag, shareMeta.SizeBytes); ok && granted {
+		served, free, err = s.reserveContinuation(ctx, grantID, share.ID, start, end)
+		if err != nil {
+			return nil, err
+		}
+		reserved = max(served, end)
+	}
+	counted := !free
+	if counted {
+		if err := s.claimDownload(ctx, share); err != nil {
+			return nil, err
+		}
+	}
+
+	response := &PublicDownloadResponse{
+		Filename:   shareMeta.Filename,
+		Size:       shareMeta.SizeBytes,
+		MimeType:   shareMeta.MimeType,
+		Sha256Hash: shareMeta.Sha256Hash,
+	}
+	if counted {
+		served, reserved = 0, shareMeta.SizeBytes
+		grantID, response.Grant, err = s.newDownloadGrant(ctx, share.ID, reserved)
+		if err != nil {
+			s.releaseDownload(share.ID)
+			return nil, fmt.Errorf("failed to issue download grant: %w", err)
 		}
-		return fmt.Errorf("failed to verify file ownership: %w", err)
+	}
+	settle := func(served, reserved int64) {
+		err := s.queries.SettleShareDownloadGrant(context.Background(), db.SettleShareDownloadGrantParams{
+			ServedThrough:   served,
+			ID:              grantID,
+			ReservedThrough: reserved,
+		})
+		if err != nil {
+			log.Printf("ERROR: failed to record download progress of share %d: %v", share.ID, err)
+		}
+	}
+
+	object, err := s.storage.Get(ctx, shareMeta.StoragePath)
+	if err != nil {
+		settle(served, reserved)
+		if counted {
+			s.releaseDownload(share.ID)
+		}
+		return nil, fmt.Errorf("could not retrieve file from storage: %w", err)
+	}
+	response.Data = &grantedObject{Object: object, served: served, reserved: reserved, settle: settle}
+	return response, nil
+}
+
+// releaseDownload gives back a download that was claimed but couldn't be served.
+func (s *Service) releaseDownload(shareID int64) {
+	if err := s.queries.ReleaseShareDownload(context.Background(), shareID); err != nil {
+		log.Printf("ERROR: failed to give back download of share %d: %v", shareID, err)
+	}
+}
+
+// etagListMatches reports whether a comma-separated list of entity tags from an If-Match or
+// If-None-Match header includes etag, or is "*". If-None-Match compares weakly, ignoring W/.
+func etagListMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ContinuationRange reports whether a request for a shared file could continue an earlier
+// download rather than start a new one: a single range that skips the first byte, such as a
+// resumed download or a seek in a video. It returns the range as a start offset and an
+// exclusive end. A Range that http.ServeContent ignores, because If-Range doesn't match the
+// ETag, is no continuation, and neither is a request for several ranges.
+func ContinuationRange(rangeHeader, ifRange, etag string, size int64) (int64, int64, bool) {
+	if ifRange != "" && ifRange != etag {
+		return 0, 0, false
+	}
+	spec, ok := strings.CutPrefix(rangeHeader, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if !ok || err != nil || start <= 0 {
+		return 0, 0, false
+	}
+	if last == "" {
+		return start, max(size, start), true
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, max(min(end+1, size), start), true
+}
+
+// PublicArchive is the content of a public folder link, downloaded as a ZIP archive.
+type PublicArchive struct {
+	Name    string
+	Entries []archive.Entry
+}
+
+// ProcessPublicArchive lists the files currently in the subtree of an authorized folder link
+// and counts the download. Files added or trashed after the link was created are reflected.
+func (s *Service) ProcessPublicArchive(ctx context.Context, share *PublicShare) (*PublicArchive, error) {
+	if !share.FolderID.Valid {
+		return nil, fmt.Errorf("share link does not point at a folder")
+	}
+
+	folder, err := s.queries.GetFolderByID(ctx, share.FolderID.Int64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve shared folder: %w", err)
+	}
+	entries, err := archive.FolderEntries(ctx, s.queries, folder.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, archive.ErrNoFiles
+	}
+
+	if err := s.claimDownload(ctx, share); err != nil {
+		return nil, err
+	}
+
+	return &PublicArchive{Name: folder.Name, Entries: entries}, nil
+}
+
+// WritePublicArchive streams a public folder link's archive to w.
+func (s *Service) WritePublicArchive(ctx context.Context, w io.Writer, publicArchive *PublicArchive) error {
+	return archive.Write(ctx, w, s.storage, publicArchive.Entries)
+}
+
+// ShareFileWithUser shares a file with another user, or changes the role of an existing share.
+// The owner and co-owners may share.
+func (s *Service) ShareFileWithUser(ctx context.Context, fileID, userID int64, recipientEmail string, role auth.ShareRole) error {
+	// 1. Verify the user is allowed to share the file.
+	access, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleCoOwner)
+	if err != nil {
+		return err
 	}
 
 	// 2. Find the recipient user by their email address.
@@ -134,74 +627,62 @@ func (s *Service) ShareFileWithUser(ctx context.Context, fileID, ownerID int64,
 		return fmt.Errorf("failed to find recipient user: %w", err)
 	}
 
-	// 3. Prevent users from sharing files with themselves.
-	if ownerID == recipient.ID {
+	// 3. Prevent users from sharing files with themselves or with the owner.
+	if userID == recipient.ID {
 		return fmt.Errorf("cannot share a file with yourself")
 	}
-
-	// 4. Check if the file is already shared with this user to avoid duplicates.
-	alreadyShared, err := s.queries.IsFileAlreadySharedWithUser(ctx, db.IsFileAlreadySharedWithUserParams{
-		UserFileID:       fileID,
-		SharedWithUserID: recipient.ID,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to check for existing share: %w", err)
+	if access.OwnerID == recipient.ID {
+		return fmt.Errorf("cannot share a file with its owner")
 	}
-	if alreadyShared {
-		return fmt.Errorf("file is already shared with this user")
+	if err := s.checkVerified(ctx, userID, recipient); err != nil {
+		return err
 	}
 
-	// 5. Create the share record in the database.
+	// 4. Create the share record, or update the role if the file is already shared with this user.
 	err = s.queries.ShareFileWithUser(ctx, db.ShareFileWithUserParams{
 		UserFileID:       fileID,
 		SharedWithUserID: recipient.ID,
+		Role:             string(role),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create share record: %w", err)
 	}
 
-	log.Printf("User %d successfully shared file %d with user %d (%s)", ownerID, fileID, recipient.ID, recipientEmail)
+	log.Printf("User %d successfully shared file %d with user %d (%s) as %s", userID, fileID, recipient.ID, recipientEmail, role)
 
-	s.auditService.LogActivity(ctx, ownerID, "share:create_user", map[string]interface{}{
+	s.auditService.LogActivity(ctx, userID, "share:create_user", map[string]interface{}{
 		"file_id": fileID,
 		"shared_with_user_id": recipient.ID,
 		"shared_with_email": recipientEmail,
+		"role": role,
 	})
 
 	return nil
 }
 
-func (s *Service) RevokePublicLinks(ctx context.Context, fileID, ownerID int64) error {
-	// First, verify ownership to ensure the user can manage this file's shares.
-	_, err := s.queries.GetUserFileForDownload(ctx, db.GetUserFileForDownloadParams{ID: fileID, OwnerID: ownerID})
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return fmt.Errorf("file not found or access denied")
-		}
-		return fmt.Errorf("failed to verify file ownership: %w", err)
+func (s *Service) RevokePublicLinks(ctx context.Context, fileID, userID int64) error {
+	// First, verify the user can manage this file's shares.
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleCoOwner); err != nil {
+		return err
 	}
 
-	s.auditService.LogActivity(ctx, ownerID, "share:revoke_public", map[string]interface{}{
+	s.auditService.LogActivity(ctx, userID, "share:revoke_public", map[string]interface{}{
 		"file_id": fileID,
 	})
 
-	return s.queries.DeletePublicShareLinksByFileID(ctx, fileID)
+	return s.queries.DeletePublicShareLinksByFileID(ctx, pgtype.Int8{Int64: fileID, Valid: true})
 }
 
 // UnshareFileWithUser removes a specific user's access to a shared file.
-func (s *Service) UnshareFileWithUser(ctx context.Context, fileID, ownerID int64, recipientID int64) error {
-	// First, verify ownership of the file.
-	_, err := s.queries.GetUserFileForDownload(ctx, db.GetUserFileForDownloadParams{ID: fileID, OwnerID: ownerID})
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return fmt.Errorf("file not found or access denied")
-		}
-		return fmt.Errorf("failed to verify file ownership: %w", err)
+func (s *Service) UnshareFileWithUser(ctx context.Context, fileID, userID int64, recipientID int64) error {
+	// First, verify the user can manage this file's shares.
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleCoOwner); err != nil {
+		return err
 	}
 
 	// --- THIS IS THE FIX ---
 	// 1. Perform the database action first.
-	err = s.queries.UnshareFileWithUser(ctx, db.UnshareFileWithUserParams{
+	err := s.queries.UnshareFileWithUser(ctx, db.UnshareFileWithUserParams{
 		UserFileID:       fileID,
 		SharedWithUserID: recipientID,
 	})
@@ -210,7 +691,7 @@ func (s *Service) UnshareFileWithUser(ctx context.Context, fileID, ownerID int64
 	}
 
 	// 2. Only if the action is successful, create the audit log.
-	s.auditService.LogActivity(ctx, ownerID, "share:revoke_user", map[string]interface{}{
+	s.auditService.LogActivity(ctx, userID, "share:revoke_user", map[string]interface{}{
 		"file_id":                 fileID,
 		"unshared_from_user_id": recipientID,
 	})
@@ -219,24 +700,22 @@ func (s *Service) UnshareFileWithUser(ctx context.Context, fileID, ownerID int64
 	return nil
 }
 
-func (s *Service) GetSharesForFile(ctx context.Context, fileID, ownerID int64) ([]db.GetSharesForFileRow, error) {
-	// First, verify ownership.
-	_, err := s.queries.GetUserFileForDownload(ctx, db.GetUserFileForDownloadParams{ID: fileID, OwnerID: ownerID})
-	if err != nil {
-		return nil, fmt.Errorf("file not found or access denied")
+func (s *Service) GetSharesForFile(ctx context.Context, fileID, userID int64) ([]db.GetSharesForFileRow, error) {
+	// First, verify the user can manage this file's shares.
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleCoOwner); err != nil {
+		return nil, err
 	}
 	return s.queries.GetSharesForFile(ctx, fileID)
 }
 
 // GetPublicShareInfo gets public share information for a file
-func (s *Service) GetPublicShareInfo(ctx context.Context, fileID, ownerID int64) (*db.GetPublicShareByFileIDRow, error) {
-	// First, verify ownership
-	_, err := s.queries.GetUserFileForDownload(ctx, db.GetUserFileForDownloadParams{ID: fileID, OwnerID: ownerID})
-	if err != nil {
-		return nil, fmt.Errorf("file not found or access denied")
+func (s *Service) GetPublicShareInfo(ctx context.Context, fileID, userID int64) (*db.GetPublicShareByFileIDRow, error) {
+	// First, verify the user can manage this file's shares.
+	if _, err := s.requireFileRole(ctx, fileID, userID, auth.ShareRoleCoOwner); err != nil {
+		return nil, err
 	}
 	
-	publicShare, err := s.queries.GetPublicShareByFileID(ctx, fileID)
+	publicShare, err := s.queries.GetPublicShareByFileID(ctx, pgtype.Int8{Int64: fileID, Valid: true})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, fmt.Errorf("no public share found")
@@ -245,4 +724,208 @@ func (s *Service) GetPublicShareInfo(ctx context.Context, fileID, ownerID int64)
 	}
 	
 	return &publicShare, nil
-}
\ No newline at end of file
+}
+
+// ShareFolderWithUser gives a user read access to a folder and everything below it,
+// including files and subfolders added after the share was created.
+// The owner and co-owners may share, and re-sharing changes the role of an existing share.
+func (s *Service) ShareFolderWithUser(ctx context.Context, folderID, userID int64, recipientEmail string, role auth.ShareRole) error {
+	access, err := s.requireFolderRole(ctx, folderID, userID, auth.ShareRoleCoOwner)
+	if err != nil {
+		return err
+	}
+
+	recipient, err := s.queries.GetUserByEmail(ctx, recipientEmail)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("recipient user with email '%s' not found", recipientEmail)
+		}
+		return fmt.Errorf("failed to find recipient user: %w", err)
+	}
+
+	if userID == recipient.ID {
+		return fmt.Errorf("cannot share a folder with yourself")
+	}
+	if access.OwnerID == recipient.ID {
+		return fmt.Errorf("cannot share a folder with its owner")
+	}
+	if err := s.checkVerified(ctx, userID, recipient); err != nil {
+		return err
+	}
+
+	err = s.queries.ShareFolderWithUser(ctx, db.ShareFolderWithUserParams{
+		FolderID:         folderID,
+		SharedWithUserID: recipient.ID,
+		Role:             string(role),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create share record: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "share:create_folder_user", map[string]interface{}{
+		"folder_id": folderID,
+		"shared_with_user_id": recipient.ID,
+		"shared_with_email": recipientEmail,
+		"role": role,
+	})
+
+	return nil
+}
+
+// UnshareFolderWithUser removes a specific user's access to a shared folder.
+func (s *Service) UnshareFolderWithUser(ctx context.Context, folderID, userID int64, recipientID int64) error {
+	if _, err := s.requireFolderRole(ctx, folderID, userID, auth.ShareRoleCoOwner); err != nil {
+		return err
+	}
+
+	err := s.queries.UnshareFolderWithUser(ctx, db.UnshareFolderWithUserParams{
+		FolderID:         folderID,
+		SharedWithUserID: recipientID,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.auditService.LogActivity(ctx, userID, "share:revoke_folder_user", map[string]interface{}{
+		"folder_id": folderID,
+		"unshared_from_user_id": recipientID,
+	})
+
+	return nil
+}
+
+// GetSharesForFolder lists the users a folder has been shared with.
+func (s *Service) GetSharesForFolder(ctx context.Context, folderID, userID int64) ([]db.GetSharesForFolderRow, error) {
+	if _, err := s.requireFolderRole(ctx, folderID, userID, auth.ShareRoleCoOwner); err != nil {
+		return nil, err
+	}
+	return s.queries.GetSharesForFolder(ctx, folderID)
+}
+
+// ListFoldersSharedWithMe retrieves the folders that have been shared with a user.
+func (s *Service) ListFoldersSharedWithMe(ctx context.Context, userID int64) ([]db.Folder, error) {
+	return s.queries.ListFoldersSharedWithUser(ctx, userID)
+}
+
+// CreatePublicFolderLink creates a public link that downloads a folder's subtree as a ZIP archive.
+func (s *Service) CreatePublicFolderLink(ctx context.Context, folderID, userID int64, settings LinkSettings) (*db.Share, error) {
+	if _, err := s.requireFolderRole(ctx, folderID, userID, auth.ShareRoleCoOwner); err != nil {
+		return nil, err
+	}
+
+	restrictions, err := s.apply(settings, linkRestrictions{})
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateShareToken(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %w", err)
+	}
+
+	share, err := s.queries.CreatePublicFolderShareLink(ctx, db.CreatePublicFolderShareLinkParams{
+		FolderID:     pgtype.Int8{Int64: folderID, Valid: true},
+		ShareToken:   token,
+		ExpiresAt:    restrictions.ExpiresAt,
+		MaxDownloads: restrictions.MaxDownloads,
+		PasswordHash: restrictions.PasswordHash,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share link in database: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "share:create_folder_public", map[string]interface{}{
+		"folder_id": folderID,
+		"share_token": token,
+		"expires_at": expiryDetail(restrictions.ExpiresAt),
+		"max_downloads": restrictions.MaxDownloads.Int64,
+		"password_protected": restrictions.PasswordHash.Valid,
+	})
+
+	return &share, nil
+}
+
+// RevokePublicFolderLinks deletes every public link to a folder.
+func (s *Service) RevokePublicFolderLinks(ctx context.Context, folderID, userID int64) error {
+	if _, err := s.requireFolderRole(ctx, folderID, userID, auth.ShareRoleCoOwner); err != nil {
+		return err
+	}
+
+	if err := s.queries.DeletePublicShareLinksByFolderID(ctx, pgtype.Int8{Int64: folderID, Valid: true}); err != nil {
+		return err
+	}
+
+	s.auditService.LogActivity(ctx, userID, "share:revoke_folder_public", map[string]interface{}{
+		"folder_id": folderID,
+	})
+
+	return nil
+}
+
+// GetPublicFolderShareInfo gets public share information for a folder.
+func (s *Service) GetPublicFolderShareInfo(ctx context.Context, folderID, userID int64) (*db.GetPublicShareByFolderIDRow, error) {
+	if _, err := s.requireFolderRole(ctx, folderID, userID, auth.ShareRoleCoOwner); err != nil {
+		return nil, err
+	}
+
+	publicShare, err := s.queries.GetPublicShareByFolderID(ctx, pgtype.Int8{Int64: folderID, Valid: true})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("no public share found")
+		}
+		return nil, err
+	}
+
+	return &publicShare, nil
+}
+
+// UpdatePublicLink changes the expiry, download limit or password of an existing public link.
+// The owner and co-owners of the linked file or folder may do this.
+func (s *Service) UpdatePublicLink(ctx context.Context, token string, userID int64, settings LinkSettings) (*db.Share, error) {
+	current, err := s.queries.GetShareSettingsByToken(ctx, token)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrShareNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve share link: %w", err)
+	}
+	if current.FolderID.Valid {
+		_, err = s.requireFolderRole(ctx, current.FolderID.Int64, userID, auth.ShareRoleCoOwner)
+	} else {
+		_, err = s.requireFileRole(ctx, current.UserFileID.Int64, userID, auth.ShareRoleCoOwner)
+	}
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrFolderNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+
+	restrictions, err := s.apply(settings, linkRestrictions{
+		ExpiresAt:    current.ExpiresAt,
+		MaxDownloads: current.MaxDownloads,
+		PasswordHash: current.PasswordHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	share, err := s.queries.UpdateShareSettings(ctx, db.UpdateShareSettingsParams{
+		ID:           current.ID,
+		ExpiresAt:    restrictions.ExpiresAt,
+		MaxDownloads: restrictions.MaxDownloads,
+		PasswordHash: restrictions.PasswordHash,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update share link: %w", err)
+	}
+
+	s.auditService.LogActivity(ctx, userID, "share:update_public", map[string]interface{}{
+		"share_id": share.ID,
+		"expires_at": expiryDetail(restrictions.ExpiresAt),
+		"max_downloads": restrictions.MaxDownloads.Int64,
+		"password_protected": restrictions.PasswordHash.Valid,
+	})
+
+	return &share, nil
+}
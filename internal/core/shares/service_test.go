@@ -0,0 +1,169 @@
+package shares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestContinuationRange(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		name      string
+		rng       string
+		ifRange   string
+		wantStart int64
+		wantEnd   int64
+		wantOK    bool
+	}{
+		{"full download", "", "", 0, 0, false},
+		{"range from start", "bytes=0-1023", "", 0, 0, false},
+		{"resume", "bytes=1024-", "", 1024, 4096, true},
+		{"seek", "bytes=1024-2047", "", 1024, 2048, true},
+		{"range past the end", "bytes=1024-9999", "", 1024, 4096, true},
+		{"start past the end", "bytes=5000-", "", 5000, 5000, true},
+		{"suffix range", "bytes=-500", "", 0, 0, false},
+		{"several ranges", "bytes=100-199, 500-599", "", 0, 0, false},
+		{"matching If-Range", "bytes=1024-", etag, 1024, 4096, true},
+		{"other If-Range", "bytes=1024-", `"xyz"`, 0, 0, false},
+		{"If-Range date", "bytes=1024-", "Mon, 02 Jan 2026 15:04:05 GMT", 0, 0, false},
+		{"other unit", "items=10-20", "", 0, 0, false},
+		{"malformed", "bytes=abc-", "", 0, 0, false},
+		{"end before start", "bytes=2000-1000", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := ContinuationRange(tt.rng, tt.ifRange, etag, 4096)
+			if start != tt.wantStart || end != tt.wantEnd || ok != tt.wantOK {
+				t.Errorf("ContinuationRange(%q, %q) = (%d, %d, %v), want (%d, %d, %v)",
+					tt.rng, tt.ifRange, start, end, ok, tt.wantStart, tt.wantEnd, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDownloadGrantID(t *testing.T) {
+	s := &Service{grantSecret: []byte("test-secret")}
+	sign := func(secret string, claims jwt.MapClaims) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	claims := func(purpose string, grantID int64, exp time.Time) jwt.MapClaims {
+		return jwt.MapClaims{"purpose": purpose, "grant_id": grantID, "share_id": 42, "exp": exp.Unix()}
+	}
+	later := time.Now().Add(time.Hour)
+	grant := sign("test-secret", claims("share_download", 7, later))
+
+	tests := []struct {
+		name    string
+		grant   string
+		shareID int64
+		wantID  int64
+		wantOK  bool
+	}{
+		{"same share", grant, 42, 7, true},
+		{"other share", grant, 43, 0, false},
+		{"no grant", "", 42, 0, false},
+		{"tampered", grant[:len(grant)-2] + "xx", 42, 0, false},
+		{"other secret", sign("other-secret", claims("share_download", 7, later)), 42, 0, false},
+		{"other purpose", sign("test-secret", claims("email_verification", 7, later)), 42, 0, false},
+		{"expired", sign("test-secret", claims("share_download", 7, time.Now().Add(-time.Minute))), 42, 0, false},
+		{"without grant ID", sign("test-secret", jwt.MapClaims{"purpose": "share_download", "share_id": 42, "exp": later.Unix()}), 42, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := s.downloadGrantID(tt.grant, tt.shareID)
+			if id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("downloadGrantID = (%d, %v), want (%d, %v)", id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+// nopCloser makes a bytes.Reader a storage.Object.
+type nopCloser struct{ *bytes.Reader }
+
+func (nopCloser) Close() error { return nil }
+
+func TestGrantedObjectRecordsServedRange(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	tests := []struct {
+		name       string
+		rng        string
+		served     int64
+		wantServed int64
+	}{
+		{"full download", "", 0, 10000},
+		{"resume", "bytes=4000-", 3900, 10000},
+		{"seek", "bytes=4000-4999", 3900, 5000},
+		{"range within what was served", "bytes=100-199", 3900, 3900},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotServed, gotReserved int64
+			object := &grantedObject{
+				Object:   nopCloser{bytes.NewReader(content)},
+				served:   tt.served,
+				reserved: 10000,
+				settle:   func(served, reserved int64) { gotServed, gotReserved = served, reserved },
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.rng != "" {
+				req.Header.Set("Range", tt.rng)
+			}
+			http.ServeContent(httptest.NewRecorder(), req, "", time.Time{}, object)
+			if err := object.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if gotServed != tt.wantServed || gotReserved != 10000 {
+				t.Errorf("settled (%d, %d), want (%d, 10000)", gotServed, gotReserved, tt.wantServed)
+			}
+		})
+	}
+}
+
+func TestGrantedObjectRecordsInterruptedDownload(t *testing.T) {
+	var gotServed int64
+	object := &grantedObject{
+		Object: nopCloser{bytes.NewReader(make([]byte, 10000))},
+		settle: func(served, _ int64) { gotServed = served },
+	}
+	if _, err := io.CopyN(io.Discard, object, 2500); err != nil {
+		t.Fatal(err)
+	}
+	object.Close()
+	if gotServed != 2500 {
+		t.Errorf("settled %d, want 2500", gotServed)
+	}
+}
+
+func TestETagListMatches(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"abc"`, false, true},
+		{`"xyz", "abc"`, false, true},
+		{`*`, false, true},
+		{`"xyz"`, false, false},
+		{`W/"abc"`, false, false},
+		{`W/"abc"`, true, true},
+		{`W/"xyz", *`, true, true},
+		{`abc`, true, false},
+	}
+	for _, tt := range tests {
+		if got := etagListMatches(tt.header, etag, tt.weak); got != tt.want {
+			t.Errorf("etagListMatches(%q, weak=%v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/karanbihani/file-vault/internal/core/files"
+	"github.com/karanbihani/file-vault/internal/db"
+	"github.com/karanbihani/file-vault/internal/storage"
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrOffsetMismatch   = errors.New("upload offset does not match the current offset")
+	ErrUploadTooLarge   = errors.New("chunk exceeds the declared upload length")
+	ErrUploadCompleted  = errors.New("upload is already complete")
+	ErrUploadExpired    = errors.New("upload has expired")
+	ErrUploadAssembling = errors.New("upload is being assembled; try again later")
+)
+
+// assemblyLease is how long a request may take to assemble a finished upload before another
+// request is allowed to try again, e.g. after the first one's server stopped.
+const assemblyLease = time.Hour
+
+// Service implements resumable uploads. Each chunk is written to object storage as its own
+// object under 'uploads/<id>/', and the confirmed offset and the chunks that make it up are
+// tracked in Postgres. Once the last byte arrives the chunks are streamed, in order, through
+// files.Service.UploadFile so the result goes through exactly the same dedup and quota logic
+// as a normal upload.
+type Service struct {
+	queries     *db.Queries
+	storage     storage.Backend
+	fileService *files.Service
+	expiry      time.Duration
+}
+
+// NewService creates a new uploads service. Incomplete uploads expire after expiry of inactivity.
+func NewService(queries *db.Queries, storageBackend storage.Backend, fileService *files.Service, expiry time.Duration) *Service {
+	return &Service{
+		queries:     queries,
+		storage:     storageBackend,
+		fileService: fileService,
+		expiry:      expiry,
+	}
+}
+
+// generateUploadID creates a cryptographically secure, random upload identifier.
+func generateUploadID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// chunkPrefix is the object key prefix under which an upload's chunks are stored.
+func chunkPrefix(uploadID string) string {
+	return "uploads/" + uploadID + "/"
+}
+
+// chunkKey names a chunk by its starting offset, followed by a random suffix so that two
+// requests for the same offset never write the same object. The offset is zero-padded so
+// that a lexical listing of the prefix returns chunks in upload order.
+func chunkKey(uploadID string, offset int64) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%020d-%s", chunkPrefix(uploadID), offset, hex.EncodeToString(suffix)), nil
+}
+
+type CreateUploadParams struct {
+	OwnerID     int64
+	Length      int64
+	Filename    string
+	ContentType string
+	Description string
+	Tags        []string
+}
+
+// CreateUpload registers a new resumable upload after checking the declared length against the user's quota.
+func (s *Service) CreateUpload(ctx context.Context, params CreateUploadParams) (*db.Upload, error) {
+	user, err := s.queries.GetUserByID(ctx, params.OwnerID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve user for quota check: %w", err)
+	}
+	if user.StorageUsedBytes+params.Length > user.StorageQuotaBytes {
+		return nil, files.ErrQuotaExceeded
+	}
+
+	id, err := generateUploadID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+
+	upload, err := s.queries.CreateUpload(ctx, db.CreateUploadParams{
+		ID:           id,
+		OwnerID:      params.OwnerID,
+		Filename:     params.Filename,
+		MimeType:     params.ContentType,
+		Description:  pgtype.Text{String: params.Description, Valid: params.Description != ""},
+		Tags:         params.Tags,
+		UploadLength: params.Length,
+		ExpiresAt:    pgtype.Timestamptz{Time: time.Now().Add(s.expiry), Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	return &upload, nil
+}
+
+// GetUpload retrieves an upload owned by ownerID. An incomplete upload whose expiry has passed
+// is gone as far as the client is concerned, even before the expiry worker removes it.
+func (s *Service) GetUpload(ctx context.Context, uploadID string, ownerID int64) (*db.Upload, error) {
+	upload, err := s.getUpload(ctx, uploadID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !upload.CompletedAt.Valid && !upload.ExpiresAt.Time.After(time.Now()) {
+		return nil, ErrUploadExpired
+	}
+	return upload, nil
+}
+
+func (s *Service) getUpload(ctx context.Context, uploadID string, ownerID int64) (*db.Upload, error) {
+	upload, err := s.queries.GetUploadForOwner(ctx, db.GetUploadForOwnerParams{ID: uploadID, OwnerID: ownerID})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve upload: %w", err)
+	}
+	return &upload, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteChunk stores data as the chunk starting at offset and advances the upload.
+// When the chunk completes the upload, the file is assembled and the returned upload has UserFileID set.
+// If assembling failed before, a request at the final offset tries again.
+func (s *Service) WriteChunk(ctx context.Context, uploadID string, ownerID int64, offset int64, data io.Reader) (*db.Upload, error) {
+	upload, err := s.GetUpload(ctx, uploadID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.CompletedAt.Valid {
+		return nil, ErrUploadCompleted
+	}
+	if offset != upload.UploadOffset {
+		return nil, ErrOffsetMismatch
+	}
+
+	var extra [1]byte
+	if upload.UploadOffset == upload.UploadLength {
+		if n, _ := data.Read(extra[:]); n > 0 {
+			return nil, ErrUploadTooLarge
+		}
+		return s.finish(ctx, upload)
+	}
+
+	// Never read past the declared length; anything beyond it is rejected below.
+	remaining := upload.UploadLength - upload.UploadOffset
+	counter := &countingReader{r: io.LimitReader(data, remaining)}
+	key, err := chunkKey(uploadID, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to name upload chunk: %w", err)
+	}
+	if err := s.storage.Save(ctx, key, counter, -1, "application/octet-stream"); err != nil {
+		return nil, fmt.Errorf("failed to store upload chunk: %w", err)
+	}
+
+	if n, _ := data.Read(extra[:]); n > 0 {
+		s.storage.Delete(ctx, key)
+		return nil, ErrUploadTooLarge
+	}
+
+	if counter.n == 0 {
+		s.storage.Delete(ctx, key)
+		return upload, nil
+	}
+
+	expiresAt := time.Now().Add(s.expiry)
+	updated, err := s.queries.AdvanceUploadOffset(ctx, db.AdvanceUploadOffsetParams{
+		ChunkSize:      counter.n,
+		ChunkKey:       key,
+		ExpiresAt:      pgtype.Timestamptz{Time: expiresAt, Valid: true},
+		ID:             uploadID,
+		ExpectedOffset: offset,
+	})
+	if err != nil {
+		s.storage.Delete(ctx, key)
+		return nil, fmt.Errorf("failed to advance upload offset: %w", err)
+	}
+	if updated == 0 {
+		// A concurrent request for the same offset won the race, or the upload expired.
+		// Only this request's own chunk is removed.
+		s.storage.Delete(ctx, key)
+		return nil, ErrOffsetMismatch
+	}
+
+	upload.UploadOffset += counter.n
+	upload.ChunkKeys = append(upload.ChunkKeys, key)
+	upload.ExpiresAt = pgtype.Timestamptz{Time: expiresAt, Valid: true}
+
+	if upload.UploadOffset == upload.UploadLength {
+		return s.finish(ctx, upload)
+	}
+	return upload, nil
+}
+
+// finish assembles a fully received upload, unless another request is already doing so. If
+// assembling fails, the claim is given up so that the next request can retry.
+func (s *Service) finish(ctx context.Context, upload *db.Upload) (*db.Upload, error) {
+	claimed, err := s.queries.ClaimUploadAssembly(ctx, db.ClaimUploadAssemblyParams{
+		ID:          upload.ID,
+		StaleBefore: pgtype.Timestamptz{Time: time.Now().Add(-assemblyLease), Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim upload for assembly: %w", err)
+	}
+	if claimed == 0 {
+		return nil, ErrUploadAssembling
+	}
+
+	userFile, err := s.assemble(ctx, upload)
+	if err != nil {
+		// The request's context may be what failed, so the claim is released without it.
+		if err := s.queries.ReleaseUploadAssembly(context.Background(), upload.ID); err != nil {
+			log.Printf("ERROR: failed to release assembly of upload %s: %v", upload.ID, err)
+		}
+		return nil, err
+	}
+	upload.UserFileID = pgtype.Int8{Int64: userFile.ID, Valid: true}
+	upload.CompletedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	return upload, nil
+}
+
+// assemble streams every chunk of a finished upload through the regular upload path,
+// records the resulting file on the upload and removes the chunk objects.
+func (s *Service) assemble(ctx context.Context, upload *db.Upload) (*db.UserFile, error) {
+	keys, err := s.legacyChunkKeys(ctx, upload.ID)
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, upload.ChunkKeys...)
+
+	reader := &chunkReader{ctx: ctx, storage: s.storage, keys: keys}
+	defer reader.Close()
+
+	userFile, err := s.fileService.UploadFile(ctx, files.UploadFileParams{
+		File:         reader,
+		Filename:     upload.Filename,
+		ContentType:  upload.MimeType,
+		DeclaredSize: upload.UploadLength,
+		OwnerID:      upload.OwnerID,
+		Description:  upload.Description.String,
+		Tags:         upload.Tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.queries.CompleteUpload(ctx, db.CompleteUploadParams{
+		ID:         upload.ID,
+		UserFileID: pgtype.Int8{Int64: userFile.ID, Valid: true},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to mark upload as complete: %w", err)
+	}
+
+	s.deleteChunks(ctx, upload.ID)
+	return userFile, nil
+}
+
+// legacyChunkKeys lists the chunks an upload received before chunk keys were recorded. They
+// are named by offset alone, and come before every recorded chunk.
+func (s *Service) legacyChunkKeys(ctx context.Context, uploadID string) ([]string, error) {
+	chunks, err := s.storage.List(ctx, chunkPrefix(uploadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upload chunks: %w", err)
+	}
+	var keys []string
+	for _, chunk := range chunks {
+		if !strings.Contains(strings.TrimPrefix(chunk.Key, chunkPrefix(uploadID)), "-") {
+			keys = append(keys, chunk.Key)
+		}
+	}
+	return keys, nil
+}
+
+// chunkReader presents a list of chunk objects as one continuous stream,
+// opening each chunk only when the previous one is exhausted.
+type chunkReader struct {
+	ctx     context.Context
+	storage storage.Backend
+	keys    []string
+	current storage.Object
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			object, err := r.storage.Get(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, fmt.Errorf("failed to open upload chunk: %w", err)
+			}
+			r.current = object
+			r.keys = r.keys[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+// deleteChunks removes every chunk object belonging to an upload.
+func (s *Service) deleteChunks(ctx context.Context, uploadID string) {
+	chunks, err := s.storage.List(ctx, chunkPrefix(uploadID))
+	if err != nil {
+		log.Printf("ERROR: failed to list chunks of upload %s: %v", uploadID, err)
+		return
+	}
+	for _, chunk := range chunks {
+		if err := s.storage.Delete(ctx, chunk.Key); err != nil {
+			log.Printf("ERROR: failed to delete chunk %s: %v", chunk.Key, err)
+		}
+	}
+}
+
+// TerminateUpload discards an upload and any chunks received so far.
+func (s *Service) TerminateUpload(ctx context.Context, uploadID string, ownerID int64) error {
+	if _, err := s.getUpload(ctx, uploadID, ownerID); err != nil {
+		return err
+	}
+	s.deleteChunks(ctx, uploadID)
+	if err := s.queries.DeleteUpload(ctx, uploadID); err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	return nil
+}
+
+// TerminateUserUploads discards every upload a user has in progress and returns how many
+// there were. It is used when the user's account is deleted.
+func (s *Service) TerminateUserUploads(ctx context.Context, ownerID int64) (int, error) {
+	uploads, err := s.queries.ListUserUploads(ctx, ownerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list uploads: %w", err)
+	}
+	for _, upload := range uploads {
+		s.deleteChunks(ctx, upload.ID)
+		if err := s.queries.DeleteUpload(ctx, upload.ID); err != nil {
+			return 0, fmt.Errorf("failed to delete upload %s: %w", upload.ID, err)
+		}
+	}
+	return len(uploads), nil
+}
+
+// PurgeExpired removes every expired upload along with its chunks and returns how many were removed.
+func (s *Service) PurgeExpired(ctx context.Context) (int, error) {
+	expired, err := s.queries.ListExpiredUploads(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired uploads: %w", err)
+	}
+	for _, upload := range expired {
+		s.deleteChunks(ctx, upload.ID)
+		if err := s.queries.DeleteUpload(ctx, upload.ID); err != nil {
+			return 0, fmt.Errorf("failed to delete expired upload %s: %w", upload.ID, err)
+		}
+	}
+	return len(expired), nil
+}
+
+// StartExpiryWorker starts a background goroutine that purges expired uploads every interval.
+func (s *Service) StartExpiryWorker(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			purged, err := s.PurgeExpired(context.Background())
+			if err != nil {
+				log.Printf("ERROR: failed to purge expired uploads: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired uploads", purged)
+			}
+		}
+	}()
+}
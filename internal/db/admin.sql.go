@@ -14,8 +14,10 @@ import (
 const getFileMetadataByID = `-- name: GetFileMetadataByID :one
 SELECT
     uf.filename,
+    uf.mime_type,
     pf.storage_path,
-    pf.size_bytes
+    pf.size_bytes,
+    pf.sha256_hash
 FROM user_files uf
 JOIN physical_files pf ON uf.physical_file_id = pf.id
 WHERE uf.id = $1
@@ -23,15 +25,23 @@ WHERE uf.id = $1
 
 type GetFileMetadataByIDRow struct {
 	Filename    string
+	MimeType    string
 	StoragePath string
 	SizeBytes   int64
+	Sha256Hash  string
 }
 
 // For admin use: retrieves file metadata without any ownership checks.
 func (q *Queries) GetFileMetadataByID(ctx context.Context, id int64) (GetFileMetadataByIDRow, error) {
 	row := q.db.QueryRow(ctx, getFileMetadataByID, id)
 	var i GetFileMetadataByIDRow
-	err := row.Scan(&i.Filename, &i.StoragePath, &i.SizeBytes)
+	err := row.Scan(
+		&i.Filename,
+		&i.MimeType,
+		&i.StoragePath,
+		&i.SizeBytes,
+		&i.Sha256Hash,
+	)
 	return i, err
 }
 
@@ -64,19 +74,92 @@ func (q *Queries) GetSystemStats(ctx context.Context) (GetSystemStatsRow, error)
 }
 
 const listAllFiles = `-- name: ListAllFiles :many
-SELECT
-    uf.id,
-    uf.filename,
-    uf.mime_type,
-    uf.upload_date,
-    pf.size_bytes,
-    u.email as owner_email
-FROM user_files uf
-JOIN users u ON uf.owner_id = u.id
-JOIN physical_files pf ON uf.physical_file_id = pf.id
-ORDER BY uf.upload_date DESC
+WITH files AS NOT MATERIALIZED (
+    SELECT
+        uf.id,
+        uf.filename,
+        uf.mime_type,
+        uf.upload_date,
+        pf.size_bytes,
+        u.email as owner_email
+    FROM user_files uf
+    JOIN users u ON uf.owner_id = u.id
+    JOIN physical_files pf ON uf.physical_file_id = pf.id
+)
+SELECT id, filename, mime_type, upload_date, size_bytes, owner_email
+FROM (
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'name' AND NOT $2::boolean
+       AND ($3::bigint IS NULL OR (filename, id) > ($4::text, $3))
+     ORDER BY filename, id
+     LIMIT $5)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'name' AND $2::boolean
+       AND ($3::bigint IS NULL OR (filename, id) < ($4::text, $3))
+     ORDER BY filename DESC, id DESC
+     LIMIT $5)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'mime' AND NOT $2::boolean
+       AND ($3::bigint IS NULL OR (mime_type, id) > ($4::text, $3))
+     ORDER BY mime_type, id
+     LIMIT $5)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'mime' AND $2::boolean
+       AND ($3::bigint IS NULL OR (mime_type, id) < ($4::text, $3))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT $5)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'size' AND NOT $2::boolean
+       AND ($3::bigint IS NULL OR (size_bytes, id) > ($6::bigint, $3))
+     ORDER BY size_bytes, id
+     LIMIT $5)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'size' AND $2::boolean
+       AND ($3::bigint IS NULL OR (size_bytes, id) < ($6::bigint, $3))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT $5)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'upload_date' AND NOT $2::boolean
+       AND ($3::bigint IS NULL OR (upload_date, id) > ($7::timestamptz, $3))
+     ORDER BY upload_date, id
+     LIMIT $5)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email FROM files
+     WHERE $1::text = 'upload_date' AND $2::boolean
+       AND ($3::bigint IS NULL OR (upload_date, id) < ($7::timestamptz, $3))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT $5)
+) page
+-- Sorts only the page read by the branch that ran.
+ORDER BY
+    CASE WHEN $1::text = 'name' AND NOT $2::boolean THEN filename END ASC,
+    CASE WHEN $1::text = 'name' AND $2::boolean THEN filename END DESC,
+    CASE WHEN $1::text = 'mime' AND NOT $2::boolean THEN mime_type END ASC,
+    CASE WHEN $1::text = 'mime' AND $2::boolean THEN mime_type END DESC,
+    CASE WHEN $1::text = 'size' AND NOT $2::boolean THEN size_bytes END ASC,
+    CASE WHEN $1::text = 'size' AND $2::boolean THEN size_bytes END DESC,
+    CASE WHEN $1::text = 'upload_date' AND NOT $2::boolean THEN upload_date END ASC,
+    CASE WHEN $1::text = 'upload_date' AND $2::boolean THEN upload_date END DESC,
+    CASE WHEN NOT $2::boolean THEN id END ASC,
+    CASE WHEN $2::boolean THEN id END DESC
 `
 
+type ListAllFilesParams struct {
+	SortKey    string
+	Descending bool
+	CursorID   pgtype.Int8
+	CursorText pgtype.Text
+	PageLimit  int32
+	CursorInt  pgtype.Int8
+	CursorTime pgtype.Timestamptz
+}
+
 type ListAllFilesRow struct {
 	ID         int64
 	Filename   string
@@ -86,9 +169,18 @@ type ListAllFilesRow struct {
 	OwnerEmail string
 }
 
-// For admin use: retrieves all files with uploader's email.
-func (q *Queries) ListAllFiles(ctx context.Context) ([]ListAllFilesRow, error) {
-	rows, err := q.db.Query(ctx, listAllFiles)
+// For admin use: retrieves a page of all files with uploader's email, paged like ListUserFiles
+// from the idx_user_files_* indexes.
+func (q *Queries) ListAllFiles(ctx context.Context, arg ListAllFilesParams) ([]ListAllFilesRow, error) {
+	rows, err := q.db.Query(ctx, listAllFiles,
+		arg.SortKey,
+		arg.Descending,
+		arg.CursorID,
+		arg.CursorText,
+		arg.PageLimit,
+		arg.CursorInt,
+		arg.CursorTime,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -11,31 +11,487 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const createAuditLog = `-- name: CreateAuditLog :exec
-INSERT INTO audit_logs (user_id, action, details)
-VALUES ($1, $2, $3)
+const createAuditArchiveSegment = `-- name: CreateAuditArchiveSegment :one
+INSERT INTO audit_archive_segments (
+    object_key, first_entry_id, last_entry_id, first_timestamp, last_timestamp, entry_count,
+    size_bytes, sha256_hash, first_prev_hash, last_hash, signature, created_at
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+RETURNING id, object_key, first_entry_id, last_entry_id, first_timestamp, last_timestamp, entry_count, size_bytes, sha256_hash, first_prev_hash, last_hash, signature, created_at
+`
+
+type CreateAuditArchiveSegmentParams struct {
+	ObjectKey      string
+	FirstEntryID   int64
+	LastEntryID    int64
+	FirstTimestamp pgtype.Timestamptz
+	LastTimestamp  pgtype.Timestamptz
+	EntryCount     int64
+	SizeBytes      int64
+	Sha256Hash     string
+	FirstPrevHash  pgtype.Text
+	LastHash       pgtype.Text
+	Signature      string
+	CreatedAt      pgtype.Timestamptz
+}
+
+func (q *Queries) CreateAuditArchiveSegment(ctx context.Context, arg CreateAuditArchiveSegmentParams) (AuditArchiveSegment, error) {
+	row := q.db.QueryRow(ctx, createAuditArchiveSegment,
+		arg.ObjectKey,
+		arg.FirstEntryID,
+		arg.LastEntryID,
+		arg.FirstTimestamp,
+		arg.LastTimestamp,
+		arg.EntryCount,
+		arg.SizeBytes,
+		arg.Sha256Hash,
+		arg.FirstPrevHash,
+		arg.LastHash,
+		arg.Signature,
+		arg.CreatedAt,
+	)
+	var i AuditArchiveSegment
+	err := row.Scan(
+		&i.ID,
+		&i.ObjectKey,
+		&i.FirstEntryID,
+		&i.LastEntryID,
+		&i.FirstTimestamp,
+		&i.LastTimestamp,
+		&i.EntryCount,
+		&i.SizeBytes,
+		&i.Sha256Hash,
+		&i.FirstPrevHash,
+		&i.LastHash,
+		&i.Signature,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createAuditCheckpoint = `-- name: CreateAuditCheckpoint :one
+INSERT INTO audit_checkpoints (last_entry_id, last_hash, signature, created_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id, last_entry_id, last_hash, signature, created_at
+`
+
+type CreateAuditCheckpointParams struct {
+	LastEntryID int64
+	LastHash    string
+	Signature   string
+	CreatedAt   pgtype.Timestamptz
+}
+
+func (q *Queries) CreateAuditCheckpoint(ctx context.Context, arg CreateAuditCheckpointParams) (AuditCheckpoint, error) {
+	row := q.db.QueryRow(ctx, createAuditCheckpoint,
+		arg.LastEntryID,
+		arg.LastHash,
+		arg.Signature,
+		arg.CreatedAt,
+	)
+	var i AuditCheckpoint
+	err := row.Scan(
+		&i.ID,
+		&i.LastEntryID,
+		&i.LastHash,
+		&i.Signature,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createAuditLog = `-- name: CreateAuditLog :one
+INSERT INTO audit_logs (user_id, action, details, prev_hash, ip_address, user_agent, request_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, user_id, action, details, timestamp, prev_hash, hash, ip_address, user_agent, request_id
 `
 
 type CreateAuditLogParams struct {
-	UserID  pgtype.Int8
-	Action  string
-	Details []byte
+	UserID    pgtype.Int8
+	Action    string
+	Details   []byte
+	PrevHash  pgtype.Text
+	IpAddress pgtype.Text
+	UserAgent pgtype.Text
+	RequestID pgtype.Text
 }
 
-// Inserts a new audit log entry.
-func (q *Queries) CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) error {
-	_, err := q.db.Exec(ctx, createAuditLog, arg.UserID, arg.Action, arg.Details)
-	return err
+// Inserts a new audit log entry. Its hash is set once the stored contents are known.
+func (q *Queries) CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) (AuditLog, error) {
+	row := q.db.QueryRow(ctx, createAuditLog,
+		arg.UserID,
+		arg.Action,
+		arg.Details,
+		arg.PrevHash,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.RequestID,
+	)
+	var i AuditLog
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Action,
+		&i.Details,
+		&i.Timestamp,
+		&i.PrevHash,
+		&i.Hash,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.RequestID,
+	)
+	return i, err
+}
+
+const deleteAuditLogRange = `-- name: DeleteAuditLogRange :execrows
+DELETE FROM audit_logs
+WHERE id BETWEEN $1 AND $2
+`
+
+type DeleteAuditLogRangeParams struct {
+	FirstEntryID int64
+	LastEntryID  int64
+}
+
+// Deletes the entries of a segment once it has been archived.
+func (q *Queries) DeleteAuditLogRange(ctx context.Context, arg DeleteAuditLogRangeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteAuditLogRange, arg.FirstEntryID, arg.LastEntryID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getAuditArchiveSegment = `-- name: GetAuditArchiveSegment :one
+SELECT id, object_key, first_entry_id, last_entry_id, first_timestamp, last_timestamp, entry_count, size_bytes, sha256_hash, first_prev_hash, last_hash, signature, created_at FROM audit_archive_segments
+WHERE id = $1
+`
+
+func (q *Queries) GetAuditArchiveSegment(ctx context.Context, id int64) (AuditArchiveSegment, error) {
+	row := q.db.QueryRow(ctx, getAuditArchiveSegment, id)
+	var i AuditArchiveSegment
+	err := row.Scan(
+		&i.ID,
+		&i.ObjectKey,
+		&i.FirstEntryID,
+		&i.LastEntryID,
+		&i.FirstTimestamp,
+		&i.LastTimestamp,
+		&i.EntryCount,
+		&i.SizeBytes,
+		&i.Sha256Hash,
+		&i.FirstPrevHash,
+		&i.LastHash,
+		&i.Signature,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getAuditChainHead = `-- name: GetAuditChainHead :one
+SELECT id, hash::text AS hash FROM audit_logs
+WHERE hash IS NOT NULL
+ORDER BY id DESC
+LIMIT 1
+`
+
+type GetAuditChainHeadRow struct {
+	ID   int64
+	Hash string
+}
+
+// Retrieves the newest entry in the hash chain.
+func (q *Queries) GetAuditChainHead(ctx context.Context) (GetAuditChainHeadRow, error) {
+	row := q.db.QueryRow(ctx, getAuditChainHead)
+	var i GetAuditChainHeadRow
+	err := row.Scan(&i.ID, &i.Hash)
+	return i, err
+}
+
+const getLatestAuditArchiveSegment = `-- name: GetLatestAuditArchiveSegment :one
+SELECT id, object_key, first_entry_id, last_entry_id, first_timestamp, last_timestamp, entry_count, size_bytes, sha256_hash, first_prev_hash, last_hash, signature, created_at FROM audit_archive_segments
+ORDER BY last_entry_id DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLatestAuditArchiveSegment(ctx context.Context) (AuditArchiveSegment, error) {
+	row := q.db.QueryRow(ctx, getLatestAuditArchiveSegment)
+	var i AuditArchiveSegment
+	err := row.Scan(
+		&i.ID,
+		&i.ObjectKey,
+		&i.FirstEntryID,
+		&i.LastEntryID,
+		&i.FirstTimestamp,
+		&i.LastTimestamp,
+		&i.EntryCount,
+		&i.SizeBytes,
+		&i.Sha256Hash,
+		&i.FirstPrevHash,
+		&i.LastHash,
+		&i.Signature,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getLatestAuditCheckpoint = `-- name: GetLatestAuditCheckpoint :one
+SELECT id, last_entry_id, last_hash, signature, created_at FROM audit_checkpoints ORDER BY id DESC LIMIT 1
+`
+
+func (q *Queries) GetLatestAuditCheckpoint(ctx context.Context) (AuditCheckpoint, error) {
+	row := q.db.QueryRow(ctx, getLatestAuditCheckpoint)
+	var i AuditCheckpoint
+	err := row.Scan(
+		&i.ID,
+		&i.LastEntryID,
+		&i.LastHash,
+		&i.Signature,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listAuditArchiveSegments = `-- name: ListAuditArchiveSegments :many
+SELECT id, object_key, first_entry_id, last_entry_id, first_timestamp, last_timestamp, entry_count, size_bytes, sha256_hash, first_prev_hash, last_hash, signature, created_at FROM audit_archive_segments
+WHERE
+    ($1::timestamptz IS NULL OR last_timestamp >= $1)
+    AND ($2::timestamptz IS NULL OR first_timestamp <= $2)
+ORDER BY first_entry_id
+`
+
+type ListAuditArchiveSegmentsParams struct {
+	StartDate pgtype.Timestamptz
+	EndDate   pgtype.Timestamptz
+}
+
+// Retrieves the archived segments with entries in the date range, oldest first.
+func (q *Queries) ListAuditArchiveSegments(ctx context.Context, arg ListAuditArchiveSegmentsParams) ([]AuditArchiveSegment, error) {
+	rows, err := q.db.Query(ctx, listAuditArchiveSegments, arg.StartDate, arg.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditArchiveSegment
+	for rows.Next() {
+		var i AuditArchiveSegment
+		if err := rows.Scan(
+			&i.ID,
+			&i.ObjectKey,
+			&i.FirstEntryID,
+			&i.LastEntryID,
+			&i.FirstTimestamp,
+			&i.LastTimestamp,
+			&i.EntryCount,
+			&i.SizeBytes,
+			&i.Sha256Hash,
+			&i.FirstPrevHash,
+			&i.LastHash,
+			&i.Signature,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditChain = `-- name: ListAuditChain :many
+SELECT id, user_id, action, details, timestamp, prev_hash, hash, ip_address, user_agent, request_id FROM audit_logs
+WHERE id > $1
+ORDER BY id
+LIMIT $2
+`
+
+type ListAuditChainParams struct {
+	AfterID   int64
+	BatchSize int32
+}
+
+// Retrieves audit log entries in chain order, starting after after_id.
+func (q *Queries) ListAuditChain(ctx context.Context, arg ListAuditChainParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listAuditChain, arg.AfterID, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Action,
+			&i.Details,
+			&i.Timestamp,
+			&i.PrevHash,
+			&i.Hash,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.RequestID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditCheckpoints = `-- name: ListAuditCheckpoints :many
+SELECT id, last_entry_id, last_hash, signature, created_at FROM audit_checkpoints ORDER BY id
+`
+
+func (q *Queries) ListAuditCheckpoints(ctx context.Context) ([]AuditCheckpoint, error) {
+	rows, err := q.db.Query(ctx, listAuditCheckpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditCheckpoint
+	for rows.Next() {
+		var i AuditCheckpoint
+		if err := rows.Scan(
+			&i.ID,
+			&i.LastEntryID,
+			&i.LastHash,
+			&i.Signature,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const listAuditLogs = `-- name: ListAuditLogs :many
-SELECT id, user_id, action, details, timestamp FROM audit_logs
-ORDER BY timestamp DESC
+SELECT al.id, al.user_id, al.action, al.details, al.timestamp, al.prev_hash, al.hash, al.ip_address, al.user_agent, al.request_id FROM audit_logs al
+WHERE
+    ($1::bigint IS NULL OR al.user_id = $1)
+    AND ($2::text IS NULL OR al.action = $2)
+    AND ($3::text IS NULL OR al.action LIKE $3 || '%')
+    -- Containment keeps the GIN index on details usable.
+    AND ($4::bigint IS NULL OR al.details @> jsonb_build_object('file_id', $4))
+    AND ($5::timestamptz IS NULL OR al.timestamp >= $5)
+    AND ($6::timestamptz IS NULL OR al.timestamp <= $6)
+    AND ($7::text IS NULL OR al.request_id = $7)
+    AND ($8::bigint IS NULL OR (al.timestamp, al.id) > ($9::timestamptz, $8))
+ORDER BY al.timestamp, al.id
+LIMIT $10
+`
+
+type ListAuditLogsParams struct {
+	UserID       pgtype.Int8
+	Action       pgtype.Text
+	ActionPrefix pgtype.Text
+	FileID       pgtype.Int8
+	StartDate    pgtype.Timestamptz
+	EndDate      pgtype.Timestamptz
+	RequestID    pgtype.Text
+	CursorID     pgtype.Int8
+	CursorTime   pgtype.Timestamptz
+	PageLimit    int32
+}
+
+// For admin use: retrieves a page of audit log entries sorted by timestamp, ties broken by ID,
+// starting after the cursor entry if there is one. Every filter is optional: the user, the exact
+// action or an action prefix (already escaped for LIKE), a file_id in details, and a time range.
+// The entries are read from idx_audit_logs_timestamp_id, oldest first.
+func (q *Queries) ListAuditLogs(ctx context.Context, arg ListAuditLogsParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listAuditLogs,
+		arg.UserID,
+		arg.Action,
+		arg.ActionPrefix,
+		arg.FileID,
+		arg.StartDate,
+		arg.EndDate,
+		arg.RequestID,
+		arg.CursorID,
+		arg.CursorTime,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Action,
+			&i.Details,
+			&i.Timestamp,
+			&i.PrevHash,
+			&i.Hash,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.RequestID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditLogsDescending = `-- name: ListAuditLogsDescending :many
+SELECT al.id, al.user_id, al.action, al.details, al.timestamp, al.prev_hash, al.hash, al.ip_address, al.user_agent, al.request_id FROM audit_logs al
+WHERE
+    ($1::bigint IS NULL OR al.user_id = $1)
+    AND ($2::text IS NULL OR al.action = $2)
+    AND ($3::text IS NULL OR al.action LIKE $3 || '%')
+    -- Containment keeps the GIN index on details usable.
+    AND ($4::bigint IS NULL OR al.details @> jsonb_build_object('file_id', $4))
+    AND ($5::timestamptz IS NULL OR al.timestamp >= $5)
+    AND ($6::timestamptz IS NULL OR al.timestamp <= $6)
+    AND ($7::text IS NULL OR al.request_id = $7)
+    AND ($8::bigint IS NULL OR (al.timestamp, al.id) < ($9::timestamptz, $8))
+ORDER BY al.timestamp DESC, al.id DESC
+LIMIT $10
 `
 
-// For admin use: retrieves all audit log entries, newest first.
-func (q *Queries) ListAuditLogs(ctx context.Context) ([]AuditLog, error) {
-	rows, err := q.db.Query(ctx, listAuditLogs)
+type ListAuditLogsDescendingParams struct {
+	UserID       pgtype.Int8
+	Action       pgtype.Text
+	ActionPrefix pgtype.Text
+	FileID       pgtype.Int8
+	StartDate    pgtype.Timestamptz
+	EndDate      pgtype.Timestamptz
+	RequestID    pgtype.Text
+	CursorID     pgtype.Int8
+	CursorTime   pgtype.Timestamptz
+	PageLimit    int32
+}
+
+// Like ListAuditLogs, but newest first.
+func (q *Queries) ListAuditLogsDescending(ctx context.Context, arg ListAuditLogsDescendingParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, listAuditLogsDescending,
+		arg.UserID,
+		arg.Action,
+		arg.ActionPrefix,
+		arg.FileID,
+		arg.StartDate,
+		arg.EndDate,
+		arg.RequestID,
+		arg.CursorID,
+		arg.CursorTime,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +505,11 @@ func (q *Queries) ListAuditLogs(ctx context.Context) ([]AuditLog, error) {
 			&i.Action,
 			&i.Details,
 			&i.Timestamp,
+			&i.PrevHash,
+			&i.Hash,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.RequestID,
 		); err != nil {
 			return nil, err
 		}
@@ -59,3 +520,37 @@ func (q *Queries) ListAuditLogs(ctx context.Context) ([]AuditLog, error) {
 	}
 	return items, nil
 }
+
+const lockAuditArchive = `-- name: LockAuditArchive :exec
+SELECT pg_advisory_xact_lock(hashtext('file-vault:audit-archive'))
+`
+
+// Takes a transaction-level lock so that only one replica archives at a time.
+func (q *Queries) LockAuditArchive(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockAuditArchive)
+	return err
+}
+
+const lockAuditChain = `-- name: LockAuditChain :exec
+SELECT pg_advisory_xact_lock(hashtext('file-vault:audit-chain'))
+`
+
+// Takes a transaction-level lock that serializes appends to the hash chain across replicas.
+func (q *Queries) LockAuditChain(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockAuditChain)
+	return err
+}
+
+const setAuditLogHash = `-- name: SetAuditLogHash :exec
+UPDATE audit_logs SET hash = $2 WHERE id = $1
+`
+
+type SetAuditLogHashParams struct {
+	ID   int64
+	Hash string
+}
+
+func (q *Queries) SetAuditLogHash(ctx context.Context, arg SetAuditLogHashParams) error {
+	_, err := q.db.Exec(ctx, setAuditLogHash, arg.ID, arg.Hash)
+	return err
+}
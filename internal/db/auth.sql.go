@@ -9,6 +9,23 @@ import (
 	"context"
 )
 
+const addUserRole = `-- name: AddUserRole :execrows
+INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+`
+
+type AddUserRoleParams struct {
+	UserID int64
+	RoleID int32
+}
+
+func (q *Queries) AddUserRole(ctx context.Context, arg AddUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addUserRole, arg.UserID, arg.RoleID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createPermission = `-- name: CreatePermission :one
 INSERT INTO permissions (name) VALUES ($1) RETURNING id, name
 `
@@ -21,13 +38,13 @@ func (q *Queries) CreatePermission(ctx context.Context, name string) (Permission
 }
 
 const createRole = `-- name: CreateRole :one
-INSERT INTO roles (name) VALUES ($1) RETURNING id, name
+INSERT INTO roles (name) VALUES ($1) RETURNING id, name, require_two_factor
 `
 
 func (q *Queries) CreateRole(ctx context.Context, name string) (Role, error) {
 	row := q.db.QueryRow(ctx, createRole, name)
 	var i Role
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(&i.ID, &i.Name, &i.RequireTwoFactor)
 	return i, err
 }
 
@@ -43,13 +60,13 @@ func (q *Queries) GetPermissionByName(ctx context.Context, name string) (Permiss
 }
 
 const getRoleByName = `-- name: GetRoleByName :one
-SELECT id, name FROM roles WHERE name = $1
+SELECT id, name, require_two_factor FROM roles WHERE name = $1
 `
 
 func (q *Queries) GetRoleByName(ctx context.Context, name string) (Role, error) {
 	row := q.db.QueryRow(ctx, getRoleByName, name)
 	var i Role
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(&i.ID, &i.Name, &i.RequireTwoFactor)
 	return i, err
 }
 
@@ -110,3 +127,40 @@ func (q *Queries) LinkUserToRole(ctx context.Context, arg LinkUserToRoleParams)
 	_, err := q.db.Exec(ctx, linkUserToRole, arg.UserID, arg.RoleID)
 	return err
 }
+
+const listenPermissionsChanged = `-- name: ListenPermissionsChanged :exec
+LISTEN permissions_changed
+`
+
+func (q *Queries) ListenPermissionsChanged(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, listenPermissionsChanged)
+	return err
+}
+
+const notifyPermissionsChanged = `-- name: NotifyPermissionsChanged :exec
+SELECT pg_notify('permissions_changed', $1::text)
+`
+
+// Tells every backend replica to forget cached permissions: those of one user, or of
+// everyone if the payload is '*'. Inside a transaction it is only delivered on commit.
+func (q *Queries) NotifyPermissionsChanged(ctx context.Context, payload string) error {
+	_, err := q.db.Exec(ctx, notifyPermissionsChanged, payload)
+	return err
+}
+
+const removeUserRole = `-- name: RemoveUserRole :execrows
+DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2
+`
+
+type RemoveUserRoleParams struct {
+	UserID int64
+	RoleID int32
+}
+
+func (q *Queries) RemoveUserRole(ctx context.Context, arg RemoveUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, removeUserRole, arg.UserID, arg.RoleID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
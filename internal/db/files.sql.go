@@ -14,18 +14,17 @@ import (
 const addTagToFile = `-- name: AddTagToFile :exec
 UPDATE user_files
 SET tags = array_append(tags, $1)
-WHERE id = $2 AND owner_id = $3
+WHERE id = $2 AND deleted_at IS NULL
 `
 
 type AddTagToFileParams struct {
-	Tag     interface{}
-	FileID  int64
-	OwnerID int64
+	Tag    interface{}
+	FileID int64
 }
 
 // CORRECTED: Use sqlc.arg() to name parameters for clear, generated code.
 func (q *Queries) AddTagToFile(ctx context.Context, arg AddTagToFileParams) error {
-	_, err := q.db.Exec(ctx, addTagToFile, arg.Tag, arg.FileID, arg.OwnerID)
+	_, err := q.db.Exec(ctx, addTagToFile, arg.Tag, arg.FileID)
 	return err
 }
 
@@ -54,7 +53,7 @@ func (q *Queries) CreatePhysicalFile(ctx context.Context, arg CreatePhysicalFile
 }
 
 const createUserFile = `-- name: CreateUserFile :one
-INSERT INTO user_files (owner_id, physical_file_id, filename, mime_type, description, tags) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date
+INSERT INTO user_files (owner_id, physical_file_id, filename, mime_type, description, tags, folder_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector
 `
 
 type CreateUserFileParams struct {
@@ -64,6 +63,7 @@ type CreateUserFileParams struct {
 	MimeType       string
 	Description    pgtype.Text
 	Tags           []string
+	FolderID       pgtype.Int8
 }
 
 func (q *Queries) CreateUserFile(ctx context.Context, arg CreateUserFileParams) (UserFile, error) {
@@ -74,6 +74,7 @@ func (q *Queries) CreateUserFile(ctx context.Context, arg CreateUserFileParams)
 		arg.MimeType,
 		arg.Description,
 		arg.Tags,
+		arg.FolderID,
 	)
 	var i UserFile
 	err := row.Scan(
@@ -85,6 +86,10 @@ func (q *Queries) CreateUserFile(ctx context.Context, arg CreateUserFileParams)
 		&i.Description,
 		&i.Tags,
 		&i.UploadDate,
+		&i.CurrentVersion,
+		&i.FolderID,
+		&i.DeletedAt,
+		&i.SearchVector,
 	)
 	return i, err
 }
@@ -118,15 +123,59 @@ func (q *Queries) DeleteUserFile(ctx context.Context, id int64) error {
 	return err
 }
 
+const getFileAccessRole = `-- name: GetFileAccessRole :one
+WITH RECURSIVE ancestors AS (
+    SELECT f.id, f.parent_id FROM folders f JOIN user_files uf ON uf.folder_id = f.id WHERE uf.id = $1
+    UNION
+    SELECT f.id, f.parent_id FROM folders f JOIN ancestors a ON f.id = a.parent_id
+),
+grants AS (
+    SELECT 'owner'::text AS role FROM user_files WHERE id = $1 AND owner_id = $2
+    UNION ALL
+    SELECT fstu.role::text FROM file_shares_to_users fstu
+    WHERE fstu.user_file_id = $1 AND fstu.shared_with_user_id = $2
+    UNION ALL
+    SELECT fs.role::text FROM folder_shares_to_users fs JOIN ancestors a ON fs.folder_id = a.id
+    WHERE fs.shared_with_user_id = $2
+)
+SELECT g.role, uf.owner_id
+FROM grants g, user_files uf
+WHERE uf.id = $1 AND uf.deleted_at IS NULL
+ORDER BY array_position(ARRAY['viewer', 'commenter', 'editor', 'co-owner', 'owner'], g.role) DESC
+LIMIT 1
+`
+
+type GetFileAccessRoleParams struct {
+	FileID int64
+	UserID int64
+}
+
+type GetFileAccessRoleRow struct {
+	Role    string
+	OwnerID int64
+}
+
+// Returns the strongest role a user has on a file: 'owner', or the highest role granted by a share
+// of the file itself or of any folder above it. No row is returned when the user has no access.
+func (q *Queries) GetFileAccessRole(ctx context.Context, arg GetFileAccessRoleParams) (GetFileAccessRoleRow, error) {
+	row := q.db.QueryRow(ctx, getFileAccessRole, arg.FileID, arg.UserID)
+	var i GetFileAccessRoleRow
+	err := row.Scan(&i.Role, &i.OwnerID)
+	return i, err
+}
+
 const getFileForUserDownload = `-- name: GetFileForUserDownload :one
 SELECT
     uf.filename,
+    uf.mime_type,
     pf.storage_path,
-    pf.size_bytes
+    pf.size_bytes,
+    pf.sha256_hash
 FROM user_files uf
 JOIN physical_files pf ON uf.physical_file_id = pf.id
 WHERE
     uf.id = $1
+    AND uf.deleted_at IS NULL
     AND (
         uf.owner_id = $2
         OR
@@ -134,6 +183,16 @@ WHERE
             SELECT 1 FROM file_shares_to_users fstu
             WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = $2
         )
+        OR
+        uf.folder_id IN (
+            WITH RECURSIVE shared_folders AS (
+                SELECT fs.folder_id AS id FROM folder_shares_to_users fs
+                WHERE fs.shared_with_user_id = $2
+                UNION
+                SELECT f.id FROM folders f JOIN shared_folders sf ON f.parent_id = sf.id
+            )
+            SELECT id FROM shared_folders
+        )
     )
 `
 
@@ -144,15 +203,23 @@ type GetFileForUserDownloadParams struct {
 
 type GetFileForUserDownloadRow struct {
 	Filename    string
+	MimeType    string
 	StoragePath string
 	SizeBytes   int64
+	Sha256Hash  string
 }
 
 // CORRECTED: Uses sqlc.arg() for explicit parameter naming.
 func (q *Queries) GetFileForUserDownload(ctx context.Context, arg GetFileForUserDownloadParams) (GetFileForUserDownloadRow, error) {
 	row := q.db.QueryRow(ctx, getFileForUserDownload, arg.FileID, arg.RequestingUserID)
 	var i GetFileForUserDownloadRow
-	err := row.Scan(&i.Filename, &i.StoragePath, &i.SizeBytes)
+	err := row.Scan(
+		&i.Filename,
+		&i.MimeType,
+		&i.StoragePath,
+		&i.SizeBytes,
+		&i.Sha256Hash,
+	)
 	return i, err
 }
 
@@ -160,7 +227,7 @@ const getFileOwnerAndPhysicalFile = `-- name: GetFileOwnerAndPhysicalFile :one
 SELECT uf.owner_id, pf.id as physical_file_id, pf.size_bytes, pf.storage_path
 FROM user_files uf
 JOIN physical_files pf ON uf.physical_file_id = pf.id
-WHERE uf.id = $1 AND uf.owner_id = $2
+WHERE uf.id = $1 AND uf.owner_id = $2 AND uf.deleted_at IS NULL
 `
 
 type GetFileOwnerAndPhysicalFileParams struct {
@@ -208,7 +275,7 @@ func (q *Queries) GetPhysicalFileByHash(ctx context.Context, sha256Hash string)
 }
 
 const getUserFileForDownload = `-- name: GetUserFileForDownload :one
-SELECT uf.id, uf.owner_id, uf.physical_file_id, uf.filename, uf.mime_type, uf.description, uf.tags, uf.upload_date, pf.storage_path FROM user_files uf JOIN physical_files pf ON uf.physical_file_id = pf.id WHERE uf.id = $1 AND uf.owner_id = $2
+SELECT uf.id, uf.owner_id, uf.physical_file_id, uf.filename, uf.mime_type, uf.description, uf.tags, uf.upload_date, uf.current_version, uf.folder_id, uf.deleted_at, uf.search_vector, pf.storage_path FROM user_files uf JOIN physical_files pf ON uf.physical_file_id = pf.id WHERE uf.id = $1 AND uf.owner_id = $2 AND uf.deleted_at IS NULL
 `
 
 type GetUserFileForDownloadParams struct {
@@ -225,6 +292,10 @@ type GetUserFileForDownloadRow struct {
 	Description    pgtype.Text
 	Tags           []string
 	UploadDate     pgtype.Timestamptz
+	CurrentVersion int32
+	FolderID       pgtype.Int8
+	DeletedAt      pgtype.Timestamptz
+	SearchVector   string `json:"-"`
 	StoragePath    string
 }
 
@@ -240,6 +311,10 @@ func (q *Queries) GetUserFileForDownload(ctx context.Context, arg GetUserFileFor
 		&i.Description,
 		&i.Tags,
 		&i.UploadDate,
+		&i.CurrentVersion,
+		&i.FolderID,
+		&i.DeletedAt,
+		&i.SearchVector,
 		&i.StoragePath,
 	)
 	return i, err
@@ -284,23 +359,141 @@ func (q *Queries) IsFileSharedWithUser(ctx context.Context, arg IsFileSharedWith
 }
 
 const listFilesSharedWithUser = `-- name: ListFilesSharedWithUser :many
-SELECT uf.id, uf.owner_id, uf.physical_file_id, uf.filename, uf.mime_type, uf.description, uf.tags, uf.upload_date
-FROM user_files uf
-JOIN file_shares_to_users fstu ON uf.id = fstu.user_file_id
-WHERE fstu.shared_with_user_id = $1
-ORDER BY uf.upload_date DESC
+WITH files AS NOT MATERIALIZED (
+    SELECT uf.id, uf.owner_id, uf.physical_file_id, uf.filename, uf.mime_type, uf.description, uf.tags, uf.upload_date, uf.current_version, uf.folder_id, uf.deleted_at, uf.search_vector, pf.size_bytes
+    FROM user_files uf
+    JOIN physical_files pf ON uf.physical_file_id = pf.id
+    WHERE
+        uf.deleted_at IS NULL
+        AND (
+            EXISTS (
+                SELECT 1 FROM file_shares_to_users fstu
+                WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = $1
+            )
+            OR
+            uf.folder_id IN (
+                WITH RECURSIVE shared_folders AS (
+                    SELECT fs.folder_id AS id FROM folder_shares_to_users fs
+                    WHERE fs.shared_with_user_id = $1
+                    UNION
+                    SELECT f.id FROM folders f JOIN shared_folders sf ON f.parent_id = sf.id
+                )
+                SELECT id FROM shared_folders
+            )
+        )
+)
+SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes
+FROM (
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'name' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (filename, id) > ($5::text, $4))
+     ORDER BY filename, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'name' AND $3::boolean
+       AND ($4::bigint IS NULL OR (filename, id) < ($5::text, $4))
+     ORDER BY filename DESC, id DESC
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'mime' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (mime_type, id) > ($5::text, $4))
+     ORDER BY mime_type, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'mime' AND $3::boolean
+       AND ($4::bigint IS NULL OR (mime_type, id) < ($5::text, $4))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'size' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (size_bytes, id) > ($7::bigint, $4))
+     ORDER BY size_bytes, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'size' AND $3::boolean
+       AND ($4::bigint IS NULL OR (size_bytes, id) < ($7::bigint, $4))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'upload_date' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (upload_date, id) > ($8::timestamptz, $4))
+     ORDER BY upload_date, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes FROM files
+     WHERE $2::text = 'upload_date' AND $3::boolean
+       AND ($4::bigint IS NULL OR (upload_date, id) < ($8::timestamptz, $4))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT $6)
+) page
+-- Sorts only the page read by the branch that ran.
+ORDER BY
+    CASE WHEN $2::text = 'name' AND NOT $3::boolean THEN filename END ASC,
+    CASE WHEN $2::text = 'name' AND $3::boolean THEN filename END DESC,
+    CASE WHEN $2::text = 'mime' AND NOT $3::boolean THEN mime_type END ASC,
+    CASE WHEN $2::text = 'mime' AND $3::boolean THEN mime_type END DESC,
+    CASE WHEN $2::text = 'size' AND NOT $3::boolean THEN size_bytes END ASC,
+    CASE WHEN $2::text = 'size' AND $3::boolean THEN size_bytes END DESC,
+    CASE WHEN $2::text = 'upload_date' AND NOT $3::boolean THEN upload_date END ASC,
+    CASE WHEN $2::text = 'upload_date' AND $3::boolean THEN upload_date END DESC,
+    CASE WHEN NOT $3::boolean THEN id END ASC,
+    CASE WHEN $3::boolean THEN id END DESC
 `
 
-// Retrieves a list of all files that have been explicitly shared with a specific user.
-func (q *Queries) ListFilesSharedWithUser(ctx context.Context, sharedWithUserID int64) ([]UserFile, error) {
-	rows, err := q.db.Query(ctx, listFilesSharedWithUser, sharedWithUserID)
+type ListFilesSharedWithUserParams struct {
+	SharedWithUserID int64
+	SortKey          string
+	Descending       bool
+	CursorID         pgtype.Int8
+	CursorText       pgtype.Text
+	PageLimit        int32
+	CursorInt        pgtype.Int8
+	CursorTime       pgtype.Timestamptz
+}
+
+type ListFilesSharedWithUserRow struct {
+	ID             int64
+	OwnerID        int64
+	PhysicalFileID int64
+	Filename       string
+	MimeType       string
+	Description    pgtype.Text
+	Tags           []string
+	UploadDate     pgtype.Timestamptz
+	CurrentVersion int32
+	FolderID       pgtype.Int8
+	DeletedAt      pgtype.Timestamptz
+	SearchVector   string `json:"-"`
+	SizeBytes      int64
+}
+
+// Retrieves a page of the files that have been shared with a specific user,
+// either directly or through a shared folder above them. Paged like ListUserFiles: the
+// branches read the idx_user_files_* indexes in order and check each file is shared.
+func (q *Queries) ListFilesSharedWithUser(ctx context.Context, arg ListFilesSharedWithUserParams) ([]ListFilesSharedWithUserRow, error) {
+	rows, err := q.db.Query(ctx, listFilesSharedWithUser,
+		arg.SharedWithUserID,
+		arg.SortKey,
+		arg.Descending,
+		arg.CursorID,
+		arg.CursorText,
+		arg.PageLimit,
+		arg.CursorInt,
+		arg.CursorTime,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []UserFile
+	var items []ListFilesSharedWithUserRow
 	for rows.Next() {
-		var i UserFile
+		var i ListFilesSharedWithUserRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.OwnerID,
@@ -310,6 +503,11 @@ func (q *Queries) ListFilesSharedWithUser(ctx context.Context, sharedWithUserID
 			&i.Description,
 			&i.Tags,
 			&i.UploadDate,
+			&i.CurrentVersion,
+			&i.FolderID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.SizeBytes,
 		); err != nil {
 			return nil, err
 		}
@@ -322,22 +520,97 @@ func (q *Queries) ListFilesSharedWithUser(ctx context.Context, sharedWithUserID
 }
 
 const listUserFiles = `-- name: ListUserFiles :many
-SELECT
-    uf.id,
-    uf.owner_id,
-    uf.physical_file_id,
-    uf.filename,
-    uf.mime_type,
-    uf.description,
-    uf.tags,
-    uf.upload_date,
-    pf.size_bytes
-FROM user_files uf
-JOIN physical_files pf ON uf.physical_file_id = pf.id
-WHERE uf.owner_id = $1
-ORDER BY uf.upload_date DESC
+WITH files AS NOT MATERIALIZED (
+    SELECT
+        uf.id,
+        uf.owner_id,
+        uf.physical_file_id,
+        uf.filename,
+        uf.mime_type,
+        uf.description,
+        uf.tags,
+        uf.upload_date,
+        uf.folder_id,
+        pf.size_bytes
+    FROM user_files uf
+    JOIN physical_files pf ON uf.physical_file_id = pf.id
+    WHERE uf.owner_id = $1 AND uf.deleted_at IS NULL
+)
+SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes
+FROM (
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'name' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (filename, id) > ($5::text, $4))
+     ORDER BY filename, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'name' AND $3::boolean
+       AND ($4::bigint IS NULL OR (filename, id) < ($5::text, $4))
+     ORDER BY filename DESC, id DESC
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'mime' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (mime_type, id) > ($5::text, $4))
+     ORDER BY mime_type, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'mime' AND $3::boolean
+       AND ($4::bigint IS NULL OR (mime_type, id) < ($5::text, $4))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'size' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (size_bytes, id) > ($7::bigint, $4))
+     ORDER BY size_bytes, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'size' AND $3::boolean
+       AND ($4::bigint IS NULL OR (size_bytes, id) < ($7::bigint, $4))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'upload_date' AND NOT $3::boolean
+       AND ($4::bigint IS NULL OR (upload_date, id) > ($8::timestamptz, $4))
+     ORDER BY upload_date, id
+     LIMIT $6)
+    UNION ALL
+    (SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes FROM files
+     WHERE $2::text = 'upload_date' AND $3::boolean
+       AND ($4::bigint IS NULL OR (upload_date, id) < ($8::timestamptz, $4))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT $6)
+) page
+-- Sorts only the page read by the branch that ran.
+ORDER BY
+    CASE WHEN $2::text = 'name' AND NOT $3::boolean THEN filename END ASC,
+    CASE WHEN $2::text = 'name' AND $3::boolean THEN filename END DESC,
+    CASE WHEN $2::text = 'mime' AND NOT $3::boolean THEN mime_type END ASC,
+    CASE WHEN $2::text = 'mime' AND $3::boolean THEN mime_type END DESC,
+    CASE WHEN $2::text = 'size' AND NOT $3::boolean THEN size_bytes END ASC,
+    CASE WHEN $2::text = 'size' AND $3::boolean THEN size_bytes END DESC,
+    CASE WHEN $2::text = 'upload_date' AND NOT $3::boolean THEN upload_date END ASC,
+    CASE WHEN $2::text = 'upload_date' AND $3::boolean THEN upload_date END DESC,
+    CASE WHEN NOT $3::boolean THEN id END ASC,
+    CASE WHEN $3::boolean THEN id END DESC
 `
 
+type ListUserFilesParams struct {
+	OwnerID    int64
+	SortKey    string
+	Descending bool
+	CursorID   pgtype.Int8
+	CursorText pgtype.Text
+	PageLimit  int32
+	CursorInt  pgtype.Int8
+	CursorTime pgtype.Timestamptz
+}
+
 type ListUserFilesRow struct {
 	ID             int64
 	OwnerID        int64
@@ -347,12 +620,26 @@ type ListUserFilesRow struct {
 	Description    pgtype.Text
 	Tags           []string
 	UploadDate     pgtype.Timestamptz
+	FolderID       pgtype.Int8
 	SizeBytes      int64
 }
 
-// CORRECTED: Join with physical_files to get the correct size_bytes.
-func (q *Queries) ListUserFiles(ctx context.Context, ownerID int64) ([]ListUserFilesRow, error) {
-	rows, err := q.db.Query(ctx, listUserFiles, ownerID)
+// Retrieves a page of a user's files, joined with physical_files to get the correct size_bytes.
+// The page is sorted by sort_key ('name', 'mime', 'size' or 'upload_date'), ties broken by ID,
+// and starts after the cursor row if there is one. Each sort key and direction has a branch of
+// its own that reads the files in that order, so that it can seek to the cursor in the
+// idx_user_files_owner_* indexes and stop at page_limit. Only the requested branch runs.
+func (q *Queries) ListUserFiles(ctx context.Context, arg ListUserFilesParams) ([]ListUserFilesRow, error) {
+	rows, err := q.db.Query(ctx, listUserFiles,
+		arg.OwnerID,
+		arg.SortKey,
+		arg.Descending,
+		arg.CursorID,
+		arg.CursorText,
+		arg.PageLimit,
+		arg.CursorInt,
+		arg.CursorTime,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -369,6 +656,7 @@ func (q *Queries) ListUserFiles(ctx context.Context, ownerID int64) ([]ListUserF
 			&i.Description,
 			&i.Tags,
 			&i.UploadDate,
+			&i.FolderID,
 			&i.SizeBytes,
 		); err != nil {
 			return nil, err
@@ -384,17 +672,58 @@ func (q *Queries) ListUserFiles(ctx context.Context, ownerID int64) ([]ListUserF
 const removeTagFromFile = `-- name: RemoveTagFromFile :exec
 UPDATE user_files
 SET tags = array_remove(tags, $1)
-WHERE id = $2 AND owner_id = $3
+WHERE id = $2 AND deleted_at IS NULL
 `
 
 type RemoveTagFromFileParams struct {
-	Tag     interface{}
-	FileID  int64
-	OwnerID int64
+	Tag    interface{}
+	FileID int64
 }
 
 // CORRECTED: Use sqlc.arg() for named parameters.
 func (q *Queries) RemoveTagFromFile(ctx context.Context, arg RemoveTagFromFileParams) error {
-	_, err := q.db.Exec(ctx, removeTagFromFile, arg.Tag, arg.FileID, arg.OwnerID)
+	_, err := q.db.Exec(ctx, removeTagFromFile, arg.Tag, arg.FileID)
 	return err
 }
+
+const updateUserFileDetails = `-- name: UpdateUserFileDetails :one
+UPDATE user_files
+SET filename = COALESCE($1, filename),
+    description = CASE WHEN $2::boolean THEN $3 ELSE description END
+WHERE id = $4 AND deleted_at IS NULL
+RETURNING id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector
+`
+
+type UpdateUserFileDetailsParams struct {
+	Filename          pgtype.Text
+	UpdateDescription bool
+	Description       pgtype.Text
+	ID                int64
+}
+
+// Renames a file and/or changes its description. A NULL filename keeps the current name, and the
+// description is only touched when update_description is set.
+func (q *Queries) UpdateUserFileDetails(ctx context.Context, arg UpdateUserFileDetailsParams) (UserFile, error) {
+	row := q.db.QueryRow(ctx, updateUserFileDetails,
+		arg.Filename,
+		arg.UpdateDescription,
+		arg.Description,
+		arg.ID,
+	)
+	var i UserFile
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.PhysicalFileID,
+		&i.Filename,
+		&i.MimeType,
+		&i.Description,
+		&i.Tags,
+		&i.UploadDate,
+		&i.CurrentVersion,
+		&i.FolderID,
+		&i.DeletedAt,
+		&i.SearchVector,
+	)
+	return i, err
+}
@@ -8,17 +8,91 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiKey struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	KeyPrefix  string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  pgtype.Timestamptz
+	LastUsedAt pgtype.Timestamptz
+	CreatedAt  pgtype.Timestamptz
+}
+
+type AuditArchiveSegment struct {
+	ID             int64
+	ObjectKey      string
+	FirstEntryID   int64
+	LastEntryID    int64
+	FirstTimestamp pgtype.Timestamptz
+	LastTimestamp  pgtype.Timestamptz
+	EntryCount     int64
+	SizeBytes      int64
+	Sha256Hash     string
+	FirstPrevHash  pgtype.Text
+	LastHash       pgtype.Text
+	Signature      string
+	CreatedAt      pgtype.Timestamptz
+}
+
+type AuditCheckpoint struct {
+	ID          int64
+	LastEntryID int64
+	LastHash    string
+	Signature   string
+	CreatedAt   pgtype.Timestamptz
+}
+
 type AuditLog struct {
 	ID        int64
 	UserID    pgtype.Int8
 	Action    string
 	Details   []byte
 	Timestamp pgtype.Timestamptz
+	PrevHash  pgtype.Text
+	Hash      pgtype.Text
+	IpAddress pgtype.Text
+	UserAgent pgtype.Text
+	RequestID pgtype.Text
 }
 
 type FileSharesToUser struct {
 	UserFileID       int64
 	SharedWithUserID int64
+	Role             string
+}
+
+type FileVersion struct {
+	ID             int64
+	UserFileID     int64
+	VersionNumber  int32
+	PhysicalFileID int64
+	MimeType       string
+	UploadedBy     pgtype.Int8
+	CreatedAt      pgtype.Timestamptz
+}
+
+type Folder struct {
+	ID        int64
+	OwnerID   int64
+	ParentID  pgtype.Int8
+	Name      string
+	CreatedAt pgtype.Timestamptz
+}
+
+type FolderSharesToUser struct {
+	FolderID         int64
+	SharedWithUserID int64
+	Role             string
+}
+
+type LoginThrottle struct {
+	Scope        string
+	Subject      string
+	Failures     int32
+	LastFailedAt pgtype.Timestamptz
+	LockedUntil  pgtype.Timestamptz
 }
 
 type Permission struct {
@@ -35,9 +109,17 @@ type PhysicalFile struct {
 	CreatedAt      pgtype.Timestamptz
 }
 
+type RecoveryCode struct {
+	ID       int64
+	UserID   int64
+	CodeHash string
+	UsedAt   pgtype.Timestamptz
+}
+
 type Role struct {
-	ID   int32
-	Name string
+	ID               int32
+	Name             string
+	RequireTwoFactor bool
 }
 
 type RolePermission struct {
@@ -45,13 +127,55 @@ type RolePermission struct {
 	PermissionID int32
 }
 
+type Session struct {
+	ID                       int64
+	UserID                   int64
+	RefreshTokenHash         string
+	PreviousRefreshTokenHash pgtype.Text
+	UserAgent                pgtype.Text
+	IpAddress                pgtype.Text
+	CreatedAt                pgtype.Timestamptz
+	LastUsedAt               pgtype.Timestamptz
+	ExpiresAt                pgtype.Timestamptz
+	RevokedAt                pgtype.Timestamptz
+}
+
 type Share struct {
 	ID            int64
-	UserFileID    int64
+	UserFileID    pgtype.Int8
 	ShareToken    string
 	IsPublic      pgtype.Bool
 	DownloadCount pgtype.Int8
 	CreatedAt     pgtype.Timestamptz
+	FolderID      pgtype.Int8
+	ExpiresAt     pgtype.Timestamptz
+	MaxDownloads  pgtype.Int8
+	PasswordHash  pgtype.Text
+}
+
+type ShareDownloadGrant struct {
+	ID            int64
+	ShareID       int64
+	ServedThrough int64
+	ExpiresAt     pgtype.Timestamptz
+	CreatedAt     pgtype.Timestamptz
+}
+
+type Upload struct {
+	ID                string
+	OwnerID           int64
+	Filename          string
+	MimeType          string
+	Description       pgtype.Text
+	Tags              []string
+	UploadLength      int64
+	UploadOffset      int64
+	UserFileID        pgtype.Int8
+	ExpiresAt         pgtype.Timestamptz
+	CreatedAt         pgtype.Timestamptz
+	ChunkKeys         []string
+	AssemblyStartedAt pgtype.Timestamptz
+	CompletedAt       pgtype.Timestamptz
 }
 
 type User struct {
@@ -61,6 +185,8 @@ type User struct {
 	StorageQuotaBytes int64
 	StorageUsedBytes  int64
 	CreatedAt         pgtype.Timestamptz
+	EmailVerifiedAt   pgtype.Timestamptz
+	SuspendedAt       pgtype.Timestamptz
 }
 
 type UserFile struct {
@@ -72,9 +198,40 @@ type UserFile struct {
 	Description    pgtype.Text
 	Tags           []string
 	UploadDate     pgtype.Timestamptz
+	CurrentVersion int32
+	FolderID       pgtype.Int8
+	DeletedAt      pgtype.Timestamptz
+	SearchVector   string `json:"-"`
+}
+
+type UserIdentity struct {
+	ID          int64
+	UserID      int64
+	Issuer      string
+	Subject     string
+	Email       pgtype.Text
+	CreatedAt   pgtype.Timestamptz
+	LastLoginAt pgtype.Timestamptz
 }
 
 type UserRole struct {
 	UserID int64
 	RoleID int32
 }
+
+type UserToken struct {
+	ID        string
+	UserID    int64
+	Purpose   string
+	ExpiresAt pgtype.Timestamptz
+	UsedAt    pgtype.Timestamptz
+	CreatedAt pgtype.Timestamptz
+}
+
+type UserTotp struct {
+	UserID       int64
+	Secret       string
+	ConfirmedAt  pgtype.Timestamptz
+	LastUsedStep pgtype.Int8
+	CreatedAt    pgtype.Timestamptz
+}
@@ -23,6 +23,34 @@ func (q *Queries) AddPermissionToRole(ctx context.Context, arg AddPermissionToRo
 	return err
 }
 
+const countUsersWithPermission = `-- name: CountUsersWithPermission :one
+SELECT COUNT(DISTINCT ur.user_id)::BIGINT AS count
+FROM user_roles ur
+JOIN users u ON ur.user_id = u.id
+JOIN role_permissions rp ON ur.role_id = rp.role_id
+JOIN permissions p ON rp.permission_id = p.id
+WHERE p.name = $1 AND u.suspended_at IS NULL
+`
+
+// Suspended users don't count, since they can't use the permission.
+func (q *Queries) CountUsersWithPermission(ctx context.Context, name string) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsersWithPermission, name)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteRole = `-- name: DeleteRole :one
+DELETE FROM roles WHERE id = $1 RETURNING id, name, require_two_factor
+`
+
+func (q *Queries) DeleteRole(ctx context.Context, id int32) (Role, error) {
+	row := q.db.QueryRow(ctx, deleteRole, id)
+	var i Role
+	err := row.Scan(&i.ID, &i.Name, &i.RequireTwoFactor)
+	return i, err
+}
+
 const getPermissionsForRole = `-- name: GetPermissionsForRole :many
 SELECT p.id, p.name
 FROM permissions p
@@ -50,6 +78,17 @@ func (q *Queries) GetPermissionsForRole(ctx context.Context, roleID int32) ([]Pe
 	return items, nil
 }
 
+const getRoleByID = `-- name: GetRoleByID :one
+SELECT id, name, require_two_factor FROM roles WHERE id = $1
+`
+
+func (q *Queries) GetRoleByID(ctx context.Context, id int32) (Role, error) {
+	row := q.db.QueryRow(ctx, getRoleByID, id)
+	var i Role
+	err := row.Scan(&i.ID, &i.Name, &i.RequireTwoFactor)
+	return i, err
+}
+
 const listPermissions = `-- name: ListPermissions :many
 SELECT id, name FROM permissions ORDER BY name
 `
@@ -75,7 +114,7 @@ func (q *Queries) ListPermissions(ctx context.Context) ([]Permission, error) {
 }
 
 const listRoles = `-- name: ListRoles :many
-SELECT id, name FROM roles ORDER BY name
+SELECT id, name, require_two_factor FROM roles ORDER BY name
 `
 
 func (q *Queries) ListRoles(ctx context.Context) ([]Role, error) {
@@ -87,7 +126,7 @@ func (q *Queries) ListRoles(ctx context.Context) ([]Role, error) {
 	var items []Role
 	for rows.Next() {
 		var i Role
-		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+		if err := rows.Scan(&i.ID, &i.Name, &i.RequireTwoFactor); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -98,6 +137,44 @@ func (q *Queries) ListRoles(ctx context.Context) ([]Role, error) {
 	return items, nil
 }
 
+const listUserRoles = `-- name: ListUserRoles :many
+SELECT r.id, r.name, r.require_two_factor
+FROM roles r
+JOIN user_roles ur ON r.id = ur.role_id
+WHERE ur.user_id = $1
+ORDER BY r.name
+`
+
+func (q *Queries) ListUserRoles(ctx context.Context, userID int64) ([]Role, error) {
+	rows, err := q.db.Query(ctx, listUserRoles, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Role
+	for rows.Next() {
+		var i Role
+		if err := rows.Scan(&i.ID, &i.Name, &i.RequireTwoFactor); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockRoleAssignments = `-- name: LockRoleAssignments :exec
+SELECT pg_advisory_xact_lock(hashtext('file-vault:role-assignments'))
+`
+
+// Serializes changes that could leave nobody able to manage roles, until the transaction ends.
+func (q *Queries) LockRoleAssignments(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockRoleAssignments)
+	return err
+}
+
 const removePermissionFromRole = `-- name: RemovePermissionFromRole :exec
 DELETE FROM role_permissions WHERE role_id = $1 AND permission_id = $2
 `
@@ -111,3 +188,53 @@ func (q *Queries) RemovePermissionFromRole(ctx context.Context, arg RemovePermis
 	_, err := q.db.Exec(ctx, removePermissionFromRole, arg.RoleID, arg.PermissionID)
 	return err
 }
+
+const renameRole = `-- name: RenameRole :one
+UPDATE roles SET name = $2 WHERE id = $1 RETURNING id, name, require_two_factor
+`
+
+type RenameRoleParams struct {
+	ID   int32
+	Name string
+}
+
+func (q *Queries) RenameRole(ctx context.Context, arg RenameRoleParams) (Role, error) {
+	row := q.db.QueryRow(ctx, renameRole, arg.ID, arg.Name)
+	var i Role
+	err := row.Scan(&i.ID, &i.Name, &i.RequireTwoFactor)
+	return i, err
+}
+
+const revokeSessionsWithoutTwoFactorForRole = `-- name: RevokeSessionsWithoutTwoFactorForRole :execrows
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE revoked_at IS NULL
+  AND user_id IN (SELECT ur.user_id FROM user_roles ur WHERE ur.role_id = $1)
+  AND user_id NOT IN (SELECT t.user_id FROM user_totp t WHERE t.confirmed_at IS NOT NULL)
+`
+
+// Signs out members of a role who haven't set up two-factor authentication, so they have to
+// enroll the next time they log in.
+func (q *Queries) RevokeSessionsWithoutTwoFactorForRole(ctx context.Context, roleID int32) (int64, error) {
+	result, err := q.db.Exec(ctx, revokeSessionsWithoutTwoFactorForRole, roleID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setRoleRequireTwoFactor = `-- name: SetRoleRequireTwoFactor :one
+UPDATE roles SET require_two_factor = $2 WHERE id = $1 RETURNING id, name, require_two_factor
+`
+
+type SetRoleRequireTwoFactorParams struct {
+	ID               int32
+	RequireTwoFactor bool
+}
+
+func (q *Queries) SetRoleRequireTwoFactor(ctx context.Context, arg SetRoleRequireTwoFactorParams) (Role, error) {
+	row := q.db.QueryRow(ctx, setRoleRequireTwoFactor, arg.ID, arg.RequireTwoFactor)
+	var i Role
+	err := row.Scan(&i.ID, &i.Name, &i.RequireTwoFactor)
+	return i, err
+}
@@ -12,54 +12,174 @@ import (
 )
 
 const searchFiles = `-- name: SearchFiles :many
-SELECT
-    uf.id,
-    uf.filename,
-    uf.mime_type,
-    uf.upload_date,
-    pf.size_bytes,
-    u.email as owner_email
-FROM
-    user_files uf
-JOIN
-    users u ON uf.owner_id = u.id
-JOIN
-    physical_files pf ON uf.physical_file_id = pf.id
-WHERE
-    (
-        $1::boolean OR
-        uf.owner_id = $2::bigint OR
-        EXISTS (
-            SELECT 1 FROM file_shares_to_users fstu
-            WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = $2::bigint
+WITH files AS NOT MATERIALIZED (
+    SELECT
+        uf.id,
+        uf.filename,
+        uf.mime_type,
+        uf.upload_date,
+        pf.size_bytes,
+        u.email as owner_email,
+        r.relevance
+    FROM
+        user_files uf
+    JOIN
+        users u ON uf.owner_id = u.id
+    JOIN
+        physical_files pf ON uf.physical_file_id = pf.id
+    CROSS JOIN LATERAL (
+        SELECT (
+            CASE WHEN $1::text IS NULL THEN 0
+            ELSE ts_rank(uf.search_vector, to_tsquery('simple', $1)) END
+            +
+            CASE WHEN $2::text IS NULL THEN 0
+            ELSE word_similarity($2, uf.filename) END
+        )::float8 AS relevance
+    ) r
+    WHERE
+        uf.deleted_at IS NULL
+    AND
+        (
+            $3::boolean OR
+            uf.owner_id = $4::bigint OR
+            EXISTS (
+                SELECT 1 FROM file_shares_to_users fstu
+                WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = $4::bigint
+            )
+            OR
+            uf.folder_id IN (
+                WITH RECURSIVE shared_folders AS (
+                    SELECT fs.folder_id AS id FROM folder_shares_to_users fs
+                    WHERE fs.shared_with_user_id = $4::bigint
+                    UNION
+                    SELECT f.id FROM folders f JOIN shared_folders sf ON f.parent_id = sf.id
+                )
+                SELECT id FROM shared_folders
+            )
         )
-    )
-AND
-    (uf.filename ILIKE '%' || $3 || '%' OR $3 IS NULL)
-AND
-    (uf.mime_type = $4 OR $4 IS NULL)
-AND
-    (pf.size_bytes >= $5 OR $5 IS NULL)
-AND
-    (pf.size_bytes <= $6 OR $6 IS NULL)
-AND
-    (uf.upload_date >= $7 OR $7 IS NULL)
-AND
-    (uf.upload_date <= $8 OR $8 IS NULL)
-AND
-    -- The @> operator checks if the tags array contains all elements from the input array.
-    -- This is efficiently powered by our GIN index.
-    (uf.tags @> $9::text[] OR $9 IS NULL)
-AND
-    -- Filter by a specific uploader's email if provided.
-    (u.email = $10 OR $10 IS NULL)
+    AND
+        -- Files matching the full-text query, or whose filename is close to it. Both are backed
+        -- by GIN indexes.
+        ($1::text IS NULL
+         OR uf.search_vector @@ to_tsquery('simple', $1)
+         OR $2::text <% uf.filename)
+    AND
+        ($5::text IS NULL OR NOT uf.search_vector @@ to_tsquery('simple', $5))
+    AND
+        -- Uses the trigram index on filename.
+        (uf.filename ILIKE '%' || $6 || '%' OR $6 IS NULL)
+    AND
+        (uf.mime_type = $7 OR $7 IS NULL)
+    AND
+        (pf.size_bytes >= $8 OR $8 IS NULL)
+    AND
+        (pf.size_bytes <= $9 OR $9 IS NULL)
+    AND
+        (uf.upload_date >= $10 OR $10 IS NULL)
+    AND
+        (uf.upload_date <= $11 OR $11 IS NULL)
+    AND
+        -- The @> operator checks if the tags array contains all elements from the input array.
+        -- This is efficiently powered by our GIN index.
+        (uf.tags @> $12::text[] OR $12 IS NULL)
+    AND
+        -- Filter by a specific uploader's email if provided.
+        (u.email = $13 OR $13 IS NULL)
+    AND
+        -- Restrict results to a folder and everything below it if provided.
+        ($14::bigint IS NULL OR uf.folder_id IN (
+            WITH RECURSIVE subtree AS (
+                SELECT id FROM folders WHERE id = $14
+                UNION ALL
+                SELECT f.id FROM folders f JOIN subtree st ON f.parent_id = st.id
+            )
+            SELECT id FROM subtree
+        ))
+)
+SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance
+FROM (
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'name' AND NOT $16::boolean
+       AND ($17::bigint IS NULL OR (filename, id) > ($18::text, $17))
+     ORDER BY filename, id
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'name' AND $16::boolean
+       AND ($17::bigint IS NULL OR (filename, id) < ($18::text, $17))
+     ORDER BY filename DESC, id DESC
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'mime' AND NOT $16::boolean
+       AND ($17::bigint IS NULL OR (mime_type, id) > ($18::text, $17))
+     ORDER BY mime_type, id
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'mime' AND $16::boolean
+       AND ($17::bigint IS NULL OR (mime_type, id) < ($18::text, $17))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'size' AND NOT $16::boolean
+       AND ($17::bigint IS NULL OR (size_bytes, id) > ($20::bigint, $17))
+     ORDER BY size_bytes, id
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'size' AND $16::boolean
+       AND ($17::bigint IS NULL OR (size_bytes, id) < ($20::bigint, $17))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'upload_date' AND NOT $16::boolean
+       AND ($17::bigint IS NULL OR (upload_date, id) > ($21::timestamptz, $17))
+     ORDER BY upload_date, id
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'upload_date' AND $16::boolean
+       AND ($17::bigint IS NULL OR (upload_date, id) < ($21::timestamptz, $17))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'relevance' AND NOT $16::boolean
+       AND ($17::bigint IS NULL OR (relevance, id) > ($22::float8, $17))
+     ORDER BY relevance, id
+     LIMIT $19)
+    UNION ALL
+    (SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance FROM files
+     WHERE $15::text = 'relevance' AND $16::boolean
+       AND ($17::bigint IS NULL OR (relevance, id) < ($22::float8, $17))
+     ORDER BY relevance DESC, id DESC
+     LIMIT $19)
+) page
+-- Sorts only the page read by the branch that ran.
 ORDER BY
-    uf.upload_date DESC
+    CASE WHEN $15::text = 'name' AND NOT $16::boolean THEN filename END ASC,
+    CASE WHEN $15::text = 'name' AND $16::boolean THEN filename END DESC,
+    CASE WHEN $15::text = 'mime' AND NOT $16::boolean THEN mime_type END ASC,
+    CASE WHEN $15::text = 'mime' AND $16::boolean THEN mime_type END DESC,
+    CASE WHEN $15::text = 'size' AND NOT $16::boolean THEN size_bytes END ASC,
+    CASE WHEN $15::text = 'size' AND $16::boolean THEN size_bytes END DESC,
+    CASE WHEN $15::text = 'upload_date' AND NOT $16::boolean THEN upload_date END ASC,
+    CASE WHEN $15::text = 'upload_date' AND $16::boolean THEN upload_date END DESC,
+    CASE WHEN $15::text = 'relevance' AND NOT $16::boolean THEN relevance END ASC,
+    CASE WHEN $15::text = 'relevance' AND $16::boolean THEN relevance END DESC,
+    CASE WHEN NOT $16::boolean THEN id END ASC,
+    CASE WHEN $16::boolean THEN id END DESC
 `
 
 type SearchFilesParams struct {
+	Query            pgtype.Text
+	Fuzzy            pgtype.Text
 	IsAdmin          bool
 	RequestingUserID int64
+	Exclude          pgtype.Text
 	Filename         pgtype.Text
 	MimeType         pgtype.Text
 	MinSize          pgtype.Int8
@@ -68,6 +188,15 @@ type SearchFilesParams struct {
 	EndDate          pgtype.Timestamptz
 	Tags             []string
 	UploaderEmail    pgtype.Text
+	FolderID         pgtype.Int8
+	SortKey          string
+	Descending       bool
+	CursorID         pgtype.Int8
+	CursorText       pgtype.Text
+	PageLimit        int32
+	CursorInt        pgtype.Int8
+	CursorTime       pgtype.Timestamptz
+	CursorFloat      pgtype.Float8
 }
 
 type SearchFilesRow struct {
@@ -77,14 +206,22 @@ type SearchFilesRow struct {
 	UploadDate pgtype.Timestamptz
 	SizeBytes  int64
 	OwnerEmail string
+	Relevance  float64
 }
 
 // Performs a comprehensive search and filter operation on user files.
 // This query is optimized with indexes and uses sqlc.narg() for optional parameters.
+// Results are paged like ListUserFiles, and can also be sorted by relevance, which no
+// index can serve.
+// query and exclude are tsquery texts, and fuzzy is matched against filenames by trigram
+// word similarity. See search.ParseQuery.
 func (q *Queries) SearchFiles(ctx context.Context, arg SearchFilesParams) ([]SearchFilesRow, error) {
 	rows, err := q.db.Query(ctx, searchFiles,
+		arg.Query,
+		arg.Fuzzy,
 		arg.IsAdmin,
 		arg.RequestingUserID,
+		arg.Exclude,
 		arg.Filename,
 		arg.MimeType,
 		arg.MinSize,
@@ -93,6 +230,15 @@ func (q *Queries) SearchFiles(ctx context.Context, arg SearchFilesParams) ([]Sea
 		arg.EndDate,
 		arg.Tags,
 		arg.UploaderEmail,
+		arg.FolderID,
+		arg.SortKey,
+		arg.Descending,
+		arg.CursorID,
+		arg.CursorText,
+		arg.PageLimit,
+		arg.CursorInt,
+		arg.CursorTime,
+		arg.CursorFloat,
 	)
 	if err != nil {
 		return nil, err
@@ -108,6 +254,7 @@ func (q *Queries) SearchFiles(ctx context.Context, arg SearchFilesParams) ([]Sea
 			&i.UploadDate,
 			&i.SizeBytes,
 			&i.OwnerEmail,
+			&i.Relevance,
 		); err != nil {
 			return nil, err
 		}
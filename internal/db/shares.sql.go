@@ -11,17 +11,81 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const claimShareDownload = `-- name: ClaimShareDownload :one
+UPDATE shares SET download_count = COALESCE(download_count, 0) + 1
+WHERE id = $1
+  AND (expires_at IS NULL OR expires_at > NOW())
+  AND (max_downloads IS NULL OR COALESCE(download_count, 0) < max_downloads)
+RETURNING download_count
+`
+
+// Atomically counts one download against a share link, but only while the link is unexpired
+// and under its download limit. No row is returned when the download must be refused.
+func (q *Queries) ClaimShareDownload(ctx context.Context, id int64) (pgtype.Int8, error) {
+	row := q.db.QueryRow(ctx, claimShareDownload, id)
+	var download_count pgtype.Int8
+	err := row.Scan(&download_count)
+	return download_count, err
+}
+
+const createPublicFolderShareLink = `-- name: CreatePublicFolderShareLink :one
+INSERT INTO shares (folder_id, share_token, expires_at, max_downloads, password_hash)
+VALUES ($1, $2, $3, $4, $5) RETURNING id, user_file_id, share_token, is_public, download_count, created_at, folder_id, expires_at, max_downloads, password_hash
+`
+
+type CreatePublicFolderShareLinkParams struct {
+	FolderID     pgtype.Int8
+	ShareToken   string
+	ExpiresAt    pgtype.Timestamptz
+	MaxDownloads pgtype.Int8
+	PasswordHash pgtype.Text
+}
+
+func (q *Queries) CreatePublicFolderShareLink(ctx context.Context, arg CreatePublicFolderShareLinkParams) (Share, error) {
+	row := q.db.QueryRow(ctx, createPublicFolderShareLink,
+		arg.FolderID,
+		arg.ShareToken,
+		arg.ExpiresAt,
+		arg.MaxDownloads,
+		arg.PasswordHash,
+	)
+	var i Share
+	err := row.Scan(
+		&i.ID,
+		&i.UserFileID,
+		&i.ShareToken,
+		&i.IsPublic,
+		&i.DownloadCount,
+		&i.CreatedAt,
+		&i.FolderID,
+		&i.ExpiresAt,
+		&i.MaxDownloads,
+		&i.PasswordHash,
+	)
+	return i, err
+}
+
 const createPublicShareLink = `-- name: CreatePublicShareLink :one
-INSERT INTO shares (user_file_id, share_token) VALUES ($1, $2) RETURNING id, user_file_id, share_token, is_public, download_count, created_at
+INSERT INTO shares (user_file_id, share_token, expires_at, max_downloads, password_hash)
+VALUES ($1, $2, $3, $4, $5) RETURNING id, user_file_id, share_token, is_public, download_count, created_at, folder_id, expires_at, max_downloads, password_hash
 `
 
 type CreatePublicShareLinkParams struct {
-	UserFileID int64
-	ShareToken string
+	UserFileID   pgtype.Int8
+	ShareToken   string
+	ExpiresAt    pgtype.Timestamptz
+	MaxDownloads pgtype.Int8
+	PasswordHash pgtype.Text
 }
 
 func (q *Queries) CreatePublicShareLink(ctx context.Context, arg CreatePublicShareLinkParams) (Share, error) {
-	row := q.db.QueryRow(ctx, createPublicShareLink, arg.UserFileID, arg.ShareToken)
+	row := q.db.QueryRow(ctx, createPublicShareLink,
+		arg.UserFileID,
+		arg.ShareToken,
+		arg.ExpiresAt,
+		arg.MaxDownloads,
+		arg.PasswordHash,
+	)
 	var i Share
 	err := row.Scan(
 		&i.ID,
@@ -30,55 +94,141 @@ func (q *Queries) CreatePublicShareLink(ctx context.Context, arg CreatePublicSha
 		&i.IsPublic,
 		&i.DownloadCount,
 		&i.CreatedAt,
+		&i.FolderID,
+		&i.ExpiresAt,
+		&i.MaxDownloads,
+		&i.PasswordHash,
 	)
 	return i, err
 }
 
+const createShareDownloadGrant = `-- name: CreateShareDownloadGrant :one
+INSERT INTO share_download_grants (share_id, served_through, expires_at)
+VALUES ($1, $2, $3) RETURNING id
+`
+
+type CreateShareDownloadGrantParams struct {
+	ShareID       int64
+	ServedThrough int64
+	ExpiresAt     pgtype.Timestamptz
+}
+
+// Records the grant for a counted download, reserved up to served_through while it is served.
+func (q *Queries) CreateShareDownloadGrant(ctx context.Context, arg CreateShareDownloadGrantParams) (int64, error) {
+	row := q.db.QueryRow(ctx, createShareDownloadGrant, arg.ShareID, arg.ServedThrough, arg.ExpiresAt)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteExpiredShareDownloadGrants = `-- name: DeleteExpiredShareDownloadGrants :execrows
+DELETE FROM share_download_grants WHERE expires_at < NOW()
+`
+
+func (q *Queries) DeleteExpiredShareDownloadGrants(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredShareDownloadGrants)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deletePublicShareLinksByFileID = `-- name: DeletePublicShareLinksByFileID :exec
 DELETE FROM shares
 WHERE user_file_id = $1
 `
 
 // Removes ALL public share links associated with a specific file.
-func (q *Queries) DeletePublicShareLinksByFileID(ctx context.Context, userFileID int64) error {
+func (q *Queries) DeletePublicShareLinksByFileID(ctx context.Context, userFileID pgtype.Int8) error {
 	_, err := q.db.Exec(ctx, deletePublicShareLinksByFileID, userFileID)
 	return err
 }
 
+const deletePublicShareLinksByFolderID = `-- name: DeletePublicShareLinksByFolderID :exec
+DELETE FROM shares
+WHERE folder_id = $1
+`
+
+// Removes ALL public share links associated with a specific folder.
+func (q *Queries) DeletePublicShareLinksByFolderID(ctx context.Context, folderID pgtype.Int8) error {
+	_, err := q.db.Exec(ctx, deletePublicShareLinksByFolderID, folderID)
+	return err
+}
+
 const getPublicShareByFileID = `-- name: GetPublicShareByFileID :one
-SELECT share_token, download_count
+SELECT share_token, download_count, expires_at, max_downloads, (password_hash IS NOT NULL)::boolean AS password_protected
 FROM shares
 WHERE user_file_id = $1 AND is_public = TRUE
 LIMIT 1
 `
 
 type GetPublicShareByFileIDRow struct {
-	ShareToken    string
-	DownloadCount pgtype.Int8
+	ShareToken        string
+	DownloadCount     pgtype.Int8
+	ExpiresAt         pgtype.Timestamptz
+	MaxDownloads      pgtype.Int8
+	PasswordProtected bool
 }
 
 // Gets public share information for a file
-func (q *Queries) GetPublicShareByFileID(ctx context.Context, userFileID int64) (GetPublicShareByFileIDRow, error) {
+func (q *Queries) GetPublicShareByFileID(ctx context.Context, userFileID pgtype.Int8) (GetPublicShareByFileIDRow, error) {
 	row := q.db.QueryRow(ctx, getPublicShareByFileID, userFileID)
 	var i GetPublicShareByFileIDRow
-	err := row.Scan(&i.ShareToken, &i.DownloadCount)
+	err := row.Scan(
+		&i.ShareToken,
+		&i.DownloadCount,
+		&i.ExpiresAt,
+		&i.MaxDownloads,
+		&i.PasswordProtected,
+	)
+	return i, err
+}
+
+const getPublicShareByFolderID = `-- name: GetPublicShareByFolderID :one
+SELECT share_token, download_count, expires_at, max_downloads, (password_hash IS NOT NULL)::boolean AS password_protected
+FROM shares
+WHERE folder_id = $1 AND is_public = TRUE
+LIMIT 1
+`
+
+type GetPublicShareByFolderIDRow struct {
+	ShareToken        string
+	DownloadCount     pgtype.Int8
+	ExpiresAt         pgtype.Timestamptz
+	MaxDownloads      pgtype.Int8
+	PasswordProtected bool
+}
+
+// Gets public share information for a folder
+func (q *Queries) GetPublicShareByFolderID(ctx context.Context, folderID pgtype.Int8) (GetPublicShareByFolderIDRow, error) {
+	row := q.db.QueryRow(ctx, getPublicShareByFolderID, folderID)
+	var i GetPublicShareByFolderIDRow
+	err := row.Scan(
+		&i.ShareToken,
+		&i.DownloadCount,
+		&i.ExpiresAt,
+		&i.MaxDownloads,
+		&i.PasswordProtected,
+	)
 	return i, err
 }
 
 const getShareByToken = `-- name: GetShareByToken :one
-SELECT s.id, s.download_count, uf.filename, pf.storage_path, pf.size_bytes
+SELECT s.id, s.download_count, uf.filename, uf.mime_type, pf.storage_path, pf.size_bytes, pf.sha256_hash
 FROM shares s
 JOIN user_files uf ON s.user_file_id = uf.id
 JOIN physical_files pf ON uf.physical_file_id = pf.id
-WHERE s.share_token = $1 AND s.is_public = TRUE
+WHERE s.share_token = $1 AND s.is_public = TRUE AND uf.deleted_at IS NULL
 `
 
 type GetShareByTokenRow struct {
 	ID            int64
 	DownloadCount pgtype.Int8
 	Filename      string
+	MimeType      string
 	StoragePath   string
 	SizeBytes     int64
+	Sha256Hash    string
 }
 
 // CORRECTED NAME: Changed from GetShareMetaByToken for clarity and consistency.
@@ -89,14 +239,82 @@ func (q *Queries) GetShareByToken(ctx context.Context, shareToken string) (GetSh
 		&i.ID,
 		&i.DownloadCount,
 		&i.Filename,
+		&i.MimeType,
 		&i.StoragePath,
 		&i.SizeBytes,
+		&i.Sha256Hash,
+	)
+	return i, err
+}
+
+const getShareSettingsByToken = `-- name: GetShareSettingsByToken :one
+SELECT id, user_file_id, folder_id, expires_at, max_downloads, password_hash
+FROM shares
+WHERE share_token = $1
+`
+
+type GetShareSettingsByTokenRow struct {
+	ID           int64
+	UserFileID   pgtype.Int8
+	FolderID     pgtype.Int8
+	ExpiresAt    pgtype.Timestamptz
+	MaxDownloads pgtype.Int8
+	PasswordHash pgtype.Text
+}
+
+// Retrieves a share link's settings and the file or folder it points at.
+func (q *Queries) GetShareSettingsByToken(ctx context.Context, shareToken string) (GetShareSettingsByTokenRow, error) {
+	row := q.db.QueryRow(ctx, getShareSettingsByToken, shareToken)
+	var i GetShareSettingsByTokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserFileID,
+		&i.FolderID,
+		&i.ExpiresAt,
+		&i.MaxDownloads,
+		&i.PasswordHash,
+	)
+	return i, err
+}
+
+const getShareTargetByToken = `-- name: GetShareTargetByToken :one
+SELECT id, share_token, user_file_id, folder_id, download_count, expires_at, max_downloads, password_hash, created_at
+FROM shares
+WHERE share_token = $1 AND is_public = TRUE
+`
+
+type GetShareTargetByTokenRow struct {
+	ID            int64
+	ShareToken    string
+	UserFileID    pgtype.Int8
+	FolderID      pgtype.Int8
+	DownloadCount pgtype.Int8
+	ExpiresAt     pgtype.Timestamptz
+	MaxDownloads  pgtype.Int8
+	PasswordHash  pgtype.Text
+	CreatedAt     pgtype.Timestamptz
+}
+
+// Resolves a public share token to the file or folder it points at, along with its restrictions.
+func (q *Queries) GetShareTargetByToken(ctx context.Context, shareToken string) (GetShareTargetByTokenRow, error) {
+	row := q.db.QueryRow(ctx, getShareTargetByToken, shareToken)
+	var i GetShareTargetByTokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.ShareToken,
+		&i.UserFileID,
+		&i.FolderID,
+		&i.DownloadCount,
+		&i.ExpiresAt,
+		&i.MaxDownloads,
+		&i.PasswordHash,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const getSharesForFile = `-- name: GetSharesForFile :many
-SELECT u.id, u.email
+SELECT u.id, u.email, fstu.role
 FROM file_shares_to_users fstu
 JOIN users u ON fstu.shared_with_user_id = u.id
 WHERE fstu.user_file_id = $1
@@ -105,6 +323,7 @@ WHERE fstu.user_file_id = $1
 type GetSharesForFileRow struct {
 	ID    int64
 	Email string
+	Role  string
 }
 
 // Retrieves all user shares for a specific file.
@@ -117,7 +336,7 @@ func (q *Queries) GetSharesForFile(ctx context.Context, userFileID int64) ([]Get
 	var items []GetSharesForFileRow
 	for rows.Next() {
 		var i GetSharesForFileRow
-		if err := rows.Scan(&i.ID, &i.Email); err != nil {
+		if err := rows.Scan(&i.ID, &i.Email, &i.Role); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -128,15 +347,6 @@ func (q *Queries) GetSharesForFile(ctx context.Context, userFileID int64) ([]Get
 	return items, nil
 }
 
-const incrementShareDownloadCount = `-- name: IncrementShareDownloadCount :exec
-UPDATE shares SET download_count = download_count + 1 WHERE id = $1
-`
-
-func (q *Queries) IncrementShareDownloadCount(ctx context.Context, id int64) error {
-	_, err := q.db.Exec(ctx, incrementShareDownloadCount, id)
-	return err
-}
-
 const isFileAlreadySharedWithUser = `-- name: IsFileAlreadySharedWithUser :one
 SELECT EXISTS(
   SELECT 1 FROM file_shares_to_users
@@ -157,23 +367,91 @@ func (q *Queries) IsFileAlreadySharedWithUser(ctx context.Context, arg IsFileAlr
 	return exists, err
 }
 
+const releaseShareDownload = `-- name: ReleaseShareDownload :exec
+UPDATE shares SET download_count = download_count - 1
+WHERE id = $1 AND download_count > 0
+`
+
+// Gives back a download claimed with ClaimShareDownload when the file couldn't be served.
+func (q *Queries) ReleaseShareDownload(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, releaseShareDownload, id)
+	return err
+}
+
+const reserveShareDownloadGrant = `-- name: ReserveShareDownloadGrant :one
+UPDATE share_download_grants g
+SET served_through = GREATEST(prior.served_through, $1)
+FROM (
+    SELECT id, served_through FROM share_download_grants WHERE id = $2 FOR UPDATE
+) prior
+WHERE g.id = prior.id
+  AND g.share_id = $3
+  AND g.expires_at > NOW()
+  AND prior.served_through <= $4
+RETURNING prior.served_through
+`
+
+type ReserveShareDownloadGrantParams struct {
+	RangeEnd         int64
+	ID               int64
+	ShareID          int64
+	MaxServedThrough int64
+}
+
+// Continues a download under an unexpired grant if the grant's responses have got no further
+// than max_served_through, and reserves the grant up to range_end while it is served. Returns
+// how far the grant had got before, or no row if the continuation isn't free.
+func (q *Queries) ReserveShareDownloadGrant(ctx context.Context, arg ReserveShareDownloadGrantParams) (int64, error) {
+	row := q.db.QueryRow(ctx, reserveShareDownloadGrant,
+		arg.RangeEnd,
+		arg.ID,
+		arg.ShareID,
+		arg.MaxServedThrough,
+	)
+	var served_through int64
+	err := row.Scan(&served_through)
+	return served_through, err
+}
+
+const settleShareDownloadGrant = `-- name: SettleShareDownloadGrant :exec
+UPDATE share_download_grants SET served_through = $1
+WHERE id = $2 AND served_through = $3
+`
+
+type SettleShareDownloadGrantParams struct {
+	ServedThrough   int64
+	ID              int64
+	ReservedThrough int64
+}
+
+// Sets how far a grant's responses have got once a response is done, unless another response
+// has reserved the grant since.
+func (q *Queries) SettleShareDownloadGrant(ctx context.Context, arg SettleShareDownloadGrantParams) error {
+	_, err := q.db.Exec(ctx, settleShareDownloadGrant, arg.ServedThrough, arg.ID, arg.ReservedThrough)
+	return err
+}
+
 const shareFileWithUser = `-- name: ShareFileWithUser :exec
 INSERT INTO file_shares_to_users (
   user_file_id,
-  shared_with_user_id
+  shared_with_user_id,
+  role
 ) VALUES (
-  $1, $2
+  $1, $2, $3
 )
+ON CONFLICT (user_file_id, shared_with_user_id) DO UPDATE SET role = EXCLUDED.role
 `
 
 type ShareFileWithUserParams struct {
 	UserFileID       int64
 	SharedWithUserID int64
+	Role             string
 }
 
-// Creates a record in the junction table to share a file with a specific user.
+// Creates a record in the junction table to share a file with a specific user,
+// or changes the role of an existing share.
 func (q *Queries) ShareFileWithUser(ctx context.Context, arg ShareFileWithUserParams) error {
-	_, err := q.db.Exec(ctx, shareFileWithUser, arg.UserFileID, arg.SharedWithUserID)
+	_, err := q.db.Exec(ctx, shareFileWithUser, arg.UserFileID, arg.SharedWithUserID, arg.Role)
 	return err
 }
 
@@ -192,3 +470,39 @@ func (q *Queries) UnshareFileWithUser(ctx context.Context, arg UnshareFileWithUs
 	_, err := q.db.Exec(ctx, unshareFileWithUser, arg.UserFileID, arg.SharedWithUserID)
 	return err
 }
+
+const updateShareSettings = `-- name: UpdateShareSettings :one
+UPDATE shares SET expires_at = $2, max_downloads = $3, password_hash = $4
+WHERE id = $1
+RETURNING id, user_file_id, share_token, is_public, download_count, created_at, folder_id, expires_at, max_downloads, password_hash
+`
+
+type UpdateShareSettingsParams struct {
+	ID           int64
+	ExpiresAt    pgtype.Timestamptz
+	MaxDownloads pgtype.Int8
+	PasswordHash pgtype.Text
+}
+
+func (q *Queries) UpdateShareSettings(ctx context.Context, arg UpdateShareSettingsParams) (Share, error) {
+	row := q.db.QueryRow(ctx, updateShareSettings,
+		arg.ID,
+		arg.ExpiresAt,
+		arg.MaxDownloads,
+		arg.PasswordHash,
+	)
+	var i Share
+	err := row.Scan(
+		&i.ID,
+		&i.UserFileID,
+		&i.ShareToken,
+		&i.IsPublic,
+		&i.DownloadCount,
+		&i.CreatedAt,
+		&i.FolderID,
+		&i.ExpiresAt,
+		&i.MaxDownloads,
+		&i.PasswordHash,
+	)
+	return i, err
+}
@@ -7,10 +7,12 @@ package db
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id, email, password_hash, storage_quota_bytes, storage_used_bytes, created_at
+INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING id, email, password_hash, storage_quota_bytes, storage_used_bytes, created_at, email_verified_at, suspended_at
 `
 
 type CreateUserParams struct {
@@ -28,12 +30,45 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.StorageQuotaBytes,
 		&i.StorageUsedBytes,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
 
+const deleteSharesWithOwner = `-- name: DeleteSharesWithOwner :exec
+WITH deleted_file_shares AS (
+    DELETE FROM file_shares_to_users fs
+    USING user_files uf
+    WHERE fs.user_file_id = uf.id AND uf.owner_id = $1 AND fs.shared_with_user_id = $1
+)
+DELETE FROM folder_shares_to_users fs
+USING folders f
+WHERE fs.folder_id = f.id AND f.owner_id = $1 AND fs.shared_with_user_id = $1
+`
+
+// Removes user shares of a user's own files and folders, which a transfer can leave behind.
+func (q *Queries) DeleteSharesWithOwner(ctx context.Context, ownerID int64) error {
+	_, err := q.db.Exec(ctx, deleteSharesWithOwner, ownerID)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1
+`
+
+// Everything the user owns goes with them through ON DELETE CASCADE, so their files must be
+// purged or transferred first to keep physical file reference counts right.
+func (q *Queries) DeleteUser(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, password_hash, storage_quota_bytes, storage_used_bytes, created_at FROM users WHERE email = $1 LIMIT 1
+SELECT id, email, password_hash, storage_quota_bytes, storage_used_bytes, created_at, email_verified_at, suspended_at FROM users WHERE email = $1 LIMIT 1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -46,12 +81,14 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.StorageQuotaBytes,
 		&i.StorageUsedBytes,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, email, password_hash, storage_quota_bytes, storage_used_bytes, created_at FROM users WHERE id = $1 LIMIT 1
+SELECT id, email, password_hash, storage_quota_bytes, storage_used_bytes, created_at, email_verified_at, suspended_at FROM users WHERE id = $1 LIMIT 1
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
@@ -64,10 +101,237 @@ func (q *Queries) GetUserByID(ctx context.Context, id int64) (User, error) {
 		&i.StorageQuotaBytes,
 		&i.StorageUsedBytes,
 		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUserFileCounts = `-- name: GetUserFileCounts :one
+SELECT
+    COUNT(*) FILTER (WHERE deleted_at IS NULL)::bigint AS file_count,
+    COUNT(*) FILTER (WHERE deleted_at IS NOT NULL)::bigint AS trashed_file_count
+FROM user_files
+WHERE owner_id = $1
+`
+
+type GetUserFileCountsRow struct {
+	FileCount        int64
+	TrashedFileCount int64
+}
+
+func (q *Queries) GetUserFileCounts(ctx context.Context, ownerID int64) (GetUserFileCountsRow, error) {
+	row := q.db.QueryRow(ctx, getUserFileCounts, ownerID)
+	var i GetUserFileCountsRow
+	err := row.Scan(&i.FileCount, &i.TrashedFileCount)
+	return i, err
+}
+
+const getUserSuspension = `-- name: GetUserSuspension :one
+SELECT suspended_at FROM users WHERE id = $1
+`
+
+// Used on every authenticated request to turn away suspended users.
+func (q *Queries) GetUserSuspension(ctx context.Context, id int64) (pgtype.Timestamptz, error) {
+	row := q.db.QueryRow(ctx, getUserSuspension, id)
+	var suspended_at pgtype.Timestamptz
+	err := row.Scan(&suspended_at)
+	return suspended_at, err
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT
+    u.id,
+    u.email,
+    u.storage_quota_bytes,
+    u.storage_used_bytes,
+    u.created_at,
+    u.email_verified_at,
+    u.suspended_at,
+    COALESCE((
+        SELECT array_agg(r.name ORDER BY r.name)
+        FROM user_roles ur JOIN roles r ON ur.role_id = r.id
+        WHERE ur.user_id = u.id
+    ), '{}')::text[] AS roles,
+    COUNT(*) OVER () AS total_count
+FROM users u
+WHERE ($1::text IS NULL OR u.email ILIKE '%' || $1 || '%')
+  AND ($2::text IS NULL OR EXISTS (
+        SELECT 1 FROM user_roles ur JOIN roles r ON ur.role_id = r.id
+        WHERE ur.user_id = u.id AND r.name = $2
+      ))
+  AND ($3::boolean IS NULL OR (u.suspended_at IS NOT NULL) = $3)
+ORDER BY u.id
+LIMIT $4 OFFSET $5
+`
+
+type ListUsersParams struct {
+	Email      pgtype.Text
+	Role       pgtype.Text
+	Suspended  pgtype.Bool
+	PageLimit  int32
+	PageOffset int32
+}
+
+type ListUsersRow struct {
+	ID                int64
+	Email             string
+	StorageQuotaBytes int64
+	StorageUsedBytes  int64
+	CreatedAt         pgtype.Timestamptz
+	EmailVerifiedAt   pgtype.Timestamptz
+	SuspendedAt       pgtype.Timestamptz
+	Roles             []string
+	TotalCount        int64
+}
+
+// For admin use: a page of users matching the optional filters, with their roles and the total
+// number of matches.
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
+	rows, err := q.db.Query(ctx, listUsers,
+		arg.Email,
+		arg.Role,
+		arg.Suspended,
+		arg.PageLimit,
+		arg.PageOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		var i ListUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.StorageQuotaBytes,
+			&i.StorageUsedBytes,
+			&i.CreatedAt,
+			&i.EmailVerifiedAt,
+			&i.SuspendedAt,
+			&i.Roles,
+			&i.TotalCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markUserEmailVerified = `-- name: MarkUserEmailVerified :exec
+UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1
+`
+
+func (q *Queries) MarkUserEmailVerified(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markUserEmailVerified, id)
+	return err
+}
+
+const reactivateUser = `-- name: ReactivateUser :execrows
+UPDATE users SET suspended_at = NULL WHERE id = $1 AND suspended_at IS NOT NULL
+`
+
+func (q *Queries) ReactivateUser(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.Exec(ctx, reactivateUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setUserStorageQuota = `-- name: SetUserStorageQuota :one
+UPDATE users SET storage_quota_bytes = $2 WHERE id = $1 RETURNING id, email, password_hash, storage_quota_bytes, storage_used_bytes, created_at, email_verified_at, suspended_at
+`
+
+type SetUserStorageQuotaParams struct {
+	ID                int64
+	StorageQuotaBytes int64
+}
+
+func (q *Queries) SetUserStorageQuota(ctx context.Context, arg SetUserStorageQuotaParams) (User, error) {
+	row := q.db.QueryRow(ctx, setUserStorageQuota, arg.ID, arg.StorageQuotaBytes)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.StorageQuotaBytes,
+		&i.StorageUsedBytes,
+		&i.CreatedAt,
+		&i.EmailVerifiedAt,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
 
+const suspendUser = `-- name: SuspendUser :execrows
+UPDATE users SET suspended_at = NOW() WHERE id = $1 AND suspended_at IS NULL
+`
+
+func (q *Queries) SuspendUser(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.Exec(ctx, suspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const transferUserFiles = `-- name: TransferUserFiles :exec
+UPDATE user_files
+SET owner_id = $1, folder_id = COALESCE(folder_id, $2)
+WHERE owner_id = $3
+`
+
+type TransferUserFilesParams struct {
+	ToUserID     int64
+	RootFolderID pgtype.Int8
+	FromUserID   int64
+}
+
+// Hands all of a user's files, including those in the trash, to another user. Files at the top
+// level are put in root_folder_id.
+func (q *Queries) TransferUserFiles(ctx context.Context, arg TransferUserFilesParams) error {
+	_, err := q.db.Exec(ctx, transferUserFiles, arg.ToUserID, arg.RootFolderID, arg.FromUserID)
+	return err
+}
+
+const transferUserFolders = `-- name: TransferUserFolders :exec
+UPDATE folders
+SET owner_id = $1, parent_id = COALESCE(parent_id, $2)
+WHERE owner_id = $3
+`
+
+type TransferUserFoldersParams struct {
+	ToUserID     int64
+	RootFolderID pgtype.Int8
+	FromUserID   int64
+}
+
+// Hands all of a user's folders to another user. Their top-level folders are put under root_folder_id.
+func (q *Queries) TransferUserFolders(ctx context.Context, arg TransferUserFoldersParams) error {
+	_, err := q.db.Exec(ctx, transferUserFolders, arg.ToUserID, arg.RootFolderID, arg.FromUserID)
+	return err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users SET password_hash = $2 WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID           int64
+	PasswordHash string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.PasswordHash)
+	return err
+}
+
 const updateUserStorageUsage = `-- name: UpdateUserStorageUsage :exec
 UPDATE users
 SET storage_used_bytes = storage_used_bytes + $1
@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	_ Sender = (*LogSender)(nil)
+	_ Sender = (*FileSender)(nil)
+)
+
+// LogSender writes email to the server log instead of sending it. It is the default. Bodies
+// carry verification and password reset links, which let anyone who can read the log take
+// over the account, so they are only logged when includeBody is set for development.
+type LogSender struct {
+	includeBody bool
+}
+
+// NewLogSender creates a sender that logs the recipient and subject of every message, and
+// its body if includeBody is set.
+func NewLogSender(includeBody bool) *LogSender {
+	return &LogSender{includeBody: includeBody}
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	if !s.includeBody {
+		log.Printf("EMAIL to %s: %s (body not logged, set MAIL_LOG_BODY=true to include it)", msg.To, msg.Subject)
+		return nil
+	}
+	log.Printf("EMAIL to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileSender saves each email as a .eml file in a directory, where it can be opened with a
+// mail client.
+type FileSender struct {
+	dir  string
+	from string
+}
+
+// NewFileSender creates a sender that writes to dir, creating the directory if needed.
+func NewFileSender(dir, from string) *FileSender {
+	if dir == "" {
+		log.Fatal("Mail directory is not set")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		log.Fatalf("Failed to create mail directory: %v", err)
+	}
+	return &FileSender{dir: dir, from: from}
+}
+
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	body, err := format(s.from, msg)
+	if err != nil {
+		return err
+	}
+	recipient := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == filepath.Separator {
+			return '_'
+		}
+		return r
+	}, msg.To)
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + recipient + ".eml"
+	return os.WriteFile(filepath.Join(s.dir, name), body, 0o640)
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+var _ Sender = (*SMTPSender)(nil)
+
+// SMTPConfig holds the settings for sending through an SMTP server.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPSender delivers email through an SMTP server. STARTTLS is used whenever the server
+// offers it, and credentials are only sent over TLS or to localhost.
+type SMTPSender struct {
+	config SMTPConfig
+}
+
+// NewSMTPSender creates a sender for the given server.
+func NewSMTPSender(config SMTPConfig) *SMTPSender {
+	return &SMTPSender{config: config}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	body, err := format(s.config.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+
+	// net/smtp has no context support, so a cancelled context only stops us from waiting.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, s.config.From, []string{msg.To}, body)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
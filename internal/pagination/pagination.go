@@ -0,0 +1,218 @@
+// Package pagination implements the cursor-based (keyset) paging shared by the list endpoints.
+// A page ends with an opaque cursor naming its last row; the next page is the rows after it in
+// the same order. Unlike offsets, cursors stay correct while rows are added and removed. Where
+// a listing's query has an index on (sort column, id), the database can also seek to the cursor
+// instead of counting past every earlier row; sorting by size or relevance has no such index.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Sort keys. File listings can be sorted by name, size, upload date or MIME type, and search
+// results also by relevance; audit logs by timestamp.
+const (
+	SortName       = "name"
+	SortSize       = "size"
+	SortUploadDate = "upload_date"
+	SortMimeType   = "mime"
+	SortRelevance  = "relevance"
+	SortTimestamp  = "timestamp"
+)
+
+// FileSorts maps the sort keys of file listings to whether they sort in descending order by
+// default, so that the newest and largest files come first and names and types are A to Z.
+var FileSorts = map[string]bool{
+	SortName:       false,
+	SortSize:       true,
+	SortUploadDate: true,
+	SortMimeType:   false,
+}
+
+// SearchSorts maps the sort keys of search results to their default order: those of file
+// listings, plus relevance, best match first.
+var SearchSorts = map[string]bool{
+	SortName:       false,
+	SortSize:       true,
+	SortUploadDate: true,
+	SortMimeType:   false,
+	SortRelevance:  true,
+}
+
+// AuditLogSorts maps the sort keys of audit logs to their default order: newest first.
+var AuditLogSorts = map[string]bool{
+	SortTimestamp: true,
+}
+
+var (
+	ErrInvalidLimit  = errors.New("limit must be a positive number")
+	ErrInvalidSort   = errors.New("invalid sort key")
+	ErrInvalidOrder  = errors.New("order must be 'asc' or 'desc'")
+	ErrInvalidCursor = errors.New("invalid cursor; it may be for a different sort order")
+)
+
+// Sort is the order of a listing. Rows with the same sort value are ordered by ID, in the same
+// direction.
+type Sort struct {
+	Key        string
+	Descending bool
+}
+
+// Key is a row's position in a listing: its value for the sort key, in whichever field suits
+// the key's type, and its ID.
+type Key struct {
+	Text  string    `json:"t,omitempty"`
+	Int   int64     `json:"n,omitempty"`
+	Float float64   `json:"f,omitempty"`
+	Time  time.Time `json:"ts,omitzero"`
+	ID    int64     `json:"id"`
+}
+
+// cursor is what an encoded cursor holds. It names its sort so that it can't be used with
+// another one.
+type cursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Key
+}
+
+// Request asks for one page of a listing.
+type Request struct {
+	Limit int32
+	Sort  Sort
+	// After is the last row of the previous page, or nil for the first page.
+	After *Key
+}
+
+// ParseRequest builds a Request from the limit, cursor, sort and order query parameters. sorts
+// maps the listing's sort keys to their default order; an empty sort means defaultSort. A
+// cursor must be used with the sort and order it was issued for.
+func ParseRequest(limit, cursorParam, sortKey, order string, sorts map[string]bool, defaultSort string) (Request, error) {
+	req := Request{Limit: DefaultLimit}
+	if limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil || n <= 0 {
+			return Request{}, ErrInvalidLimit
+		}
+		req.Limit = int32(min(n, MaxLimit))
+	}
+
+	if sortKey == "" {
+		sortKey = defaultSort
+	}
+	descending, ok := sorts[sortKey]
+	if !ok {
+		return Request{}, ErrInvalidSort
+	}
+	switch order {
+	case "":
+	case "asc":
+		descending = false
+	case "desc":
+		descending = true
+	default:
+		return Request{}, ErrInvalidOrder
+	}
+	req.Sort = Sort{Key: sortKey, Descending: descending}
+
+	if cursorParam != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursorParam)
+		if err != nil {
+			return Request{}, ErrInvalidCursor
+		}
+		var c cursor
+		if err := json.Unmarshal(raw, &c); err != nil || c.Sort != req.Sort.Key || c.Descending != req.Sort.Descending {
+			return Request{}, ErrInvalidCursor
+		}
+		req.After = &c.Key
+	}
+	return req, nil
+}
+
+// FetchLimit is how many rows to query for the page: one more than it holds, which shows
+// whether there is a next page.
+func (r Request) FetchLimit() int32 {
+	return r.Limit + 1
+}
+
+// CursorID, CursorText, CursorInt, CursorFloat and CursorTime are the previous page's last
+// row as query parameters. They are all NULL for the first page.
+func (r Request) CursorID() pgtype.Int8 {
+	if r.After == nil {
+		return pgtype.Int8{}
+	}
+	return pgtype.Int8{Int64: r.After.ID, Valid: true}
+}
+
+func (r Request) CursorText() pgtype.Text {
+	if r.After == nil {
+		return pgtype.Text{}
+	}
+	return pgtype.Text{String: r.After.Text, Valid: true}
+}
+
+func (r Request) CursorInt() pgtype.Int8 {
+	if r.After == nil {
+		return pgtype.Int8{}
+	}
+	return pgtype.Int8{Int64: r.After.Int, Valid: true}
+}
+
+func (r Request) CursorFloat() pgtype.Float8 {
+	if r.After == nil {
+		return pgtype.Float8{}
+	}
+	return pgtype.Float8{Float64: r.After.Float, Valid: true}
+}
+
+func (r Request) CursorTime() pgtype.Timestamptz {
+	if r.After == nil {
+		return pgtype.Timestamptz{}
+	}
+	return pgtype.Timestamptz{Time: r.After.Time, Valid: true}
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// NewPage makes a page out of rows queried with r.FetchLimit(). key gives a row's position
+// in r.Sort.
+func NewPage[T any](r Request, rows []T, key func(T) Key) Page[T] {
+	if rows == nil {
+		rows = []T{}
+	}
+	if len(rows) <= int(r.Limit) {
+		return Page[T]{Items: rows}
+	}
+	rows = rows[:r.Limit]
+	raw, _ := json.Marshal(cursor{Sort: r.Sort.Key, Descending: r.Sort.Descending, Key: key(rows[len(rows)-1])})
+	return Page[T]{Items: rows, NextCursor: base64.RawURLEncoding.EncodeToString(raw)}
+}
+
+// FileKey is a file's position in a listing sorted by sortKey.
+func FileKey(sortKey string, id int64, name, mimeType string, size int64, uploadDate pgtype.Timestamptz) Key {
+	switch sortKey {
+	case SortName:
+		return Key{Text: name, ID: id}
+	case SortMimeType:
+		return Key{Text: mimeType, ID: id}
+	case SortSize:
+		return Key{Int: size, ID: id}
+	default:
+		return Key{Time: uploadDate.Time, ID: id}
+	}
+}
@@ -0,0 +1,161 @@
+package pagination
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   string
+		sort    string
+		order   string
+		want    Request
+		wantErr error
+	}{
+		{"defaults", "", "", "", Request{Limit: DefaultLimit, Sort: Sort{Key: SortUploadDate, Descending: true}}, nil},
+		{"default order of a key", "", SortName, "", Request{Limit: DefaultLimit, Sort: Sort{Key: SortName}}, nil},
+		{"ascending", "", SortSize, "asc", Request{Limit: DefaultLimit, Sort: Sort{Key: SortSize}}, nil},
+		{"descending", "", SortName, "desc", Request{Limit: DefaultLimit, Sort: Sort{Key: SortName, Descending: true}}, nil},
+		{"limit", "10", "", "", Request{Limit: 10, Sort: Sort{Key: SortUploadDate, Descending: true}}, nil},
+		{"limit above the maximum", "1000", "", "", Request{Limit: MaxLimit, Sort: Sort{Key: SortUploadDate, Descending: true}}, nil},
+		{"zero limit", "0", "", "", Request{}, ErrInvalidLimit},
+		{"negative limit", "-5", "", "", Request{}, ErrInvalidLimit},
+		{"limit not a number", "ten", "", "", Request{}, ErrInvalidLimit},
+		{"limit out of range", "99999999999", "", "", Request{}, ErrInvalidLimit},
+		{"unknown sort", "", "owner", "", Request{}, ErrInvalidSort},
+		{"sort of another listing", "", SortRelevance, "", Request{}, ErrInvalidSort},
+		{"unknown order", "", "", "up", Request{}, ErrInvalidOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRequest(tt.limit, "", tt.sort, tt.order, FileSorts, SortUploadDate)
+			if err != tt.wantErr {
+				t.Fatalf("ParseRequest error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRequest = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// row stands in for a listing's query row.
+type row struct {
+	id       int64
+	name     string
+	size     int64
+	uploaded time.Time
+}
+
+func rowKey(sortKey string) func(row) Key {
+	return func(r row) Key {
+		return FileKey(sortKey, r.id, r.name, "text/plain", r.size, pgtype.Timestamptz{Time: r.uploaded, Valid: true})
+	}
+}
+
+// rows returns n rows with IDs 1..n.
+func rows(n int) []row {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 678901000, time.UTC)
+	out := make([]row, n)
+	for i := range out {
+		out[i] = row{id: int64(i + 1), name: string(rune('a' + i)), size: int64(100 * (i + 1)), uploaded: start.Add(time.Duration(i) * time.Hour)}
+	}
+	return out
+}
+
+func TestNewPage(t *testing.T) {
+	req := Request{Limit: 3, Sort: Sort{Key: SortName}}
+	tests := []struct {
+		name       string
+		rows       []row
+		wantItems  int
+		wantCursor bool
+	}{
+		{"no rows", nil, 0, false},
+		{"fewer rows than the limit", rows(2), 2, false},
+		{"as many rows as the limit", rows(3), 3, false},
+		{"one row more than the limit", rows(4), 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := NewPage(req, tt.rows, rowKey(SortName))
+			if page.Items == nil {
+				t.Error("items should be empty rather than nil, so they encode as []")
+			}
+			if len(page.Items) != tt.wantItems || (page.NextCursor != "") != tt.wantCursor {
+				t.Errorf("page has %d items and cursor %q, want %d items and a cursor: %v",
+					len(page.Items), page.NextCursor, tt.wantItems, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, sortKey := range []string{SortName, SortSize, SortUploadDate, SortMimeType} {
+		for _, order := range []string{"asc", "desc"} {
+			t.Run(sortKey+" "+order, func(t *testing.T) {
+				req, err := ParseRequest("3", "", sortKey, order, FileSorts, SortUploadDate)
+				if err != nil {
+					t.Fatal(err)
+				}
+				page := NewPage(req, rows(4), rowKey(sortKey))
+
+				next, err := ParseRequest("3", page.NextCursor, sortKey, order, FileSorts, SortUploadDate)
+				if err != nil {
+					t.Fatalf("cursor %q: %v", page.NextCursor, err)
+				}
+				want := rowKey(sortKey)(rows(4)[2])
+				if next.After == nil || next.After.ID != want.ID || next.After.Text != want.Text ||
+					next.After.Int != want.Int || !next.After.Time.Equal(want.Time) {
+					t.Errorf("cursor decodes to %+v, want %+v", next.After, want)
+				}
+				if got := next.CursorID(); !got.Valid || got.Int64 != 3 {
+					t.Errorf("CursorID = %+v, want 3", got)
+				}
+			})
+		}
+	}
+
+	// The first page has no cursor.
+	req, err := ParseRequest("", "", "", "", FileSorts, SortUploadDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.After != nil || req.CursorID().Valid || req.CursorText().Valid || req.CursorInt().Valid ||
+		req.CursorFloat().Valid || req.CursorTime().Valid {
+		t.Errorf("first page request has a cursor: %+v", req)
+	}
+}
+
+func TestCursorRejectedForOtherSort(t *testing.T) {
+	req, err := ParseRequest("3", "", SortName, "asc", FileSorts, SortUploadDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := NewPage(req, rows(4), rowKey(SortName)).NextCursor
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   string
+		order  string
+	}{
+		{"other sort key", cursor, SortMimeType, "asc"},
+		{"other order", cursor, SortName, "desc"},
+		{"default sort", cursor, "", ""},
+		{"not base64", "not a cursor!", SortName, "asc"},
+		{"not JSON", "bm90IEpTT04", SortName, "asc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRequest("3", tt.cursor, tt.sort, tt.order, FileSorts, SortUploadDate); err != ErrInvalidCursor {
+				t.Errorf("ParseRequest error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
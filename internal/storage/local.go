@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ Backend = (*LocalBackend)(nil)
+
+// LocalBackend stores objects as plain files under a root directory.
+// It is intended for small single-node deployments that don't want to run MinIO.
+type LocalBackend struct {
+	root string
+}
+
+// NewLocalBackend creates a filesystem backend rooted at root, creating the directory if needed.
+func NewLocalBackend(root string) *LocalBackend {
+	if root == "" {
+		log.Fatal("Local storage root directory is not set")
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		log.Fatalf("Failed to create local storage directory: %v", err)
+	}
+	return &LocalBackend{root: root}
+}
+
+// path resolves an object name to a file path, refusing names that escape the root.
+func (b *LocalBackend) path(objectName string) (string, error) {
+	cleaned := filepath.Clean("/" + filepath.FromSlash(objectName))
+	if cleaned == string(filepath.Separator) {
+		return "", errors.New("invalid object name")
+	}
+	return filepath.Join(b.root, cleaned), nil
+}
+
+// Save writes the object to a temporary file first and renames it into place,
+// so a failed upload never leaves a truncated object behind.
+func (b *LocalBackend) Save(ctx context.Context, objectName string, data io.Reader, size int64, contentType string) error {
+	path, err := b.path(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get opens the object's file for reading. Directories hold the objects under a prefix and
+// are not objects themselves.
+func (b *LocalBackend) Get(ctx context.Context, objectName string) (Object, error) {
+	path, err := b.path(objectName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, ErrObjectNotFound
+	}
+	return f, nil
+}
+
+// Delete removes the object's file.
+func (b *LocalBackend) Delete(ctx context.Context, objectName string) error {
+	path, err := b.path(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Stat returns the object's size and modification time.
+// The filesystem does not record a content type, so ContentType is left empty.
+func (b *LocalBackend) Stat(ctx context.Context, objectName string) (*ObjectInfo, error) {
+	path, err := b.path(objectName)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrObjectNotFound
+	}
+	return &ObjectInfo{Key: objectName, Size: fi.Size(), LastModified: fi.ModTime()}, nil
+}
+
+// List walks the root directory and returns every object whose key starts with prefix.
+func (b *LocalBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: fi.Size(), LastModified: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
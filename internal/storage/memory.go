@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+// MemoryBackend keeps every object in process memory.
+// It is meant for tests and throwaway instances; nothing survives a restart.
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// NewMemoryBackend creates an empty in-memory backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{objects: make(map[string]memoryObject)}
+}
+
+// Save reads data fully into memory and stores it under objectName.
+func (b *MemoryBackend) Save(ctx context.Context, objectName string, data io.Reader, size int64, contentType string) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[objectName] = memoryObject{data: content, contentType: contentType, lastModified: time.Now()}
+	return nil
+}
+
+// Get returns a reader over a snapshot of the object's content.
+func (b *MemoryBackend) Get(ctx context.Context, objectName string) (Object, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	obj, ok := b.objects[objectName]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return nopCloser{bytes.NewReader(obj.data)}, nil
+}
+
+// Delete removes an object from memory.
+func (b *MemoryBackend) Delete(ctx context.Context, objectName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, objectName)
+	return nil
+}
+
+// Stat returns the metadata of a stored object.
+func (b *MemoryBackend) Stat(ctx context.Context, objectName string) (*ObjectInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	obj, ok := b.objects[objectName]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return &ObjectInfo{
+		Key:          objectName,
+		Size:         int64(len(obj.data)),
+		ContentType:  obj.contentType,
+		LastModified: obj.lastModified,
+	}, nil
+}
+
+// List returns every object whose key starts with prefix, sorted by key.
+func (b *MemoryBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var objects []ObjectInfo
+	for key, obj := range b.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         int64(len(obj.data)),
+			ContentType:  obj.contentType,
+			LastModified: obj.lastModified,
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// nopCloser adds a no-op Close to a bytes.Reader so it satisfies Object.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
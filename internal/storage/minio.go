@@ -18,7 +18,9 @@ type Config struct {
 	UseSSL          bool
 }
 
-// Client is a wrapper around the MinIO client that provides our application's storage methods.
+var _ Backend = (*Client)(nil)
+
+// Client is the MinIO implementation of Backend.
 type Client struct {
 	minioClient *minio.Client
 	bucketName  string
@@ -65,12 +67,61 @@ func (c *Client) Save(ctx context.Context, objectName string, data io.Reader, si
 }
 
 // Get retrieves a file object from the MinIO bucket.
-// CORRECTED: Return type is now *minio.Object to give access to the Stat() method.
-func (c *Client) Get(ctx context.Context, objectName string) (*minio.Object, error) {
-	return c.minioClient.GetObject(ctx, c.bucketName, objectName, minio.GetObjectOptions{})
+// The returned *minio.Object is seekable, so it satisfies Object directly.
+func (c *Client) Get(ctx context.Context, objectName string) (Object, error) {
+	object, err := c.minioClient.GetObject(ctx, c.bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, translateMinioError(err)
+	}
+	// GetObject doesn't contact MinIO until the object is first used, so a missing object
+	// would only show up as a failed read after the response has started. Stat now instead.
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, translateMinioError(err)
+	}
+	return object, nil
 }
 
 // Delete removes a file object from the MinIO bucket.
 func (c *Client) Delete(ctx context.Context, objectName string) error {
 	return c.minioClient.RemoveObject(ctx, c.bucketName, objectName, minio.RemoveObjectOptions{})
 }
+
+// Stat returns the metadata MinIO holds for an object.
+func (c *Client) Stat(ctx context.Context, objectName string) (*ObjectInfo, error) {
+	info, err := c.minioClient.StatObject(ctx, c.bucketName, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, translateMinioError(err)
+	}
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// List returns every object in the bucket whose key starts with prefix.
+func (c *Client) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for info := range c.minioClient.ListObjects(ctx, c.bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          info.Key,
+			Size:         info.Size,
+			ContentType:  info.ContentType,
+			LastModified: info.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// translateMinioError maps MinIO's "no such key" response onto ErrObjectNotFound.
+func translateMinioError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrObjectNotFound
+	}
+	return err
+}
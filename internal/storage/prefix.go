@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+var _ Backend = (*PrefixBackend)(nil)
+
+// PrefixBackend keeps its objects under a fixed key prefix of another backend, so that
+// several kinds of object can share one bucket or directory without their keys colliding.
+// Keys passed to and returned by it don't include the prefix.
+type PrefixBackend struct {
+	backend Backend
+	prefix  string
+}
+
+// NewPrefixBackend creates a backend that stores its objects in backend under prefix.
+func NewPrefixBackend(backend Backend, prefix string) *PrefixBackend {
+	return &PrefixBackend{backend: backend, prefix: prefix}
+}
+
+// Save stores the object under the prefix.
+func (b *PrefixBackend) Save(ctx context.Context, objectName string, data io.Reader, size int64, contentType string) error {
+	return b.backend.Save(ctx, b.prefix+objectName, data, size, contentType)
+}
+
+// Get opens the object stored under the prefix.
+func (b *PrefixBackend) Get(ctx context.Context, objectName string) (Object, error) {
+	return b.backend.Get(ctx, b.prefix+objectName)
+}
+
+// Delete removes the object stored under the prefix.
+func (b *PrefixBackend) Delete(ctx context.Context, objectName string) error {
+	return b.backend.Delete(ctx, b.prefix+objectName)
+}
+
+// Stat returns the metadata of the object stored under the prefix.
+func (b *PrefixBackend) Stat(ctx context.Context, objectName string) (*ObjectInfo, error) {
+	info, err := b.backend.Stat(ctx, b.prefix+objectName)
+	if err != nil {
+		return nil, err
+	}
+	info.Key = strings.TrimPrefix(info.Key, b.prefix)
+	return info, nil
+}
+
+// List returns every object under the prefix whose key starts with prefix.
+func (b *PrefixBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects, err := b.backend.List(ctx, b.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range objects {
+		objects[i].Key = strings.TrimPrefix(objects[i].Key, b.prefix)
+	}
+	return objects, nil
+}
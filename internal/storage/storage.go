@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrObjectNotFound is returned by a Backend when the requested object does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object without reading its content.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Object is a readable, seekable handle on a stored object.
+// Seeking lets callers serve partial content without re-fetching the object.
+type Object interface {
+	io.ReadSeekCloser
+}
+
+// Backend is the set of operations the services need from object storage.
+// Every implementation stores objects under flat string keys; a "/" in a key
+// is treated as a prefix separator by List.
+type Backend interface {
+	// Save stores data under objectName. size may be -1 if it is not known up front.
+	Save(ctx context.Context, objectName string, data io.Reader, size int64, contentType string) error
+	// Get opens an object for reading. The caller must close it.
+	Get(ctx context.Context, objectName string) (Object, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, objectName string) error
+	// Stat returns the metadata of an object, or ErrObjectNotFound.
+	Stat(ctx context.Context, objectName string) (*ObjectInfo, error)
+	// List returns the metadata of every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testBackend checks the behaviour every Backend must share.
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+	save := func(key, content string) {
+		t.Helper()
+		if err := b.Save(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+			t.Fatalf("Save(%q): %v", key, err)
+		}
+	}
+	read := func(key string) string {
+		t.Helper()
+		obj, err := b.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		defer obj.Close()
+		content, err := io.ReadAll(obj)
+		if err != nil {
+			t.Fatalf("reading %q: %v", key, err)
+		}
+		return string(content)
+	}
+
+	save("a/1.txt", "hello")
+	save("a/2.txt", "world!")
+	save("b/1.txt", "other")
+
+	if got := read("a/1.txt"); got != "hello" {
+		t.Errorf("Get(a/1.txt) = %q, want %q", got, "hello")
+	}
+	save("a/1.txt", "replaced")
+	if got := read("a/1.txt"); got != "replaced" {
+		t.Errorf("after saving again, Get(a/1.txt) = %q, want %q", got, "replaced")
+	}
+
+	// Objects are seekable, so that ranges can be served.
+	obj, err := b.Get(ctx, "a/2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(obj); string(rest) != "ld!" {
+		t.Errorf("after seeking to 3, read %q, want %q", rest, "ld!")
+	}
+	obj.Close()
+
+	info, err := b.Stat(ctx, "a/2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "a/2.txt" || info.Size != 6 || info.LastModified.IsZero() {
+		t.Errorf("Stat(a/2.txt) = %+v", info)
+	}
+
+	list := func(prefix string) []string {
+		t.Helper()
+		objects, err := b.List(ctx, prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", prefix, err)
+		}
+		var keys []string
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+		}
+		return keys
+	}
+	for prefix, want := range map[string][]string{
+		"":     {"a/1.txt", "a/2.txt", "b/1.txt"},
+		"a/":   {"a/1.txt", "a/2.txt"},
+		"b/1":  {"b/1.txt"},
+		"none": nil,
+	} {
+		if got := list(prefix); !reflect.DeepEqual(got, want) {
+			t.Errorf("List(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+
+	if err := b.Delete(ctx, "a/1.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "a/1.txt"); err != nil {
+		t.Errorf("deleting a missing object: %v", err)
+	}
+	if got := list("a/"); !reflect.DeepEqual(got, []string{"a/2.txt"}) {
+		t.Errorf("after Delete, List(a/) = %q", got)
+	}
+
+	for _, key := range []string{"a/1.txt", "missing", "a/"} {
+		if _, err := b.Get(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Get(%q) error = %v, want %v", key, err, ErrObjectNotFound)
+		}
+		if _, err := b.Stat(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("Stat(%q) error = %v, want %v", key, err, ErrObjectNotFound)
+		}
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemoryBackend())
+}
+
+func TestMemoryBackendKeepsContentType(t *testing.T) {
+	b := NewMemoryBackend()
+	if err := b.Save(context.Background(), "x.pdf", strings.NewReader("%PDF"), -1, "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := b.Stat(context.Background(), "x.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "application/pdf" || info.Size != 4 {
+		t.Errorf("Stat = %+v", info)
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	testBackend(t, NewLocalBackend(t.TempDir()))
+}
+
+func TestLocalBackendStaysInsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	b := NewLocalBackend(root)
+	ctx := context.Background()
+
+	if err := b.Save(ctx, "../outside.txt", strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("an object was written outside the root: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside.txt")); err != nil {
+		t.Errorf("the object should be stored inside the root: %v", err)
+	}
+
+	for _, key := range []string{"", "/", ".."} {
+		if err := b.Save(ctx, key, strings.NewReader("x"), 1, ""); err == nil {
+			t.Errorf("Save(%q) should fail", key)
+		}
+	}
+}
+
+func TestLocalBackendListSkipsPartialUploads(t *testing.T) {
+	root := t.TempDir()
+	b := NewLocalBackend(root)
+	if err := os.WriteFile(filepath.Join(root, ".upload-123"), []byte("partial"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := b.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("List = %+v, want no objects", objects)
+	}
+}
@@ -0,0 +1,3 @@
+-- This migration rolls back the resumable upload tracking table.
+DROP INDEX IF EXISTS idx_uploads_expires_at;
+DROP TABLE IF EXISTS uploads;
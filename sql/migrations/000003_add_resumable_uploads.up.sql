@@ -0,0 +1,21 @@
+-- This migration adds state tracking for resumable (tus protocol) uploads.
+
+-- Each row is one in-progress upload. The bytes themselves live in object storage as
+-- chunk objects under 'uploads/<id>/'; only the agreed length and the confirmed offset
+-- are tracked here.
+CREATE TABLE uploads (
+    id VARCHAR(64) PRIMARY KEY,
+    owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(255) NOT NULL,
+    description TEXT,
+    tags TEXT[],
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    user_file_id BIGINT REFERENCES user_files(id) ON DELETE SET NULL, -- Set once the upload has been assembled.
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- The cleanup worker scans for expired uploads.
+CREATE INDEX idx_uploads_expires_at ON uploads (expires_at);
@@ -0,0 +1,5 @@
+-- This migration rolls back file version history.
+-- Physical files referenced only by old versions are left behind with a stale reference count.
+ALTER TABLE user_files DROP COLUMN IF EXISTS current_version;
+DROP INDEX IF EXISTS idx_file_versions_physical_file_id;
+DROP TABLE IF EXISTS file_versions;
@@ -0,0 +1,24 @@
+-- This migration adds per-file version history.
+
+-- Every version of a user file points at the physical file holding its content.
+-- physical_files.reference_count counts file_versions rows from now on; since every
+-- existing user file gets exactly one version below, existing counts stay correct.
+CREATE TABLE file_versions (
+    id BIGSERIAL PRIMARY KEY,
+    user_file_id BIGINT NOT NULL REFERENCES user_files(id) ON DELETE CASCADE,
+    version_number INT NOT NULL,
+    physical_file_id BIGINT NOT NULL REFERENCES physical_files(id) ON DELETE RESTRICT,
+    mime_type VARCHAR(255) NOT NULL,
+    uploaded_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_file_id, version_number)
+);
+
+CREATE INDEX idx_file_versions_physical_file_id ON file_versions (physical_file_id);
+
+-- The current version number. user_files.physical_file_id and mime_type always mirror this version.
+ALTER TABLE user_files ADD COLUMN current_version INT NOT NULL DEFAULT 1;
+
+-- Backfill version 1 for every existing file.
+INSERT INTO file_versions (user_file_id, version_number, physical_file_id, mime_type, uploaded_by, created_at)
+SELECT id, 1, physical_file_id, mime_type, owner_id, upload_date FROM user_files;
@@ -0,0 +1,7 @@
+-- This migration rolls back the folder hierarchy. Every file ends up back in a flat list.
+DROP TABLE IF EXISTS folder_shares_to_users;
+DROP INDEX IF EXISTS idx_user_files_folder_id;
+ALTER TABLE user_files DROP COLUMN IF EXISTS folder_id;
+DROP INDEX IF EXISTS idx_folders_parent_id;
+DROP INDEX IF EXISTS idx_folders_unique_name;
+DROP TABLE IF EXISTS folders;
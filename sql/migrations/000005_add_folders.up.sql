@@ -0,0 +1,27 @@
+-- This migration adds a folder hierarchy for user files.
+
+-- Folders form a tree per owner. A NULL parent_id means the folder sits at the owner's root.
+-- Deleting a folder deletes its whole subtree of folders; files inside fall back to the root.
+CREATE TABLE folders (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id BIGINT REFERENCES folders(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Folder names are unique within their parent. Root folders have no parent, so
+-- COALESCE maps them to a shared sentinel instead of letting NULLs compare as distinct.
+CREATE UNIQUE INDEX idx_folders_unique_name ON folders (owner_id, COALESCE(parent_id, 0), name);
+CREATE INDEX idx_folders_parent_id ON folders (parent_id);
+
+-- The folder a file lives in. NULL means the owner's root.
+ALTER TABLE user_files ADD COLUMN folder_id BIGINT REFERENCES folders(id) ON DELETE SET NULL;
+CREATE INDEX idx_user_files_folder_id ON user_files (folder_id);
+
+-- Sharing a folder with a user grants them read access to everything in its subtree.
+CREATE TABLE folder_shares_to_users (
+    folder_id BIGINT NOT NULL REFERENCES folders(id) ON DELETE CASCADE,
+    shared_with_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (folder_id, shared_with_user_id)
+);
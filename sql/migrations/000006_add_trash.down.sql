@@ -0,0 +1,3 @@
+-- This migration rolls back the trash. Files that are still in the trash become visible again.
+DROP INDEX IF EXISTS idx_user_files_deleted_at;
+ALTER TABLE user_files DROP COLUMN IF EXISTS deleted_at;
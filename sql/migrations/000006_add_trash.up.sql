@@ -0,0 +1,8 @@
+-- This migration adds a per-user trash for deleted files.
+
+-- A file with deleted_at set is in its owner's trash. It keeps its versions, shares and
+-- storage usage until it is restored or purged, but is hidden everywhere else.
+ALTER TABLE user_files ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- The purge worker scans for files that have been in the trash longer than the retention period.
+CREATE INDEX idx_user_files_deleted_at ON user_files (deleted_at) WHERE deleted_at IS NOT NULL;
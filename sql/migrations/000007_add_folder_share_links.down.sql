@@ -0,0 +1,5 @@
+-- This migration rolls back public folder share links. Existing folder links are deleted.
+DELETE FROM shares WHERE folder_id IS NOT NULL;
+ALTER TABLE shares DROP CONSTRAINT IF EXISTS shares_single_target;
+ALTER TABLE shares DROP COLUMN IF EXISTS folder_id;
+ALTER TABLE shares ALTER COLUMN user_file_id SET NOT NULL;
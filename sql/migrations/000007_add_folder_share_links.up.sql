@@ -0,0 +1,7 @@
+-- This migration lets a public share link point at a whole folder instead of a single file.
+
+-- A share targets exactly one file or exactly one folder. Folder links are downloaded as a ZIP
+-- archive of the folder's subtree as it is at download time.
+ALTER TABLE shares ALTER COLUMN user_file_id DROP NOT NULL;
+ALTER TABLE shares ADD COLUMN folder_id BIGINT REFERENCES folders(id) ON DELETE CASCADE;
+ALTER TABLE shares ADD CONSTRAINT shares_single_target CHECK ((user_file_id IS NULL) <> (folder_id IS NULL));
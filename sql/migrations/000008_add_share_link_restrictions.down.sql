@@ -0,0 +1,4 @@
+-- This migration removes expiry, download limits and passwords from public share links.
+ALTER TABLE shares DROP COLUMN IF EXISTS password_hash;
+ALTER TABLE shares DROP COLUMN IF EXISTS max_downloads;
+ALTER TABLE shares DROP COLUMN IF EXISTS expires_at;
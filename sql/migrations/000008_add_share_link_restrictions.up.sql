@@ -0,0 +1,9 @@
+-- This migration adds expiry, download limits and optional passwords to public share links.
+
+-- NULL means the link never expires / unlimited downloads / no password. Links created
+-- before this migration are left as they were; the server treats them as expiring the
+-- maximum link lifetime after they were created. New links get an expiry unless the server
+-- allows links without one (SHARE_LINK_MAX_DAYS=0).
+ALTER TABLE shares ADD COLUMN expires_at TIMESTAMPTZ;
+ALTER TABLE shares ADD COLUMN max_downloads BIGINT CHECK (max_downloads > 0);
+ALTER TABLE shares ADD COLUMN password_hash TEXT;
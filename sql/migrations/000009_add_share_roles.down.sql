@@ -0,0 +1,3 @@
+-- This migration removes roles from user-to-user shares. Every share becomes read-only again.
+ALTER TABLE folder_shares_to_users DROP COLUMN IF EXISTS role;
+ALTER TABLE file_shares_to_users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,7 @@
+-- This migration adds a role to user-to-user shares of files and folders.
+-- viewer and commenter can download, editor can also change the file, and co-owner can also re-share it.
+-- Existing shares were read-only, so they become viewer shares.
+ALTER TABLE file_shares_to_users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'viewer'
+    CHECK (role IN ('viewer', 'commenter', 'editor', 'co-owner'));
+ALTER TABLE folder_shares_to_users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'viewer'
+    CHECK (role IN ('viewer', 'commenter', 'editor', 'co-owner'));
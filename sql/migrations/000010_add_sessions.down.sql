@@ -0,0 +1,2 @@
+-- This migration removes login sessions. Issued access tokens stop working.
+DROP TABLE IF EXISTS sessions;
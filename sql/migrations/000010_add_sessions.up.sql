@@ -0,0 +1,21 @@
+-- This migration adds server-side login sessions.
+
+-- Each row is one login on one device. The client holds a short-lived access token naming the
+-- session and a refresh token that is rotated on every use; only SHA-256 hashes of refresh
+-- tokens are stored. The previous hash is kept so that replaying an already-rotated refresh
+-- token can be detected and the session revoked.
+CREATE TABLE sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    refresh_token_hash VARCHAR(64) UNIQUE NOT NULL,
+    previous_refresh_token_hash VARCHAR(64),
+    user_agent TEXT,
+    ip_address VARCHAR(64),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX idx_sessions_previous_refresh_token_hash ON sessions (previous_refresh_token_hash);
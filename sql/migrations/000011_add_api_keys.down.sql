@@ -0,0 +1,2 @@
+-- This migration removes API keys. Scripts using them stop working.
+DROP TABLE IF EXISTS api_keys;
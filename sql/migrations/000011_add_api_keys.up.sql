@@ -0,0 +1,16 @@
+-- This migration adds personal API keys for scripts and CI jobs.
+
+-- A key acts as its user, limited to the permissions listed in scopes. Only a SHA-256 hash of
+-- the key is stored; key_prefix is its first few characters so users can tell keys apart.
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ, -- NULL means the key never expires.
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
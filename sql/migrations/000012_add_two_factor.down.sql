@@ -0,0 +1,4 @@
+-- This migration removes two-factor authentication. Users log in with their password alone again.
+ALTER TABLE roles DROP COLUMN IF EXISTS require_two_factor;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_totp;
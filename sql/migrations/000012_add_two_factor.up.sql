@@ -0,0 +1,24 @@
+-- This migration adds optional TOTP (RFC 6238) two-factor authentication.
+
+-- A user's authenticator secret. It is pending until the user confirms it with a valid code;
+-- only confirmed secrets are asked for at login. last_used_step is the most recent 30-second
+-- time step a code was accepted for, so a code can't be used twice.
+CREATE TABLE user_totp (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    last_used_step BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One-time codes for logging in without the authenticator. Stored as SHA-256 hashes.
+CREATE TABLE recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    UNIQUE (user_id, code_hash)
+);
+
+-- Members of a role with this set must use two-factor authentication to log in.
+ALTER TABLE roles ADD COLUMN require_two_factor BOOLEAN NOT NULL DEFAULT false;
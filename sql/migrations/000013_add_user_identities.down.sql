@@ -0,0 +1,3 @@
+-- This migration removes single sign-on identities. Users created by SSO keep their accounts
+-- but have no usable password until they reset it.
+DROP TABLE IF EXISTS user_identities;
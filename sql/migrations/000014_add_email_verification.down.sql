@@ -0,0 +1,3 @@
+-- This migration removes email verification and single-use account tokens.
+DROP TABLE IF EXISTS user_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
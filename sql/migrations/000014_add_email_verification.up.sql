@@ -0,0 +1,18 @@
+-- This migration adds email verification and single-use account tokens.
+
+-- NULL until the user follows the link in their verification email. Existing accounts start
+-- unverified and can request a verification email at any time.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+-- Email verification and password reset tokens are signed, so they can't be forged, and
+-- recorded here by their ID, so each can only be used once.
+CREATE TABLE user_tokens (
+    id TEXT PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL, -- 'verify_email' or 'reset_password'
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_tokens_user_id_purpose ON user_tokens (user_id, purpose);
@@ -0,0 +1,2 @@
+-- This migration removes failed-login tracking.
+DROP TABLE IF EXISTS login_throttles;
@@ -0,0 +1,12 @@
+-- This migration adds failed-login tracking for brute-force protection.
+
+-- One row per account (keyed by the email tried, so unknown emails are throttled too) and per
+-- client IP address. A row is locked while locked_until is in the future.
+CREATE TABLE login_throttles (
+    scope VARCHAR(10) NOT NULL, -- 'account' or 'ip'
+    subject TEXT NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,2 @@
+-- This migration removes account suspension.
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
@@ -0,0 +1,4 @@
+-- This migration adds account suspension.
+
+-- A suspended user can't log in or use their sessions or API keys until an admin reactivates them.
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMPTZ;
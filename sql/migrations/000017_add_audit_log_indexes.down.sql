@@ -0,0 +1,5 @@
+-- This migration removes the audit log indexes.
+DROP INDEX IF EXISTS idx_audit_logs_timestamp_id;
+DROP INDEX IF EXISTS idx_audit_logs_user_id_timestamp;
+DROP INDEX IF EXISTS idx_audit_logs_action;
+DROP INDEX IF EXISTS idx_audit_logs_details;
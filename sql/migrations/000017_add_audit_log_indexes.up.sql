@@ -0,0 +1,14 @@
+-- This migration adds indexes for filtering and paging the audit log.
+
+-- Paging walks the log in (timestamp, id) order.
+CREATE INDEX idx_audit_logs_timestamp_id ON audit_logs (timestamp, id);
+
+-- Filtering by user, which also speeds up setting user_id to NULL when a user is deleted.
+CREATE INDEX idx_audit_logs_user_id_timestamp ON audit_logs (user_id, timestamp);
+
+-- 'text_pattern_ops' lets action prefix matches (e.g. LIKE 'share:%') use the index.
+CREATE INDEX idx_audit_logs_action ON audit_logs (action text_pattern_ops);
+
+-- A GIN index on details for containment queries (e.g. details @> '{"file_id": 42}').
+-- The 'jsonb_path_ops' operator class only supports @>, but is smaller and faster for it.
+CREATE INDEX idx_audit_logs_details ON audit_logs USING GIN (details jsonb_path_ops);
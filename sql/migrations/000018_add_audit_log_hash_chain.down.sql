@@ -0,0 +1,6 @@
+-- This migration removes the audit log hash chain.
+DROP TABLE IF EXISTS audit_checkpoints;
+UPDATE audit_logs SET user_id = NULL WHERE user_id NOT IN (SELECT id FROM users);
+ALTER TABLE audit_logs ADD CONSTRAINT audit_logs_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS hash;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS prev_hash;
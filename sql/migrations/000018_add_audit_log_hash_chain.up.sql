@@ -0,0 +1,21 @@
+-- This migration adds a hash chain to the audit log.
+
+-- Each entry's hash covers its contents and prev_hash, the hash of the entry before it, so
+-- editing, inserting or deleting an entry breaks the chain from that entry on. Entries written
+-- before this migration have no hash and aren't covered.
+ALTER TABLE audit_logs ADD COLUMN prev_hash TEXT;
+ALTER TABLE audit_logs ADD COLUMN hash TEXT;
+
+-- A hashed entry must never change, but deleting a user used to set user_id to NULL on all of
+-- their entries. Entries now keep the ID of a user who has since been deleted.
+ALTER TABLE audit_logs DROP CONSTRAINT IF EXISTS audit_logs_user_id_fkey;
+
+-- A checkpoint is a signed record of the newest entry in the chain at some point, so that
+-- deleting the newest entries can be detected too.
+CREATE TABLE audit_checkpoints (
+    id BIGSERIAL PRIMARY KEY,
+    last_entry_id BIGINT NOT NULL,
+    last_hash TEXT NOT NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
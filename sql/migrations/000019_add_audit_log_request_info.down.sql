@@ -0,0 +1,5 @@
+-- This migration removes the request details from audit log entries.
+DROP INDEX IF EXISTS idx_audit_logs_request_id;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS request_id;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS ip_address;
@@ -0,0 +1,11 @@
+-- This migration records the request behind each audit log entry.
+
+-- The client's IP address and user agent, and the ID the request was given, which also
+-- appears in the X-Request-ID response header. Background jobs have no request, so all three
+-- are nullable.
+ALTER TABLE audit_logs ADD COLUMN ip_address TEXT;
+ALTER TABLE audit_logs ADD COLUMN user_agent TEXT;
+ALTER TABLE audit_logs ADD COLUMN request_id TEXT;
+
+-- Finds every entry a single request produced.
+CREATE INDEX idx_audit_logs_request_id ON audit_logs (request_id) WHERE request_id IS NOT NULL;
@@ -0,0 +1,3 @@
+-- This migration removes the record of archived audit log segments. The archived objects
+-- themselves are left in object storage.
+DROP TABLE IF EXISTS audit_archive_segments;
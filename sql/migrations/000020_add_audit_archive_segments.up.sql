@@ -0,0 +1,26 @@
+-- This migration adds a record of the audit log segments archived to object storage.
+
+-- Entries older than the retention period are moved out of audit_logs in segments of
+-- consecutive entries, each stored as one gzipped NDJSON object. The row is written in the
+-- same transaction that deletes the entries, once the object has been read back and checked.
+CREATE TABLE audit_archive_segments (
+    id BIGSERIAL PRIMARY KEY,
+    object_key TEXT NOT NULL UNIQUE,
+    first_entry_id BIGINT NOT NULL,
+    last_entry_id BIGINT NOT NULL,
+    first_timestamp TIMESTAMPTZ NOT NULL,
+    last_timestamp TIMESTAMPTZ NOT NULL,
+    entry_count BIGINT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    -- The SHA-256 of the object as stored.
+    sha256_hash TEXT NOT NULL,
+    -- Where the segment sits in the hash chain: the prev_hash of its first entry and the hash
+    -- of its last. Both are NULL for entries from before the chain.
+    first_prev_hash TEXT,
+    last_hash TEXT,
+    -- Signs all of the above, like a checkpoint, so the record can't be altered unnoticed.
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_audit_archive_segments_timestamps ON audit_archive_segments (first_timestamp, last_timestamp);
@@ -0,0 +1,5 @@
+-- This migration removes full-text and fuzzy search over files.
+DROP INDEX IF EXISTS idx_user_files_search_vector;
+ALTER TABLE user_files DROP COLUMN IF EXISTS search_vector;
+DROP INDEX IF EXISTS idx_user_files_filename_trgm;
+DROP EXTENSION IF EXISTS pg_trgm;
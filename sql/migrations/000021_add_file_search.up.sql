@@ -0,0 +1,24 @@
+-- This migration adds full-text and fuzzy search over files.
+
+-- Trigram matching, which gives search its typo tolerance on filenames. It also lets
+-- ILIKE '%...%' use an index, which the text_pattern_ops index from 000002 can't do.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_user_files_filename_trgm ON user_files USING GIN (filename gin_trgm_ops);
+
+-- The searchable text of a file, kept up to date by Postgres. The 'simple' configuration is
+-- used because filenames and tags are rarely English sentences and shouldn't be stemmed.
+-- Filenames are indexed both whole and split at punctuation, so that 'report' finds
+-- quarterly_report.pdf. Matches in the filename rank above matches in tags, and those above
+-- matches in the description.
+--
+-- A generated column may only use immutable functions, which rules out array_to_string.
+-- Tags go through array_to_tsvector instead (which rejects NULL and empty elements), and its
+-- text form through to_tsvector, which splits and lowercases them like the other fields.
+ALTER TABLE user_files ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', filename || ' ' || regexp_replace(filename, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(array_to_tsvector(array_remove(array_remove(tags, NULL), ''))::text, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(description, '')), 'C')
+) STORED;
+
+CREATE INDEX idx_user_files_search_vector ON user_files USING GIN (search_vector);
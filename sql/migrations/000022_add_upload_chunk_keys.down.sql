@@ -0,0 +1,4 @@
+-- This migration removes chunk and assembly tracking from resumable uploads.
+ALTER TABLE uploads DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE uploads DROP COLUMN IF EXISTS assembly_started_at;
+ALTER TABLE uploads DROP COLUMN IF EXISTS chunk_keys;
@@ -0,0 +1,16 @@
+-- This migration makes resumable uploads safe against concurrent and failed requests.
+
+-- The objects holding the upload's chunks, in order. Each PATCH stores its chunk under a key
+-- of its own and records it here when it advances the offset, so a request that loses a race
+-- can't overwrite or delete the winner's chunk. Uploads started before this migration have
+-- no keys recorded, and their chunks are found by listing.
+ALTER TABLE uploads ADD COLUMN chunk_keys TEXT[] NOT NULL DEFAULT '{}';
+
+-- When a request started assembling the file, so that only one does at a time. It is cleared
+-- if assembly fails, so the client can retry.
+ALTER TABLE uploads ADD COLUMN assembly_started_at TIMESTAMPTZ;
+
+-- When the file was assembled. user_file_id alone can't tell, since it is cleared if the
+-- file is deleted.
+ALTER TABLE uploads ADD COLUMN completed_at TIMESTAMPTZ;
+UPDATE uploads SET completed_at = created_at WHERE user_file_id IS NOT NULL;
@@ -0,0 +1,2 @@
+-- This migration removes the download grants of public file links.
+DROP TABLE IF EXISTS share_download_grants;
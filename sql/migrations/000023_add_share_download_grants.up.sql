@@ -0,0 +1,16 @@
+-- This migration records the download grants handed out for public file links.
+
+-- A counted download of a shared file issues a grant that lets the client continue it, e.g.
+-- resume or seek, without being counted again. served_through is how far into the file the
+-- grant's responses have got: a continuation is only free if it starts about there, so a
+-- grant can't be used to fetch the file again from the start.
+CREATE TABLE share_download_grants (
+    id BIGSERIAL PRIMARY KEY,
+    share_id BIGINT NOT NULL REFERENCES shares(id) ON DELETE CASCADE,
+    served_through BIGINT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Expired grants are deleted periodically.
+CREATE INDEX idx_share_download_grants_expires_at ON share_download_grants(expires_at);
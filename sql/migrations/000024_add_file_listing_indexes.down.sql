@@ -0,0 +1,9 @@
+-- This migration removes the file listing indexes and restores the ones they replaced.
+CREATE INDEX IF NOT EXISTS idx_user_files_mime_type ON user_files (mime_type);
+CREATE INDEX IF NOT EXISTS idx_user_files_upload_date ON user_files (upload_date);
+DROP INDEX IF EXISTS idx_user_files_upload_date_id;
+DROP INDEX IF EXISTS idx_user_files_mime_type_id;
+DROP INDEX IF EXISTS idx_user_files_filename_id;
+DROP INDEX IF EXISTS idx_user_files_owner_upload_date_id;
+DROP INDEX IF EXISTS idx_user_files_owner_mime_type_id;
+DROP INDEX IF EXISTS idx_user_files_owner_filename_id;
@@ -0,0 +1,16 @@
+-- This migration adds indexes that serve paged file listings in their sort order.
+
+-- A listing sorted by a column reads the index on (column, id) from the cursor onwards, in
+-- either direction. A user's own files are listed from the partial indexes, which leave out
+-- the trash. Sorting by size can't use an index, since sizes are kept in physical_files.
+CREATE INDEX idx_user_files_owner_filename_id ON user_files (owner_id, filename, id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_user_files_owner_mime_type_id ON user_files (owner_id, mime_type, id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_user_files_owner_upload_date_id ON user_files (owner_id, upload_date, id) WHERE deleted_at IS NULL;
+
+-- All files (for admins), shared files and search results. These replace the plain indexes
+-- on mime_type and upload_date, which they also serve filters for.
+CREATE INDEX idx_user_files_filename_id ON user_files (filename, id);
+CREATE INDEX idx_user_files_mime_type_id ON user_files (mime_type, id);
+CREATE INDEX idx_user_files_upload_date_id ON user_files (upload_date, id);
+DROP INDEX IF EXISTS idx_user_files_mime_type;
+DROP INDEX IF EXISTS idx_user_files_upload_date;
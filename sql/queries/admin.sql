@@ -1,16 +1,80 @@
 -- name: ListAllFiles :many
--- For admin use: retrieves all files with uploader's email.
-SELECT
-    uf.id,
-    uf.filename,
-    uf.mime_type,
-    uf.upload_date,
-    pf.size_bytes,
-    u.email as owner_email
-FROM user_files uf
-JOIN users u ON uf.owner_id = u.id
-JOIN physical_files pf ON uf.physical_file_id = pf.id
-ORDER BY uf.upload_date DESC;
+-- For admin use: retrieves a page of all files with uploader's email, paged like ListUserFiles
+-- from the idx_user_files_* indexes.
+WITH files AS NOT MATERIALIZED (
+    SELECT
+        uf.id,
+        uf.filename,
+        uf.mime_type,
+        uf.upload_date,
+        pf.size_bytes,
+        u.email as owner_email
+    FROM user_files uf
+    JOIN users u ON uf.owner_id = u.id
+    JOIN physical_files pf ON uf.physical_file_id = pf.id
+)
+SELECT id, filename, mime_type, upload_date, size_bytes, owner_email
+FROM (
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) > (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) < (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) > (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) < (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT @page_limit)
+) page
+-- Sorts only the page read by the branch that ran.
+ORDER BY
+    CASE WHEN @sort_key::text = 'name' AND NOT @descending::boolean THEN filename END ASC,
+    CASE WHEN @sort_key::text = 'name' AND @descending::boolean THEN filename END DESC,
+    CASE WHEN @sort_key::text = 'mime' AND NOT @descending::boolean THEN mime_type END ASC,
+    CASE WHEN @sort_key::text = 'mime' AND @descending::boolean THEN mime_type END DESC,
+    CASE WHEN @sort_key::text = 'size' AND NOT @descending::boolean THEN size_bytes END ASC,
+    CASE WHEN @sort_key::text = 'size' AND @descending::boolean THEN size_bytes END DESC,
+    CASE WHEN @sort_key::text = 'upload_date' AND NOT @descending::boolean THEN upload_date END ASC,
+    CASE WHEN @sort_key::text = 'upload_date' AND @descending::boolean THEN upload_date END DESC,
+    CASE WHEN NOT @descending::boolean THEN id END ASC,
+    CASE WHEN @descending::boolean THEN id END DESC;
 
 -- name: GetSystemStats :one
 -- For admin use: retrieves system-wide aggregate statistics.
@@ -24,8 +88,10 @@ SELECT
 -- For admin use: retrieves file metadata without any ownership checks.
 SELECT
     uf.filename,
+    uf.mime_type,
     pf.storage_path,
-    pf.size_bytes
+    pf.size_bytes,
+    pf.sha256_hash
 FROM user_files uf
 JOIN physical_files pf ON uf.physical_file_id = pf.id
 WHERE uf.id = $1;
\ No newline at end of file
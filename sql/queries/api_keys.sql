@@ -0,0 +1,24 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (user_id, name, key_prefix, key_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetActiveAPIKeyByHash :one
+SELECT * FROM api_keys
+WHERE key_hash = $1 AND (expires_at IS NULL OR expires_at > NOW());
+
+-- name: ListUserAPIKeys :many
+SELECT * FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteAPIKey :one
+DELETE FROM api_keys
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: TouchAPIKey :exec
+UPDATE api_keys SET last_used_at = NOW() WHERE id = $1;
+
+-- name: DeleteUserAPIKeys :execrows
+DELETE FROM api_keys WHERE user_id = $1;
@@ -1,9 +1,106 @@
--- name: CreateAuditLog :exec
--- Inserts a new audit log entry.
-INSERT INTO audit_logs (user_id, action, details)
-VALUES ($1, $2, $3);
+-- name: CreateAuditLog :one
+-- Inserts a new audit log entry. Its hash is set once the stored contents are known.
+INSERT INTO audit_logs (user_id, action, details, prev_hash, ip_address, user_agent, request_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
 
--- name: ListAuditLogs :many
--- For admin use: retrieves all audit log entries, newest first.
+-- name: SetAuditLogHash :exec
+UPDATE audit_logs SET hash = $2 WHERE id = $1;
+
+-- name: LockAuditChain :exec
+-- Takes a transaction-level lock that serializes appends to the hash chain across replicas.
+SELECT pg_advisory_xact_lock(hashtext('file-vault:audit-chain'));
+
+-- name: GetAuditChainHead :one
+-- Retrieves the newest entry in the hash chain.
+SELECT id, hash::text AS hash FROM audit_logs
+WHERE hash IS NOT NULL
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: ListAuditChain :many
+-- Retrieves audit log entries in chain order, starting after after_id.
 SELECT * FROM audit_logs
-ORDER BY timestamp DESC;
\ No newline at end of file
+WHERE id > @after_id
+ORDER BY id
+LIMIT @batch_size;
+
+-- name: CreateAuditCheckpoint :one
+INSERT INTO audit_checkpoints (last_entry_id, last_hash, signature, created_at)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetLatestAuditCheckpoint :one
+SELECT * FROM audit_checkpoints ORDER BY id DESC LIMIT 1;
+
+-- name: ListAuditCheckpoints :many
+SELECT * FROM audit_checkpoints ORDER BY id;
+
+-- name: ListAuditLogs :many
+-- For admin use: retrieves a page of audit log entries sorted by timestamp, ties broken by ID,
+-- starting after the cursor entry if there is one. Every filter is optional: the user, the exact
+-- action or an action prefix (already escaped for LIKE), a file_id in details, and a time range.
+-- The entries are read from idx_audit_logs_timestamp_id, oldest first.
+SELECT al.* FROM audit_logs al
+WHERE
+    (sqlc.narg('user_id')::bigint IS NULL OR al.user_id = sqlc.narg('user_id'))
+    AND (sqlc.narg('action')::text IS NULL OR al.action = sqlc.narg('action'))
+    AND (sqlc.narg('action_prefix')::text IS NULL OR al.action LIKE sqlc.narg('action_prefix') || '%')
+    -- Containment keeps the GIN index on details usable.
+    AND (sqlc.narg('file_id')::bigint IS NULL OR al.details @> jsonb_build_object('file_id', sqlc.narg('file_id')))
+    AND (sqlc.narg('start_date')::timestamptz IS NULL OR al.timestamp >= sqlc.narg('start_date'))
+    AND (sqlc.narg('end_date')::timestamptz IS NULL OR al.timestamp <= sqlc.narg('end_date'))
+    AND (sqlc.narg('request_id')::text IS NULL OR al.request_id = sqlc.narg('request_id'))
+    AND (sqlc.narg('cursor_id')::bigint IS NULL OR (al.timestamp, al.id) > (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+ORDER BY al.timestamp, al.id
+LIMIT @page_limit;
+
+-- name: ListAuditLogsDescending :many
+-- Like ListAuditLogs, but newest first.
+SELECT al.* FROM audit_logs al
+WHERE
+    (sqlc.narg('user_id')::bigint IS NULL OR al.user_id = sqlc.narg('user_id'))
+    AND (sqlc.narg('action')::text IS NULL OR al.action = sqlc.narg('action'))
+    AND (sqlc.narg('action_prefix')::text IS NULL OR al.action LIKE sqlc.narg('action_prefix') || '%')
+    -- Containment keeps the GIN index on details usable.
+    AND (sqlc.narg('file_id')::bigint IS NULL OR al.details @> jsonb_build_object('file_id', sqlc.narg('file_id')))
+    AND (sqlc.narg('start_date')::timestamptz IS NULL OR al.timestamp >= sqlc.narg('start_date'))
+    AND (sqlc.narg('end_date')::timestamptz IS NULL OR al.timestamp <= sqlc.narg('end_date'))
+    AND (sqlc.narg('request_id')::text IS NULL OR al.request_id = sqlc.narg('request_id'))
+    AND (sqlc.narg('cursor_id')::bigint IS NULL OR (al.timestamp, al.id) < (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+ORDER BY al.timestamp DESC, al.id DESC
+LIMIT @page_limit;
+
+-- name: LockAuditArchive :exec
+-- Takes a transaction-level lock so that only one replica archives at a time.
+SELECT pg_advisory_xact_lock(hashtext('file-vault:audit-archive'));
+
+-- name: CreateAuditArchiveSegment :one
+INSERT INTO audit_archive_segments (
+    object_key, first_entry_id, last_entry_id, first_timestamp, last_timestamp, entry_count,
+    size_bytes, sha256_hash, first_prev_hash, last_hash, signature, created_at
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+RETURNING *;
+
+-- name: DeleteAuditLogRange :execrows
+-- Deletes the entries of a segment once it has been archived.
+DELETE FROM audit_logs
+WHERE id BETWEEN @first_entry_id AND @last_entry_id;
+
+-- name: GetAuditArchiveSegment :one
+SELECT * FROM audit_archive_segments
+WHERE id = $1;
+
+-- name: GetLatestAuditArchiveSegment :one
+SELECT * FROM audit_archive_segments
+ORDER BY last_entry_id DESC
+LIMIT 1;
+
+-- name: ListAuditArchiveSegments :many
+-- Retrieves the archived segments with entries in the date range, oldest first.
+SELECT * FROM audit_archive_segments
+WHERE
+    (sqlc.narg('start_date')::timestamptz IS NULL OR last_timestamp >= sqlc.narg('start_date'))
+    AND (sqlc.narg('end_date')::timestamptz IS NULL OR first_timestamp <= sqlc.narg('end_date'))
+ORDER BY first_entry_id;
\ No newline at end of file
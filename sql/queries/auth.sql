@@ -13,6 +13,14 @@ JOIN role_permissions rp ON p.id = rp.permission_id
 JOIN user_roles ur ON rp.role_id = ur.role_id
 WHERE ur.user_id = $1;
 
+-- name: NotifyPermissionsChanged :exec
+-- Tells every backend replica to forget cached permissions: those of one user, or of
+-- everyone if the payload is '*'. Inside a transaction it is only delivered on commit.
+SELECT pg_notify('permissions_changed', $1::text);
+
+-- name: ListenPermissionsChanged :exec
+LISTEN permissions_changed;
+
 -- name: GetRoleByName :one
 SELECT * FROM roles WHERE name = $1;
 
@@ -23,4 +31,10 @@ SELECT * FROM permissions WHERE name = $1;
 INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2);
 
 -- name: LinkRoleToPermission :exec
-INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2);
\ No newline at end of file
+INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2);
+
+-- name: AddUserRole :execrows
+INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;
+
+-- name: RemoveUserRole :execrows
+DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2;
\ No newline at end of file
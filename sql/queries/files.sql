@@ -9,34 +9,102 @@ INSERT INTO physical_files (sha256_hash, size_bytes, storage_path) VALUES ($1, $
 UPDATE physical_files SET reference_count = reference_count + 1 WHERE id = $1 RETURNING *;
 
 -- name: CreateUserFile :one
-INSERT INTO user_files (owner_id, physical_file_id, filename, mime_type, description, tags) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *;
+INSERT INTO user_files (owner_id, physical_file_id, filename, mime_type, description, tags, folder_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *;
 
 -- name: ListUserFiles :many
--- name: ListUserFiles :many
--- CORRECTED: Join with physical_files to get the correct size_bytes.
-SELECT
-    uf.id,
-    uf.owner_id,
-    uf.physical_file_id,
-    uf.filename,
-    uf.mime_type,
-    uf.description,
-    uf.tags,
-    uf.upload_date,
-    pf.size_bytes
-FROM user_files uf
-JOIN physical_files pf ON uf.physical_file_id = pf.id
-WHERE uf.owner_id = $1
-ORDER BY uf.upload_date DESC;
+-- Retrieves a page of a user's files, joined with physical_files to get the correct size_bytes.
+-- The page is sorted by sort_key ('name', 'mime', 'size' or 'upload_date'), ties broken by ID,
+-- and starts after the cursor row if there is one. Each sort key and direction has a branch of
+-- its own that reads the files in that order, so that it can seek to the cursor in the
+-- idx_user_files_owner_* indexes and stop at page_limit. Only the requested branch runs.
+WITH files AS NOT MATERIALIZED (
+    SELECT
+        uf.id,
+        uf.owner_id,
+        uf.physical_file_id,
+        uf.filename,
+        uf.mime_type,
+        uf.description,
+        uf.tags,
+        uf.upload_date,
+        uf.folder_id,
+        pf.size_bytes
+    FROM user_files uf
+    JOIN physical_files pf ON uf.physical_file_id = pf.id
+    WHERE uf.owner_id = @owner_id AND uf.deleted_at IS NULL
+)
+SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, folder_id, size_bytes
+FROM (
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) > (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) < (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) > (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) < (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT @page_limit)
+) page
+-- Sorts only the page read by the branch that ran.
+ORDER BY
+    CASE WHEN @sort_key::text = 'name' AND NOT @descending::boolean THEN filename END ASC,
+    CASE WHEN @sort_key::text = 'name' AND @descending::boolean THEN filename END DESC,
+    CASE WHEN @sort_key::text = 'mime' AND NOT @descending::boolean THEN mime_type END ASC,
+    CASE WHEN @sort_key::text = 'mime' AND @descending::boolean THEN mime_type END DESC,
+    CASE WHEN @sort_key::text = 'size' AND NOT @descending::boolean THEN size_bytes END ASC,
+    CASE WHEN @sort_key::text = 'size' AND @descending::boolean THEN size_bytes END DESC,
+    CASE WHEN @sort_key::text = 'upload_date' AND NOT @descending::boolean THEN upload_date END ASC,
+    CASE WHEN @sort_key::text = 'upload_date' AND @descending::boolean THEN upload_date END DESC,
+    CASE WHEN NOT @descending::boolean THEN id END ASC,
+    CASE WHEN @descending::boolean THEN id END DESC;
+
 -- name: GetUserFileForDownload :one
-SELECT uf.*, pf.storage_path FROM user_files uf JOIN physical_files pf ON uf.physical_file_id = pf.id WHERE uf.id = $1 AND uf.owner_id = $2;
+SELECT uf.*, pf.storage_path FROM user_files uf JOIN physical_files pf ON uf.physical_file_id = pf.id WHERE uf.id = $1 AND uf.owner_id = $2 AND uf.deleted_at IS NULL;
 
 -- name: GetFileOwnerAndPhysicalFile :one
 -- CORRECTED: Added pf.storage_path to the SELECT and uf.owner_id to the WHERE clause.
 SELECT uf.owner_id, pf.id as physical_file_id, pf.size_bytes, pf.storage_path
 FROM user_files uf
 JOIN physical_files pf ON uf.physical_file_id = pf.id
-WHERE uf.id = $1 AND uf.owner_id = $2;
+WHERE uf.id = $1 AND uf.owner_id = $2 AND uf.deleted_at IS NULL;
 
 -- name: DeleteUserFile :exec
 DELETE FROM user_files WHERE id = $1;
@@ -46,12 +114,94 @@ UPDATE physical_files SET reference_count = reference_count - 1 WHERE id = $1 RE
 DELETE FROM physical_files WHERE id = $1;
 
 -- name: ListFilesSharedWithUser :many
--- Retrieves a list of all files that have been explicitly shared with a specific user.
-SELECT uf.*
-FROM user_files uf
-JOIN file_shares_to_users fstu ON uf.id = fstu.user_file_id
-WHERE fstu.shared_with_user_id = $1
-ORDER BY uf.upload_date DESC;
+-- Retrieves a page of the files that have been shared with a specific user,
+-- either directly or through a shared folder above them. Paged like ListUserFiles: the
+-- branches read the idx_user_files_* indexes in order and check each file is shared.
+WITH files AS NOT MATERIALIZED (
+    SELECT uf.*, pf.size_bytes
+    FROM user_files uf
+    JOIN physical_files pf ON uf.physical_file_id = pf.id
+    WHERE
+        uf.deleted_at IS NULL
+        AND (
+            EXISTS (
+                SELECT 1 FROM file_shares_to_users fstu
+                WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = @shared_with_user_id
+            )
+            OR
+            uf.folder_id IN (
+                WITH RECURSIVE shared_folders AS (
+                    SELECT fs.folder_id AS id FROM folder_shares_to_users fs
+                    WHERE fs.shared_with_user_id = @shared_with_user_id
+                    UNION
+                    SELECT f.id FROM folders f JOIN shared_folders sf ON f.parent_id = sf.id
+                )
+                SELECT id FROM shared_folders
+            )
+        )
+)
+SELECT id, owner_id, physical_file_id, filename, mime_type, description, tags, upload_date, current_version, folder_id, deleted_at, search_vector, size_bytes
+FROM (
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) > (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) < (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) > (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) < (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT @page_limit)
+) page
+-- Sorts only the page read by the branch that ran.
+ORDER BY
+    CASE WHEN @sort_key::text = 'name' AND NOT @descending::boolean THEN filename END ASC,
+    CASE WHEN @sort_key::text = 'name' AND @descending::boolean THEN filename END DESC,
+    CASE WHEN @sort_key::text = 'mime' AND NOT @descending::boolean THEN mime_type END ASC,
+    CASE WHEN @sort_key::text = 'mime' AND @descending::boolean THEN mime_type END DESC,
+    CASE WHEN @sort_key::text = 'size' AND NOT @descending::boolean THEN size_bytes END ASC,
+    CASE WHEN @sort_key::text = 'size' AND @descending::boolean THEN size_bytes END DESC,
+    CASE WHEN @sort_key::text = 'upload_date' AND NOT @descending::boolean THEN upload_date END ASC,
+    CASE WHEN @sort_key::text = 'upload_date' AND @descending::boolean THEN upload_date END DESC,
+    CASE WHEN NOT @descending::boolean THEN id END ASC,
+    CASE WHEN @descending::boolean THEN id END DESC;
 
 -- name: IsFileSharedWithUser :one
 -- Checks if a specific file has been shared with a specific user. Returns true or false.
@@ -64,12 +214,15 @@ SELECT EXISTS(
 -- CORRECTED: Uses sqlc.arg() for explicit parameter naming.
 SELECT
     uf.filename,
+    uf.mime_type,
     pf.storage_path,
-    pf.size_bytes
+    pf.size_bytes,
+    pf.sha256_hash
 FROM user_files uf
 JOIN physical_files pf ON uf.physical_file_id = pf.id
 WHERE
     uf.id = sqlc.arg(file_id)
+    AND uf.deleted_at IS NULL
     AND (
         uf.owner_id = sqlc.arg(requesting_user_id)
         OR
@@ -77,16 +230,58 @@ WHERE
             SELECT 1 FROM file_shares_to_users fstu
             WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = sqlc.arg(requesting_user_id)
         )
+        OR
+        uf.folder_id IN (
+            WITH RECURSIVE shared_folders AS (
+                SELECT fs.folder_id AS id FROM folder_shares_to_users fs
+                WHERE fs.shared_with_user_id = sqlc.arg(requesting_user_id)
+                UNION
+                SELECT f.id FROM folders f JOIN shared_folders sf ON f.parent_id = sf.id
+            )
+            SELECT id FROM shared_folders
+        )
     );
 
 -- name: AddTagToFile :exec
 -- CORRECTED: Use sqlc.arg() to name parameters for clear, generated code.
 UPDATE user_files
 SET tags = array_append(tags, sqlc.arg(tag))
-WHERE id = sqlc.arg(file_id) AND owner_id = sqlc.arg(owner_id);
+WHERE id = sqlc.arg(file_id) AND deleted_at IS NULL;
 
 -- name: RemoveTagFromFile :exec
 -- CORRECTED: Use sqlc.arg() for named parameters.
 UPDATE user_files
 SET tags = array_remove(tags, sqlc.arg(tag))
-WHERE id = sqlc.arg(file_id) AND owner_id = sqlc.arg(owner_id);
\ No newline at end of file
+WHERE id = sqlc.arg(file_id) AND deleted_at IS NULL;
+
+-- name: GetFileAccessRole :one
+-- Returns the strongest role a user has on a file: 'owner', or the highest role granted by a share
+-- of the file itself or of any folder above it. No row is returned when the user has no access.
+WITH RECURSIVE ancestors AS (
+    SELECT f.id, f.parent_id FROM folders f JOIN user_files uf ON uf.folder_id = f.id WHERE uf.id = sqlc.arg(file_id)
+    UNION
+    SELECT f.id, f.parent_id FROM folders f JOIN ancestors a ON f.id = a.parent_id
+),
+grants AS (
+    SELECT 'owner'::text AS role FROM user_files WHERE id = sqlc.arg(file_id) AND owner_id = sqlc.arg(user_id)
+    UNION ALL
+    SELECT fstu.role::text FROM file_shares_to_users fstu
+    WHERE fstu.user_file_id = sqlc.arg(file_id) AND fstu.shared_with_user_id = sqlc.arg(user_id)
+    UNION ALL
+    SELECT fs.role::text FROM folder_shares_to_users fs JOIN ancestors a ON fs.folder_id = a.id
+    WHERE fs.shared_with_user_id = sqlc.arg(user_id)
+)
+SELECT g.role, uf.owner_id
+FROM grants g, user_files uf
+WHERE uf.id = sqlc.arg(file_id) AND uf.deleted_at IS NULL
+ORDER BY array_position(ARRAY['viewer', 'commenter', 'editor', 'co-owner', 'owner'], g.role) DESC
+LIMIT 1;
+
+-- name: UpdateUserFileDetails :one
+-- Renames a file and/or changes its description. A NULL filename keeps the current name, and the
+-- description is only touched when update_description is set.
+UPDATE user_files
+SET filename = COALESCE(sqlc.arg(filename), filename),
+    description = CASE WHEN sqlc.arg(update_description)::boolean THEN sqlc.arg(description) ELSE description END
+WHERE id = sqlc.arg(id) AND deleted_at IS NULL
+RETURNING *;
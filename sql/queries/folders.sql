@@ -0,0 +1,140 @@
+-- name: CreateFolder :one
+INSERT INTO folders (owner_id, parent_id, name) VALUES ($1, $2, $3) RETURNING *;
+
+-- name: GetFolderByID :one
+SELECT * FROM folders WHERE id = $1;
+
+-- name: ListFoldersInFolder :many
+-- Retrieves the direct subfolders of a folder, or the owner's root folders when parent_id is NULL.
+SELECT * FROM folders
+WHERE owner_id = sqlc.arg(owner_id) AND parent_id IS NOT DISTINCT FROM sqlc.narg(parent_id)
+ORDER BY name;
+
+-- name: ListFilesInFolder :many
+-- Retrieves the files directly inside a folder, or the owner's root files when folder_id is NULL.
+SELECT
+    uf.id,
+    uf.owner_id,
+    uf.physical_file_id,
+    uf.filename,
+    uf.mime_type,
+    uf.description,
+    uf.tags,
+    uf.upload_date,
+    uf.current_version,
+    uf.folder_id,
+    pf.size_bytes
+FROM user_files uf
+JOIN physical_files pf ON uf.physical_file_id = pf.id
+WHERE uf.owner_id = sqlc.arg(owner_id) AND uf.folder_id IS NOT DISTINCT FROM sqlc.narg(folder_id) AND uf.deleted_at IS NULL
+ORDER BY uf.filename;
+
+-- name: RenameFolder :one
+UPDATE folders SET name = $1 WHERE id = $2 RETURNING *;
+
+-- name: MoveFolder :one
+UPDATE folders SET parent_id = $1 WHERE id = $2 RETURNING *;
+
+-- name: DeleteFolder :exec
+-- Deletes a folder. Its subfolders are removed by the cascade.
+DELETE FROM folders WHERE id = $1;
+
+-- name: IsFolderInSubtree :one
+-- Checks whether folder_id is root_id itself or one of its descendants. Used to prevent cycles on move.
+WITH RECURSIVE subtree AS (
+    SELECT id FROM folders WHERE id = sqlc.arg(root_id)
+    UNION ALL
+    SELECT f.id FROM folders f JOIN subtree st ON f.parent_id = st.id
+)
+SELECT EXISTS (SELECT 1 FROM subtree WHERE id = sqlc.arg(folder_id));
+
+-- name: ListFileIDsInSubtree :many
+-- Retrieves the IDs of every file inside a folder or any of its descendants, excluding trashed files.
+WITH RECURSIVE subtree AS (
+    SELECT id FROM folders WHERE id = $1
+    UNION ALL
+    SELECT f.id FROM folders f JOIN subtree st ON f.parent_id = st.id
+)
+SELECT uf.id FROM user_files uf WHERE uf.folder_id IN (SELECT id FROM subtree) AND uf.deleted_at IS NULL;
+
+-- name: MoveUserFile :execrows
+UPDATE user_files SET folder_id = sqlc.narg(folder_id)
+WHERE id = sqlc.arg(id) AND owner_id = sqlc.arg(owner_id) AND deleted_at IS NULL;
+
+-- name: ShareFolderWithUser :exec
+-- Shares a folder with a user, or changes the role of an existing share.
+INSERT INTO folder_shares_to_users (folder_id, shared_with_user_id, role) VALUES ($1, $2, $3)
+ON CONFLICT (folder_id, shared_with_user_id) DO UPDATE SET role = EXCLUDED.role;
+
+-- name: IsFolderAlreadySharedWithUser :one
+SELECT EXISTS(
+  SELECT 1 FROM folder_shares_to_users
+  WHERE folder_id = $1 AND shared_with_user_id = $2
+);
+
+-- name: UnshareFolderWithUser :exec
+DELETE FROM folder_shares_to_users
+WHERE folder_id = $1 AND shared_with_user_id = $2;
+
+-- name: IsFolderSharedWithUser :one
+-- Checks whether a folder, or any folder above it, has been shared with the user.
+WITH RECURSIVE ancestors AS (
+    SELECT id, parent_id FROM folders WHERE id = sqlc.arg(folder_id)
+    UNION ALL
+    SELECT f.id, f.parent_id FROM folders f JOIN ancestors a ON f.id = a.parent_id
+)
+SELECT EXISTS (
+    SELECT 1 FROM ancestors a
+    JOIN folder_shares_to_users fs ON fs.folder_id = a.id
+    WHERE fs.shared_with_user_id = sqlc.arg(shared_with_user_id)
+);
+
+-- name: ListFoldersSharedWithUser :many
+-- Retrieves the folders that have been explicitly shared with a specific user.
+SELECT f.*
+FROM folders f
+JOIN folder_shares_to_users fs ON f.id = fs.folder_id
+WHERE fs.shared_with_user_id = $1
+ORDER BY f.name;
+
+-- name: GetSharesForFolder :many
+-- Retrieves all user shares for a specific folder.
+SELECT u.id, u.email, fs.role
+FROM folder_shares_to_users fs
+JOIN users u ON fs.shared_with_user_id = u.id
+WHERE fs.folder_id = $1;
+
+-- name: ListFilesInSubtreeForArchive :many
+-- Retrieves every file below a folder together with its path relative to that folder,
+-- e.g. 'reports/2024/' for a file two levels down. Files directly inside have an empty path.
+WITH RECURSIVE subtree AS (
+    SELECT id, ''::text AS path FROM folders WHERE id = $1
+    UNION ALL
+    SELECT f.id, st.path || f.name || '/' FROM folders f JOIN subtree st ON f.parent_id = st.id
+)
+SELECT uf.id, st.path, uf.filename, pf.storage_path, pf.size_bytes
+FROM user_files uf
+JOIN subtree st ON uf.folder_id = st.id
+JOIN physical_files pf ON uf.physical_file_id = pf.id
+WHERE uf.deleted_at IS NULL
+ORDER BY st.path, uf.filename;
+
+-- name: GetFolderAccessRole :one
+-- Returns the strongest role a user has on a folder: 'owner', or the highest role granted by a
+-- share of the folder or of any folder above it. No row is returned when the user has no access.
+WITH RECURSIVE ancestors AS (
+    SELECT id, parent_id FROM folders WHERE id = sqlc.arg(folder_id)
+    UNION
+    SELECT f.id, f.parent_id FROM folders f JOIN ancestors a ON f.id = a.parent_id
+),
+grants AS (
+    SELECT 'owner'::text AS role FROM folders WHERE id = sqlc.arg(folder_id) AND owner_id = sqlc.arg(user_id)
+    UNION ALL
+    SELECT fs.role::text FROM folder_shares_to_users fs JOIN ancestors a ON fs.folder_id = a.id
+    WHERE fs.shared_with_user_id = sqlc.arg(user_id)
+)
+SELECT g.role, f.owner_id
+FROM grants g, folders f
+WHERE f.id = sqlc.arg(folder_id)
+ORDER BY array_position(ARRAY['viewer', 'commenter', 'editor', 'co-owner', 'owner'], g.role) DESC
+LIMIT 1;
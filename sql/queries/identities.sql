@@ -0,0 +1,12 @@
+-- name: GetUserByIdentity :one
+SELECT u.* FROM users u
+JOIN user_identities i ON i.user_id = u.id
+WHERE i.issuer = $1 AND i.subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4);
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET last_login_at = NOW(), email = $3
+WHERE issuer = $1 AND subject = $2;
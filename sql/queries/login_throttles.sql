@@ -0,0 +1,24 @@
+-- name: GetLoginThrottles :many
+-- Returns the throttle rows that apply to a login attempt for an email from an IP address.
+SELECT * FROM login_throttles
+WHERE (scope = 'account' AND subject = sqlc.arg(email)::text)
+   OR (scope = 'ip' AND subject = sqlc.arg(ip_address)::text);
+
+-- name: RecordLoginFailure :one
+-- Counts a failed login. Failures older than the window are forgotten, so the count restarts.
+INSERT INTO login_throttles (scope, subject, failures, last_failed_at)
+VALUES (sqlc.arg(scope), sqlc.arg(subject), 1, NOW())
+ON CONFLICT (scope, subject) DO UPDATE
+SET failures = CASE WHEN login_throttles.last_failed_at < sqlc.arg(window_start) THEN 1 ELSE login_throttles.failures + 1 END,
+    last_failed_at = NOW()
+RETURNING *;
+
+-- name: LockLogin :exec
+UPDATE login_throttles SET locked_until = $3 WHERE scope = $1 AND subject = $2;
+
+-- name: ClearLoginThrottle :execrows
+DELETE FROM login_throttles WHERE scope = $1 AND subject = $2;
+
+-- name: DeleteStaleLoginThrottles :execrows
+DELETE FROM login_throttles
+WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < NOW());
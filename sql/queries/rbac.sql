@@ -14,4 +14,45 @@ WHERE rp.role_id = $1;
 INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;
 
 -- name: RemovePermissionFromRole :exec
-DELETE FROM role_permissions WHERE role_id = $1 AND permission_id = $2;
\ No newline at end of file
+DELETE FROM role_permissions WHERE role_id = $1 AND permission_id = $2;
+
+-- name: SetRoleRequireTwoFactor :one
+UPDATE roles SET require_two_factor = $2 WHERE id = $1 RETURNING *;
+
+-- name: RevokeSessionsWithoutTwoFactorForRole :execrows
+-- Signs out members of a role who haven't set up two-factor authentication, so they have to
+-- enroll the next time they log in.
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE revoked_at IS NULL
+  AND user_id IN (SELECT ur.user_id FROM user_roles ur WHERE ur.role_id = $1)
+  AND user_id NOT IN (SELECT t.user_id FROM user_totp t WHERE t.confirmed_at IS NOT NULL);
+
+-- name: GetRoleByID :one
+SELECT * FROM roles WHERE id = $1;
+
+-- name: RenameRole :one
+UPDATE roles SET name = $2 WHERE id = $1 RETURNING *;
+
+-- name: DeleteRole :one
+DELETE FROM roles WHERE id = $1 RETURNING *;
+
+-- name: ListUserRoles :many
+SELECT r.*
+FROM roles r
+JOIN user_roles ur ON r.id = ur.role_id
+WHERE ur.user_id = $1
+ORDER BY r.name;
+
+-- name: LockRoleAssignments :exec
+-- Serializes changes that could leave nobody able to manage roles, until the transaction ends.
+SELECT pg_advisory_xact_lock(hashtext('file-vault:role-assignments'));
+
+-- name: CountUsersWithPermission :one
+-- Suspended users don't count, since they can't use the permission.
+SELECT COUNT(DISTINCT ur.user_id)::BIGINT AS count
+FROM user_roles ur
+JOIN users u ON ur.user_id = u.id
+JOIN role_permissions rp ON ur.role_id = rp.role_id
+JOIN permissions p ON rp.permission_id = p.id
+WHERE p.name = $1 AND u.suspended_at IS NULL;
@@ -1,48 +1,169 @@
 -- name: SearchFiles :many
 -- Performs a comprehensive search and filter operation on user files.
 -- This query is optimized with indexes and uses sqlc.narg() for optional parameters.
-SELECT
-    uf.id,
-    uf.filename,
-    uf.mime_type,
-    uf.upload_date,
-    pf.size_bytes,
-    u.email as owner_email
-FROM
-    user_files uf
-JOIN
-    users u ON uf.owner_id = u.id
-JOIN
-    physical_files pf ON uf.physical_file_id = pf.id
-WHERE
-    (
-        @is_admin::boolean OR
-        uf.owner_id = @requesting_user_id::bigint OR
-        EXISTS (
-            SELECT 1 FROM file_shares_to_users fstu
-            WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = @requesting_user_id::bigint
+-- Results are paged like ListUserFiles, and can also be sorted by relevance, which no
+-- index can serve.
+-- query and exclude are tsquery texts, and fuzzy is matched against filenames by trigram
+-- word similarity. See search.ParseQuery.
+WITH files AS NOT MATERIALIZED (
+    SELECT
+        uf.id,
+        uf.filename,
+        uf.mime_type,
+        uf.upload_date,
+        pf.size_bytes,
+        u.email as owner_email,
+        r.relevance
+    FROM
+        user_files uf
+    JOIN
+        users u ON uf.owner_id = u.id
+    JOIN
+        physical_files pf ON uf.physical_file_id = pf.id
+    CROSS JOIN LATERAL (
+        SELECT (
+            CASE WHEN sqlc.narg('query')::text IS NULL THEN 0
+            ELSE ts_rank(uf.search_vector, to_tsquery('simple', sqlc.narg('query'))) END
+            +
+            CASE WHEN sqlc.narg('fuzzy')::text IS NULL THEN 0
+            ELSE word_similarity(sqlc.narg('fuzzy'), uf.filename) END
+        )::float8 AS relevance
+    ) r
+    WHERE
+        uf.deleted_at IS NULL
+    AND
+        (
+            @is_admin::boolean OR
+            uf.owner_id = @requesting_user_id::bigint OR
+            EXISTS (
+                SELECT 1 FROM file_shares_to_users fstu
+                WHERE fstu.user_file_id = uf.id AND fstu.shared_with_user_id = @requesting_user_id::bigint
+            )
+            OR
+            uf.folder_id IN (
+                WITH RECURSIVE shared_folders AS (
+                    SELECT fs.folder_id AS id FROM folder_shares_to_users fs
+                    WHERE fs.shared_with_user_id = @requesting_user_id::bigint
+                    UNION
+                    SELECT f.id FROM folders f JOIN shared_folders sf ON f.parent_id = sf.id
+                )
+                SELECT id FROM shared_folders
+            )
         )
-    )
-AND
-    (uf.filename ILIKE '%' || sqlc.narg('filename') || '%' OR sqlc.narg('filename') IS NULL)
-AND
-    (uf.mime_type = sqlc.narg('mime_type') OR sqlc.narg('mime_type') IS NULL)
-AND
-    (pf.size_bytes >= sqlc.narg('min_size') OR sqlc.narg('min_size') IS NULL)
-AND
-    (pf.size_bytes <= sqlc.narg('max_size') OR sqlc.narg('max_size') IS NULL)
-AND
-    (uf.upload_date >= sqlc.narg('start_date') OR sqlc.narg('start_date') IS NULL)
-AND
-    (uf.upload_date <= sqlc.narg('end_date') OR sqlc.narg('end_date') IS NULL)
-AND
-    -- The @> operator checks if the tags array contains all elements from the input array.
-    -- This is efficiently powered by our GIN index.
-    (uf.tags @> sqlc.narg('tags')::text[] OR sqlc.narg('tags') IS NULL)
-AND
-    -- Filter by a specific uploader's email if provided.
-    (u.email = sqlc.narg('uploader_email') OR sqlc.narg('uploader_email') IS NULL)
+    AND
+        -- Files matching the full-text query, or whose filename is close to it. Both are backed
+        -- by GIN indexes.
+        (sqlc.narg('query')::text IS NULL
+         OR uf.search_vector @@ to_tsquery('simple', sqlc.narg('query'))
+         OR sqlc.narg('fuzzy')::text <% uf.filename)
+    AND
+        (sqlc.narg('exclude')::text IS NULL OR NOT uf.search_vector @@ to_tsquery('simple', sqlc.narg('exclude')))
+    AND
+        -- Uses the trigram index on filename.
+        (uf.filename ILIKE '%' || sqlc.narg('filename') || '%' OR sqlc.narg('filename') IS NULL)
+    AND
+        (uf.mime_type = sqlc.narg('mime_type') OR sqlc.narg('mime_type') IS NULL)
+    AND
+        (pf.size_bytes >= sqlc.narg('min_size') OR sqlc.narg('min_size') IS NULL)
+    AND
+        (pf.size_bytes <= sqlc.narg('max_size') OR sqlc.narg('max_size') IS NULL)
+    AND
+        (uf.upload_date >= sqlc.narg('start_date') OR sqlc.narg('start_date') IS NULL)
+    AND
+        (uf.upload_date <= sqlc.narg('end_date') OR sqlc.narg('end_date') IS NULL)
+    AND
+        -- The @> operator checks if the tags array contains all elements from the input array.
+        -- This is efficiently powered by our GIN index.
+        (uf.tags @> sqlc.narg('tags')::text[] OR sqlc.narg('tags') IS NULL)
+    AND
+        -- Filter by a specific uploader's email if provided.
+        (u.email = sqlc.narg('uploader_email') OR sqlc.narg('uploader_email') IS NULL)
+    AND
+        -- Restrict results to a folder and everything below it if provided.
+        (sqlc.narg('folder_id')::bigint IS NULL OR uf.folder_id IN (
+            WITH RECURSIVE subtree AS (
+                SELECT id FROM folders WHERE id = sqlc.narg('folder_id')
+                UNION ALL
+                SELECT f.id FROM folders f JOIN subtree st ON f.parent_id = st.id
+            )
+            SELECT id FROM subtree
+        ))
+)
+SELECT id, filename, mime_type, upload_date, size_bytes, owner_email, relevance
+FROM (
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'name' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (filename, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY filename DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) > (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'mime' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (mime_type, id) < (sqlc.narg('cursor_text')::text, sqlc.narg('cursor_id')))
+     ORDER BY mime_type DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) > (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'size' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (size_bytes, id) < (sqlc.narg('cursor_int')::bigint, sqlc.narg('cursor_id')))
+     ORDER BY size_bytes DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) > (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'upload_date' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (upload_date, id) < (sqlc.narg('cursor_time')::timestamptz, sqlc.narg('cursor_id')))
+     ORDER BY upload_date DESC, id DESC
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'relevance' AND NOT @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (relevance, id) > (sqlc.narg('cursor_float')::float8, sqlc.narg('cursor_id')))
+     ORDER BY relevance, id
+     LIMIT @page_limit)
+    UNION ALL
+    (SELECT * FROM files
+     WHERE @sort_key::text = 'relevance' AND @descending::boolean
+       AND (sqlc.narg('cursor_id')::bigint IS NULL OR (relevance, id) < (sqlc.narg('cursor_float')::float8, sqlc.narg('cursor_id')))
+     ORDER BY relevance DESC, id DESC
+     LIMIT @page_limit)
+) page
+-- Sorts only the page read by the branch that ran.
 ORDER BY
-    uf.upload_date DESC;
+    CASE WHEN @sort_key::text = 'name' AND NOT @descending::boolean THEN filename END ASC,
+    CASE WHEN @sort_key::text = 'name' AND @descending::boolean THEN filename END DESC,
+    CASE WHEN @sort_key::text = 'mime' AND NOT @descending::boolean THEN mime_type END ASC,
+    CASE WHEN @sort_key::text = 'mime' AND @descending::boolean THEN mime_type END DESC,
+    CASE WHEN @sort_key::text = 'size' AND NOT @descending::boolean THEN size_bytes END ASC,
+    CASE WHEN @sort_key::text = 'size' AND @descending::boolean THEN size_bytes END DESC,
+    CASE WHEN @sort_key::text = 'upload_date' AND NOT @descending::boolean THEN upload_date END ASC,
+    CASE WHEN @sort_key::text = 'upload_date' AND @descending::boolean THEN upload_date END DESC,
+    CASE WHEN @sort_key::text = 'relevance' AND NOT @descending::boolean THEN relevance END ASC,
+    CASE WHEN @sort_key::text = 'relevance' AND @descending::boolean THEN relevance END DESC,
+    CASE WHEN NOT @descending::boolean THEN id END ASC,
+    CASE WHEN @descending::boolean THEN id END DESC;
 
     
\ No newline at end of file
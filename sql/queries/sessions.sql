@@ -0,0 +1,48 @@
+-- name: CreateSession :one
+INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetActiveSession :one
+-- Used on every authenticated request to check that the session behind an access token is still live.
+SELECT * FROM sessions
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW();
+
+-- name: RotateSessionRefreshToken :one
+-- Swaps a live refresh token for a new one in a single statement, so two concurrent
+-- refreshes with the same token cannot both succeed.
+UPDATE sessions
+SET refresh_token_hash = sqlc.arg(new_refresh_token_hash),
+    previous_refresh_token_hash = refresh_token_hash,
+    last_used_at = NOW(),
+    expires_at = sqlc.arg(expires_at)
+WHERE refresh_token_hash = sqlc.arg(refresh_token_hash) AND revoked_at IS NULL AND expires_at > NOW()
+RETURNING *;
+
+-- name: RevokeSessionByPreviousRefreshToken :one
+-- A refresh token that has already been rotated is being replayed, so it has probably been stolen.
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL
+RETURNING id, user_id;
+
+-- name: ListUserSessions :many
+SELECT * FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeSession :one
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+RETURNING id;
+
+-- name: RevokeAllUserSessions :execrows
+UPDATE sessions
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW();
+
+-- name: DeleteStaleSessions :execrows
+-- Sessions are kept for a while after they end so they still show up when investigating a login.
+DELETE FROM sessions
+WHERE expires_at < sqlc.arg(before) OR revoked_at < sqlc.arg(before);
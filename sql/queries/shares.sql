@@ -1,25 +1,51 @@
 -- name: CreatePublicShareLink :one
-INSERT INTO shares (user_file_id, share_token) VALUES ($1, $2) RETURNING *;
+INSERT INTO shares (user_file_id, share_token, expires_at, max_downloads, password_hash)
+VALUES ($1, $2, $3, $4, $5) RETURNING *;
 
 -- name: GetShareByToken :one
 -- CORRECTED NAME: Changed from GetShareMetaByToken for clarity and consistency.
-SELECT s.id, s.download_count, uf.filename, pf.storage_path, pf.size_bytes
+SELECT s.id, s.download_count, uf.filename, uf.mime_type, pf.storage_path, pf.size_bytes, pf.sha256_hash
 FROM shares s
 JOIN user_files uf ON s.user_file_id = uf.id
 JOIN physical_files pf ON uf.physical_file_id = pf.id
-WHERE s.share_token = $1 AND s.is_public = TRUE;
+WHERE s.share_token = $1 AND s.is_public = TRUE AND uf.deleted_at IS NULL;
 
--- name: IncrementShareDownloadCount :exec
-UPDATE shares SET download_count = download_count + 1 WHERE id = $1;
+-- name: ClaimShareDownload :one
+-- Atomically counts one download against a share link, but only while the link is unexpired
+-- and under its download limit. No row is returned when the download must be refused.
+UPDATE shares SET download_count = COALESCE(download_count, 0) + 1
+WHERE id = $1
+  AND (expires_at IS NULL OR expires_at > NOW())
+  AND (max_downloads IS NULL OR COALESCE(download_count, 0) < max_downloads)
+RETURNING download_count;
+
+-- name: ReleaseShareDownload :exec
+-- Gives back a download claimed with ClaimShareDownload when the file couldn't be served.
+UPDATE shares SET download_count = download_count - 1
+WHERE id = $1 AND download_count > 0;
+
+-- name: GetShareSettingsByToken :one
+-- Retrieves a share link's settings and the file or folder it points at.
+SELECT id, user_file_id, folder_id, expires_at, max_downloads, password_hash
+FROM shares
+WHERE share_token = $1;
+
+-- name: UpdateShareSettings :one
+UPDATE shares SET expires_at = $2, max_downloads = $3, password_hash = $4
+WHERE id = $1
+RETURNING *;
 
 -- name: ShareFileWithUser :exec
--- Creates a record in the junction table to share a file with a specific user.
+-- Creates a record in the junction table to share a file with a specific user,
+-- or changes the role of an existing share.
 INSERT INTO file_shares_to_users (
   user_file_id,
-  shared_with_user_id
+  shared_with_user_id,
+  role
 ) VALUES (
-  $1, $2
-);
+  $1, $2, $3
+)
+ON CONFLICT (user_file_id, shared_with_user_id) DO UPDATE SET role = EXCLUDED.role;
 
 -- name: IsFileAlreadySharedWithUser :one
 -- Checks if a share record already exists to prevent duplicates.
@@ -40,14 +66,65 @@ WHERE user_file_id = $1;
 
 -- name: GetSharesForFile :many
 -- Retrieves all user shares for a specific file.
-SELECT u.id, u.email
+SELECT u.id, u.email, fstu.role
 FROM file_shares_to_users fstu
 JOIN users u ON fstu.shared_with_user_id = u.id
 WHERE fstu.user_file_id = $1;
 
 -- name: GetPublicShareByFileID :one
 -- Gets public share information for a file
-SELECT share_token, download_count
+SELECT share_token, download_count, expires_at, max_downloads, (password_hash IS NOT NULL)::boolean AS password_protected
 FROM shares
 WHERE user_file_id = $1 AND is_public = TRUE
 LIMIT 1;
+
+-- name: CreatePublicFolderShareLink :one
+INSERT INTO shares (folder_id, share_token, expires_at, max_downloads, password_hash)
+VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: GetShareTargetByToken :one
+-- Resolves a public share token to the file or folder it points at, along with its restrictions.
+SELECT id, share_token, user_file_id, folder_id, download_count, expires_at, max_downloads, password_hash, created_at
+FROM shares
+WHERE share_token = $1 AND is_public = TRUE;
+
+-- name: DeletePublicShareLinksByFolderID :exec
+-- Removes ALL public share links associated with a specific folder.
+DELETE FROM shares
+WHERE folder_id = $1;
+
+-- name: GetPublicShareByFolderID :one
+-- Gets public share information for a folder
+SELECT share_token, download_count, expires_at, max_downloads, (password_hash IS NOT NULL)::boolean AS password_protected
+FROM shares
+WHERE folder_id = $1 AND is_public = TRUE
+LIMIT 1;
+
+-- name: CreateShareDownloadGrant :one
+-- Records the grant for a counted download, reserved up to served_through while it is served.
+INSERT INTO share_download_grants (share_id, served_through, expires_at)
+VALUES ($1, $2, $3) RETURNING id;
+
+-- name: ReserveShareDownloadGrant :one
+-- Continues a download under an unexpired grant if the grant's responses have got no further
+-- than max_served_through, and reserves the grant up to range_end while it is served. Returns
+-- how far the grant had got before, or no row if the continuation isn't free.
+UPDATE share_download_grants g
+SET served_through = GREATEST(prior.served_through, sqlc.arg(range_end))
+FROM (
+    SELECT id, served_through FROM share_download_grants WHERE id = sqlc.arg(id) FOR UPDATE
+) prior
+WHERE g.id = prior.id
+  AND g.share_id = sqlc.arg(share_id)
+  AND g.expires_at > NOW()
+  AND prior.served_through <= sqlc.arg(max_served_through)
+RETURNING prior.served_through;
+
+-- name: SettleShareDownloadGrant :exec
+-- Sets how far a grant's responses have got once a response is done, unless another response
+-- has reserved the grant since.
+UPDATE share_download_grants SET served_through = sqlc.arg(served_through)
+WHERE id = sqlc.arg(id) AND served_through = sqlc.arg(reserved_through);
+
+-- name: DeleteExpiredShareDownloadGrants :execrows
+DELETE FROM share_download_grants WHERE expires_at < NOW();
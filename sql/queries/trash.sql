@@ -0,0 +1,44 @@
+-- name: TrashUserFile :execrows
+-- Moves a file owned by the given user to the trash.
+UPDATE user_files SET deleted_at = NOW()
+WHERE id = $1 AND owner_id = $2 AND deleted_at IS NULL;
+
+-- name: RestoreUserFile :one
+-- Takes a file out of the trash.
+UPDATE user_files SET deleted_at = NULL
+WHERE id = $1 AND owner_id = $2 AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: ListTrashedFiles :many
+-- Retrieves the files in a user's trash, most recently deleted first.
+SELECT
+    uf.id,
+    uf.filename,
+    uf.mime_type,
+    uf.folder_id,
+    uf.upload_date,
+    uf.deleted_at,
+    pf.size_bytes
+FROM user_files uf
+JOIN physical_files pf ON uf.physical_file_id = pf.id
+WHERE uf.owner_id = $1 AND uf.deleted_at IS NOT NULL
+ORDER BY uf.deleted_at DESC;
+
+-- name: GetTrashedFileForUpdate :one
+-- Locks a trashed file owned by the given user while it is purged.
+SELECT * FROM user_files
+WHERE id = $1 AND owner_id = $2 AND deleted_at IS NOT NULL
+FOR UPDATE;
+
+-- name: ListTrashedFileIDs :many
+-- Retrieves the IDs of every file in a user's trash.
+SELECT id FROM user_files WHERE owner_id = $1 AND deleted_at IS NOT NULL;
+
+-- name: ListExpiredTrashedFiles :many
+-- Retrieves every file that was moved to the trash before the cutoff.
+SELECT id, owner_id FROM user_files WHERE deleted_at < $1;
+
+-- name: TrashAllUserFiles :execrows
+-- Moves every file a user owns to the trash, so that they can all be purged.
+UPDATE user_files SET deleted_at = NOW()
+WHERE owner_id = $1 AND deleted_at IS NULL;
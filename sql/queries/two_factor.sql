@@ -0,0 +1,49 @@
+-- name: UpsertPendingTOTP :one
+-- Starts (or restarts) enrollment. A confirmed secret is never replaced; no row is returned then.
+INSERT INTO user_totp (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = NULL
+WHERE user_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp WHERE user_id = $1;
+
+-- name: ConfirmUserTOTP :exec
+UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1;
+
+-- name: UseTOTPStep :one
+-- Records that a code for this time step was accepted. Returns no row if a code for the same
+-- or a later step was already used, which makes each code single-use.
+UPDATE user_totp
+SET last_used_step = sqlc.arg(step)
+WHERE user_id = sqlc.arg(user_id) AND (last_used_step IS NULL OR last_used_step < sqlc.arg(step))
+RETURNING user_id;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp WHERE user_id = $1;
+
+-- name: CreateRecoveryCodes :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+SELECT sqlc.arg(user_id)::bigint, unnest(sqlc.arg(code_hashes)::text[]);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: UseRecoveryCode :one
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+RETURNING id;
+
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: UserRequiresTwoFactor :one
+-- True if any of the user's roles requires two-factor authentication.
+SELECT EXISTS (
+    SELECT 1 FROM user_roles ur
+    JOIN roles r ON r.id = ur.role_id
+    WHERE ur.user_id = $1 AND r.require_two_factor
+);
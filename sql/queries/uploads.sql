@@ -0,0 +1,50 @@
+-- name: CreateUpload :one
+-- Registers a new resumable upload with its total length and expiry.
+INSERT INTO uploads (id, owner_id, filename, mime_type, description, tags, upload_length, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: GetUploadForOwner :one
+-- Retrieves an upload only if it belongs to the requesting user.
+SELECT * FROM uploads
+WHERE id = $1 AND owner_id = $2;
+
+-- name: AdvanceUploadOffset :execrows
+-- Moves the offset forward after a chunk has been stored and records the chunk's object. The
+-- expected offset acts as an optimistic lock: if another request already advanced it, or the
+-- upload has expired, no row is updated.
+UPDATE uploads
+SET upload_offset = upload_offset + sqlc.arg(chunk_size),
+    chunk_keys = array_append(chunk_keys, sqlc.arg(chunk_key)::text),
+    expires_at = sqlc.arg(expires_at)
+WHERE id = sqlc.arg(id) AND upload_offset = sqlc.arg(expected_offset) AND expires_at > NOW();
+
+-- name: ClaimUploadAssembly :execrows
+-- Claims a fully received upload for assembly. No row is updated if it is already assembled,
+-- or another request started assembling it after stale_before.
+UPDATE uploads
+SET assembly_started_at = NOW()
+WHERE id = sqlc.arg(id)
+  AND completed_at IS NULL
+  AND upload_offset = upload_length
+  AND (assembly_started_at IS NULL OR assembly_started_at < sqlc.arg(stale_before));
+
+-- name: ReleaseUploadAssembly :exec
+-- Gives up a claim after assembly failed, so the client can retry.
+UPDATE uploads SET assembly_started_at = NULL WHERE id = $1 AND completed_at IS NULL;
+
+-- name: CompleteUpload :exec
+-- Links a fully received upload to the user_files row it produced.
+UPDATE uploads SET user_file_id = $2, completed_at = NOW() WHERE id = $1;
+
+-- name: DeleteUpload :exec
+DELETE FROM uploads WHERE id = $1;
+
+-- name: ListExpiredUploads :many
+-- Retrieves uploads whose expiry has passed, for the cleanup worker.
+SELECT * FROM uploads
+WHERE expires_at < NOW()
+ORDER BY expires_at;
+
+-- name: ListUserUploads :many
+SELECT * FROM uploads WHERE owner_id = $1;
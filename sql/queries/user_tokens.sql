@@ -0,0 +1,19 @@
+-- name: CreateUserToken :exec
+INSERT INTO user_tokens (id, user_id, purpose, expires_at) VALUES ($1, $2, $3, $4);
+
+-- name: UseUserToken :one
+-- Marks a token as used in the same statement that checks it, so it can't be used twice.
+UPDATE user_tokens
+SET used_at = NOW()
+WHERE id = $1 AND user_id = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id;
+
+-- name: InvalidateUserTokens :exec
+-- Retires every outstanding token a user has for a purpose, e.g. older reset links once a
+-- new one is sent.
+UPDATE user_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;
+
+-- name: DeleteStaleUserTokens :execrows
+DELETE FROM user_tokens WHERE expires_at < $1;
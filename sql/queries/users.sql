@@ -12,3 +12,85 @@ SELECT * FROM users WHERE id = $1 LIMIT 1;
 UPDATE users
 SET storage_used_bytes = storage_used_bytes + sqlc.arg(amount)
 WHERE id = sqlc.arg(id);
+
+-- name: MarkUserEmailVerified :exec
+UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users SET password_hash = $2 WHERE id = $1;
+
+-- name: GetUserSuspension :one
+-- Used on every authenticated request to turn away suspended users.
+SELECT suspended_at FROM users WHERE id = $1;
+
+-- name: ListUsers :many
+-- For admin use: a page of users matching the optional filters, with their roles and the total
+-- number of matches.
+SELECT
+    u.id,
+    u.email,
+    u.storage_quota_bytes,
+    u.storage_used_bytes,
+    u.created_at,
+    u.email_verified_at,
+    u.suspended_at,
+    COALESCE((
+        SELECT array_agg(r.name ORDER BY r.name)
+        FROM user_roles ur JOIN roles r ON ur.role_id = r.id
+        WHERE ur.user_id = u.id
+    ), '{}')::text[] AS roles,
+    COUNT(*) OVER () AS total_count
+FROM users u
+WHERE (sqlc.narg(email)::text IS NULL OR u.email ILIKE '%' || sqlc.narg(email) || '%')
+  AND (sqlc.narg(role)::text IS NULL OR EXISTS (
+        SELECT 1 FROM user_roles ur JOIN roles r ON ur.role_id = r.id
+        WHERE ur.user_id = u.id AND r.name = sqlc.narg(role)
+      ))
+  AND (sqlc.narg(suspended)::boolean IS NULL OR (u.suspended_at IS NOT NULL) = sqlc.narg(suspended))
+ORDER BY u.id
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: GetUserFileCounts :one
+SELECT
+    COUNT(*) FILTER (WHERE deleted_at IS NULL)::bigint AS file_count,
+    COUNT(*) FILTER (WHERE deleted_at IS NOT NULL)::bigint AS trashed_file_count
+FROM user_files
+WHERE owner_id = $1;
+
+-- name: SetUserStorageQuota :one
+UPDATE users SET storage_quota_bytes = $2 WHERE id = $1 RETURNING *;
+
+-- name: SuspendUser :execrows
+UPDATE users SET suspended_at = NOW() WHERE id = $1 AND suspended_at IS NULL;
+
+-- name: ReactivateUser :execrows
+UPDATE users SET suspended_at = NULL WHERE id = $1 AND suspended_at IS NOT NULL;
+
+-- name: DeleteUser :execrows
+-- Everything the user owns goes with them through ON DELETE CASCADE, so their files must be
+-- purged or transferred first to keep physical file reference counts right.
+DELETE FROM users WHERE id = $1;
+
+-- name: TransferUserFolders :exec
+-- Hands all of a user's folders to another user. Their top-level folders are put under root_folder_id.
+UPDATE folders
+SET owner_id = sqlc.arg(to_user_id), parent_id = COALESCE(parent_id, sqlc.arg(root_folder_id))
+WHERE owner_id = sqlc.arg(from_user_id);
+
+-- name: TransferUserFiles :exec
+-- Hands all of a user's files, including those in the trash, to another user. Files at the top
+-- level are put in root_folder_id.
+UPDATE user_files
+SET owner_id = sqlc.arg(to_user_id), folder_id = COALESCE(folder_id, sqlc.arg(root_folder_id))
+WHERE owner_id = sqlc.arg(from_user_id);
+
+-- name: DeleteSharesWithOwner :exec
+-- Removes user shares of a user's own files and folders, which a transfer can leave behind.
+WITH deleted_file_shares AS (
+    DELETE FROM file_shares_to_users fs
+    USING user_files uf
+    WHERE fs.user_file_id = uf.id AND uf.owner_id = $1 AND fs.shared_with_user_id = $1
+)
+DELETE FROM folder_shares_to_users fs
+USING folders f
+WHERE fs.folder_id = f.id AND f.owner_id = $1 AND fs.shared_with_user_id = $1;
@@ -0,0 +1,55 @@
+-- name: CreateFileVersion :one
+INSERT INTO file_versions (user_file_id, version_number, physical_file_id, mime_type, uploaded_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetUserFileForUpdate :one
+-- Locks a user file row while a new version is added. The caller checks the user's role first.
+SELECT * FROM user_files
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE;
+
+-- name: SetCurrentFileVersion :one
+-- Points a user file at one of its versions.
+UPDATE user_files
+SET physical_file_id = sqlc.arg(physical_file_id),
+    mime_type = sqlc.arg(mime_type),
+    current_version = sqlc.arg(current_version)
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: ListFileVersions :many
+-- Retrieves the version history of a file, newest first.
+SELECT
+    fv.id,
+    fv.version_number,
+    fv.mime_type,
+    fv.uploaded_by,
+    fv.created_at,
+    pf.size_bytes,
+    pf.sha256_hash
+FROM file_versions fv
+JOIN physical_files pf ON fv.physical_file_id = pf.id
+WHERE fv.user_file_id = $1
+ORDER BY fv.version_number DESC;
+
+-- name: GetFileVersion :one
+-- Retrieves a single version together with the location of its content.
+SELECT
+    fv.id,
+    fv.version_number,
+    fv.physical_file_id,
+    fv.mime_type,
+    pf.storage_path,
+    pf.size_bytes,
+    pf.sha256_hash
+FROM file_versions fv
+JOIN physical_files pf ON fv.physical_file_id = pf.id
+WHERE fv.user_file_id = $1 AND fv.version_number = $2;
+
+-- name: ListFileVersionPhysicalFiles :many
+-- Retrieves the physical file behind every version of a file, used to release references on delete.
+SELECT fv.physical_file_id, pf.size_bytes, pf.storage_path
+FROM file_versions fv
+JOIN physical_files pf ON fv.physical_file_id = pf.id
+WHERE fv.user_file_id = $1;
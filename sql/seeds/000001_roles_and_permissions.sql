@@ -28,7 +28,8 @@ INSERT INTO permissions (name) VALUES
     ('admin:view_all_files'),
     ('admin:view_all_stats'),
     ('admin:download_any_file'),
-    ('admin:view_audit_logs')
+    ('admin:view_audit_logs'),
+    ('admin:manage_users')
 ON CONFLICT (name) DO NOTHING;
 
 -- Map permissions to roles
@@ -66,5 +67,6 @@ INSERT INTO role_permissions (role_id, permission_id) VALUES
     (2, 13), -- admin can admin:view_all_files
     (2, 14), -- admin can admin:view_all_stats
     (2, 15),  -- admin can admin:download_any_file
-    (2, 16)  -- admin can admin:view_audit_logs
+    (2, 16), -- admin can admin:view_audit_logs
+    (2, 17)  -- admin can admin:manage_users
 ON CONFLICT DO NOTHING;
\ No newline at end of file